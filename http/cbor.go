@@ -0,0 +1,159 @@
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+const CBOR_MAJOR_UNSIGNED byte = 0
+const CBOR_MAJOR_NEGATIVE byte = 1
+const CBOR_MAJOR_BYTES byte = 2
+const CBOR_MAJOR_TEXT byte = 3
+const CBOR_MAJOR_ARRAY byte = 4
+const CBOR_MAJOR_MAP byte = 5
+
+const CBOR_FALSE byte = 0xf4
+const CBOR_TRUE byte = 0xf5
+const CBOR_NULL byte = 0xf6
+const CBOR_FLOAT64 byte = 0xfb
+
+type CborEncoder struct{}
+
+func (CborEncoder) ContentType() string {
+	return CONTENT_TYPE_CBOR
+}
+
+func (CborEncoder) Encode(content any) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := encodeCbor(&buffer, reflect.ValueOf(content))
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeCborHead(buffer *bytes.Buffer, major byte, length uint64) {
+	major <<= 5
+
+	switch {
+	case length < 24:
+		buffer.WriteByte(major | byte(length))
+	case length <= math.MaxUint8:
+		buffer.WriteByte(major | 24)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(major | 25)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	case length <= math.MaxUint32:
+		buffer.WriteByte(major | 26)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	default:
+		buffer.WriteByte(major | 27)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, length))
+	}
+}
+
+func encodeCbor(buffer *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buffer.WriteByte(CBOR_NULL)
+		return nil
+	}
+
+	if value.Type() == timeType {
+		text := value.Interface().(time.Time).Format(time.RFC3339Nano)
+		writeCborHead(buffer, CBOR_MAJOR_TEXT, uint64(len(text)))
+		buffer.WriteString(text)
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			buffer.WriteByte(CBOR_NULL)
+			return nil
+		}
+		return encodeCbor(buffer, value.Elem())
+	case reflect.Bool:
+		if value.Bool() {
+			buffer.WriteByte(CBOR_TRUE)
+		} else {
+			buffer.WriteByte(CBOR_FALSE)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number := value.Int()
+		if number >= 0 {
+			writeCborHead(buffer, CBOR_MAJOR_UNSIGNED, uint64(number))
+		} else {
+			writeCborHead(buffer, CBOR_MAJOR_NEGATIVE, uint64(-1-number))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeCborHead(buffer, CBOR_MAJOR_UNSIGNED, value.Uint())
+	case reflect.Float32, reflect.Float64:
+		buffer.WriteByte(CBOR_FLOAT64)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(value.Float())))
+	case reflect.String:
+		writeCborHead(buffer, CBOR_MAJOR_TEXT, uint64(value.Len()))
+		buffer.WriteString(value.String())
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			buffer.WriteByte(CBOR_NULL)
+			return nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(data), value)
+			writeCborHead(buffer, CBOR_MAJOR_BYTES, uint64(len(data)))
+			buffer.Write(data)
+			return nil
+		}
+		writeCborHead(buffer, CBOR_MAJOR_ARRAY, uint64(value.Len()))
+		for i := 0; i < value.Len(); i++ {
+			err := encodeCbor(buffer, value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.IsNil() {
+			buffer.WriteByte(CBOR_NULL)
+			return nil
+		}
+		writeCborHead(buffer, CBOR_MAJOR_MAP, uint64(value.Len()))
+		for _, key := range sortedMapKeys(value) {
+			err := encodeCbor(buffer, key)
+			if err != nil {
+				return err
+			}
+			err = encodeCbor(buffer, value.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := []encodedField{}
+		for _, field := range encodedFields(value.Type()) {
+			if field.omitEmpty && value.FieldByIndex(field.index).IsZero() {
+				continue
+			}
+			fields = append(fields, field)
+		}
+
+		writeCborHead(buffer, CBOR_MAJOR_MAP, uint64(len(fields)))
+		for _, field := range fields {
+			writeCborHead(buffer, CBOR_MAJOR_TEXT, uint64(len(field.name)))
+			buffer.WriteString(field.name)
+			err := encodeCbor(buffer, value.FieldByIndex(field.index))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %s", value.Type())
+	}
+
+	return nil
+}
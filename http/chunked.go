@@ -0,0 +1,69 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const MAX_CHUNK_SIZE = 1 << 30
+
+func readChunkedContent(reader *bufio.Reader, trailers map[string]string, maxSize int64) ([]byte, error) {
+	var content bytes.Buffer
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk size: %w", err)
+		}
+
+		rawSize, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(rawSize), 16, 64)
+		if err != nil || size < 0 || size > MAX_CHUNK_SIZE {
+			return nil, fmt.Errorf("invalid chunk size [%s]", rawSize)
+		}
+
+		if size == 0 {
+			break
+		}
+		if int64(content.Len())+size > maxSize {
+			return nil, fmt.Errorf("%w: chunked content exceeds %d bytes", ErrResponseTooLarge, maxSize)
+		}
+
+		_, err = io.CopyN(&content, reader, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk: %w", err)
+		}
+
+		crlf := make([]byte, 2)
+		_, err = io.ReadFull(reader, crlf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk terminator: %w", err)
+		}
+		if string(crlf) != CLRF {
+			return nil, fmt.Errorf("chunk is not terminated by CRLF")
+		}
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trailer: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		name, value, err := parseHeaderLine(line)
+		if err != nil {
+			return nil, err
+		}
+		trailers[name] = value
+	}
+
+	return content.Bytes(), nil
+}
@@ -0,0 +1,434 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_CLIENT_TIMEOUT = 30 * time.Second
+const DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+const DEFAULT_IDLE_TIMEOUT = 90 * time.Second
+const DEFAULT_MAX_IDLE_PER_HOST = 4
+const DEFAULT_MAX_REDIRECTS = 10
+const DEFAULT_MAX_RESPONSE_SIZE = 64 << 20
+
+var ErrTooManyRedirects = errors.New("too many redirects")
+var ErrClientClosed = errors.New("client closed")
+var ErrResponseTooLarge = errors.New("response too large")
+
+type ClientConfig struct {
+	Timeout         time.Duration
+	DialTimeout     time.Duration
+	IdleTimeout     time.Duration
+	MaxIdlePerHost  int
+	MaxRedirects    int
+	MaxResponseSize int64
+	TLSConfig       *tls.Config
+	Dial            func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+type Client struct {
+	config ClientConfig
+	mutex  sync.Mutex
+	idle   map[string][]*clientConn
+	closed bool
+}
+
+type clientConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+}
+
+func NewClient(config ClientConfig) *Client {
+	if config.Timeout == 0 {
+		config.Timeout = DEFAULT_CLIENT_TIMEOUT
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	if config.MaxIdlePerHost == 0 {
+		config.MaxIdlePerHost = DEFAULT_MAX_IDLE_PER_HOST
+	}
+	if config.MaxRedirects == 0 {
+		config.MaxRedirects = DEFAULT_MAX_REDIRECTS
+	}
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = DEFAULT_MAX_RESPONSE_SIZE
+	}
+	if config.Dial == nil {
+		dialer := &net.Dialer{Timeout: config.DialTimeout}
+		config.Dial = dialer.DialContext
+	}
+
+	return &Client{config: config, idle: map[string][]*clientConn{}}
+}
+
+func NewClientRequest(ctx context.Context, method string, rawURL string, content []byte) (HttpRequest, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return HttpRequest{}, fmt.Errorf("invalid url %s: %w", rawURL, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return HttpRequest{}, fmt.Errorf("unsupported url scheme [%s]", target.Scheme)
+	}
+	if target.Host == "" {
+		return HttpRequest{}, fmt.Errorf("url %s has no host", rawURL)
+	}
+	if content == nil {
+		content = []byte{}
+	}
+
+	request := HttpRequest{
+		Method:   method,
+		FullPath: target.RequestURI(),
+		Protocol: "HTTP/1.1",
+		Headers:  map[string]string{"Host": target.Host},
+		Content:  content,
+		Scheme:   target.Scheme,
+		Host:     target.Host}
+
+	return request.WithContext(ctx), nil
+}
+
+func (client *Client) Get(ctx context.Context, rawURL string) (HttpResponse, error) {
+	request, err := NewClientRequest(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	return client.Do(request)
+}
+
+func (client *Client) Post(ctx context.Context, rawURL string, contentType string, content []byte) (HttpResponse, error) {
+	request, err := NewClientRequest(ctx, "POST", rawURL, content)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	request.Headers["Content-Type"] = contentType
+	return client.Do(request)
+}
+
+func (client *Client) Do(request HttpRequest) (HttpResponse, error) {
+	ctx := request.Context()
+	if client.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.config.Timeout)
+		defer cancel()
+	}
+
+	for redirects := 0; ; redirects++ {
+		response, err := client.roundTrip(ctx, request)
+		if err != nil {
+			return HttpResponse{}, err
+		}
+
+		location, exists := response.Header("Location")
+		if !isRedirect(response.StatusCode) || !exists || client.config.MaxRedirects < 0 {
+			return response, nil
+		}
+		if redirects >= client.config.MaxRedirects {
+			return response, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+
+		request, err = redirectRequest(request, response.StatusCode, location)
+		if err != nil {
+			return response, err
+		}
+	}
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+func redirectRequest(request HttpRequest, statusCode int, location string) (HttpRequest, error) {
+	base := &url.URL{Scheme: request.Scheme, Host: request.Host, Path: request.Path()}
+	target, err := base.Parse(location)
+	if err != nil {
+		return request, fmt.Errorf("invalid redirect location %s: %w", location, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return request, fmt.Errorf("unsupported redirect scheme [%s]", target.Scheme)
+	}
+
+	headers := map[string]string{}
+	for headerName, headerValue := range request.Headers {
+		headers[headerName] = headerValue
+	}
+	DeleteHeader(headers, "Host")
+	headers["Host"] = target.Host
+
+	if target.Host != request.Host {
+		DeleteHeader(headers, "Authorization")
+		DeleteHeader(headers, "Cookie")
+	}
+
+	redirected := request
+	redirected.Scheme = target.Scheme
+	redirected.Host = target.Host
+	redirected.FullPath = target.RequestURI()
+	redirected.Headers = headers
+
+	if statusCode != 307 && statusCode != 308 && request.Method != "HEAD" {
+		redirected.Method = "GET"
+		redirected.Content = []byte{}
+		DeleteHeader(headers, "Content-Type")
+	}
+
+	return redirected, nil
+}
+
+func (client *Client) roundTrip(ctx context.Context, request HttpRequest) (HttpResponse, error) {
+	if request.Scheme == "" {
+		request.Scheme = "http"
+	}
+	if request.Host == "" {
+		request.Host, _ = request.Header("Host")
+	}
+	if request.Host == "" {
+		return HttpResponse{}, fmt.Errorf("request has no host")
+	}
+	if _, exists := request.Header("Host"); !exists {
+		headers := map[string]string{"Host": request.Host}
+		for headerName, headerValue := range request.Headers {
+			headers[headerName] = headerValue
+		}
+		request.Headers = headers
+	}
+
+	key := request.Scheme + "://" + hostWithPort(request.Scheme, request.Host)
+
+	cc, reused, err := client.getConn(ctx, request.Scheme, request.Host, key)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+
+	response, reusable, err := client.exchange(ctx, cc, request)
+	if err != nil && reused && IsIdempotent(request.Method) && ctx.Err() == nil {
+		cc, _, err = client.getConn(ctx, request.Scheme, request.Host, "")
+		if err != nil {
+			return HttpResponse{}, err
+		}
+		response, reusable, err = client.exchange(ctx, cc, request)
+	}
+	if err != nil {
+		return HttpResponse{}, err
+	}
+
+	connection, _ := request.Header("Connection")
+	if reusable && !strings.EqualFold(connection, "close") {
+		client.putConn(key, cc)
+	} else {
+		cc.conn.Close()
+	}
+
+	return response, nil
+}
+
+func (client *Client) exchange(ctx context.Context, cc *clientConn, request HttpRequest) (HttpResponse, bool, error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		cc.conn.SetDeadline(deadline)
+	} else {
+		cc.conn.SetDeadline(time.Time{})
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		cc.conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	response, reusable, err := cc.roundTrip(request, client.config.MaxResponseSize)
+	if err != nil {
+		cc.conn.Close()
+		if ctx.Err() != nil {
+			return HttpResponse{}, false, ctx.Err()
+		}
+		if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			return HttpResponse{}, false, fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+		return HttpResponse{}, false, err
+	}
+
+	return response, reusable, nil
+}
+
+func (cc *clientConn) roundTrip(request HttpRequest, maxSize int64) (HttpResponse, bool, error) {
+	_, err := cc.conn.Write(request.Serialize())
+	if err != nil {
+		return HttpResponse{}, false, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	for {
+		response, reusable, err := parseHttpResponse(cc.reader, request.Method, maxSize)
+		if err != nil {
+			return HttpResponse{}, false, err
+		}
+		if response.StatusCode >= 200 || response.StatusCode == 101 {
+			return response, reusable && response.StatusCode != 101, nil
+		}
+	}
+}
+
+func (cc *clientConn) isAlive() bool {
+	cc.conn.SetReadDeadline(time.Now())
+	_, err := cc.reader.Peek(1)
+	cc.conn.SetReadDeadline(time.Time{})
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func IsIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func hostWithPort(scheme string, host string) string {
+	_, _, err := net.SplitHostPort(host)
+	if err == nil {
+		return host
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if scheme == "https" {
+		return net.JoinHostPort(host, "443")
+	}
+	return net.JoinHostPort(host, "80")
+}
+
+func (client *Client) getConn(ctx context.Context, scheme string, host string, key string) (*clientConn, bool, error) {
+	client.mutex.Lock()
+	closed := client.closed
+	client.mutex.Unlock()
+	if closed {
+		return nil, false, ErrClientClosed
+	}
+
+	if key != "" {
+		cc := client.takeIdle(key)
+		if cc != nil {
+			return cc, true, nil
+		}
+	}
+
+	conn, err := client.Dial(ctx, scheme, host)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &clientConn{conn: conn, reader: bufio.NewReader(conn)}, false, nil
+}
+
+func (client *Client) Dial(ctx context.Context, scheme string, host string) (net.Conn, error) {
+	address := hostWithPort(scheme, host)
+	conn, err := client.config.Dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	if scheme != "https" {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+	if client.config.TLSConfig != nil {
+		config = client.config.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		serverName, _, _ := net.SplitHostPort(address)
+		config.ServerName = serverName
+	}
+
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with %s failed: %w", address, err)
+	}
+	return tlsConn, nil
+}
+
+func (client *Client) takeIdle(key string) *clientConn {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	conns := client.idle[key]
+	for len(conns) > 0 {
+		cc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if time.Since(cc.idleSince) > client.config.IdleTimeout || !cc.isAlive() {
+			cc.conn.Close()
+			continue
+		}
+
+		client.idle[key] = conns
+		return cc
+	}
+
+	delete(client.idle, key)
+	return nil
+}
+
+func (client *Client) putConn(key string, cc *clientConn) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.closed || len(client.idle[key]) >= client.config.MaxIdlePerHost {
+		cc.conn.Close()
+		return
+	}
+
+	cc.conn.SetDeadline(time.Time{})
+	cc.idleSince = time.Now()
+	client.idle[key] = append(client.idle[key], cc)
+}
+
+func (client *Client) IdleConnections() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	count := 0
+	for _, conns := range client.idle {
+		count += len(conns)
+	}
+	return count
+}
+
+func (client *Client) CloseIdleConnections() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	for key, conns := range client.idle {
+		for _, cc := range conns {
+			cc.conn.Close()
+		}
+		delete(client.idle, key)
+	}
+}
+
+func (client *Client) Close() {
+	client.CloseIdleConnections()
+
+	client.mutex.Lock()
+	client.closed = true
+	client.mutex.Unlock()
+}
@@ -0,0 +1,328 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testUpstream struct {
+	listener net.Listener
+	accepted atomic.Int32
+}
+
+func startTestUpstream(t *testing.T, tlsConfig *tls.Config, handle func(HttpRequest, net.Conn) bool) *testUpstream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	upstream := &testUpstream{listener: listener}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream.accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					request, _, err := ParseHttpRequest(reader)
+					if err != nil {
+						return
+					}
+					if !handle(request, conn) {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return upstream
+}
+
+func (upstream *testUpstream) url(path string) string {
+	return "http://" + upstream.listener.Addr().String() + path
+}
+
+func TestParseHttpResponse(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+		headers  map[string]string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhelloEXTRA", "hello", map[string]string{"Content-Length": "5"}},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+			"hello world", map[string]string{"Content-Length": "11", "X-Checksum": "abc"}},
+		{"HTTP/1.0 404 Not Found\r\nContent-Type: text/plain\r\n\r\nmissing", "missing", map[string]string{"Content-Type": "text/plain"}},
+	}
+
+	for _, tt := range tests {
+		response, _, err := ParseHttpResponse(strings.NewReader(tt.raw))
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", tt.raw, err)
+			continue
+		}
+		if string(response.Content) != tt.expected {
+			t.Errorf("content was not %q. got=%q", tt.expected, response.Content)
+		}
+		if len(response.Headers) != len(tt.headers) {
+			t.Errorf("expected headers %v. got=%v", tt.headers, response.Headers)
+		}
+		for name, value := range tt.headers {
+			if response.Headers[name] != value {
+				t.Errorf("header %s was not %s. got=%s", name, value, response.Headers[name])
+			}
+		}
+	}
+
+	_, _, err := ParseHttpResponse(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	if err == nil {
+		t.Errorf("expected error for invalid chunk size")
+	}
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	upstream := startTestUpstream(t, nil, func(request HttpRequest, conn net.Conn) bool {
+		conn.Write(NewPlainTextResponse(request.FullPath, 200).Serialize())
+		return true
+	})
+
+	client := NewClient(ClientConfig{})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		path := fmt.Sprintf("/request/%d?x=1", i)
+		response, err := client.Get(context.Background(), upstream.url(path))
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		if response.StatusCode != 200 || string(response.Content) != path {
+			t.Errorf("unexpected response %d: %d %s", i, response.StatusCode, response.Content)
+		}
+	}
+
+	if upstream.accepted.Load() != 1 {
+		t.Errorf("expected a single pooled connection. got=%d", upstream.accepted.Load())
+	}
+	if client.IdleConnections() != 1 {
+		t.Errorf("expected one idle connection. got=%d", client.IdleConnections())
+	}
+}
+
+func TestClientRetriesStaleConnection(t *testing.T) {
+	upstream := startTestUpstream(t, nil, func(request HttpRequest, conn net.Conn) bool {
+		conn.Write(NewPlainTextResponse("ok", 200).Serialize())
+		return false
+	})
+
+	client := NewClient(ClientConfig{})
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		response, err := client.Get(context.Background(), upstream.url("/"))
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		if string(response.Content) != "ok" {
+			t.Errorf("unexpected content. got=%s", response.Content)
+		}
+	}
+
+	if upstream.accepted.Load() != 2 {
+		t.Errorf("expected the stale connection to be replaced. got=%d connections", upstream.accepted.Load())
+	}
+}
+
+func TestClientRedirects(t *testing.T) {
+	var upstream *testUpstream
+	upstream = startTestUpstream(t, nil, func(request HttpRequest, conn net.Conn) bool {
+		response := NewPlainTextResponse(request.Method+" "+string(request.Content), 200)
+		switch request.Path() {
+		case "/found":
+			response = HttpResponse{StatusCode: 302, Headers: map[string]string{"Location": "/target"}}
+		case "/temporary":
+			response = HttpResponse{StatusCode: 307, Headers: map[string]string{"Location": upstream.url("/target")}}
+		case "/loop":
+			response = HttpResponse{StatusCode: 301, Headers: map[string]string{"Location": "loop"}}
+		}
+		conn.Write(response.Serialize())
+		return true
+	})
+
+	client := NewClient(ClientConfig{MaxRedirects: 3})
+	defer client.Close()
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/found", "GET "},
+		{"/temporary", "POST payload"},
+	}
+
+	for _, tt := range tests {
+		response, err := client.Post(context.Background(), upstream.url(tt.path), CONTENT_TYPE_PLAIN, []byte("payload"))
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", tt.path, err)
+		}
+		if string(response.Content) != tt.expected {
+			t.Errorf("redirect from %s did not yield %q. got=%q", tt.path, tt.expected, response.Content)
+		}
+	}
+
+	response, err := client.Get(context.Background(), upstream.url("/loop"))
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected too many redirects. got=%v", err)
+	}
+	if response.StatusCode != 301 {
+		t.Errorf("expected last redirect response. got=%d", response.StatusCode)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	upstream := startTestUpstream(t, nil, func(request HttpRequest, conn net.Conn) bool {
+		time.Sleep(time.Second)
+		return false
+	})
+
+	client := NewClient(ClientConfig{Timeout: 50 * time.Millisecond})
+	defer client.Close()
+
+	start := time.Now()
+	_, err := client.Get(context.Background(), upstream.url("/"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded. got=%v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("timeout was not enforced. took=%s", time.Since(start))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = NewClient(ClientConfig{}).Get(ctx, upstream.url("/"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled. got=%v", err)
+	}
+}
+
+func TestClientMaxResponseSize(t *testing.T) {
+	tests := []struct {
+		raw      string
+		accepted bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 9223372036854775807\r\n\r\nhello", false},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 17\r\n\r\nhello", false},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n8\r\n01234567\r\n9\r\n012345678\r\n0\r\n\r\n", false},
+		{"HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n0123456789abcdefX", false},
+		{"HTTP/1.1 200 OK\r\nConnection: close\r\n\r\n0123456789abcdef", true},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 16\r\n\r\n0123456789abcdef", true},
+	}
+
+	for _, tt := range tests {
+		upstream := startTestUpstream(t, nil, func(request HttpRequest, conn net.Conn) bool {
+			conn.Write([]byte(tt.raw))
+			return false
+		})
+
+		client := NewClient(ClientConfig{MaxResponseSize: 16})
+		response, err := client.Get(context.Background(), upstream.url("/"))
+		client.Close()
+
+		if tt.accepted {
+			if err != nil || string(response.Content) != "0123456789abcdef" {
+				t.Errorf("expected %q to be accepted. got=%q, %v", tt.raw, response.Content, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("expected %q to be rejected as too large. got=%v", tt.raw, err)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	certificate, pool := generateClientTestCertificate(t)
+
+	upstream := startTestUpstream(t, &tls.Config{Certificates: []tls.Certificate{certificate}},
+		func(request HttpRequest, conn net.Conn) bool {
+			conn.Write(NewPlainTextResponse("secure", 200).Serialize())
+			return true
+		})
+
+	client := NewClient(ClientConfig{TLSConfig: &tls.Config{RootCAs: pool}})
+	defer client.Close()
+
+	_, port, _ := net.SplitHostPort(upstream.listener.Addr().String())
+	response, err := client.Get(context.Background(), "https://localhost:"+port+"/")
+	if err != nil {
+		t.Fatalf("tls request failed: %v", err)
+	}
+	if string(response.Content) != "secure" {
+		t.Errorf("unexpected content. got=%s", response.Content)
+	}
+
+	_, err = NewClient(ClientConfig{}).Get(context.Background(), "https://localhost:"+port+"/")
+	if err == nil {
+		t.Errorf("expected untrusted certificate to be rejected")
+	}
+}
+
+func generateClientTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
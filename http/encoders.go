@@ -0,0 +1,114 @@
+package http
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const CONTENT_TYPE_XML = "application/xml"
+const CONTENT_TYPE_CBOR = "application/cbor"
+const CONTENT_TYPE_MSGPACK = "application/msgpack"
+
+type Encoder interface {
+	ContentType() string
+	Encode(content any) ([]byte, error)
+}
+
+type JsonEncoder struct{}
+
+func (JsonEncoder) ContentType() string {
+	return CONTENT_TYPE_JSON
+}
+
+func (JsonEncoder) Encode(content any) ([]byte, error) {
+	return json.Marshal(content)
+}
+
+type TextEncoder struct{}
+
+func (TextEncoder) ContentType() string {
+	return CONTENT_TYPE_PLAIN
+}
+
+func (TextEncoder) Encode(content any) ([]byte, error) {
+	switch value := content.(type) {
+	case string:
+		return []byte(value), nil
+	case []byte:
+		return value, nil
+	case encoding.TextMarshaler:
+		return value.MarshalText()
+	case fmt.Stringer:
+		return []byte(value.String()), nil
+	case error:
+		return []byte(value.Error()), nil
+	}
+	return []byte(fmt.Sprint(content)), nil
+}
+
+type XmlEncoder struct{}
+
+func (XmlEncoder) ContentType() string {
+	return CONTENT_TYPE_XML
+}
+
+func (XmlEncoder) Encode(content any) ([]byte, error) {
+	encoded, err := xml.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), encoded...), nil
+}
+
+type encodedField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+func encodedFields(t reflect.Type) []encodedField {
+	fields := []encodedField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for _, embedded := range encodedFields(field.Type) {
+				embedded.index = append([]int{i}, embedded.index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, encodedField{
+			name:      name,
+			index:     []int{i},
+			omitEmpty: strings.Contains(options, "omitempty")})
+	}
+
+	return fields
+}
+
+func sortedMapKeys(value reflect.Value) []reflect.Value {
+	keys := value.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	return keys
+}
+
+var timeType = reflect.TypeOf(time.Time{})
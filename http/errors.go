@@ -7,6 +7,7 @@ import (
 type HttpError struct {
 	StatusCode int
 	Message    string
+	Headers    map[string]string
 }
 
 func (he HttpError) Error() string {
@@ -14,9 +15,14 @@ func (he HttpError) Error() string {
 }
 
 func (he HttpError) ToResponse() HttpResponse {
+	headers := map[string]string{}
+	for name, value := range he.Headers {
+		headers[name] = value
+	}
+
 	return HttpResponse{
 		StatusCode: he.StatusCode,
-		Headers:    map[string]string{},
+		Headers:    headers,
 		Content:    []byte{}}
 }
 
@@ -34,9 +40,58 @@ func ErrorNotFound(message string) HttpError {
 	}
 }
 
+func ClientClosedRequest(message string) HttpError {
+	return HttpError{
+		StatusCode: 499,
+		Message:    message,
+	}
+}
+
 func InternalServerError(message string) HttpError {
 	return HttpError{
 		StatusCode: 500,
 		Message:    message,
 	}
 }
+
+func ServiceUnavailable(message string) HttpError {
+	return HttpError{
+		StatusCode: 503,
+		Message:    message,
+	}
+}
+
+func Forbidden(message string) HttpError {
+	return HttpError{
+		StatusCode: 403,
+		Message:    message,
+	}
+}
+
+func MethodNotAllowed(message string) HttpError {
+	return HttpError{
+		StatusCode: 405,
+		Message:    message,
+	}
+}
+
+func NotAcceptable(message string) HttpError {
+	return HttpError{
+		StatusCode: 406,
+		Message:    message,
+	}
+}
+
+func BadGateway(message string) HttpError {
+	return HttpError{
+		StatusCode: 502,
+		Message:    message,
+	}
+}
+
+func GatewayTimeout(message string) HttpError {
+	return HttpError{
+		StatusCode: 504,
+		Message:    message,
+	}
+}
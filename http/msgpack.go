@@ -0,0 +1,208 @@
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+const MSGPACK_NIL byte = 0xc0
+const MSGPACK_FALSE byte = 0xc2
+const MSGPACK_TRUE byte = 0xc3
+const MSGPACK_FLOAT64 byte = 0xcb
+
+type MsgpackEncoder struct{}
+
+func (MsgpackEncoder) ContentType() string {
+	return CONTENT_TYPE_MSGPACK
+}
+
+func (MsgpackEncoder) Encode(content any) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := encodeMsgpack(&buffer, reflect.ValueOf(content))
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeMsgpackUint(buffer *bytes.Buffer, number uint64) {
+	switch {
+	case number < 128:
+		buffer.WriteByte(byte(number))
+	case number <= math.MaxUint8:
+		buffer.WriteByte(0xcc)
+		buffer.WriteByte(byte(number))
+	case number <= math.MaxUint16:
+		buffer.WriteByte(0xcd)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(number)))
+	case number <= math.MaxUint32:
+		buffer.WriteByte(0xce)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(number)))
+	default:
+		buffer.WriteByte(0xcf)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, number))
+	}
+}
+
+func writeMsgpackInt(buffer *bytes.Buffer, number int64) {
+	switch {
+	case number >= 0:
+		writeMsgpackUint(buffer, uint64(number))
+	case number >= -32:
+		buffer.WriteByte(byte(number))
+	case number >= math.MinInt8:
+		buffer.WriteByte(0xd0)
+		buffer.WriteByte(byte(number))
+	case number >= math.MinInt16:
+		buffer.WriteByte(0xd1)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(number)))
+	case number >= math.MinInt32:
+		buffer.WriteByte(0xd2)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(number)))
+	default:
+		buffer.WriteByte(0xd3)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, uint64(number)))
+	}
+}
+
+func writeMsgpackString(buffer *bytes.Buffer, text string) {
+	length := len(text)
+	switch {
+	case length < 32:
+		buffer.WriteByte(0xa0 | byte(length))
+	case length <= math.MaxUint8:
+		buffer.WriteByte(0xd9)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(0xda)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	default:
+		buffer.WriteByte(0xdb)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	}
+	buffer.WriteString(text)
+}
+
+func writeMsgpackBinary(buffer *bytes.Buffer, data []byte) {
+	length := len(data)
+	switch {
+	case length <= math.MaxUint8:
+		buffer.WriteByte(0xc4)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(0xc5)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	default:
+		buffer.WriteByte(0xc6)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	}
+	buffer.Write(data)
+}
+
+func writeMsgpackContainer(buffer *bytes.Buffer, length int, fixPrefix byte, prefix16 byte, prefix32 byte) {
+	switch {
+	case length < 16:
+		buffer.WriteByte(fixPrefix | byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(prefix16)
+		buffer.Write(binary.BigEndian.AppendUint16(nil, uint16(length)))
+	default:
+		buffer.WriteByte(prefix32)
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	}
+}
+
+func encodeMsgpack(buffer *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buffer.WriteByte(MSGPACK_NIL)
+		return nil
+	}
+
+	if value.Type() == timeType {
+		writeMsgpackString(buffer, value.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			buffer.WriteByte(MSGPACK_NIL)
+			return nil
+		}
+		return encodeMsgpack(buffer, value.Elem())
+	case reflect.Bool:
+		if value.Bool() {
+			buffer.WriteByte(MSGPACK_TRUE)
+		} else {
+			buffer.WriteByte(MSGPACK_FALSE)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMsgpackInt(buffer, value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeMsgpackUint(buffer, value.Uint())
+	case reflect.Float32, reflect.Float64:
+		buffer.WriteByte(MSGPACK_FLOAT64)
+		buffer.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(value.Float())))
+	case reflect.String:
+		writeMsgpackString(buffer, value.String())
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			buffer.WriteByte(MSGPACK_NIL)
+			return nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(data), value)
+			writeMsgpackBinary(buffer, data)
+			return nil
+		}
+		writeMsgpackContainer(buffer, value.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < value.Len(); i++ {
+			err := encodeMsgpack(buffer, value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.IsNil() {
+			buffer.WriteByte(MSGPACK_NIL)
+			return nil
+		}
+		writeMsgpackContainer(buffer, value.Len(), 0x80, 0xde, 0xdf)
+		for _, key := range sortedMapKeys(value) {
+			err := encodeMsgpack(buffer, key)
+			if err != nil {
+				return err
+			}
+			err = encodeMsgpack(buffer, value.MapIndex(key))
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := []encodedField{}
+		for _, field := range encodedFields(value.Type()) {
+			if field.omitEmpty && value.FieldByIndex(field.index).IsZero() {
+				continue
+			}
+			fields = append(fields, field)
+		}
+
+		writeMsgpackContainer(buffer, len(fields), 0x80, 0xde, 0xdf)
+		for _, field := range fields {
+			writeMsgpackString(buffer, field.name)
+			err := encodeMsgpack(buffer, value.FieldByIndex(field.index))
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", value.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package http
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const CHARSET_UTF8 = "utf-8"
+
+type MediaRange struct {
+	Type    string
+	Subtype string
+	Params  map[string]string
+	Quality float64
+}
+
+func (mediaRange MediaRange) specificity() int {
+	specificity := 0
+	if mediaRange.Type != "*" {
+		specificity++
+	}
+	if mediaRange.Subtype != "*" {
+		specificity++
+	}
+	return specificity*10 + len(mediaRange.Params)
+}
+
+func (mediaRange MediaRange) matches(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	mainType, subtype, _ := strings.Cut(mediaType, "/")
+
+	if mediaRange.Type != "*" && mediaRange.Type != mainType {
+		return false
+	}
+	if mediaRange.Subtype != "*" && mediaRange.Subtype != subtype {
+		return false
+	}
+	for name, value := range mediaRange.Params {
+		declared, exists := params[name]
+		if name == "charset" || !exists {
+			continue
+		}
+		if !strings.EqualFold(declared, value) {
+			return false
+		}
+	}
+	return true
+}
+
+type CharsetRange struct {
+	Charset string
+	Quality float64
+}
+
+func ParseAccept(header string) []MediaRange {
+	ranges := []MediaRange{}
+
+	for _, element := range strings.Split(header, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(element)
+		if err != nil {
+			continue
+		}
+
+		mainType, subtype, found := strings.Cut(mediaType, "/")
+		if !found || (mainType == "*" && subtype != "*") {
+			continue
+		}
+
+		quality := parseQuality(params)
+		delete(params, "q")
+
+		ranges = append(ranges, MediaRange{
+			Type:    mainType,
+			Subtype: subtype,
+			Params:  params,
+			Quality: quality})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Quality != ranges[j].Quality {
+			return ranges[i].Quality > ranges[j].Quality
+		}
+		return ranges[i].specificity() > ranges[j].specificity()
+	})
+
+	return ranges
+}
+
+func ParseAcceptCharset(header string) []CharsetRange {
+	ranges := []CharsetRange{}
+
+	for _, element := range strings.Split(header, ",") {
+		charset, rawParams, _ := strings.Cut(strings.TrimSpace(element), ";")
+		charset = strings.ToLower(strings.TrimSpace(charset))
+		if charset == "" {
+			continue
+		}
+
+		params := map[string]string{}
+		for _, param := range strings.Split(rawParams, ";") {
+			name, value, found := strings.Cut(param, "=")
+			if found {
+				params[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			}
+		}
+
+		ranges = append(ranges, CharsetRange{Charset: charset, Quality: parseQuality(params)})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Quality > ranges[j].Quality
+	})
+
+	return ranges
+}
+
+func parseQuality(params map[string]string) float64 {
+	raw, exists := params["q"]
+	if !exists {
+		return 1
+	}
+
+	quality, err := strconv.ParseFloat(raw, 64)
+	if err != nil || quality < 0 || quality > 1 {
+		return 0
+	}
+	return quality
+}
+
+type Negotiator struct {
+	encoders []Encoder
+	charsets []string
+}
+
+func NewNegotiator(encoders ...Encoder) *Negotiator {
+	return &Negotiator{encoders: encoders, charsets: []string{CHARSET_UTF8}}
+}
+
+func DefaultNegotiator() *Negotiator {
+	return NewNegotiator(
+		JsonEncoder{},
+		TextEncoder{},
+		CborEncoder{},
+		MsgpackEncoder{},
+		XmlEncoder{})
+}
+
+func (negotiator *Negotiator) Encoders() []Encoder {
+	return negotiator.encoders
+}
+
+func (negotiator *Negotiator) Negotiate(request HttpRequest) (Encoder, string, error) {
+	charset, err := negotiator.negotiateCharset(request)
+	if err != nil {
+		return nil, "", err
+	}
+
+	accept, exists := request.Header("Accept")
+	if !exists || strings.TrimSpace(accept) == "" {
+		if len(negotiator.encoders) == 0 {
+			return nil, "", NotAcceptable("no encoders registered")
+		}
+		return negotiator.encoders[0], charset, nil
+	}
+
+	var best Encoder
+	bestQuality := 0.0
+	bestSpecificity := -1
+
+	for _, encoder := range negotiator.encoders {
+		quality, specificity := encoderQuality(ParseAccept(accept), encoder.ContentType())
+		if quality > bestQuality || (quality == bestQuality && quality > 0 && specificity > bestSpecificity) {
+			best = encoder
+			bestQuality = quality
+			bestSpecificity = specificity
+		}
+	}
+
+	if best == nil {
+		return nil, "", NotAcceptable(fmt.Sprintf("none of %s acceptable", strings.Join(negotiator.contentTypes(), ", ")))
+	}
+
+	return best, charset, nil
+}
+
+func encoderQuality(ranges []MediaRange, contentType string) (float64, int) {
+	quality := 0.0
+	specificity := -1
+
+	for _, mediaRange := range ranges {
+		if mediaRange.matches(contentType) && mediaRange.specificity() > specificity {
+			quality = mediaRange.Quality
+			specificity = mediaRange.specificity()
+		}
+	}
+
+	return quality, specificity
+}
+
+func (negotiator *Negotiator) negotiateCharset(request HttpRequest) (string, error) {
+	header, exists := request.Header("Accept-Charset")
+	if !exists || strings.TrimSpace(header) == "" {
+		return negotiator.charsets[0], nil
+	}
+
+	ranges := ParseAcceptCharset(header)
+	for _, charset := range negotiator.charsets {
+		if charsetQuality(ranges, charset) > 0 {
+			return charset, nil
+		}
+	}
+
+	return "", NotAcceptable(fmt.Sprintf("charset %s not acceptable", strings.Join(negotiator.charsets, ", ")))
+}
+
+func charsetQuality(ranges []CharsetRange, charset string) float64 {
+	wildcardQuality := 0.0
+	for _, charsetRange := range ranges {
+		if charsetRange.Charset == charset {
+			return charsetRange.Quality
+		}
+		if charsetRange.Charset == "*" {
+			wildcardQuality = charsetRange.Quality
+		}
+	}
+	return wildcardQuality
+}
+
+func (negotiator *Negotiator) contentTypes() []string {
+	contentTypes := []string{}
+	for _, encoder := range negotiator.encoders {
+		contentTypes = append(contentTypes, encoder.ContentType())
+	}
+	return contentTypes
+}
+
+func (negotiator *Negotiator) Respond(request HttpRequest, content any, statusCode int) (HttpResponse, error) {
+	encoder, charset, err := negotiator.Negotiate(request)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+
+	encoded, err := encoder.Encode(content)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+
+	contentType := encoder.ContentType()
+	if strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "charset=") {
+		contentType += "; charset=" + charset
+	}
+
+	return HttpResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type": contentType,
+			"Vary":         "Accept, Accept-Charset"},
+		Content: encoded}, nil
+}
@@ -0,0 +1,184 @@
+package http
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type negotiationPayload struct {
+	Name    string   `json:"name"`
+	Count   int      `json:"count"`
+	Skipped string   `json:"-"`
+	Note    string   `json:"note,omitempty"`
+	Tags    []string `json:"tags"`
+}
+
+func negotiationRequest(headers map[string]string) HttpRequest {
+	return HttpRequest{
+		Method:   "GET",
+		FullPath: "/",
+		Protocol: "HTTP/1.1",
+		Headers:  headers,
+		Content:  []byte{}}
+}
+
+func TestParseAccept(t *testing.T) {
+	ranges := ParseAccept("text/*;q=0.3, text/html;q=0.7, text/html;level=1, */*;q=0.5")
+
+	expected := []struct {
+		mediaType string
+		quality   float64
+	}{
+		{"text/html", 1},
+		{"text/html", 0.7},
+		{"*/*", 0.5},
+		{"text/*", 0.3},
+	}
+
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %d media ranges. got=%d", len(expected), len(ranges))
+	}
+
+	for i, exp := range expected {
+		mediaType := ranges[i].Type + "/" + ranges[i].Subtype
+		if mediaType != exp.mediaType || ranges[i].Quality != exp.quality {
+			t.Errorf("range %d is not %s;q=%v. got=%s;q=%v",
+				i, exp.mediaType, exp.quality, mediaType, ranges[i].Quality)
+		}
+	}
+
+	if ranges[0].Params["level"] != "1" {
+		t.Errorf("expected level=1 on first range. got=%v", ranges[0].Params)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	negotiator := DefaultNegotiator()
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", CONTENT_TYPE_JSON},
+		{"*/*", CONTENT_TYPE_JSON},
+		{"text/plain", CONTENT_TYPE_PLAIN},
+		{"text/*, application/json;q=0.5", CONTENT_TYPE_PLAIN},
+		{"application/*;q=0.2, application/cbor", CONTENT_TYPE_CBOR},
+		{"application/msgpack;q=0.9, application/xml", CONTENT_TYPE_XML},
+		{"*/*;q=0.1, application/json;q=0", CONTENT_TYPE_PLAIN},
+		{"application/json; charset=utf-8", CONTENT_TYPE_JSON},
+		{"text/plain; charset=utf-8", CONTENT_TYPE_PLAIN},
+		{"application/cbor; profile=compact", CONTENT_TYPE_CBOR},
+	}
+
+	for _, tt := range tests {
+		headers := map[string]string{}
+		if tt.accept != "" {
+			headers["Accept"] = tt.accept
+		}
+
+		encoder, charset, err := negotiator.Negotiate(negotiationRequest(headers))
+		if err != nil {
+			t.Errorf("negotiation for %q failed: %v", tt.accept, err)
+			continue
+		}
+		if encoder.ContentType() != tt.expected {
+			t.Errorf("negotiation for %q did not pick %s. got=%s", tt.accept, tt.expected, encoder.ContentType())
+		}
+		if charset != CHARSET_UTF8 {
+			t.Errorf("charset was not %s. got=%s", CHARSET_UTF8, charset)
+		}
+	}
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	negotiator := DefaultNegotiator()
+
+	tests := []map[string]string{
+		{"Accept": "image/png"},
+		{"Accept": "application/json;q=0, text/*;q=0, application/*;q=0"},
+		{"Accept-Charset": "iso-8859-1"},
+		{"Accept-Charset": "*;q=0"},
+	}
+
+	for _, headers := range tests {
+		_, err := negotiator.Respond(negotiationRequest(headers), "hello", 200)
+
+		var httpError HttpError
+		if !errors.As(err, &httpError) || httpError.StatusCode != 406 {
+			t.Errorf("expected 406 for %v. got=%v", headers, err)
+		}
+	}
+}
+
+func TestRespond(t *testing.T) {
+	negotiator := DefaultNegotiator()
+
+	request := negotiationRequest(map[string]string{"accept": "text/plain", "Accept-Charset": "utf-8, *;q=0.1"})
+	response, err := negotiator.Respond(request, "hello", 201)
+	if err != nil {
+		t.Fatalf("respond failed: %v", err)
+	}
+
+	if response.StatusCode != 201 {
+		t.Errorf("status code was not 201. got=%d", response.StatusCode)
+	}
+	if response.Headers["Content-Type"] != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected content type. got=%s", response.Headers["Content-Type"])
+	}
+	if response.Headers["Vary"] != "Accept, Accept-Charset" {
+		t.Errorf("unexpected vary header. got=%s", response.Headers["Vary"])
+	}
+	if string(response.Content) != "hello" {
+		t.Errorf("content was not hello. got=%s", response.Content)
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	payload := negotiationPayload{Name: "a", Count: -2, Skipped: "x", Tags: []string{"b"}}
+
+	tests := []struct {
+		encoder  Encoder
+		expected []byte
+	}{
+		{JsonEncoder{}, []byte(`{"name":"a","count":-2,"tags":["b"]}`)},
+		{CborEncoder{}, []byte{
+			0xa3,
+			0x64, 'n', 'a', 'm', 'e', 0x61, 'a',
+			0x65, 'c', 'o', 'u', 'n', 't', 0x21,
+			0x64, 't', 'a', 'g', 's', 0x81, 0x61, 'b'}},
+		{MsgpackEncoder{}, []byte{
+			0x83,
+			0xa4, 'n', 'a', 'm', 'e', 0xa1, 'a',
+			0xa5, 'c', 'o', 'u', 'n', 't', 0xfe,
+			0xa4, 't', 'a', 'g', 's', 0x91, 0xa1, 'b'}},
+	}
+
+	for _, tt := range tests {
+		encoded, err := tt.encoder.Encode(payload)
+		if err != nil {
+			t.Errorf("%s encoding failed: %v", tt.encoder.ContentType(), err)
+			continue
+		}
+		if !slices.Equal(encoded, tt.expected) {
+			t.Errorf("unexpected %s encoding.\nexpected=%x\ngot=%x", tt.encoder.ContentType(), tt.expected, encoded)
+		}
+	}
+}
+
+func TestEncodeCborMapsAndNumbers(t *testing.T) {
+	encoded, err := CborEncoder{}.Encode(map[string]any{"b": 1000, "a": []byte{1}, "c": nil})
+	if err != nil {
+		t.Fatalf("cbor encoding failed: %v", err)
+	}
+
+	expected := []byte{
+		0xa3,
+		0x61, 'a', 0x41, 0x01,
+		0x61, 'b', 0x19, 0x03, 0xe8,
+		0x61, 'c', 0xf6}
+	if !slices.Equal(encoded, expected) {
+		t.Errorf("unexpected cbor encoding.\nexpected=%x\ngot=%x", expected, encoded)
+	}
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const CONTENT_TYPE_PROBLEM_JSON = "application/problem+json"
+const PROBLEM_TYPE_DEFAULT = "about:blank"
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+func NewProblem(statusCode int, detail string) Problem {
+	title, exists := reasonPhrases[statusCode]
+	if !exists {
+		title = fmt.Sprintf("%d", statusCode)
+	}
+
+	return Problem{
+		Type:   PROBLEM_TYPE_DEFAULT,
+		Title:  title,
+		Status: statusCode,
+		Detail: detail}
+}
+
+func (problem Problem) Error() string {
+	return fmt.Sprintf("%d: %s", problem.Status, problem.Detail)
+}
+
+func (problem Problem) ToResponse() HttpResponse {
+	content, err := json.Marshal(problem)
+	if err != nil {
+		content = []byte{}
+	}
+
+	return HttpResponse{
+		StatusCode: problem.Status,
+		Headers:    map[string]string{"Content-Type": CONTENT_TYPE_PROBLEM_JSON},
+		Content:    content}
+}
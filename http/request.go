@@ -3,6 +3,8 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"strconv"
@@ -10,11 +12,30 @@ import (
 )
 
 type HttpRequest struct {
-	Method   string
-	FullPath string
-	Protocol string
-	Headers  map[string]string
-	Content  []byte
+	Method     string
+	FullPath   string
+	Protocol   string
+	Headers    map[string]string
+	Content    []byte
+	RemoteAddr string
+	ClientIP   string
+	Scheme     string
+	Host       string
+	PathParams map[string]string
+	TLS        *tls.ConnectionState
+	ctx        context.Context
+}
+
+func (request HttpRequest) Context() context.Context {
+	if request.ctx == nil {
+		return context.Background()
+	}
+	return request.ctx
+}
+
+func (request HttpRequest) WithContext(ctx context.Context) HttpRequest {
+	request.ctx = ctx
+	return request
 }
 
 func (request HttpRequest) Path() string {
@@ -22,6 +43,37 @@ func (request HttpRequest) Path() string {
 	return cleanPath
 }
 
+func (request HttpRequest) PathParam(name string) string {
+	return request.PathParams[name]
+}
+
+func (request HttpRequest) Header(name string) (string, bool) {
+	return lookupHeader(request.Headers, name)
+}
+
+func lookupHeader(headers map[string]string, name string) (string, bool) {
+	value, exists := headers[name]
+	if exists {
+		return value, true
+	}
+
+	for headerName, headerValue := range headers {
+		if strings.EqualFold(headerName, name) {
+			return headerValue, true
+		}
+	}
+
+	return "", false
+}
+
+func DeleteHeader(headers map[string]string, name string) {
+	for headerName := range headers {
+		if strings.EqualFold(headerName, name) {
+			delete(headers, headerName)
+		}
+	}
+}
+
 func (request HttpRequest) GetQueryParams() map[string]string {
 	_, paramString, found := strings.Cut(request.FullPath, "?")
 	if !found {
@@ -67,6 +119,35 @@ func (request HttpRequest) String() string {
 	return buffer.String()
 }
 
+func (request HttpRequest) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	protocol := request.Protocol
+	if protocol == "" {
+		protocol = "HTTP/1.1"
+	}
+	buffer.WriteString(fmt.Sprintf("%s %s %s", request.Method, request.FullPath, protocol))
+	buffer.WriteString(CLRF)
+
+	for headerName, headerValue := range request.Headers {
+		if strings.EqualFold(headerName, "Content-Length") || strings.EqualFold(headerName, "Transfer-Encoding") {
+			continue
+		}
+		buffer.WriteString(fmt.Sprintf("%s: %s", headerName, headerValue))
+		buffer.WriteString(CLRF)
+	}
+
+	if len(request.Content) > 0 || request.Method == "POST" || request.Method == "PUT" || request.Method == "PATCH" {
+		buffer.WriteString(fmt.Sprintf("Content-Length: %d", len(request.Content)))
+		buffer.WriteString(CLRF)
+	}
+
+	buffer.WriteString(CLRF)
+	buffer.Write(request.Content)
+
+	return buffer.Bytes()
+}
+
 func parseRequestMethod(reader *bufio.Reader) (string, error) {
 	method, err := reader.ReadString(' ')
 	if err != nil {
@@ -124,7 +205,7 @@ func parseRequestProtocol(reader *bufio.Reader) (string, error) {
 
 	protocol = strings.TrimSpace(protocol)
 
-	if protocol != "HTTP/1.1" {
+	if protocol != "HTTP/1.1" && protocol != "HTTP/1.0" {
 		return "", fmt.Errorf("protocol [%s] is not supported", protocol)
 	}
 
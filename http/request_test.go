@@ -27,7 +27,7 @@ hello world
 
 	requestReader := strings.NewReader(rawRequest)
 
-	request, err := ParseHttpRequest(requestReader)
+	request, _, err := ParseHttpRequest(requestReader)
 
 	if err != nil {
 		t.Fatalf("failed to parse http request: %v", err)
@@ -88,7 +88,7 @@ Content-Type: text/plain
 
 	requestReader := strings.NewReader(rawRequest)
 
-	request, err := ParseHttpRequest(requestReader)
+	request, _, err := ParseHttpRequest(requestReader)
 
 	if err != nil {
 		t.Fatalf("failed to parse http request: %v", err)
@@ -1,9 +1,13 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 )
 
 const CLRF = "\r\n"
@@ -30,6 +34,7 @@ var reasonPhrases = map[int]string{
 	304: "Not Modified",
 	305: "Use Proxy",
 	307: "Temporary Redirect",
+	308: "Permanent Redirect",
 
 	400: "Bad Request",
 	401: "Unauthorized",
@@ -49,7 +54,9 @@ var reasonPhrases = map[int]string{
 	415: "Unsupported Media Type",
 	416: "Range Not Satisfiable",
 	417: "Expectation Failed",
+	422: "Unprocessable Content",
 	426: "Upgrade Required",
+	499: "Client Closed Request",
 
 	500: "Internal Server Error",
 	501: "Not Implemented",
@@ -88,6 +95,10 @@ func NewJsonResponse(content interface{}, statusCode int) (HttpResponse, error)
 	return response, nil
 }
 
+func (response HttpResponse) Header(name string) (string, bool) {
+	return lookupHeader(response.Headers, name)
+}
+
 func (response HttpResponse) Serialize() []byte {
 	var buffer bytes.Buffer
 
@@ -119,3 +130,113 @@ func GetStatus(statusCode int) string {
 	}
 	return fmt.Sprintf("%d %s", statusCode, phrase)
 }
+
+func parseResponseStatusLine(reader *bufio.Reader) (string, int, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", 0, err
+	}
+
+	protocol, status, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if protocol != "HTTP/1.1" && protocol != "HTTP/1.0" {
+		return "", 0, fmt.Errorf("protocol [%s] is not supported", protocol)
+	}
+
+	rawStatusCode, _, _ := strings.Cut(status, " ")
+	statusCode, err := strconv.Atoi(rawStatusCode)
+	if err != nil || statusCode < 100 || statusCode > 999 {
+		return "", 0, fmt.Errorf("status code [%s] is invalid", rawStatusCode)
+	}
+
+	return protocol, statusCode, nil
+}
+
+func responseHasContent(method string, statusCode int) bool {
+	if method == "HEAD" {
+		return false
+	}
+	if method == "CONNECT" && statusCode >= 200 && statusCode < 300 {
+		return false
+	}
+	if statusCode < 200 || statusCode == 204 || statusCode == 304 {
+		return false
+	}
+	return true
+}
+
+func ParseHttpResponse(rawReader io.Reader) (HttpResponse, *bufio.Reader, error) {
+	return ParseHttpResponseToMethod(rawReader, "GET")
+}
+
+func ParseHttpResponseToMethod(rawReader io.Reader, method string) (HttpResponse, *bufio.Reader, error) {
+	bufReader := bufio.NewReader(rawReader)
+	response, _, err := parseHttpResponse(bufReader, method, DEFAULT_MAX_RESPONSE_SIZE)
+	return response, bufReader, err
+}
+
+func parseHttpResponse(reader *bufio.Reader, method string, maxSize int64) (HttpResponse, bool, error) {
+	response := HttpResponse{}
+
+	protocol, statusCode, err := parseResponseStatusLine(reader)
+	if err != nil {
+		return response, false, fmt.Errorf("failed to parse response status line: %w", err)
+	}
+
+	headers, err := parseRequestHeaders(reader)
+	if err != nil {
+		return response, false, fmt.Errorf("failed to parse response headers: %w", err)
+	}
+
+	response.StatusCode = statusCode
+	response.Headers = headers
+	response.Content = []byte{}
+
+	connection, _ := lookupHeader(headers, "Connection")
+	reusable := protocol == "HTTP/1.1" && !strings.EqualFold(connection, "close")
+
+	if !responseHasContent(method, statusCode) {
+		return response, reusable, nil
+	}
+
+	transferEncoding, chunked := lookupHeader(headers, "Transfer-Encoding")
+	if chunked && strings.EqualFold(strings.TrimSpace(transferEncoding), "chunked") {
+		content, err := readChunkedContent(reader, headers, maxSize)
+		if err != nil {
+			return response, false, fmt.Errorf("failed to read chunked response content: %w", err)
+		}
+		DeleteHeader(headers, "Transfer-Encoding")
+		headers["Content-Length"] = strconv.Itoa(len(content))
+		response.Content = content
+		return response, reusable, nil
+	}
+
+	rawContentLength, exists := lookupHeader(headers, "Content-Length")
+	if !exists {
+		content, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+		if err != nil {
+			return response, false, fmt.Errorf("failed to read response content: %w", err)
+		}
+		if int64(len(content)) > maxSize {
+			return response, false, fmt.Errorf("%w: content exceeds %d bytes", ErrResponseTooLarge, maxSize)
+		}
+		response.Content = content
+		return response, false, nil
+	}
+
+	contentLength, err := strconv.ParseInt(rawContentLength, 10, 64)
+	if err != nil || contentLength < 0 {
+		return response, false, fmt.Errorf("invalid Content-Length: %s", rawContentLength)
+	}
+	if contentLength > maxSize {
+		return response, false, fmt.Errorf("%w: Content-Length %d exceeds %d bytes", ErrResponseTooLarge, contentLength, maxSize)
+	}
+
+	content := make([]byte, contentLength)
+	_, err = io.ReadFull(reader, content)
+	if err != nil {
+		return response, false, fmt.Errorf("failed to read response content: %w", err)
+	}
+	response.Content = content
+
+	return response, reusable, nil
+}
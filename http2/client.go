@@ -0,0 +1,186 @@
+package http2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func NewClientConn(conn net.Conn, config Config) (*Conn, error) {
+	c := newConn(conn, nil, config, true)
+	c.nextStreamID = 1
+
+	_, err := conn.Write([]byte(CLIENT_PREFACE))
+	if err != nil {
+		return nil, err
+	}
+	err = c.writeSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	go c.run()
+	return c, nil
+}
+
+func (c *Conn) OpenStream(ctx context.Context, request http.HttpRequest, endStream bool) (*Stream, error) {
+	return c.openStream(request, requestFields(request, ""), endStream)
+}
+
+func (c *Conn) OpenConnectStream(ctx context.Context, request http.HttpRequest, protocol string) (*Stream, error) {
+	select {
+	case <-c.settingsReceived:
+	case <-c.done:
+		return nil, ErrConnClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !c.PeerSupportsConnectProtocol() {
+		return nil, ErrConnectProtocolDisabled
+	}
+
+	request.Method = "CONNECT"
+	stream, err := c.openStream(request, requestFields(request, protocol), false)
+	if err != nil {
+		return nil, err
+	}
+	stream.protocol = protocol
+	return stream, nil
+}
+
+func (c *Conn) openStream(request http.HttpRequest, fields []HeaderField, endStream bool) (*Stream, error) {
+	c.writeMutex.Lock()
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		c.writeMutex.Unlock()
+		return nil, ErrConnClosed
+	}
+	if c.goingAway {
+		c.mutex.Unlock()
+		c.writeMutex.Unlock()
+		return nil, ErrGoAway
+	}
+	stream := newStream(c, c.nextStreamID)
+	c.nextStreamID += 2
+	c.addStream(stream)
+	stream.request = request
+	stream.headersSent = true
+	stream.localClosed = endStream
+	c.mutex.Unlock()
+
+	err := c.writeHeadersLocked(stream.id, fields, endStream)
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.removeStream(stream.id)
+		stream.abort(err)
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (stream *Stream) ReadResponse(ctx context.Context) (http.HttpResponse, error) {
+	select {
+	case <-stream.responded:
+	case <-ctx.Done():
+		stream.Close()
+		return http.HttpResponse{}, ctx.Err()
+	}
+
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+	if stream.response.StatusCode == 0 {
+		return http.HttpResponse{}, stream.err
+	}
+	return stream.response, nil
+}
+
+func (c *Conn) RoundTrip(ctx context.Context, request http.HttpRequest) (http.HttpResponse, error) {
+	stream, err := c.OpenStream(ctx, request, len(request.Content) == 0)
+	if err != nil {
+		return http.HttpResponse{}, err
+	}
+	defer stream.Close()
+	stop := context.AfterFunc(ctx, func() { stream.Close() })
+	defer stop()
+
+	var writeErr error
+	if len(request.Content) > 0 {
+		_, writeErr = stream.writeData(request.Content, true)
+	}
+
+	response, err := stream.ReadResponse(ctx)
+	if err == nil {
+		response.Content, err = io.ReadAll(stream)
+	}
+	if ctx.Err() != nil {
+		return http.HttpResponse{}, ctx.Err()
+	}
+	if err != nil && writeErr != nil {
+		return http.HttpResponse{}, writeErr
+	}
+	if err != nil {
+		return http.HttpResponse{}, err
+	}
+	return response, nil
+}
+
+func requestFields(request http.HttpRequest, protocol string) []HeaderField {
+	scheme := request.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	authority, exists := request.Header("Host")
+	if !exists {
+		authority = request.Host
+	}
+
+	fields := []HeaderField{{Name: ":method", Value: request.Method}}
+	if protocol != "" {
+		fields = append(fields, HeaderField{Name: ":protocol", Value: protocol})
+	}
+	if request.Method == "CONNECT" && protocol == "" {
+		fields = append(fields, HeaderField{Name: ":authority", Value: authority})
+	} else {
+		fields = append(fields,
+			HeaderField{Name: ":scheme", Value: scheme},
+			HeaderField{Name: ":authority", Value: authority},
+			HeaderField{Name: ":path", Value: request.FullPath})
+	}
+
+	fields = appendHeaderFields(fields, request.Headers)
+	if len(request.Content) > 0 {
+		fields = append(fields, HeaderField{Name: "content-length", Value: strconv.Itoa(len(request.Content))})
+	}
+	return fields
+}
+
+func responseFromFields(fields []HeaderField) (http.HttpResponse, error) {
+	response := http.HttpResponse{Headers: map[string]string{}}
+	for _, field := range fields {
+		if field.Name == ":status" {
+			statusCode, err := strconv.Atoi(field.Value)
+			if err != nil || statusCode < 100 || statusCode > 999 {
+				return response, fmt.Errorf("invalid :status %s", field.Value)
+			}
+			response.StatusCode = statusCode
+			continue
+		}
+		if strings.HasPrefix(field.Name, ":") {
+			return response, fmt.Errorf("unknown pseudo header %s", field.Name)
+		}
+		response.Headers[textproto.CanonicalMIMEHeaderKey(field.Name)] = field.Value
+	}
+
+	if response.StatusCode == 0 {
+		return response, errors.New("missing :status pseudo header")
+	}
+	return response, nil
+}
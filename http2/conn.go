@@ -0,0 +1,715 @@
+package http2
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const DEFAULT_MAX_CONCURRENT_STREAMS = 250
+const DEFAULT_INITIAL_WINDOW_SIZE = 1 << 20
+const DEFAULT_MAX_HEADER_LIST_SIZE = 1 << 20
+const DEFAULT_MAX_REQUEST_SIZE = 10 << 20
+const DEFAULT_IDLE_TIMEOUT = 5 * time.Minute
+
+var ErrConnClosed = errors.New("http2 connection closed")
+var ErrGoAway = errors.New("http2 connection is going away")
+var ErrConnectProtocolDisabled = errors.New("http2 peer does not support extended CONNECT")
+
+type Config struct {
+	MaxConcurrentStreams  uint32
+	InitialWindowSize     uint32
+	MaxFrameSize          uint32
+	MaxHeaderListSize     uint32
+	MaxRequestSize        int
+	IdleTimeout           time.Duration
+	EnableConnectProtocol bool
+}
+
+func (config Config) WithDefaults() Config {
+	if config.MaxConcurrentStreams == 0 {
+		config.MaxConcurrentStreams = DEFAULT_MAX_CONCURRENT_STREAMS
+	}
+	if config.InitialWindowSize == 0 {
+		config.InitialWindowSize = DEFAULT_INITIAL_WINDOW_SIZE
+	}
+	if config.MaxFrameSize == 0 {
+		config.MaxFrameSize = MIN_FRAME_SIZE
+	}
+	if config.MaxHeaderListSize == 0 {
+		config.MaxHeaderListSize = DEFAULT_MAX_HEADER_LIST_SIZE
+	}
+	if config.MaxRequestSize == 0 {
+		config.MaxRequestSize = DEFAULT_MAX_REQUEST_SIZE
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+	return config
+}
+
+func (config Config) Validate() error {
+	config = config.WithDefaults()
+	if config.InitialWindowSize < DEFAULT_WINDOW_SIZE || config.InitialWindowSize > MAX_WINDOW_SIZE {
+		return fmt.Errorf("http2 initial window size %d must be between %d and %d", config.InitialWindowSize, DEFAULT_WINDOW_SIZE, MAX_WINDOW_SIZE)
+	}
+	if config.MaxFrameSize < MIN_FRAME_SIZE || config.MaxFrameSize > MAX_FRAME_SIZE {
+		return fmt.Errorf("http2 max frame size %d must be between %d and %d", config.MaxFrameSize, MIN_FRAME_SIZE, MAX_FRAME_SIZE)
+	}
+	return nil
+}
+
+type Handler func(stream *Stream, request http.HttpRequest)
+
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	data      []byte
+}
+
+type Conn struct {
+	conn     net.Conn
+	reader   io.Reader
+	config   Config
+	isClient bool
+	handler  Handler
+	ctx      context.Context
+	cancel   context.CancelFunc
+	decoder  *Decoder
+	pending  *headerBlock
+
+	writeMutex sync.Mutex
+
+	mutex               sync.Mutex
+	streams             map[uint32]*Stream
+	lastPeerStreamID    uint32
+	activeHandlers      int
+	nextStreamID        uint32
+	sendWindow          int
+	receiveUnacked      int
+	peerMaxFrameSize    int
+	peerInitialWindow   int
+	peerConnectProtocol bool
+	goingAway           bool
+	closed              bool
+	err                 error
+	idleTimer           *time.Timer
+	settingsReceived    chan struct{}
+	receiveSettings     sync.Once
+	done                chan struct{}
+}
+
+func newConn(conn net.Conn, reader io.Reader, config Config, isClient bool) *Conn {
+	if reader == nil {
+		reader = conn
+	}
+	config = config.WithDefaults()
+
+	c := &Conn{
+		conn:              conn,
+		reader:            reader,
+		config:            config,
+		isClient:          isClient,
+		decoder:           NewDecoder(DEFAULT_HEADER_TABLE_SIZE, int(config.MaxHeaderListSize)),
+		streams:           map[uint32]*Stream{},
+		sendWindow:        DEFAULT_WINDOW_SIZE,
+		peerMaxFrameSize:  MIN_FRAME_SIZE,
+		peerInitialWindow: DEFAULT_WINDOW_SIZE,
+		settingsReceived:  make(chan struct{}),
+		done:              make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Conn) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+func (c *Conn) NumStreams() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.streams)
+}
+
+func (c *Conn) PeerSupportsConnectProtocol() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peerConnectProtocol
+}
+
+func (c *Conn) Close() error {
+	c.close(ErrConnClosed)
+	return nil
+}
+
+func (c *Conn) Shutdown() {
+	c.mutex.Lock()
+	if c.goingAway || c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.goingAway = true
+	lastStreamID := c.lastPeerStreamID
+	idle := len(c.streams) == 0
+	c.mutex.Unlock()
+
+	c.writeFrame(goAwayFrame(lastStreamID, ERROR_NO_ERROR, ""))
+	if idle {
+		c.close(nil)
+	}
+}
+
+func (c *Conn) writeSettings() error {
+	settings := SettingsFrame(
+		Setting{ID: SETTINGS_ENABLE_PUSH, Value: 0},
+		Setting{ID: SETTINGS_MAX_CONCURRENT_STREAMS, Value: c.config.MaxConcurrentStreams},
+		Setting{ID: SETTINGS_INITIAL_WINDOW_SIZE, Value: c.config.InitialWindowSize},
+		Setting{ID: SETTINGS_MAX_FRAME_SIZE, Value: c.config.MaxFrameSize},
+		Setting{ID: SETTINGS_MAX_HEADER_LIST_SIZE, Value: c.config.MaxHeaderListSize})
+	if c.config.EnableConnectProtocol {
+		settings.Payload = append(settings.Payload, SettingsFrame(Setting{ID: SETTINGS_ENABLE_CONNECT_PROTOCOL, Value: 1}).Payload...)
+	}
+	if c.isClient {
+		settings = SettingsFrame(
+			Setting{ID: SETTINGS_ENABLE_PUSH, Value: 0},
+			Setting{ID: SETTINGS_INITIAL_WINDOW_SIZE, Value: c.config.InitialWindowSize},
+			Setting{ID: SETTINGS_MAX_FRAME_SIZE, Value: c.config.MaxFrameSize},
+			Setting{ID: SETTINGS_MAX_HEADER_LIST_SIZE, Value: c.config.MaxHeaderListSize})
+	}
+
+	err := c.writeFrame(settings)
+	if err != nil {
+		return err
+	}
+	if c.config.InitialWindowSize > DEFAULT_WINDOW_SIZE {
+		return c.writeFrame(windowUpdateFrame(0, c.config.InitialWindowSize-DEFAULT_WINDOW_SIZE))
+	}
+	return nil
+}
+
+func (c *Conn) readLoop() error {
+	first := true
+	for {
+		frame, err := ReadFrame(c.reader, c.config.MaxFrameSize)
+		if err != nil {
+			return err
+		}
+
+		if first && frame.Type != FRAME_SETTINGS {
+			return ConnectionError{Code: ERROR_PROTOCOL, Reason: "first frame is not SETTINGS"}
+		}
+		first = false
+
+		if c.pending != nil && (frame.Type != FRAME_CONTINUATION || frame.StreamID != c.pending.streamID) {
+			return ConnectionError{Code: ERROR_PROTOCOL, Reason: "expected CONTINUATION frame"}
+		}
+
+		err = c.handleFrame(frame)
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			c.resetStream(streamErr.StreamID, streamErr.Code, streamErr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Conn) run() {
+	err := c.readLoop()
+
+	var connErr ConnectionError
+	if errors.As(err, &connErr) {
+		c.mutex.Lock()
+		lastStreamID := c.lastPeerStreamID
+		c.mutex.Unlock()
+		c.writeFrame(goAwayFrame(lastStreamID, connErr.Code, connErr.Reason))
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	c.close(err)
+}
+
+func (c *Conn) handleFrame(frame Frame) error {
+	switch frame.Type {
+	case FRAME_DATA:
+		return c.handleData(frame)
+	case FRAME_HEADERS:
+		return c.handleHeaders(frame)
+	case FRAME_CONTINUATION:
+		return c.handleContinuation(frame)
+	case FRAME_PRIORITY:
+		if frame.StreamID == 0 {
+			return ConnectionError{Code: ERROR_PROTOCOL, Reason: "PRIORITY frame on stream 0"}
+		}
+		if len(frame.Payload) != 5 {
+			return StreamError{StreamID: frame.StreamID, Code: ERROR_FRAME_SIZE}
+		}
+		return nil
+	case FRAME_RST_STREAM:
+		return c.handleReset(frame)
+	case FRAME_SETTINGS:
+		return c.handleSettings(frame)
+	case FRAME_PUSH_PROMISE:
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "PUSH_PROMISE is not enabled"}
+	case FRAME_PING:
+		return c.handlePing(frame)
+	case FRAME_GOAWAY:
+		return c.handleGoAway(frame)
+	case FRAME_WINDOW_UPDATE:
+		return c.handleWindowUpdate(frame)
+	}
+	return nil
+}
+
+func (c *Conn) isPeerStream(streamID uint32) bool {
+	return (streamID%2 == 1) != c.isClient
+}
+
+func (c *Conn) isIdleStream(streamID uint32) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.isPeerStream(streamID) {
+		return streamID > c.lastPeerStreamID
+	}
+	return streamID >= c.nextStreamID || c.nextStreamID == 0
+}
+
+func (c *Conn) stream(streamID uint32) *Stream {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.streams[streamID]
+}
+
+func (c *Conn) handleData(frame Frame) error {
+	if frame.StreamID == 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "DATA frame on stream 0"}
+	}
+	data, err := removePadding(frame)
+	if err != nil {
+		return err
+	}
+
+	length := len(frame.Payload)
+	c.receiveUnacked += length
+	if c.receiveUnacked > int(c.config.InitialWindowSize) {
+		return ConnectionError{Code: ERROR_FLOW_CONTROL, Reason: "peer exceeded the connection window"}
+	}
+	if c.receiveUnacked >= int(c.config.InitialWindowSize)/2 {
+		c.writeFrame(windowUpdateFrame(0, uint32(c.receiveUnacked)))
+		c.receiveUnacked = 0
+	}
+
+	stream := c.stream(frame.StreamID)
+	if stream == nil {
+		if c.isIdleStream(frame.StreamID) {
+			return ConnectionError{Code: ERROR_PROTOCOL, Reason: "DATA frame on idle stream"}
+		}
+		return StreamError{StreamID: frame.StreamID, Code: ERROR_STREAM_CLOSED}
+	}
+
+	return stream.receiveData(data, length-len(data), frame.Has(FLAG_END_STREAM))
+}
+
+func (c *Conn) handleHeaders(frame Frame) error {
+	if frame.StreamID == 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "HEADERS frame on stream 0"}
+	}
+	block, err := removePadding(frame)
+	if err != nil {
+		return err
+	}
+	if frame.Has(FLAG_PRIORITY) {
+		if len(block) < 5 {
+			return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "HEADERS frame too short for priority"}
+		}
+		block = block[5:]
+	}
+
+	pending := &headerBlock{
+		streamID:  frame.StreamID,
+		endStream: frame.Has(FLAG_END_STREAM),
+		data:      append([]byte{}, block...)}
+	if !frame.Has(FLAG_END_HEADERS) {
+		c.pending = pending
+		return nil
+	}
+	return c.handleHeaderBlock(pending)
+}
+
+func (c *Conn) handleContinuation(frame Frame) error {
+	if c.pending == nil {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "unexpected CONTINUATION frame"}
+	}
+
+	c.pending.data = append(c.pending.data, frame.Payload...)
+	if len(c.pending.data) > 2*int(c.config.MaxHeaderListSize) {
+		return ConnectionError{Code: ERROR_ENHANCE_YOUR_CALM, Reason: "header block too large"}
+	}
+	if !frame.Has(FLAG_END_HEADERS) {
+		return nil
+	}
+
+	pending := c.pending
+	c.pending = nil
+	return c.handleHeaderBlock(pending)
+}
+
+func (c *Conn) handleHeaderBlock(block *headerBlock) error {
+	fields, err := c.decoder.Decode(block.data)
+	tooLarge := errors.Is(err, ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return err
+	}
+
+	stream := c.stream(block.streamID)
+	if stream != nil {
+		return stream.receiveHeaders(fields, block.endStream)
+	}
+
+	if !c.isPeerStream(block.streamID) {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: fmt.Sprintf("HEADERS on unexpected stream %d", block.streamID)}
+	}
+	if c.isClient {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "server initiated streams are not supported"}
+	}
+
+	c.mutex.Lock()
+	if block.streamID <= c.lastPeerStreamID {
+		c.mutex.Unlock()
+		return ConnectionError{Code: ERROR_STREAM_CLOSED, Reason: fmt.Sprintf("HEADERS on closed stream %d", block.streamID)}
+	}
+	c.lastPeerStreamID = block.streamID
+	if c.goingAway || c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	if len(c.streams) >= int(c.config.MaxConcurrentStreams) || c.activeHandlers >= int(c.config.MaxConcurrentStreams) {
+		c.mutex.Unlock()
+		return StreamError{StreamID: block.streamID, Code: ERROR_REFUSED_STREAM}
+	}
+	stream = newStream(c, block.streamID)
+	c.addStream(stream)
+	c.mutex.Unlock()
+
+	if tooLarge {
+		stream.remoteClosed = block.endStream
+		stream.dispatched = true
+		return stream.reject(431)
+	}
+
+	request, protocol, err := requestFromFields(fields, c.config.EnableConnectProtocol)
+	if err != nil {
+		return StreamError{StreamID: block.streamID, Code: ERROR_PROTOCOL}
+	}
+	stream.protocol = protocol
+	return stream.receiveRequest(request, block.endStream)
+}
+
+func (c *Conn) handleReset(frame Frame) error {
+	if frame.StreamID == 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "RST_STREAM frame on stream 0"}
+	}
+	if len(frame.Payload) != 4 {
+		return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "RST_STREAM frame must be 4 bytes"}
+	}
+	if c.isIdleStream(frame.StreamID) {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "RST_STREAM frame on idle stream"}
+	}
+
+	stream := c.stream(frame.StreamID)
+	if stream != nil {
+		code := binary.BigEndian.Uint32(frame.Payload)
+		c.removeStream(stream.id)
+		stream.abort(StreamError{StreamID: stream.id, Code: code})
+	}
+	return nil
+}
+
+func (c *Conn) handleSettings(frame Frame) error {
+	if frame.StreamID != 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "SETTINGS frame on a stream"}
+	}
+	if frame.Has(FLAG_ACK) {
+		if len(frame.Payload) != 0 {
+			return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "SETTINGS acknowledgement with payload"}
+		}
+		return nil
+	}
+
+	settings, err := ParseSettings(frame.Payload)
+	if err != nil {
+		return err
+	}
+	err = c.applySettings(settings)
+	if err != nil {
+		return err
+	}
+	c.receiveSettings.Do(func() { close(c.settingsReceived) })
+	return c.writeFrame(Frame{Type: FRAME_SETTINGS, Flags: FLAG_ACK})
+}
+
+func (c *Conn) applySettings(settings []Setting) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, setting := range settings {
+		switch setting.ID {
+		case SETTINGS_ENABLE_PUSH:
+			if setting.Value > 1 {
+				return ConnectionError{Code: ERROR_PROTOCOL, Reason: "invalid ENABLE_PUSH setting"}
+			}
+		case SETTINGS_INITIAL_WINDOW_SIZE:
+			if setting.Value > MAX_WINDOW_SIZE {
+				return ConnectionError{Code: ERROR_FLOW_CONTROL, Reason: "invalid INITIAL_WINDOW_SIZE setting"}
+			}
+			delta := int(setting.Value) - c.peerInitialWindow
+			c.peerInitialWindow = int(setting.Value)
+			for _, stream := range c.streams {
+				stream.sendWindow += delta
+				notify(stream.writable)
+			}
+		case SETTINGS_MAX_FRAME_SIZE:
+			if setting.Value < MIN_FRAME_SIZE || setting.Value > MAX_FRAME_SIZE {
+				return ConnectionError{Code: ERROR_PROTOCOL, Reason: "invalid MAX_FRAME_SIZE setting"}
+			}
+			c.peerMaxFrameSize = int(setting.Value)
+		case SETTINGS_ENABLE_CONNECT_PROTOCOL:
+			if setting.Value > 1 {
+				return ConnectionError{Code: ERROR_PROTOCOL, Reason: "invalid ENABLE_CONNECT_PROTOCOL setting"}
+			}
+			c.peerConnectProtocol = setting.Value == 1
+		}
+	}
+	return nil
+}
+
+func (c *Conn) handlePing(frame Frame) error {
+	if frame.StreamID != 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "PING frame on a stream"}
+	}
+	if len(frame.Payload) != 8 {
+		return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "PING frame must be 8 bytes"}
+	}
+	if frame.Has(FLAG_ACK) {
+		return nil
+	}
+	return c.writeFrame(Frame{Type: FRAME_PING, Flags: FLAG_ACK, Payload: frame.Payload})
+}
+
+func (c *Conn) handleGoAway(frame Frame) error {
+	if frame.StreamID != 0 {
+		return ConnectionError{Code: ERROR_PROTOCOL, Reason: "GOAWAY frame on a stream"}
+	}
+	if len(frame.Payload) < 8 {
+		return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "GOAWAY frame too short"}
+	}
+	lastStreamID := binary.BigEndian.Uint32(frame.Payload) & MAX_WINDOW_SIZE
+
+	c.mutex.Lock()
+	c.goingAway = true
+	abandoned := []*Stream{}
+	for id, stream := range c.streams {
+		if !c.isPeerStream(id) && id > lastStreamID {
+			delete(c.streams, id)
+			abandoned = append(abandoned, stream)
+		}
+	}
+	idle := len(c.streams) == 0
+	c.mutex.Unlock()
+
+	for _, stream := range abandoned {
+		stream.abort(ErrGoAway)
+	}
+	if idle {
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *Conn) handleWindowUpdate(frame Frame) error {
+	if len(frame.Payload) != 4 {
+		return ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "WINDOW_UPDATE frame must be 4 bytes"}
+	}
+	increment := int(binary.BigEndian.Uint32(frame.Payload) & MAX_WINDOW_SIZE)
+
+	if frame.StreamID == 0 {
+		if increment == 0 {
+			return ConnectionError{Code: ERROR_PROTOCOL, Reason: "WINDOW_UPDATE with zero increment"}
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.sendWindow+increment > MAX_WINDOW_SIZE {
+			return ConnectionError{Code: ERROR_FLOW_CONTROL, Reason: "connection window overflow"}
+		}
+		c.sendWindow += increment
+		for _, stream := range c.streams {
+			notify(stream.writable)
+		}
+		return nil
+	}
+
+	if increment == 0 {
+		return StreamError{StreamID: frame.StreamID, Code: ERROR_PROTOCOL}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stream := c.streams[frame.StreamID]
+	if stream == nil {
+		return nil
+	}
+	if stream.sendWindow+increment > MAX_WINDOW_SIZE {
+		return StreamError{StreamID: frame.StreamID, Code: ERROR_FLOW_CONTROL}
+	}
+	stream.sendWindow += increment
+	notify(stream.writable)
+	return nil
+}
+
+func (c *Conn) addStream(stream *Stream) {
+	c.streams[stream.id] = stream
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+func (c *Conn) removeStream(streamID uint32) {
+	c.mutex.Lock()
+	_, exists := c.streams[streamID]
+	delete(c.streams, streamID)
+	idle := exists && len(c.streams) == 0
+	finished := idle && c.goingAway
+	if idle && c.idleTimer != nil {
+		c.idleTimer.Reset(c.config.IdleTimeout)
+	}
+	c.mutex.Unlock()
+
+	if finished && !c.isClient {
+		c.close(nil)
+	}
+}
+
+func (c *Conn) startHandler() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.activeHandlers >= int(c.config.MaxConcurrentStreams) {
+		return false
+	}
+	c.activeHandlers++
+	return true
+}
+
+func (c *Conn) endHandler() {
+	c.mutex.Lock()
+	c.activeHandlers--
+	c.mutex.Unlock()
+}
+
+func (c *Conn) onIdle() {
+	if c.NumStreams() == 0 {
+		c.Shutdown()
+	}
+}
+
+func (c *Conn) resetStream(streamID uint32, code uint32, err error) {
+	stream := c.stream(streamID)
+	c.removeStream(streamID)
+	if stream != nil {
+		stream.abort(err)
+	}
+	c.writeFrame(resetFrame(streamID, code))
+}
+
+func (c *Conn) close(err error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	c.err = err
+	streams := c.streams
+	c.streams = map[uint32]*Stream{}
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	close(c.done)
+	c.mutex.Unlock()
+
+	if err == nil {
+		err = ErrConnClosed
+	}
+	for _, stream := range streams {
+		stream.abort(err)
+	}
+	c.cancel()
+	c.conn.Close()
+}
+
+func (c *Conn) writeFrame(frame Frame) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeLocked(frame)
+}
+
+func (c *Conn) writeLocked(frame Frame) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+	_, err := c.conn.Write(frame.Serialize())
+	return err
+}
+
+func (c *Conn) writeHeaders(streamID uint32, fields []HeaderField, endStream bool) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.writeHeadersLocked(streamID, fields, endStream)
+}
+
+func (c *Conn) writeHeadersLocked(streamID uint32, fields []HeaderField, endStream bool) error {
+	c.mutex.Lock()
+	maxFrameSize := c.peerMaxFrameSize
+	c.mutex.Unlock()
+
+	block := EncodeHeaders(fields)
+	frameType := FRAME_HEADERS
+	var flags byte
+	if endStream {
+		flags = FLAG_END_STREAM
+	}
+
+	for {
+		chunk := block[:min(len(block), maxFrameSize)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= FLAG_END_HEADERS
+		}
+
+		err := c.writeLocked(Frame{Type: frameType, Flags: flags, StreamID: streamID, Payload: chunk})
+		if err != nil || len(block) == 0 {
+			return err
+		}
+		frameType = FRAME_CONTINUATION
+		flags = 0
+	}
+}
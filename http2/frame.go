@@ -0,0 +1,224 @@
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const CLIENT_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const FRAME_HEADER_SIZE = 9
+
+const FRAME_DATA byte = 0x0
+const FRAME_HEADERS byte = 0x1
+const FRAME_PRIORITY byte = 0x2
+const FRAME_RST_STREAM byte = 0x3
+const FRAME_SETTINGS byte = 0x4
+const FRAME_PUSH_PROMISE byte = 0x5
+const FRAME_PING byte = 0x6
+const FRAME_GOAWAY byte = 0x7
+const FRAME_WINDOW_UPDATE byte = 0x8
+const FRAME_CONTINUATION byte = 0x9
+
+const FLAG_END_STREAM byte = 0x1
+const FLAG_ACK byte = 0x1
+const FLAG_END_HEADERS byte = 0x4
+const FLAG_PADDED byte = 0x8
+const FLAG_PRIORITY byte = 0x20
+
+const SETTINGS_HEADER_TABLE_SIZE uint16 = 0x1
+const SETTINGS_ENABLE_PUSH uint16 = 0x2
+const SETTINGS_MAX_CONCURRENT_STREAMS uint16 = 0x3
+const SETTINGS_INITIAL_WINDOW_SIZE uint16 = 0x4
+const SETTINGS_MAX_FRAME_SIZE uint16 = 0x5
+const SETTINGS_MAX_HEADER_LIST_SIZE uint16 = 0x6
+const SETTINGS_ENABLE_CONNECT_PROTOCOL uint16 = 0x8
+
+const ERROR_NO_ERROR uint32 = 0x0
+const ERROR_PROTOCOL uint32 = 0x1
+const ERROR_INTERNAL uint32 = 0x2
+const ERROR_FLOW_CONTROL uint32 = 0x3
+const ERROR_SETTINGS_TIMEOUT uint32 = 0x4
+const ERROR_STREAM_CLOSED uint32 = 0x5
+const ERROR_FRAME_SIZE uint32 = 0x6
+const ERROR_REFUSED_STREAM uint32 = 0x7
+const ERROR_CANCEL uint32 = 0x8
+const ERROR_COMPRESSION uint32 = 0x9
+const ERROR_CONNECT uint32 = 0xa
+const ERROR_ENHANCE_YOUR_CALM uint32 = 0xb
+const ERROR_INADEQUATE_SECURITY uint32 = 0xc
+const ERROR_HTTP_1_1_REQUIRED uint32 = 0xd
+
+const DEFAULT_WINDOW_SIZE = 65535
+const MIN_FRAME_SIZE = 16384
+const MAX_FRAME_SIZE = 1<<24 - 1
+const MAX_WINDOW_SIZE = 1<<31 - 1
+
+var ErrInvalidPreface = errors.New("invalid http2 client preface")
+
+var errorNames = map[uint32]string{
+	ERROR_NO_ERROR:            "NO_ERROR",
+	ERROR_PROTOCOL:            "PROTOCOL_ERROR",
+	ERROR_INTERNAL:            "INTERNAL_ERROR",
+	ERROR_FLOW_CONTROL:        "FLOW_CONTROL_ERROR",
+	ERROR_SETTINGS_TIMEOUT:    "SETTINGS_TIMEOUT",
+	ERROR_STREAM_CLOSED:       "STREAM_CLOSED",
+	ERROR_FRAME_SIZE:          "FRAME_SIZE_ERROR",
+	ERROR_REFUSED_STREAM:      "REFUSED_STREAM",
+	ERROR_CANCEL:              "CANCEL",
+	ERROR_COMPRESSION:         "COMPRESSION_ERROR",
+	ERROR_CONNECT:             "CONNECT_ERROR",
+	ERROR_ENHANCE_YOUR_CALM:   "ENHANCE_YOUR_CALM",
+	ERROR_INADEQUATE_SECURITY: "INADEQUATE_SECURITY",
+	ERROR_HTTP_1_1_REQUIRED:   "HTTP_1_1_REQUIRED",
+}
+
+func ErrorName(code uint32) string {
+	name, exists := errorNames[code]
+	if !exists {
+		return fmt.Sprintf("UNKNOWN_ERROR_%d", code)
+	}
+	return name
+}
+
+type ConnectionError struct {
+	Code   uint32
+	Reason string
+}
+
+func (err ConnectionError) Error() string {
+	return fmt.Sprintf("http2 connection error %s: %s", ErrorName(err.Code), err.Reason)
+}
+
+type StreamError struct {
+	StreamID uint32
+	Code     uint32
+}
+
+func (err StreamError) Error() string {
+	return fmt.Sprintf("http2 stream %d reset: %s", err.StreamID, ErrorName(err.Code))
+}
+
+type Frame struct {
+	Type     byte
+	Flags    byte
+	StreamID uint32
+	Payload  []byte
+}
+
+func (frame Frame) Has(flag byte) bool {
+	return frame.Flags&flag != 0
+}
+
+func (frame Frame) Serialize() []byte {
+	data := make([]byte, FRAME_HEADER_SIZE, FRAME_HEADER_SIZE+len(frame.Payload))
+	length := len(frame.Payload)
+	data[0] = byte(length >> 16)
+	data[1] = byte(length >> 8)
+	data[2] = byte(length)
+	data[3] = frame.Type
+	data[4] = frame.Flags
+	binary.BigEndian.PutUint32(data[5:], frame.StreamID&MAX_WINDOW_SIZE)
+	return append(data, frame.Payload...)
+}
+
+func ReadFrame(reader io.Reader, maxSize uint32) (Frame, error) {
+	header := make([]byte, FRAME_HEADER_SIZE)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	if length > maxSize {
+		return Frame{}, ConnectionError{Code: ERROR_FRAME_SIZE, Reason: fmt.Sprintf("frame of %d bytes exceeds limit of %d", length, maxSize)}
+	}
+
+	frame := Frame{
+		Type:     header[3],
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & MAX_WINDOW_SIZE,
+		Payload:  make([]byte, length)}
+	_, err = io.ReadFull(reader, frame.Payload)
+	if err != nil {
+		return Frame{}, err
+	}
+	return frame, nil
+}
+
+func ReadPreface(reader io.Reader) error {
+	preface := make([]byte, len(CLIENT_PREFACE))
+	_, err := io.ReadFull(reader, preface)
+	if err != nil {
+		return err
+	}
+	if string(preface) != CLIENT_PREFACE {
+		return ErrInvalidPreface
+	}
+	return nil
+}
+
+func removePadding(frame Frame) ([]byte, error) {
+	if !frame.Has(FLAG_PADDED) {
+		return frame.Payload, nil
+	}
+	if len(frame.Payload) == 0 {
+		return nil, ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "padded frame without pad length"}
+	}
+
+	padding := int(frame.Payload[0])
+	if padding >= len(frame.Payload) {
+		return nil, ConnectionError{Code: ERROR_PROTOCOL, Reason: "padding exceeds frame payload"}
+	}
+	return frame.Payload[1 : len(frame.Payload)-padding], nil
+}
+
+type Setting struct {
+	ID    uint16
+	Value uint32
+}
+
+func SettingsFrame(settings ...Setting) Frame {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, setting := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, setting.ID)
+		payload = binary.BigEndian.AppendUint32(payload, setting.Value)
+	}
+	return Frame{Type: FRAME_SETTINGS, Payload: payload}
+}
+
+func ParseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnectionError{Code: ERROR_FRAME_SIZE, Reason: "settings payload is not a multiple of 6 bytes"}
+	}
+
+	settings := make([]Setting, 0, len(payload)/6)
+	for offset := 0; offset < len(payload); offset += 6 {
+		settings = append(settings, Setting{
+			ID:    binary.BigEndian.Uint16(payload[offset:]),
+			Value: binary.BigEndian.Uint32(payload[offset+2:])})
+	}
+	return settings, nil
+}
+
+func windowUpdateFrame(streamID uint32, increment uint32) Frame {
+	return Frame{
+		Type:     FRAME_WINDOW_UPDATE,
+		StreamID: streamID,
+		Payload:  binary.BigEndian.AppendUint32(nil, increment)}
+}
+
+func resetFrame(streamID uint32, code uint32) Frame {
+	return Frame{
+		Type:     FRAME_RST_STREAM,
+		StreamID: streamID,
+		Payload:  binary.BigEndian.AppendUint32(nil, code)}
+}
+
+func goAwayFrame(lastStreamID uint32, code uint32, debug string) Frame {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, code)
+	return Frame{Type: FRAME_GOAWAY, Payload: append(payload, debug...)}
+}
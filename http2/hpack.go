@@ -0,0 +1,339 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+const DEFAULT_HEADER_TABLE_SIZE = 4096
+const HEADER_ENTRY_OVERHEAD = 32
+
+var ErrInvalidHuffman = errors.New("invalid huffman encoded string")
+var ErrHeaderListTooLarge = errors.New("header list exceeds size limit")
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+func (field HeaderField) size() int {
+	return len(field.Name) + len(field.Value) + HEADER_ENTRY_OVERHEAD
+}
+
+var staticTable = []HeaderField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+type Decoder struct {
+	entries     []HeaderField
+	size        int
+	maxSize     int
+	allowedSize int
+	maxListSize int
+}
+
+func NewDecoder(maxTableSize int, maxHeaderListSize int) *Decoder {
+	return &Decoder{
+		maxSize:     maxTableSize,
+		allowedSize: maxTableSize,
+		maxListSize: maxHeaderListSize}
+}
+
+func (decoder *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	fields := []HeaderField{}
+	listSize := 0
+	leading := true
+	tooLarge := false
+
+	for len(block) > 0 {
+		first := block[0]
+
+		if first&0xE0 == 0x20 {
+			if !leading {
+				return nil, compressionError("dynamic table size update after header field")
+			}
+			size, rest, err := readInteger(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(decoder.allowedSize) {
+				return nil, compressionError(fmt.Sprintf("dynamic table size %d exceeds limit of %d", size, decoder.allowedSize))
+			}
+			decoder.maxSize = int(size)
+			decoder.evict(0)
+			block = rest
+			continue
+		}
+		leading = false
+
+		var field HeaderField
+		var err error
+		switch {
+		case first&0x80 != 0:
+			var index uint64
+			index, block, err = readInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, err = decoder.lookup(index)
+		case first&0xC0 == 0x40:
+			field, block, err = decoder.readLiteral(block, 6)
+			if err == nil {
+				decoder.add(field)
+			}
+		default:
+			field, block, err = decoder.readLiteral(block, 4)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		listSize += field.size()
+		if decoder.maxListSize > 0 && listSize > decoder.maxListSize {
+			tooLarge = true
+			fields = nil
+		}
+		if !tooLarge {
+			fields = append(fields, field)
+		}
+	}
+
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func compressionError(reason string) error {
+	return ConnectionError{Code: ERROR_COMPRESSION, Reason: reason}
+}
+
+func (decoder *Decoder) lookup(index uint64) (HeaderField, error) {
+	if index == 0 {
+		return HeaderField{}, compressionError("header index 0 is invalid")
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+
+	dynamicIndex := index - uint64(len(staticTable)) - 1
+	if dynamicIndex >= uint64(len(decoder.entries)) {
+		return HeaderField{}, compressionError(fmt.Sprintf("header index %d is out of range", index))
+	}
+	return decoder.entries[dynamicIndex], nil
+}
+
+func (decoder *Decoder) readLiteral(block []byte, prefix uint) (HeaderField, []byte, error) {
+	index, rest, err := readInteger(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var field HeaderField
+	if index == 0 {
+		field.Name, rest, err = decoder.readString(rest)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	} else {
+		named, err := decoder.lookup(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		field.Name = named.Name
+	}
+
+	field.Value, rest, err = decoder.readString(rest)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+	return field, rest, nil
+}
+
+func (decoder *Decoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, compressionError("truncated string literal")
+	}
+	huffman := block[0]&0x80 != 0
+
+	length, rest, err := readInteger(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(rest)) {
+		return "", nil, compressionError("truncated string literal")
+	}
+
+	raw := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(raw), rest, nil
+	}
+
+	decoded, err := decodeHuffman(raw)
+	if err != nil {
+		return "", nil, compressionError(err.Error())
+	}
+	return decoded, rest, nil
+}
+
+func (decoder *Decoder) add(field HeaderField) {
+	if field.size() > decoder.maxSize {
+		decoder.entries = decoder.entries[:0]
+		decoder.size = 0
+		return
+	}
+
+	decoder.evict(field.size())
+	decoder.entries = append([]HeaderField{field}, decoder.entries...)
+	decoder.size += field.size()
+}
+
+func (decoder *Decoder) evict(incoming int) {
+	for len(decoder.entries) > 0 && decoder.size+incoming > decoder.maxSize {
+		last := decoder.entries[len(decoder.entries)-1]
+		decoder.entries = decoder.entries[:len(decoder.entries)-1]
+		decoder.size -= last.size()
+	}
+}
+
+func readInteger(block []byte, prefix uint) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, compressionError("truncated integer")
+	}
+
+	mask := byte(1<<prefix - 1)
+	value := uint64(block[0] & mask)
+	if value < uint64(mask) {
+		return value, block[1:], nil
+	}
+
+	var shift uint
+	for i := 1; i < len(block); i++ {
+		value += uint64(block[i]&0x7F) << shift
+		if block[i]&0x80 == 0 {
+			return value, block[i+1:], nil
+		}
+		shift += 7
+		if shift > 28 {
+			return 0, nil, compressionError("integer overflow")
+		}
+	}
+	return 0, nil, compressionError("truncated integer")
+}
+
+func appendInteger(data []byte, flags byte, prefix uint, value uint64) []byte {
+	mask := uint64(1<<prefix - 1)
+	if value < mask {
+		return append(data, flags|byte(value))
+	}
+
+	data = append(data, flags|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		data = append(data, byte(value&0x7F)|0x80)
+		value >>= 7
+	}
+	return append(data, byte(value))
+}
+
+func appendString(data []byte, value string) []byte {
+	if huffmanLength(value) < len(value) {
+		data = appendInteger(data, 0x80, 7, uint64(huffmanLength(value)))
+		return appendHuffman(data, value)
+	}
+	data = appendInteger(data, 0, 7, uint64(len(value)))
+	return append(data, value...)
+}
+
+func EncodeHeaders(fields []HeaderField) []byte {
+	data := []byte{}
+	for _, field := range fields {
+		nameIndex := 0
+		fullIndex := 0
+		for i, entry := range staticTable {
+			if entry.Name != field.Name {
+				continue
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+			if entry.Value == field.Value {
+				fullIndex = i + 1
+				break
+			}
+		}
+
+		if fullIndex != 0 {
+			data = appendInteger(data, 0x80, 7, uint64(fullIndex))
+			continue
+		}
+
+		data = appendInteger(data, 0, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			data = appendString(data, field.Name)
+		}
+		data = appendString(data, field.Value)
+	}
+	return data
+}
@@ -0,0 +1,444 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestDecoderExamples(t *testing.T) {
+	decoder := NewDecoder(DEFAULT_HEADER_TABLE_SIZE, 0)
+	tests := []struct {
+		block    string
+		expected []HeaderField
+	}{
+		{"828684418cf1e3c2e5f23a6ba0ab90f4ff", []HeaderField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}}},
+		{"828684be5886a8eb10649cbf", []HeaderField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}}},
+		{"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf", []HeaderField{
+			{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}}},
+	}
+
+	for _, tt := range tests {
+		block, _ := hex.DecodeString(tt.block)
+		fields, err := decoder.Decode(block)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", tt.block, err)
+		}
+		if fmt.Sprint(fields) != fmt.Sprint(tt.expected) {
+			t.Errorf("unexpected fields for %s. expected=%v, got=%v", tt.block, tt.expected, fields)
+		}
+	}
+
+	invalid := []string{"80", "c0", "828684418cf1e3c2e5f23a6ba0ab90f4fe", "3fe21f", "8220"}
+	for _, raw := range invalid {
+		block, _ := hex.DecodeString(raw)
+		if _, err := NewDecoder(DEFAULT_HEADER_TABLE_SIZE, 0).Decode(block); err == nil {
+			t.Errorf("expected block %s to be rejected", raw)
+		}
+	}
+
+	block, _ := hex.DecodeString("828684418cf1e3c2e5f23a6ba0ab90f4ff")
+	if _, err := NewDecoder(DEFAULT_HEADER_TABLE_SIZE, 64).Decode(block); !errors.Is(err, ErrHeaderListTooLarge) {
+		t.Errorf("expected header list limit to apply. got=%v", err)
+	}
+}
+
+func TestEncodeHeadersRoundTrip(t *testing.T) {
+	fields := []HeaderField{
+		{":status", "200"},
+		{"content-type", "application/json"},
+		{"x-request-id", "0123456789abcdef"},
+		{"set-cookie", string(bytes.Repeat([]byte("v"), 300))},
+		{"x-binary", "\x00\xff"},
+	}
+
+	decoded, err := NewDecoder(DEFAULT_HEADER_TABLE_SIZE, 0).Decode(EncodeHeaders(fields))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(fields) {
+		t.Errorf("fields did not round trip. expected=%v, got=%v", fields, decoded)
+	}
+}
+
+func startServer(t *testing.T, config Config, handler Handler) (string, chan *Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	conns := make(chan *Conn, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			serverConn := NewServerConn(conn, nil, config, handler)
+			conns <- serverConn
+			go serverConn.Serve(context.Background(), nil)
+		}
+	}()
+
+	return listener.Addr().String(), conns
+}
+
+func dialClient(t *testing.T, address string) *Conn {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	client, err := NewClientConn(conn, Config{})
+	if err != nil {
+		t.Fatalf("failed to start client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func echoHandler(stream *Stream, request http.HttpRequest) {
+	response := http.HttpResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"X-Path": request.FullPath, "X-Host": request.Headers["Host"]},
+		Content:    request.Content}
+	stream.WriteResponse(response)
+}
+
+func TestRoundTripFlowControl(t *testing.T) {
+	address, _ := startServer(t, Config{}, echoHandler)
+	client := dialClient(t, address)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			payload := bytes.Repeat([]byte{byte('a' + i)}, 3*DEFAULT_INITIAL_WINDOW_SIZE+17)
+			response, err := client.RoundTrip(context.Background(), http.HttpRequest{
+				Method:   "POST",
+				FullPath: fmt.Sprintf("/echo/%d", i),
+				Headers:  map[string]string{"Host": "example.internal"},
+				Content:  payload})
+			if err != nil {
+				t.Errorf("round trip %d failed: %v", i, err)
+				return
+			}
+			if response.StatusCode != 200 || response.Headers["X-Path"] != fmt.Sprintf("/echo/%d", i) || response.Headers["X-Host"] != "example.internal" {
+				t.Errorf("unexpected response %d: %d %v", i, response.StatusCode, response.Headers)
+			}
+			if !bytes.Equal(response.Content, payload) {
+				t.Errorf("stream %d echoed %d bytes, expected %d", i, len(response.Content), len(payload))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	response, err := client.RoundTrip(context.Background(), http.HttpRequest{Method: "HEAD", FullPath: "/", Headers: map[string]string{"Host": "h"}})
+	if err != nil || response.StatusCode != 200 || len(response.Content) != 0 {
+		t.Errorf("unexpected HEAD response %d %q: %v", response.StatusCode, response.Content, err)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	address, _ := startServer(t, Config{MaxRequestSize: 1024}, echoHandler)
+	client := dialClient(t, address)
+
+	response, err := client.RoundTrip(context.Background(), http.HttpRequest{
+		Method:   "POST",
+		FullPath: "/",
+		Headers:  map[string]string{"Host": "h"},
+		Content:  make([]byte, 4096)})
+	if err != nil || response.StatusCode != 413 {
+		t.Fatalf("expected 413. got=%d: %v", response.StatusCode, err)
+	}
+
+	response, err = client.RoundTrip(context.Background(), http.HttpRequest{Method: "GET", FullPath: "/after", Headers: map[string]string{"Host": "h"}})
+	if err != nil || response.StatusCode != 200 {
+		t.Errorf("expected connection to stay usable. got=%d: %v", response.StatusCode, err)
+	}
+}
+
+func TestStreamingAndReset(t *testing.T) {
+	cancelled := make(chan struct{})
+	address, _ := startServer(t, Config{}, func(stream *Stream, request http.HttpRequest) {
+		if request.FullPath == "/hang" {
+			stream.WriteHeaders([]HeaderField{{Name: ":status", Value: "200"}}, false)
+			<-request.Context().Done()
+			close(cancelled)
+			return
+		}
+
+		stream.WriteHeaders([]HeaderField{{Name: ":status", Value: "200"}}, false)
+		io.Copy(stream, stream)
+		stream.CloseWrite()
+	})
+	client := dialClient(t, address)
+
+	stream, err := client.OpenStream(context.Background(), http.HttpRequest{Method: "CONNECT", Headers: map[string]string{"Host": "target:443"}}, false)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	response, err := stream.ReadResponse(context.Background())
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("unexpected CONNECT response %d: %v", response.StatusCode, err)
+	}
+
+	payload := bytes.Repeat([]byte("tunnel"), 50000)
+	go func() {
+		stream.Write(payload)
+		stream.CloseWrite()
+	}()
+	echoed, err := io.ReadAll(stream)
+	if err != nil || !bytes.Equal(echoed, payload) {
+		t.Fatalf("stream echoed %d bytes, expected %d: %v", len(echoed), len(payload), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.RoundTrip(ctx, http.HttpRequest{Method: "GET", FullPath: "/hang", Headers: map[string]string{"Host": "h"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected round trip to time out. got=%v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected RST_STREAM to cancel the handler context")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for client.NumStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected finished streams to be removed. got=%d", client.NumStreams())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestResetStreamsCountUntilHandlersFinish(t *testing.T) {
+	release := make(chan struct{})
+	releaseHandlers := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(releaseHandlers)
+	address, conns := startServer(t, Config{MaxConcurrentStreams: 2}, func(stream *Stream, request http.HttpRequest) {
+		<-release
+		echoHandler(stream, request)
+	})
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	request := EncodeHeaders([]HeaderField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "h"}})
+	openStream := func(streamID uint32) {
+		conn.Write(Frame{Type: FRAME_HEADERS, Flags: FLAG_END_HEADERS | FLAG_END_STREAM, StreamID: streamID, Payload: request}.Serialize())
+	}
+	readUntil := func(frameType byte, streamID uint32) Frame {
+		for {
+			frame, err := ReadFrame(conn, MAX_FRAME_SIZE)
+			if err != nil {
+				t.Fatalf("expected frame %d on stream %d: %v", frameType, streamID, err)
+			}
+			if frame.Type == frameType && frame.StreamID == streamID {
+				return frame
+			}
+		}
+	}
+
+	conn.Write([]byte(CLIENT_PREFACE))
+	conn.Write(SettingsFrame().Serialize())
+	for streamID := uint32(1); streamID <= 5; streamID += 2 {
+		openStream(streamID)
+		conn.Write(resetFrame(streamID, ERROR_CANCEL).Serialize())
+	}
+
+	frame := readUntil(FRAME_RST_STREAM, 5)
+	if code := binary.BigEndian.Uint32(frame.Payload); code != ERROR_REFUSED_STREAM {
+		t.Errorf("expected stream beyond the handler limit to be refused. got=%s", ErrorName(code))
+	}
+
+	serverConn := <-conns
+	releaseHandlers()
+	deadline := time.Now().Add(time.Second)
+	for {
+		serverConn.mutex.Lock()
+		active := serverConn.activeHandlers
+		serverConn.mutex.Unlock()
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected reset handlers to finish. active=%d", active)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	openStream(7)
+	readUntil(FRAME_HEADERS, 7)
+}
+
+func TestShutdownDrainsStreams(t *testing.T) {
+	release := make(chan struct{})
+	address, conns := startServer(t, Config{}, func(stream *Stream, request http.HttpRequest) {
+		<-release
+		echoHandler(stream, request)
+	})
+	client := dialClient(t, address)
+
+	result := make(chan error, 1)
+	go func() {
+		response, err := client.RoundTrip(context.Background(), http.HttpRequest{Method: "GET", FullPath: "/slow", Headers: map[string]string{"Host": "h"}})
+		if err == nil && response.StatusCode != 200 {
+			err = fmt.Errorf("unexpected status %d", response.StatusCode)
+		}
+		result <- err
+	}()
+
+	serverConn := <-conns
+	for serverConn.NumStreams() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	serverConn.Shutdown()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := client.OpenStream(context.Background(), http.HttpRequest{Method: "GET", FullPath: "/", Headers: map[string]string{"Host": "h"}}, true)
+		if errors.Is(err, ErrGoAway) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected client to observe GOAWAY. got=%v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("in-flight request failed during shutdown: %v", err)
+	}
+	select {
+	case <-serverConn.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected drained connection to close")
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	address, _ := startServer(t, Config{}, echoHandler)
+
+	tests := []struct {
+		name   string
+		frames []Frame
+		code   uint32
+	}{
+		{"first frame not settings", []Frame{{Type: FRAME_PING, Payload: make([]byte, 8)}}, ERROR_PROTOCOL},
+		{"data on stream 0", []Frame{SettingsFrame(), {Type: FRAME_DATA}}, ERROR_PROTOCOL},
+		{"even stream id", []Frame{SettingsFrame(), {Type: FRAME_HEADERS, Flags: FLAG_END_HEADERS, StreamID: 2}}, ERROR_PROTOCOL},
+		{"invalid hpack", []Frame{SettingsFrame(), {Type: FRAME_HEADERS, Flags: FLAG_END_HEADERS, StreamID: 1, Payload: []byte{0x80}}}, ERROR_COMPRESSION},
+		{"zero window update", []Frame{SettingsFrame(), windowUpdateFrame(0, 0)}, ERROR_PROTOCOL},
+		{"interrupted header block", []Frame{
+			SettingsFrame(),
+			{Type: FRAME_HEADERS, StreamID: 1, Payload: EncodeHeaders([]HeaderField{{":method", "GET"}})},
+			{Type: FRAME_PING, Payload: make([]byte, 8)}}, ERROR_PROTOCOL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			conn.Write([]byte(CLIENT_PREFACE))
+			for _, frame := range tt.frames {
+				conn.Write(frame.Serialize())
+			}
+
+			for {
+				frame, err := ReadFrame(conn, MAX_FRAME_SIZE)
+				if err != nil {
+					t.Fatalf("expected GOAWAY before close: %v", err)
+				}
+				if frame.Type == FRAME_GOAWAY {
+					code := binary.BigEndian.Uint32(frame.Payload[4:])
+					if code != tt.code {
+						t.Errorf("expected %s. got=%s", ErrorName(tt.code), ErrorName(code))
+					}
+					return
+				}
+			}
+		})
+	}
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: h\r\n\r\n"))
+	_, err = io.ReadAll(conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected connection with invalid preface to be closed. got=%v", err)
+	}
+}
+
+func TestExtendedConnectRequests(t *testing.T) {
+	websocketFields := []HeaderField{
+		{":method", "CONNECT"}, {":protocol", "websocket"}, {":scheme", "https"}, {":path", "/chat?room=1"}, {":authority", "example.com"}}
+
+	request, protocol, err := requestFromFields(websocketFields, true)
+	if err != nil {
+		t.Fatalf("failed to parse extended CONNECT: %v", err)
+	}
+	if protocol != "websocket" || request.Method != "CONNECT" || request.FullPath != "/chat?room=1" {
+		t.Errorf("unexpected extended CONNECT request. got=%s %s %s", protocol, request.Method, request.FullPath)
+	}
+
+	if _, _, err := requestFromFields(websocketFields, false); err == nil {
+		t.Errorf("expected :protocol to be rejected when extended CONNECT is disabled")
+	}
+
+	invalid := [][]HeaderField{
+		{{":method", "GET"}, {":protocol", "websocket"}, {":scheme", "https"}, {":path", "/"}, {":authority", "example.com"}},
+		{{":method", "CONNECT"}, {":protocol", "websocket"}, {":authority", "example.com"}},
+		{{":method", "CONNECT"}, {":protocol", ""}, {":scheme", "https"}, {":path", "/"}, {":authority", "example.com"}},
+	}
+	for _, fields := range invalid {
+		if _, _, err := requestFromFields(fields, true); err == nil {
+			t.Errorf("expected %v to be rejected", fields)
+		}
+	}
+
+	request, protocol, err = requestFromFields([]HeaderField{{":method", "CONNECT"}, {":authority", "example.com:443"}}, true)
+	if err != nil || protocol != "" || request.FullPath != "example.com:443" {
+		t.Errorf("expected plain CONNECT to be unaffected. got=%q %q %v", protocol, request.FullPath, err)
+	}
+
+	disabledAddress, _ := startServer(t, Config{}, echoHandler)
+	client := dialClient(t, disabledAddress)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = client.OpenConnectStream(ctx, http.HttpRequest{FullPath: "/", Headers: map[string]string{"Host": "h"}}, "websocket")
+	if !errors.Is(err, ErrConnectProtocolDisabled) {
+		t.Errorf("expected ErrConnectProtocolDisabled. got=%v", err)
+	}
+}
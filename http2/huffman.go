@@ -0,0 +1,86 @@
+package http2
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   byte
+	leaf     bool
+}
+
+var huffmanTree = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+	for symbol, code := range huffmanCodes {
+		node := root
+		for bit := int(huffmanCodeLengths[symbol]) - 1; bit >= 0; bit-- {
+			branch := (code >> bit) & 1
+			if node.children[branch] == nil {
+				node.children[branch] = &huffmanNode{}
+			}
+			node = node.children[branch]
+		}
+		node.symbol = byte(symbol)
+		node.leaf = true
+	}
+	return root
+}
+
+func decodeHuffman(data []byte) (string, error) {
+	decoded := make([]byte, 0, len(data)*8/5)
+	node := huffmanTree
+	pending := 0
+	allOnes := true
+
+	for _, value := range data {
+		for bit := 7; bit >= 0; bit-- {
+			branch := (value >> bit) & 1
+			node = node.children[branch]
+			if node == nil {
+				return "", ErrInvalidHuffman
+			}
+			pending++
+			allOnes = allOnes && branch == 1
+
+			if node.leaf {
+				decoded = append(decoded, node.symbol)
+				node = huffmanTree
+				pending = 0
+				allOnes = true
+			}
+		}
+	}
+
+	if pending > 7 || !allOnes {
+		return "", ErrInvalidHuffman
+	}
+	return string(decoded), nil
+}
+
+func huffmanLength(value string) int {
+	bits := 0
+	for i := 0; i < len(value); i++ {
+		bits += int(huffmanCodeLengths[value[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func appendHuffman(data []byte, value string) []byte {
+	var buffer uint64
+	var bits uint
+
+	for i := 0; i < len(value); i++ {
+		length := uint(huffmanCodeLengths[value[i]])
+		buffer = buffer<<length | uint64(huffmanCodes[value[i]])
+		bits += length
+		for bits >= 8 {
+			bits -= 8
+			data = append(data, byte(buffer>>bits))
+		}
+	}
+
+	if bits > 0 {
+		padding := 8 - bits
+		data = append(data, byte(buffer<<padding|(1<<padding-1)))
+	}
+	return data
+}
@@ -0,0 +1,256 @@
+package http2
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const PROTOCOL = "HTTP/2.0"
+const ALPN_PROTOCOL = "h2"
+const UPGRADE_TOKEN = "h2c"
+const SETTINGS_HEADER = "HTTP2-Settings"
+
+var connectionHeaders = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+func NewServerConn(conn net.Conn, reader io.Reader, config Config, handler Handler) *Conn {
+	c := newConn(conn, reader, config, false)
+	c.handler = handler
+	return c
+}
+
+func (c *Conn) Serve(ctx context.Context, upgrade *http.HttpRequest) error {
+	c.cancel()
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.mutex.Lock()
+	c.idleTimer = time.AfterFunc(c.config.IdleTimeout, c.onIdle)
+	c.mutex.Unlock()
+
+	err := c.writeSettings()
+	if err != nil {
+		c.close(err)
+		return err
+	}
+
+	if upgrade != nil {
+		err = c.serveUpgrade(*upgrade)
+		if err != nil {
+			c.close(err)
+			return err
+		}
+	}
+
+	err = ReadPreface(c.reader)
+	if err != nil {
+		c.close(err)
+		return err
+	}
+
+	c.run()
+	return c.Err()
+}
+
+func (c *Conn) serveUpgrade(request http.HttpRequest) error {
+	encoded, _ := request.Header(SETTINGS_HEADER)
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %w", SETTINGS_HEADER, err)
+	}
+	settings, err := ParseSettings(payload)
+	if err != nil {
+		return err
+	}
+	err = c.applySettings(settings)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range append(connectionHeaders, strings.ToLower(SETTINGS_HEADER)) {
+		for headerName := range request.Headers {
+			if strings.EqualFold(headerName, name) {
+				delete(request.Headers, headerName)
+			}
+		}
+	}
+	request.Protocol = PROTOCOL
+
+	c.mutex.Lock()
+	c.lastPeerStreamID = 1
+	stream := newStream(c, 1)
+	stream.remoteClosed = true
+	stream.dispatched = true
+	stream.request = request.WithContext(stream.ctx)
+	c.addStream(stream)
+	c.mutex.Unlock()
+
+	return stream.dispatch()
+}
+
+func IsUpgradeRequest(request http.HttpRequest) bool {
+	upgrade, _ := request.Header("Upgrade")
+	connection, _ := request.Header("Connection")
+	_, hasSettings := request.Header(SETTINGS_HEADER)
+
+	return hasSettings &&
+		hasToken(upgrade, UPGRADE_TOKEN) &&
+		hasToken(connection, "Upgrade") &&
+		hasToken(connection, SETTINGS_HEADER)
+}
+
+func hasToken(value string, token string) bool {
+	for _, element := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(element), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (stream *Stream) WriteResponse(response http.HttpResponse) error {
+	stream.mutex.Lock()
+	method := stream.request.Method
+	stream.mutex.Unlock()
+
+	hasBody := method != "HEAD" && response.StatusCode >= 200 && response.StatusCode != 204 && response.StatusCode != 304
+	fields := []HeaderField{{Name: ":status", Value: strconv.Itoa(response.StatusCode)}}
+	fields = appendHeaderFields(fields, response.Headers)
+	if response.StatusCode >= 200 && response.StatusCode != 204 && response.StatusCode != 304 {
+		fields = append(fields, HeaderField{Name: "content-length", Value: strconv.Itoa(len(response.Content))})
+	}
+
+	endStream := !hasBody || len(response.Content) == 0
+	err := stream.WriteHeaders(fields, endStream)
+	if err != nil || endStream {
+		return err
+	}
+
+	_, err = stream.writeData(response.Content, true)
+	return err
+}
+
+func appendHeaderFields(fields []HeaderField, headers map[string]string) []HeaderField {
+	for name, value := range headers {
+		lowerName := strings.ToLower(name)
+		if lowerName == "content-length" || lowerName == "host" || isConnectionHeader(lowerName) {
+			continue
+		}
+		fields = append(fields, HeaderField{Name: lowerName, Value: value})
+	}
+	return fields
+}
+
+func isConnectionHeader(name string) bool {
+	for _, connectionHeader := range connectionHeaders {
+		if name == connectionHeader {
+			return true
+		}
+	}
+	return false
+}
+
+func (stream *Stream) Accept(headers map[string]string) error {
+	fields := []HeaderField{{Name: ":status", Value: "200"}}
+	fields = appendHeaderFields(fields, headers)
+	return stream.WriteHeaders(fields, false)
+}
+
+func requestFromFields(fields []HeaderField, connectProtocol bool) (http.HttpRequest, string, error) {
+	pseudo := map[string]string{}
+	headers := map[string]string{}
+	regular := false
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			if regular {
+				return http.HttpRequest{}, "", errors.New("pseudo header after regular header")
+			}
+			switch field.Name {
+			case ":method", ":scheme", ":authority", ":path":
+			case ":protocol":
+				if !connectProtocol {
+					return http.HttpRequest{}, "", errors.New(":protocol pseudo header without extended CONNECT")
+				}
+			default:
+				return http.HttpRequest{}, "", fmt.Errorf("unknown pseudo header %s", field.Name)
+			}
+			if _, exists := pseudo[field.Name]; exists {
+				return http.HttpRequest{}, "", fmt.Errorf("duplicate pseudo header %s", field.Name)
+			}
+			pseudo[field.Name] = field.Value
+			continue
+		}
+
+		regular = true
+		err := addHeaderField(headers, field)
+		if err != nil {
+			return http.HttpRequest{}, "", err
+		}
+	}
+
+	method := pseudo[":method"]
+	protocol, extended := pseudo[":protocol"]
+	if method == "" {
+		return http.HttpRequest{}, "", errors.New("missing :method pseudo header")
+	}
+	switch {
+	case extended:
+		if method != "CONNECT" || protocol == "" || pseudo[":authority"] == "" || pseudo[":scheme"] == "" || pseudo[":path"] == "" {
+			return http.HttpRequest{}, "", errors.New("malformed extended CONNECT request")
+		}
+	case method == "CONNECT":
+		if pseudo[":authority"] == "" || pseudo[":scheme"] != "" || pseudo[":path"] != "" {
+			return http.HttpRequest{}, "", errors.New("malformed CONNECT request")
+		}
+	case pseudo[":scheme"] == "" || pseudo[":path"] == "":
+		return http.HttpRequest{}, "", errors.New("missing :scheme or :path pseudo header")
+	}
+
+	if authority := pseudo[":authority"]; authority != "" {
+		headers["Host"] = authority
+	}
+
+	fullPath := pseudo[":path"]
+	if method == "CONNECT" && !extended {
+		fullPath = pseudo[":authority"]
+	}
+
+	return http.HttpRequest{
+		Method:   method,
+		FullPath: fullPath,
+		Protocol: PROTOCOL,
+		Headers:  headers,
+		Content:  []byte{}}, protocol, nil
+}
+
+func addHeaderField(headers map[string]string, field HeaderField) error {
+	if field.Name != strings.ToLower(field.Name) {
+		return fmt.Errorf("header %s is not lowercase", field.Name)
+	}
+	if isConnectionHeader(field.Name) {
+		return fmt.Errorf("connection specific header %s", field.Name)
+	}
+	if field.Name == "te" && field.Value != "trailers" {
+		return errors.New("te header must be trailers")
+	}
+
+	name := textproto.CanonicalMIMEHeaderKey(field.Name)
+	existing, exists := headers[name]
+	switch {
+	case !exists:
+		headers[name] = field.Value
+	case field.Name == "cookie":
+		headers[name] = existing + "; " + field.Value
+	default:
+		headers[name] = existing + ", " + field.Value
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+package http2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+var ErrHeadersNotSent = errors.New("http2 response headers have not been sent")
+
+type Stream struct {
+	conn      *Conn
+	id        uint32
+	protocol  string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	readable  chan struct{}
+	writable  chan struct{}
+	responded chan struct{}
+	respond   sync.Once
+
+	writeMutex sync.Mutex
+
+	mutex         sync.Mutex
+	request       http.HttpRequest
+	response      http.HttpResponse
+	buffer        []byte
+	consumed      int
+	receiveWindow int
+	streaming     bool
+	dispatched    bool
+	remoteClosed  bool
+	localClosed   bool
+	headersSent   bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	sendWindow int
+}
+
+func newStream(c *Conn, id uint32) *Stream {
+	stream := &Stream{
+		conn:          c,
+		id:            id,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		responded:     make(chan struct{}),
+		receiveWindow: int(c.config.InitialWindowSize),
+		sendWindow:    c.peerInitialWindow}
+	stream.ctx, stream.cancel = context.WithCancel(c.ctx)
+	return stream
+}
+
+func (stream *Stream) ID() uint32 {
+	return stream.id
+}
+
+func (stream *Stream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *Stream) ConnectProtocol() string {
+	return stream.protocol
+}
+
+func (stream *Stream) WriteHeaders(fields []HeaderField, endStream bool) error {
+	stream.mutex.Lock()
+	if stream.err != nil {
+		err := stream.err
+		stream.mutex.Unlock()
+		return err
+	}
+	if stream.localClosed {
+		stream.mutex.Unlock()
+		return io.ErrClosedPipe
+	}
+	stream.headersSent = true
+	stream.mutex.Unlock()
+
+	err := stream.conn.writeHeaders(stream.id, fields, endStream)
+	if err != nil {
+		return err
+	}
+	if endStream {
+		stream.closeLocal()
+	}
+	return nil
+}
+
+func (stream *Stream) Read(data []byte) (int, error) {
+	for {
+		stream.mutex.Lock()
+		if len(stream.buffer) > 0 {
+			n := copy(data, stream.buffer)
+			stream.buffer = stream.buffer[n:]
+			stream.consumed += n
+			increment := 0
+			if stream.consumed >= int(stream.conn.config.InitialWindowSize)/2 && !stream.remoteClosed {
+				increment = stream.consumed
+				stream.receiveWindow += increment
+				stream.consumed = 0
+			}
+			stream.mutex.Unlock()
+
+			if increment > 0 {
+				stream.conn.writeFrame(windowUpdateFrame(stream.id, uint32(increment)))
+			}
+			return n, nil
+		}
+		if stream.remoteClosed {
+			stream.mutex.Unlock()
+			return 0, io.EOF
+		}
+		if stream.err != nil {
+			err := stream.err
+			stream.mutex.Unlock()
+			return 0, err
+		}
+		deadline := stream.readDeadline
+		stream.mutex.Unlock()
+
+		err := wait(stream.readable, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (stream *Stream) Write(data []byte) (int, error) {
+	return stream.writeData(data, false)
+}
+
+func (stream *Stream) writeData(data []byte, endStream bool) (int, error) {
+	stream.writeMutex.Lock()
+	defer stream.writeMutex.Unlock()
+
+	written := 0
+	for {
+		stream.mutex.Lock()
+		if stream.err != nil {
+			err := stream.err
+			stream.mutex.Unlock()
+			return written, err
+		}
+		if stream.localClosed {
+			stream.mutex.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if !stream.headersSent {
+			stream.mutex.Unlock()
+			return written, ErrHeadersNotSent
+		}
+		deadline := stream.writeDeadline
+		stream.mutex.Unlock()
+
+		remaining := len(data) - written
+		if remaining == 0 && !endStream {
+			return written, nil
+		}
+
+		c := stream.conn
+		c.mutex.Lock()
+		n := min(remaining, stream.sendWindow, c.sendWindow, c.peerMaxFrameSize)
+		if remaining > 0 && n <= 0 {
+			c.mutex.Unlock()
+			err := wait(stream.writable, deadline)
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		n = max(n, 0)
+		stream.sendWindow -= n
+		c.sendWindow -= n
+		c.mutex.Unlock()
+
+		last := endStream && written+n == len(data)
+		var flags byte
+		if last {
+			flags = FLAG_END_STREAM
+		}
+		err := c.writeFrame(Frame{Type: FRAME_DATA, Flags: flags, StreamID: stream.id, Payload: data[written : written+n]})
+		if err != nil {
+			return written, err
+		}
+		written += n
+
+		if last {
+			stream.closeLocal()
+			return written, nil
+		}
+	}
+}
+
+func (stream *Stream) CloseWrite() error {
+	stream.mutex.Lock()
+	localClosed := stream.localClosed || stream.err != nil
+	stream.mutex.Unlock()
+	if localClosed {
+		return nil
+	}
+
+	_, err := stream.writeData(nil, true)
+	return err
+}
+
+func (stream *Stream) Close() error {
+	stream.mutex.Lock()
+	if stream.err != nil {
+		stream.mutex.Unlock()
+		return nil
+	}
+	finished := stream.localClosed && stream.remoteClosed
+	code := ERROR_CANCEL
+	if stream.localClosed {
+		code = ERROR_NO_ERROR
+	}
+	stream.mutex.Unlock()
+
+	if finished {
+		stream.abort(net.ErrClosed)
+		return nil
+	}
+	stream.conn.resetStream(stream.id, code, net.ErrClosed)
+	return nil
+}
+
+func (stream *Stream) LocalAddr() net.Addr {
+	return stream.conn.conn.LocalAddr()
+}
+
+func (stream *Stream) RemoteAddr() net.Addr {
+	return stream.conn.conn.RemoteAddr()
+}
+
+func (stream *Stream) SetDeadline(deadline time.Time) error {
+	stream.SetReadDeadline(deadline)
+	return stream.SetWriteDeadline(deadline)
+}
+
+func (stream *Stream) SetReadDeadline(deadline time.Time) error {
+	stream.mutex.Lock()
+	stream.readDeadline = deadline
+	stream.mutex.Unlock()
+	notify(stream.readable)
+	return nil
+}
+
+func (stream *Stream) SetWriteDeadline(deadline time.Time) error {
+	stream.mutex.Lock()
+	stream.writeDeadline = deadline
+	stream.mutex.Unlock()
+	notify(stream.writable)
+	return nil
+}
+
+func (stream *Stream) receiveData(data []byte, padding int, endStream bool) error {
+	stream.mutex.Lock()
+	if stream.remoteClosed {
+		stream.mutex.Unlock()
+		return StreamError{StreamID: stream.id, Code: ERROR_STREAM_CLOSED}
+	}
+
+	stream.receiveWindow -= len(data) + padding
+	if stream.receiveWindow < 0 {
+		stream.mutex.Unlock()
+		return StreamError{StreamID: stream.id, Code: ERROR_FLOW_CONTROL}
+	}
+
+	increment := padding
+	buffered := !stream.streaming && !stream.conn.isClient
+	if buffered && stream.dispatched {
+		increment += len(data)
+		data = nil
+	} else if buffered {
+		if len(stream.buffer)+len(data) > stream.conn.config.MaxRequestSize {
+			stream.remoteClosed = endStream
+			stream.buffer = nil
+			stream.dispatched = true
+			stream.mutex.Unlock()
+			return stream.reject(413)
+		}
+		increment += len(data)
+	}
+	if stream.err == nil {
+		stream.buffer = append(stream.buffer, data...)
+	}
+	if endStream {
+		stream.remoteClosed = true
+		increment = 0
+	}
+	stream.receiveWindow += increment
+	dispatch := buffered && endStream && !stream.dispatched
+	stream.dispatched = stream.dispatched || dispatch
+	stream.mutex.Unlock()
+	notify(stream.readable)
+
+	if increment > 0 {
+		stream.conn.writeFrame(windowUpdateFrame(stream.id, uint32(increment)))
+	}
+	if endStream {
+		stream.closeRemote()
+	}
+	if dispatch {
+		return stream.dispatch()
+	}
+	return nil
+}
+
+func (stream *Stream) receiveHeaders(fields []HeaderField, endStream bool) error {
+	stream.mutex.Lock()
+	if stream.remoteClosed {
+		stream.mutex.Unlock()
+		return StreamError{StreamID: stream.id, Code: ERROR_STREAM_CLOSED}
+	}
+	responded := stream.response.StatusCode != 0
+	stream.mutex.Unlock()
+
+	if !stream.conn.isClient || responded {
+		if !endStream {
+			return StreamError{StreamID: stream.id, Code: ERROR_PROTOCOL}
+		}
+		return stream.receiveData(nil, 0, true)
+	}
+
+	response, err := responseFromFields(fields)
+	if err != nil {
+		return StreamError{StreamID: stream.id, Code: ERROR_PROTOCOL}
+	}
+	if response.StatusCode < 200 {
+		return nil
+	}
+
+	stream.mutex.Lock()
+	stream.response = response
+	stream.mutex.Unlock()
+	stream.respond.Do(func() { close(stream.responded) })
+
+	if endStream {
+		return stream.receiveData(nil, 0, true)
+	}
+	return nil
+}
+
+func (stream *Stream) receiveRequest(request http.HttpRequest, endStream bool) error {
+	request = request.WithContext(stream.ctx)
+
+	stream.mutex.Lock()
+	stream.request = request
+	stream.streaming = request.Method == "CONNECT"
+	stream.mutex.Unlock()
+
+	if contentLength, exists := request.Header("Content-Length"); exists {
+		size, err := strconv.Atoi(contentLength)
+		if err != nil || size < 0 {
+			return StreamError{StreamID: stream.id, Code: ERROR_PROTOCOL}
+		}
+		if size > stream.conn.config.MaxRequestSize {
+			stream.mutex.Lock()
+			stream.dispatched = true
+			stream.mutex.Unlock()
+			return stream.reject(413)
+		}
+	}
+
+	if stream.streaming {
+		stream.mutex.Lock()
+		stream.dispatched = true
+		stream.mutex.Unlock()
+		err := stream.dispatch()
+		if err != nil || !endStream {
+			return err
+		}
+	}
+	if endStream {
+		return stream.receiveData(nil, 0, true)
+	}
+	return nil
+}
+
+func (stream *Stream) dispatch() error {
+	stream.mutex.Lock()
+	request := stream.request
+	if !stream.streaming {
+		request.Content = stream.buffer
+		stream.buffer = nil
+
+		contentLength, exists := request.Header("Content-Length")
+		if exists && contentLength != strconv.Itoa(len(request.Content)) {
+			stream.mutex.Unlock()
+			return StreamError{StreamID: stream.id, Code: ERROR_PROTOCOL}
+		}
+	}
+	stream.mutex.Unlock()
+
+	if !stream.conn.startHandler() {
+		return StreamError{StreamID: stream.id, Code: ERROR_REFUSED_STREAM}
+	}
+	go func() {
+		defer stream.finish()
+		stream.conn.handler(stream, request)
+	}()
+	return nil
+}
+
+func (stream *Stream) reject(statusCode int) error {
+	if !stream.conn.startHandler() {
+		return StreamError{StreamID: stream.id, Code: ERROR_REFUSED_STREAM}
+	}
+	go func() {
+		defer stream.finish()
+		stream.WriteHeaders([]HeaderField{
+			{Name: ":status", Value: strconv.Itoa(statusCode)},
+			{Name: "content-length", Value: "0"}}, true)
+	}()
+	return nil
+}
+
+func (stream *Stream) finish() {
+	defer stream.conn.endHandler()
+
+	stream.mutex.Lock()
+	err := stream.err
+	headersSent := stream.headersSent
+	localClosed := stream.localClosed
+	stream.mutex.Unlock()
+
+	if err != nil {
+		return
+	}
+	if !headersSent {
+		stream.conn.resetStream(stream.id, ERROR_INTERNAL, net.ErrClosed)
+		return
+	}
+	if !localClosed && stream.CloseWrite() != nil {
+		return
+	}
+
+	stream.mutex.Lock()
+	remoteClosed := stream.remoteClosed
+	stream.mutex.Unlock()
+	if !remoteClosed {
+		stream.conn.resetStream(stream.id, ERROR_NO_ERROR, net.ErrClosed)
+	}
+}
+
+func (stream *Stream) closeLocal() {
+	stream.mutex.Lock()
+	stream.localClosed = true
+	finished := stream.remoteClosed
+	stream.mutex.Unlock()
+
+	if finished {
+		stream.conn.removeStream(stream.id)
+	}
+}
+
+func (stream *Stream) closeRemote() {
+	stream.mutex.Lock()
+	finished := stream.localClosed
+	stream.mutex.Unlock()
+
+	if finished {
+		stream.conn.removeStream(stream.id)
+	}
+}
+
+func (stream *Stream) abort(err error) {
+	stream.mutex.Lock()
+	if stream.err == nil {
+		stream.err = err
+	}
+	stream.mutex.Unlock()
+
+	stream.cancel()
+	stream.respond.Do(func() { close(stream.responded) })
+	notify(stream.readable)
+	notify(stream.writable)
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
+
+func wait(signal chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-signal
+		return nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-signal:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+const COMBINED_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+
+type combinedHandler struct {
+	writer   io.Writer
+	mutex    *sync.Mutex
+	attrs    []slog.Attr
+	fallback slog.Handler
+}
+
+func newCombinedHandler(writer io.Writer) *combinedHandler {
+	return &combinedHandler{
+		writer:   writer,
+		mutex:    &sync.Mutex{},
+		attrs:    []slog.Attr{},
+		fallback: slog.NewTextHandler(writer, nil)}
+}
+
+func (handler *combinedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.fallback.Enabled(ctx, level)
+}
+
+func (handler *combinedHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Message != ACCESS_LOG_MESSAGE {
+		return handler.fallback.Handle(ctx, record)
+	}
+
+	values := map[string]string{}
+	for _, attr := range handler.attrs {
+		values[attr.Key] = attr.Value.String()
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		values[attr.Key] = attr.Value.String()
+		return true
+	})
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(
+		"%s - - [%s] \"%s %s %s\" %s %s \"%s\" \"%s\"\n",
+		combinedHost(values),
+		record.Time.Format(COMBINED_TIME_LAYOUT),
+		values["method"],
+		values["path"],
+		values["protocol"],
+		combinedValue(values, "status"),
+		combinedValue(values, "bytes"),
+		combinedValue(values, "referer"),
+		combinedValue(values, "user_agent")))
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	_, err := handler.writer.Write(buffer.Bytes())
+	return err
+}
+
+func (handler *combinedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &combinedHandler{
+		writer:   handler.writer,
+		mutex:    handler.mutex,
+		attrs:    append(append([]slog.Attr{}, handler.attrs...), attrs...),
+		fallback: handler.fallback.WithAttrs(attrs)}
+}
+
+func (handler *combinedHandler) WithGroup(name string) slog.Handler {
+	return &combinedHandler{
+		writer:   handler.writer,
+		mutex:    handler.mutex,
+		attrs:    handler.attrs,
+		fallback: handler.fallback.WithGroup(name)}
+}
+
+func combinedValue(values map[string]string, key string) string {
+	value, exists := values[key]
+	if !exists || value == "" {
+		return "-"
+	}
+	return value
+}
+
+func combinedHost(values map[string]string) string {
+	address := combinedValue(values, "remote_addr")
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const FORMAT_JSON = "json"
+const FORMAT_LOGFMT = "logfmt"
+const FORMAT_COMBINED = "combined"
+
+const ACCESS_LOG_MESSAGE = "http request"
+
+type loggerKey struct{}
+
+func NewLogger(writer io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case FORMAT_JSON:
+		return slog.New(slog.NewJSONHandler(writer, nil)), nil
+	case FORMAT_LOGFMT:
+		return slog.New(slog.NewTextHandler(writer, nil)), nil
+	case FORMAT_COMBINED:
+		return slog.New(newCombinedHandler(writer)), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format: %s", format)
+}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCombinedFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := NewLogger(&buffer, FORMAT_COMBINED)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	logger.With("request_id", "abc").Info(ACCESS_LOG_MESSAGE,
+		"remote_addr", "10.0.0.1:54321",
+		"method", "GET",
+		"path", "/greet?first_name=a",
+		"protocol", "HTTP/1.1",
+		"status", 200,
+		"bytes", 42,
+		"user_agent", "curl/8.7.1",
+		"referer", "")
+
+	expected := regexp.MustCompile(
+		`^10\.0\.0\.1 - - \[[^\]]+\] "GET /greet\?first_name=a HTTP/1\.1" 200 42 "-" "curl/8\.7\.1"\n$`)
+	if !expected.MatchString(buffer.String()) {
+		t.Errorf("unexpected combined log line. got=%q", buffer.String())
+	}
+}
+
+func TestCombinedFormatFallback(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, FORMAT_COMBINED)
+
+	logger.Info("server started", "port", 8080)
+
+	if !strings.Contains(buffer.String(), `msg="server started" port=8080`) {
+		t.Errorf("expected logfmt fallback line. got=%q", buffer.String())
+	}
+}
+
+func TestJsonFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, _ := NewLogger(&buffer, FORMAT_JSON)
+
+	logger.Info(ACCESS_LOG_MESSAGE, "status", 404)
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("log line is not valid json: %v", err)
+	}
+
+	if record["status"] != float64(404) {
+		t.Errorf("expected status 404. got=%v", record["status"])
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewLogger(&bytes.Buffer{}, "xml")
+	if err == nil {
+		t.Errorf("expected error for unknown log format")
+	}
+}
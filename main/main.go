@@ -1,74 +1,240 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/http2"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/metrics"
 	"github.com/brain-dev-null/gosocks/server"
-	"github.com/brain-dev-null/gosocks/websocket"
+	"github.com/brain-dev-null/gosocks/tracing"
 )
 
 func main() {
-	srv := server.NewServer(8080)
-	routes := server.NewRouter()
-	websocketEchoHandler := websocket.WsHandler{
-		OnOpen: func(conn websocket.WsConnection) { log.Println("Connection opened") },
-		OnMessage: func(wme websocket.WsMessageEvent, wc websocket.WsConnection) {
-			msg := string(wme.Data)
-			if msg == "bye" {
-				log.Println("closing now")
-				wc.Close(1000, "client closed")
-				return
-			}
-			response := fmt.Sprintf("received: %s", msg)
-			log.Println(response)
-			wc.SendText(response)
-		},
-		OnClose: func(wce websocket.WsCloseEvent, wc websocket.WsConnection) {
-			log.Printf("Closed: %d(%s) clean=%t\n", wce.Code, wce.Reason, wce.WasClean)
-		},
-		OnError: func(err error, conn websocket.WsConnection) {
-			log.Printf("Error: %s\n", err.Error())
-		},
-	}
-	websocketEcho := websocket.NewWsConnection(websocketEchoHandler)
-	routes.AddRoute("/greet", echo)
-	routes.AddWebSocket("/wstest", websocketEcho)
-	srv.SetRoutes(routes)
-	err := srv.Start()
+	if len(os.Args) > 1 && os.Args[1] == "tunnel" {
+		runTunnelClient(os.Args[2:])
+		return
+	}
+
+	listenAddress := flag.String("listen", ":8080", "data plane listen address (host:port or unix:/path.sock)")
+	adminAddress := flag.String("admin-listen", "", "optional control plane listen address for metrics and health routes")
+	logFormat := flag.String("log-format", logging.FORMAT_LOGFMT, "log format: json, logfmt or combined")
+	metricsPath := flag.String("metrics-path", "/metrics", "route serving Prometheus metrics")
+	routesPath := flag.String("routes-path", "", "optional route printing the registered route table")
+	openapiPath := flag.String("openapi-path", "/openapi.json", "route serving the OpenAPI document (empty = disabled)")
+	traceStdout := flag.Bool("trace-stdout", false, "export trace spans as JSON to stdout")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time to drain connections on shutdown")
+	maxConnections := flag.Int("max-connections", 0, "maximum concurrent connections (0 = unlimited)")
+	maxHttpRequests := flag.Int("max-http-requests", 0, "maximum concurrent HTTP requests (0 = unlimited)")
+	maxWebSockets := flag.Int("max-websockets", 0, "maximum concurrent WebSocket connections (0 = unlimited)")
+	workers := flag.Int("workers", 0, "size of the HTTP worker pool (0 = goroutine per request)")
+	shutdownDelay := flag.Duration("shutdown-delay", 0, "time to report not ready before closing the listener")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "accept PROXY protocol v1/v2 headers on the data plane listener")
+	proxyTrusted := flag.String("proxy-trusted", "", "comma separated IPs or CIDRs allowed to send PROXY headers (required with -proxy-protocol on TCP, unix socket peers are always trusted)")
+	proxyAllowMissing := flag.Bool("proxy-allow-missing", false, "accept connections from trusted sources that send no PROXY header")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated IPs or CIDRs whose Forwarded/X-Forwarded-* headers are trusted")
+	allowedOrigins := flag.String("allowed-origins", "", "comma separated origins allowed to open WebSockets in addition to same-origin")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file enabling TLS on the data plane listener")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	proxyUpstreams := flag.String("proxy-upstreams", "", "comma separated upstream URLs to reverse proxy to (empty = disabled)")
+	proxyPrefix := flag.String("proxy-prefix", "/legacy", "path prefix forwarded to -proxy-upstreams")
+	proxyHealthPath := flag.String("proxy-health-path", "", "upstream path probed to detect unhealthy upstreams (empty = disabled)")
+	connectAllow := flag.String("connect-allow", "", "comma separated host:port patterns CONNECT may tunnel to (empty = CONNECT disabled)")
+	connectCredentials := flag.String("connect-credentials", "", "comma separated user:password pairs required for CONNECT (empty = no auth)")
+	connectIdleTimeout := flag.Duration("connect-idle-timeout", server.DEFAULT_TUNNEL_IDLE_TIMEOUT, "close CONNECT tunnels idle for this long")
+	socksAllow := flag.String("socks-allow", "", "comma separated host:port patterns SOCKS5 may reach (empty = SOCKS5 disabled)")
+	socksListen := flag.String("socks-listen", "", "dedicated SOCKS5 listen address (empty = detect SOCKS5 on -listen)")
+	socksCredentials := flag.String("socks-credentials", "", "comma separated user:password pairs required for SOCKS5 (empty = no auth)")
+	socksUdp := flag.Bool("socks-udp", true, "allow SOCKS5 UDP ASSOCIATE")
+	socksIdleTimeout := flag.Duration("socks-idle-timeout", server.DEFAULT_TUNNEL_IDLE_TIMEOUT, "close SOCKS5 sessions idle for this long")
+	wsTunnelAllow := flag.String("ws-tunnel-allow", "", "comma separated host:port patterns the WebSocket tunnel may reach (empty = tunnel disabled)")
+	wsTunnelPath := flag.String("ws-tunnel-path", "/tunnel", "route serving the WebSocket tunnel")
+	wsTunnelIdleTimeout := flag.Duration("ws-tunnel-idle-timeout", server.DEFAULT_TUNNEL_IDLE_TIMEOUT, "close WebSocket tunnel streams idle for this long")
+	enableHttp2 := flag.Bool("http2", true, "serve HTTP/2 via h2c prior knowledge, Upgrade: h2c and ALPN h2")
+	http2WebSockets := flag.Bool("http2-websockets", true, "accept WebSockets over HTTP/2 extended CONNECT (RFC 8441)")
+	http2MaxStreams := flag.Int("http2-max-streams", http2.DEFAULT_MAX_CONCURRENT_STREAMS, "maximum concurrent streams per HTTP/2 connection")
+	flag.Parse()
+
+	logger, err := logging.NewLogger(os.Stdout, *logFormat)
 	if err != nil {
-		log.Panicf("error: %v", err)
+		log.Fatalf("error: %v", err)
 	}
 
-	for {
+	dataListener := server.ListenerConfig{
+		Name:    "data",
+		Address: *listenAddress}
+	if *socksListen != "" {
+		dataListener.Protocol = server.LISTENER_PROTOCOL_HTTP
+	}
+	if *proxyProtocol {
+		if *proxyTrusted == "" && !strings.HasPrefix(*listenAddress, server.UNIX_ADDRESS_PREFIX) {
+			log.Fatalf("error: -proxy-protocol requires -proxy-trusted")
+		}
+		dataListener.ProxyProtocol = &server.ProxyProtocolConfig{AllowMissingHeader: *proxyAllowMissing}
+		if *proxyTrusted != "" {
+			dataListener.ProxyProtocol.TrustedSources = strings.Split(*proxyTrusted, ",")
+		}
 	}
-}
 
-func echo(request http.HttpRequest) (http.HttpResponse, error) {
-	params := request.GetQueryParams()
+	if *tlsCert != "" {
+		certificate, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		dataListener.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
 
-	firstName, exists := params["first_name"]
-	if !exists {
-		return http.HttpResponse{}, http.BadRequest("missing param: first_name")
+	srv := server.NewServerWithListeners(dataListener)
+	srv.SetLogger(logger)
+	if *trustedProxies != "" {
+		err = srv.SetTrustedProxies(strings.Split(*trustedProxies, ",")...)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
 	}
+	if *allowedOrigins != "" {
+		srv.SetAllowedOrigins(strings.Split(*allowedOrigins, ",")...)
+	}
+	srv.SetShutdownDelay(*shutdownDelay)
+	srv.SetConnectionLimits(server.ConnectionLimits{
+		MaxConnections:   *maxConnections,
+		MaxHttpRequests:  *maxHttpRequests,
+		MaxWebSockets:    *maxWebSockets,
+		RejectWithStatus: true,
+		Workers:          *workers,
+		WorkerQueueSize:  *workers * 4})
+	registry := metrics.NewRegistry()
+	srv.SetMetrics(registry)
+	if *traceStdout {
+		srv.SetTracer(tracing.NewTracer(tracing.NewStdoutExporter(os.Stdout)))
+	}
+	if *enableHttp2 {
+		err = srv.SetHttp2(http2.Config{
+			MaxConcurrentStreams:  uint32(*http2MaxStreams),
+			EnableConnectProtocol: *http2WebSockets})
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+	if *connectAllow != "" {
+		err = srv.SetConnectTunnel(server.ConnectConfig{
+			AllowedTargets: strings.Split(*connectAllow, ","),
+			Credentials:    parseCredentials("-connect-credentials", *connectCredentials),
+			IdleTimeout:    *connectIdleTimeout})
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+	if *socksAllow != "" {
+		err = srv.SetSocksProxy(server.SocksConfig{
+			AllowedTargets: strings.Split(*socksAllow, ","),
+			Credentials:    parseCredentials("-socks-credentials", *socksCredentials),
+			DisableUDP:     !*socksUdp,
+			IdleTimeout:    *socksIdleTimeout})
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		if *socksListen != "" {
+			srv.AddListener(server.ListenerConfig{
+				Name:     "socks",
+				Address:  *socksListen,
+				Protocol: server.LISTENER_PROTOCOL_SOCKS})
+		}
+	}
+	routes := buildDataRoutes()
+	if *proxyUpstreams != "" {
+		proxy, err := server.NewReverseProxy(server.ReverseProxyConfig{
+			Upstreams:       strings.Split(*proxyUpstreams, ","),
+			StripPrefix:     *proxyPrefix,
+			HealthCheckPath: *proxyHealthPath})
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		defer proxy.Close()
 
-	lastName, exists := params["last_name"]
-	if !exists {
-		return http.HttpResponse{}, http.BadRequest("missing param: last_name")
+		err = proxy.Mount(routes, *proxyPrefix, server.WithName("proxy"), server.WithSummary("Reverse proxy to legacy upstreams"))
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		srv.AddReadinessCheck("upstreams", proxy.ReadinessCheck())
 	}
+	if *wsTunnelAllow != "" {
+		handler, err := server.NewWebSocketTunnel(server.WebSocketTunnelConfig{
+			AllowedTargets: strings.Split(*wsTunnelAllow, ","),
+			IdleTimeout:    *wsTunnelIdleTimeout})
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
 
-	greeting := fmt.Sprintf("Hello, %s %s", firstName, lastName)
-	responseObject := EchoResponse{
-		FirstName: firstName,
-		LastName:  lastName,
-		Greeting:  greeting}
+		err = routes.AddWebSocket(*wsTunnelPath, handler, server.WithName("tunnel"), server.WithSummary("Multiplexed TCP tunnel"))
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
 
-	return http.NewJsonResponse(&responseObject, 200)
+	adminRoutes := routes
+	if *adminAddress != "" {
+		adminRoutes = server.NewRouter()
+		srv.AddListener(server.ListenerConfig{
+			Name:        "admin",
+			Address:     *adminAddress,
+			Router:      adminRoutes,
+			Permissions: 0660})
+	}
+	addAdminRoutes(adminRoutes, routes, srv, registry, adminOptions{
+		metricsPath: *metricsPath,
+		routesPath:  *routesPath,
+		openapiPath: *openapiPath})
+
+	srv.SetRoutes(routes)
+	err = srv.Start()
+	if err != nil {
+		log.Panicf("error: %v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range signals {
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+
+		if sig == syscall.SIGHUP {
+			err = srv.Reexec(ctx)
+		} else {
+			err = srv.Shutdown(ctx)
+		}
+		cancel()
+
+		if err != nil {
+			logger.Error("graceful shutdown incomplete", "error", err)
+		}
+
+		if srv.Status().ShuttingDown {
+			return
+		}
+	}
 }
 
-type EchoResponse struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Greeting  string `json:"greeting"`
+func parseCredentials(flagName string, value string) map[string]string {
+	credentials := map[string]string{}
+	if value == "" {
+		return credentials
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		user, password, found := strings.Cut(pair, ":")
+		if !found {
+			log.Fatalf("error: invalid %s entry %q", flagName, pair)
+		}
+		credentials[user] = password
+	}
+	return credentials
 }
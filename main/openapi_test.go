@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/server"
+)
+
+const OPENAPI_SPEC_FILE = "openapi.json"
+
+var updateSpec = flag.Bool("update", false, "rewrite the committed OpenAPI document")
+
+func TestOpenAPISpecUpToDate(t *testing.T) {
+	dataRoutes := buildDataRoutes()
+	adminRoutes := server.NewRouter()
+	addAdminRoutes(adminRoutes, dataRoutes, server.NewServer(0), metrics.NewRegistry(), adminOptions{
+		metricsPath: "/metrics",
+		openapiPath: "/openapi.json"})
+
+	generated, err := server.MarshalOpenAPI(openapiInfo, dataRoutes, adminRoutes)
+	if err != nil {
+		t.Fatalf("failed to generate OpenAPI document: %v", err)
+	}
+
+	if *updateSpec {
+		err := os.WriteFile(OPENAPI_SPEC_FILE, generated, 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", OPENAPI_SPEC_FILE, err)
+		}
+	}
+
+	committed, err := os.ReadFile(OPENAPI_SPEC_FILE)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", OPENAPI_SPEC_FILE, err)
+	}
+
+	if !bytes.Equal(committed, generated) {
+		t.Errorf("%s is out of date with the registered routes; run `go test ./main -run TestOpenAPISpecUpToDate -update`", OPENAPI_SPEC_FILE)
+	}
+}
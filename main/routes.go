@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/server"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+var openapiInfo = server.OpenAPIInfo{
+	Title:       "gosocks",
+	Version:     "0.1.0",
+	Description: "gosocks data and control plane"}
+
+type adminOptions struct {
+	metricsPath string
+	routesPath  string
+	openapiPath string
+}
+
+func buildDataRoutes() server.Router {
+	routes := server.NewRouter()
+	websocketEchoHandler := websocket.WsHandler{
+		OnOpen: func(conn websocket.WsConnection) {
+			log.Printf("Connection opened from %s (%s)\n", conn.Request().ClientIP, conn.RemoteAddr())
+		},
+		OnMessage: func(wme websocket.WsMessageEvent, wc websocket.WsConnection) {
+			msg := string(wme.Data)
+			if msg == "bye" {
+				log.Println("closing now")
+				wc.Close(1000, "client closed")
+				return
+			}
+			response := fmt.Sprintf("received: %s", msg)
+			log.Println(response)
+			wc.SendText(response)
+		},
+		OnClose: func(wce websocket.WsCloseEvent, wc websocket.WsConnection) {
+			log.Printf("Closed: %d(%s) clean=%t\n", wce.Code, wce.Reason, wce.WasClean)
+		},
+		OnError: func(err error, conn websocket.WsConnection) {
+			log.Printf("Error: %s\n", err.Error())
+		},
+	}
+	websocketEcho := websocket.NewWsConnection(websocketEchoHandler)
+	routes.AddRoute("/greet", server.WithTimeout(server.JSON(echo), 5*time.Second),
+		server.WithMethods("GET"),
+		server.WithName("greet"),
+		server.WithSummary("Greet a person by name"),
+		server.WithSchemas(EchoRequest{}, EchoResponse{}))
+	routes.AddWebSocket("/wstest", websocketEcho, server.WithName("wstest"))
+
+	return routes
+}
+
+func addAdminRoutes(adminRoutes server.Router, dataRoutes server.Router, srv server.Server, registry *metrics.Registry, options adminOptions) {
+	adminRoutes.AddRoute(options.metricsPath, metrics.Handler(registry),
+		server.WithMethods("GET"),
+		server.WithName("metrics"),
+		server.WithSummary("Prometheus metrics"))
+	server.AddHealthRoutes(adminRoutes, srv)
+	if options.routesPath != "" {
+		adminRoutes.AddRoute(options.routesPath, server.RoutesHandler(dataRoutes),
+			server.WithMethods("GET"),
+			server.WithName("routes"),
+			server.WithSummary("Registered route table"))
+	}
+	if options.openapiPath != "" {
+		adminRoutes.AddRoute(options.openapiPath, server.OpenAPIHandler(openapiInfo, dataRoutes, adminRoutes),
+			server.WithMethods("GET"),
+			server.WithName("openapi"),
+			server.WithSummary("OpenAPI document"))
+	}
+}
+
+type EchoRequest struct {
+	FirstName string `json:"-" query:"first_name" validate:"required,max=64"`
+	LastName  string `json:"-" query:"last_name" validate:"required,max=64"`
+}
+
+func echo(ctx context.Context, request EchoRequest) (EchoResponse, error) {
+	greeting := fmt.Sprintf("Hello, %s %s", request.FirstName, request.LastName)
+	return EchoResponse{
+		FirstName: request.FirstName,
+		LastName:  request.LastName,
+		Greeting:  greeting}, nil
+}
+
+type EchoResponse struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Greeting  string `json:"greeting"`
+}
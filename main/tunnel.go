@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/tunnel"
+)
+
+func runTunnelClient(args []string) {
+	flags := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	tunnelUrl := flags.String("url", "", "ws:// or wss:// URL of the server tunnel route")
+	socksAddress := flags.String("socks", "127.0.0.1:1080", "local SOCKS5 listen address (empty = disabled)")
+	forwards := flags.String("forward", "", "comma separated local=target pairs forwarded through the tunnel")
+	headers := flags.String("header", "", "comma separated name:value headers sent with the tunnel handshake")
+	insecure := flags.Bool("insecure", false, "skip verification of the server TLS certificate")
+	logFormat := flags.String("log-format", logging.FORMAT_LOGFMT, "log format: json, logfmt or combined")
+	flags.Parse(args)
+
+	logger, err := logging.NewLogger(os.Stderr, *logFormat)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	config := tunnel.ClientConfig{
+		URL:     *tunnelUrl,
+		Headers: parseHeaders(*headers),
+		Logger:  logger}
+	if *insecure {
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	client, err := tunnel.NewClient(config)
+	if err != nil {
+		log.Fatalf("error: %v", err)
+	}
+	defer client.Close()
+
+	var listeners []net.Listener
+	listen := func(address string) net.Listener {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			log.Fatalf("error: %v", err)
+		}
+		listeners = append(listeners, listener)
+		return listener
+	}
+
+	if *socksAddress != "" {
+		listener := listen(*socksAddress)
+		logger.Info("serving socks5 through tunnel", "address", listener.Addr().String())
+		go client.ServeSocks(listener)
+	}
+	if *forwards != "" {
+		for _, forward := range strings.Split(*forwards, ",") {
+			local, target, found := strings.Cut(forward, "=")
+			if !found {
+				log.Fatalf("error: invalid -forward entry %q", forward)
+			}
+			listener := listen(local)
+			logger.Info("forwarding through tunnel", "address", listener.Addr().String(), "target", target)
+			go client.ServeForward(listener, target)
+		}
+	}
+	if len(listeners) == 0 {
+		log.Fatalf("error: nothing to serve, set -socks or -forward")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+func parseHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	if value == "" {
+		return headers
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		name, headerValue, found := strings.Cut(pair, ":")
+		if !found {
+			log.Fatalf("error: invalid -header entry %q", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	}
+	return headers
+}
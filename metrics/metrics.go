@@ -0,0 +1,326 @@
+package metrics
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const TYPE_COUNTER = "counter"
+const TYPE_GAUGE = "gauge"
+const TYPE_HISTOGRAM = "histogram"
+
+const labelSeparator = "\xff"
+
+var DEFAULT_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+	mutex      sync.Mutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+type Counter struct {
+	metric *metric
+}
+
+type Gauge struct {
+	metric *metric
+}
+
+type Histogram struct {
+	metric *metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: []*metric{}}
+}
+
+func (registry *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{metric: registry.register(name, help, TYPE_COUNTER, nil, labelNames)}
+}
+
+func (registry *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{metric: registry.register(name, help, TYPE_GAUGE, nil, labelNames)}
+}
+
+func (registry *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	sortedBuckets := append([]float64{}, buckets...)
+	sort.Float64s(sortedBuckets)
+	return &Histogram{metric: registry.register(name, help, TYPE_HISTOGRAM, sortedBuckets, labelNames)}
+}
+
+func (registry *Registry) register(name string, help string, metricType string, buckets []float64, labelNames []string) *metric {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	m := &metric{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*series{}}
+	registry.metrics = append(registry.metrics, m)
+	return m
+}
+
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *Counter) Add(value float64, labelValues ...string) {
+	if counter == nil || value < 0 {
+		return
+	}
+	counter.metric.update(labelValues, func(s *series) {
+		s.value += value
+	})
+}
+
+func (gauge *Gauge) Inc(labelValues ...string) {
+	gauge.Add(1, labelValues...)
+}
+
+func (gauge *Gauge) Dec(labelValues ...string) {
+	gauge.Add(-1, labelValues...)
+}
+
+func (gauge *Gauge) Add(value float64, labelValues ...string) {
+	if gauge == nil {
+		return
+	}
+	gauge.metric.update(labelValues, func(s *series) {
+		s.value += value
+	})
+}
+
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	if gauge == nil {
+		return
+	}
+	gauge.metric.update(labelValues, func(s *series) {
+		s.value = value
+	})
+}
+
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	if histogram == nil {
+		return
+	}
+	buckets := histogram.metric.buckets
+	histogram.metric.update(labelValues, func(s *series) {
+		if s.bucketCounts == nil {
+			s.bucketCounts = make([]uint64, len(buckets))
+		}
+		for i, upperBound := range buckets {
+			if value <= upperBound {
+				s.bucketCounts[i]++
+			}
+		}
+		s.value += value
+		s.count++
+	})
+}
+
+func (m *metric) update(labelValues []string, apply func(*series)) {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values. got=%d",
+			m.name, len(m.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, labelSeparator)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, exists := m.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		m.series[key] = s
+	}
+	apply(s)
+}
+
+type Snapshot struct {
+	XMLName xml.Name         `json:"-" xml:"metrics"`
+	Metrics []MetricSnapshot `json:"metrics" xml:"metric"`
+}
+
+type MetricSnapshot struct {
+	Name   string           `json:"name" xml:"name,attr"`
+	Help   string           `json:"help" xml:"help"`
+	Type   string           `json:"type" xml:"type,attr"`
+	Series []SeriesSnapshot `json:"series" xml:"series"`
+
+	labelNames []string
+}
+
+type SeriesSnapshot struct {
+	Labels  []Label          `json:"labels,omitempty" xml:"label"`
+	Value   float64          `json:"value" xml:"value"`
+	Count   uint64           `json:"count,omitempty" xml:"count,omitempty"`
+	Buckets []BucketSnapshot `json:"buckets,omitempty" xml:"bucket"`
+}
+
+type Label struct {
+	Name  string `json:"name" xml:"name,attr"`
+	Value string `json:"value" xml:"value,attr"`
+}
+
+type BucketSnapshot struct {
+	UpperBound float64 `json:"le" xml:"le,attr"`
+	Count      uint64  `json:"count" xml:"count,attr"`
+}
+
+func (registry *Registry) Snapshot() Snapshot {
+	registry.mutex.Lock()
+	metrics := append([]*metric{}, registry.metrics...)
+	registry.mutex.Unlock()
+
+	snapshot := Snapshot{Metrics: []MetricSnapshot{}}
+	for _, m := range metrics {
+		snapshot.Metrics = append(snapshot.Metrics, m.snapshot())
+	}
+	return snapshot
+}
+
+func (registry *Registry) WriteText(writer io.Writer) error {
+	_, err := io.WriteString(writer, registry.Snapshot().String())
+	return err
+}
+
+func (m *metric) snapshot() MetricSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	snapshot := MetricSnapshot{
+		Name:       m.name,
+		Help:       m.help,
+		Type:       m.metricType,
+		Series:     []SeriesSnapshot{},
+		labelNames: m.labelNames}
+
+	for _, key := range keys {
+		s := m.series[key]
+		seriesSnapshot := SeriesSnapshot{Value: s.value}
+		for i, labelName := range m.labelNames {
+			seriesSnapshot.Labels = append(seriesSnapshot.Labels, Label{Name: labelName, Value: s.labelValues[i]})
+		}
+
+		if m.metricType == TYPE_HISTOGRAM {
+			seriesSnapshot.Count = s.count
+			for i, upperBound := range m.buckets {
+				seriesSnapshot.Buckets = append(seriesSnapshot.Buckets,
+					BucketSnapshot{UpperBound: upperBound, Count: s.bucketCounts[i]})
+			}
+		}
+
+		snapshot.Series = append(snapshot.Series, seriesSnapshot)
+	}
+
+	return snapshot
+}
+
+func (snapshot Snapshot) String() string {
+	var builder strings.Builder
+	for _, m := range snapshot.Metrics {
+		m.writeText(&builder)
+	}
+	return builder.String()
+}
+
+func (m MetricSnapshot) writeText(builder *strings.Builder) {
+	builder.WriteString(fmt.Sprintf("# HELP %s %s\n", m.Name, escapeHelp(m.Help)))
+	builder.WriteString(fmt.Sprintf("# TYPE %s %s\n", m.Name, m.Type))
+
+	for _, s := range m.Series {
+		labelValues := []string{}
+		for _, label := range s.Labels {
+			labelValues = append(labelValues, label.Value)
+		}
+
+		if m.Type != TYPE_HISTOGRAM {
+			builder.WriteString(fmt.Sprintf("%s%s %s\n",
+				m.Name, formatLabels(m.labelNames, labelValues, ""), formatValue(s.Value)))
+			continue
+		}
+
+		for _, bucket := range s.Buckets {
+			builder.WriteString(fmt.Sprintf("%s_bucket%s %d\n",
+				m.Name, formatLabels(m.labelNames, labelValues, formatValue(bucket.UpperBound)), bucket.Count))
+		}
+		builder.WriteString(fmt.Sprintf("%s_bucket%s %d\n",
+			m.Name, formatLabels(m.labelNames, labelValues, "+Inf"), s.Count))
+		builder.WriteString(fmt.Sprintf("%s_sum%s %s\n",
+			m.Name, formatLabels(m.labelNames, labelValues, ""), formatValue(s.Value)))
+		builder.WriteString(fmt.Sprintf("%s_count%s %d\n",
+			m.Name, formatLabels(m.labelNames, labelValues, ""), s.Count))
+	}
+}
+
+func formatLabels(labelNames []string, labelValues []string, le string) string {
+	pairs := []string{}
+	for i, labelName := range labelNames {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	if math.IsInf(value, -1) {
+		return "-Inf"
+	}
+	if math.IsNaN(value) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("requests_total", "Total requests.", "route", "status")
+	connections := registry.NewGauge("connections", "Open connections.")
+	latency := registry.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/foo", "200")
+	requests.Inc("/foo", "200")
+	requests.Inc("/b\"ar", "404")
+	connections.Inc()
+	connections.Inc()
+	connections.Dec()
+	latency.Observe(0.05, "/foo")
+	latency.Observe(0.5, "/foo")
+	latency.Observe(5, "/foo")
+
+	expected := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{route="/b\"ar",status="404"} 1
+requests_total{route="/foo",status="200"} 2
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/foo",le="0.1"} 1
+latency_seconds_bucket{route="/foo",le="1"} 2
+latency_seconds_bucket{route="/foo",le="+Inf"} 3
+latency_seconds_sum{route="/foo"} 5.55
+latency_seconds_count{route="/foo"} 3
+`
+
+	var buffer bytes.Buffer
+	err := registry.WriteText(&buffer)
+	if err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+
+	if buffer.String() != expected {
+		t.Errorf("unexpected exposition output.\nexpected=\n%s\ngot=\n%s", expected, buffer.String())
+	}
+}
+
+func TestNilInstrumentsAreNoops(t *testing.T) {
+	serverMetrics := &ServerMetrics{}
+
+	serverMetrics.HttpRequests.Inc("/", "GET", "200")
+	serverMetrics.ActiveConnections.Dec()
+	serverMetrics.WsPingRtt.Observe(0.1)
+}
+
+func TestHandlerNegotiatesFormat(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Total requests.", "route").Inc("/foo")
+	handler := Handler(registry)
+
+	tests := []struct {
+		accept      string
+		contentType string
+		content     string
+	}{
+		{"", CONTENT_TYPE_PROMETHEUS, "# HELP requests_total Total requests.\n# TYPE requests_total counter\nrequests_total{route=\"/foo\"} 1\n"},
+		{"text/plain", CONTENT_TYPE_PROMETHEUS, "# HELP requests_total Total requests.\n# TYPE requests_total counter\nrequests_total{route=\"/foo\"} 1\n"},
+		{"application/json", http.CONTENT_TYPE_JSON, `{"metrics":[{"name":"requests_total","help":"Total requests.","type":"counter","series":[{"labels":[{"name":"route","value":"/foo"}],"value":1}]}]}`},
+	}
+
+	for _, tt := range tests {
+		headers := map[string]string{}
+		if tt.accept != "" {
+			headers["Accept"] = tt.accept
+		}
+
+		response, err := handler(http.HttpRequest{Method: "GET", FullPath: "/metrics", Headers: headers})
+		if err != nil {
+			t.Errorf("handler failed for %q: %v", tt.accept, err)
+			continue
+		}
+		if response.Headers["Content-Type"] != tt.contentType {
+			t.Errorf("content type for %q was not %s. got=%s", tt.accept, tt.contentType, response.Headers["Content-Type"])
+		}
+		if string(response.Content) != tt.content {
+			t.Errorf("unexpected content for %q.\nexpected=%s\ngot=%s", tt.accept, tt.content, response.Content)
+		}
+	}
+
+	_, err := handler(http.HttpRequest{Method: "GET", FullPath: "/metrics", Headers: map[string]string{"Accept": "image/png"}})
+	httpError, ok := err.(http.HttpError)
+	if !ok || httpError.StatusCode != 406 {
+		t.Errorf("expected 406 for image/png. got=%v", err)
+	}
+}
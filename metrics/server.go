@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const CONTENT_TYPE_PROMETHEUS = "text/plain; version=0.0.4; charset=utf-8"
+
+const DIRECTION_IN = "in"
+const DIRECTION_OUT = "out"
+
+var PING_RTT_BUCKETS = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+type ServerMetrics struct {
+	HttpRequests        *Counter
+	HttpRequestDuration *Histogram
+	ActiveConnections   *Gauge
+	WsOpened            *Counter
+	WsClosed            *Counter
+	WsFrames            *Counter
+	WsBytes             *Counter
+	WsPingRtt           *Histogram
+	HandlerPanics       *Counter
+	ConnectionsRejected *Counter
+	TunnelsOpened       *Counter
+	TunnelsRejected     *Counter
+	ActiveTunnels       *Gauge
+	TunnelBytes         *Counter
+}
+
+type metricsKey struct{}
+
+func NewServerMetrics(registry *Registry) *ServerMetrics {
+	return &ServerMetrics{
+		HttpRequests: registry.NewCounter(
+			"gosocks_http_requests_total",
+			"Total number of HTTP requests.",
+			"route", "method", "status"),
+		HttpRequestDuration: registry.NewHistogram(
+			"gosocks_http_request_duration_seconds",
+			"HTTP request latency in seconds.",
+			DEFAULT_BUCKETS,
+			"route", "method"),
+		ActiveConnections: registry.NewGauge(
+			"gosocks_active_connections",
+			"Number of currently open client connections."),
+		WsOpened: registry.NewCounter(
+			"gosocks_websocket_connections_opened_total",
+			"Total number of WebSocket connections opened."),
+		WsClosed: registry.NewCounter(
+			"gosocks_websocket_connections_closed_total",
+			"Total number of WebSocket connections closed.",
+			"code"),
+		WsFrames: registry.NewCounter(
+			"gosocks_websocket_frames_total",
+			"Total number of WebSocket frames.",
+			"direction"),
+		WsBytes: registry.NewCounter(
+			"gosocks_websocket_bytes_total",
+			"Total number of WebSocket bytes on the wire.",
+			"direction"),
+		WsPingRtt: registry.NewHistogram(
+			"gosocks_websocket_ping_rtt_seconds",
+			"Round trip time between WebSocket pings and pongs in seconds.",
+			PING_RTT_BUCKETS),
+		HandlerPanics: registry.NewCounter(
+			"gosocks_handler_panics_total",
+			"Total number of recovered handler panics.",
+			"kind"),
+		ConnectionsRejected: registry.NewCounter(
+			"gosocks_connections_rejected_total",
+			"Total number of connections and requests rejected by limits.",
+			"reason"),
+		TunnelsOpened: registry.NewCounter(
+			"gosocks_tunnels_opened_total",
+			"Total number of tunnels opened.",
+			"kind"),
+		TunnelsRejected: registry.NewCounter(
+			"gosocks_tunnels_rejected_total",
+			"Total number of tunnel requests rejected.",
+			"kind", "reason"),
+		ActiveTunnels: registry.NewGauge(
+			"gosocks_active_tunnels",
+			"Number of currently open tunnels.",
+			"kind"),
+		TunnelBytes: registry.NewCounter(
+			"gosocks_tunnel_bytes_total",
+			"Total number of bytes relayed through tunnels.",
+			"kind", "direction"),
+	}
+}
+
+func WithMetrics(ctx context.Context, serverMetrics *ServerMetrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, serverMetrics)
+}
+
+func FromContext(ctx context.Context) *ServerMetrics {
+	serverMetrics, ok := ctx.Value(metricsKey{}).(*ServerMetrics)
+	if !ok || serverMetrics == nil {
+		return &ServerMetrics{}
+	}
+	return serverMetrics
+}
+
+type expositionEncoder struct {
+	http.TextEncoder
+}
+
+func (expositionEncoder) ContentType() string {
+	return CONTENT_TYPE_PROMETHEUS
+}
+
+func Handler(registry *Registry) func(http.HttpRequest) (http.HttpResponse, error) {
+	negotiator := http.NewNegotiator(
+		expositionEncoder{},
+		http.JsonEncoder{},
+		http.CborEncoder{},
+		http.MsgpackEncoder{},
+		http.XmlEncoder{})
+
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		return negotiator.Respond(request, registry.Snapshot(), 200)
+	}
+}
@@ -0,0 +1,68 @@
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const FRAME_OPEN byte = 0x01
+const FRAME_OPEN_OK byte = 0x02
+const FRAME_OPEN_FAIL byte = 0x03
+const FRAME_DATA byte = 0x04
+const FRAME_CLOSE byte = 0x05
+const FRAME_RESET byte = 0x06
+const FRAME_WINDOW byte = 0x07
+
+const HEADER_SIZE = 5
+const MAX_DATA_SIZE = 16 * 1024
+const INITIAL_WINDOW = 256 * 1024
+
+type Frame struct {
+	Type     byte
+	StreamID uint32
+	Payload  []byte
+}
+
+func (frame Frame) Serialize() []byte {
+	buffer := make([]byte, HEADER_SIZE, HEADER_SIZE+len(frame.Payload))
+	buffer[0] = frame.Type
+	binary.BigEndian.PutUint32(buffer[1:], frame.StreamID)
+	return append(buffer, frame.Payload...)
+}
+
+func ParseFrame(data []byte) (Frame, error) {
+	if len(data) < HEADER_SIZE {
+		return Frame{}, fmt.Errorf("mux frame too short: %d bytes", len(data))
+	}
+
+	frame := Frame{
+		Type:     data[0],
+		StreamID: binary.BigEndian.Uint32(data[1:HEADER_SIZE]),
+		Payload:  data[HEADER_SIZE:]}
+
+	switch frame.Type {
+	case FRAME_OPEN, FRAME_OPEN_OK, FRAME_CLOSE, FRAME_RESET, FRAME_DATA:
+	case FRAME_OPEN_FAIL:
+		if len(frame.Payload) < 1 {
+			return Frame{}, fmt.Errorf("mux open failure frame without reply code")
+		}
+	case FRAME_WINDOW:
+		if len(frame.Payload) != 4 {
+			return Frame{}, fmt.Errorf("mux window frame has invalid length %d", len(frame.Payload))
+		}
+	default:
+		return Frame{}, fmt.Errorf("unknown mux frame type %d", frame.Type)
+	}
+
+	if frame.StreamID == 0 {
+		return Frame{}, fmt.Errorf("mux frame for reserved stream 0")
+	}
+	return frame, nil
+}
+
+func windowFrame(streamID uint32, increment int) Frame {
+	return Frame{
+		Type:     FRAME_WINDOW,
+		StreamID: streamID,
+		Payload:  binary.BigEndian.AppendUint32(nil, uint32(increment))}
+}
@@ -0,0 +1,180 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameSerialization(t *testing.T) {
+	frame := Frame{Type: FRAME_DATA, StreamID: 7, Payload: []byte("payload")}
+
+	parsed, err := ParseFrame(frame.Serialize())
+	if err != nil {
+		t.Fatalf("failed to parse frame: %v", err)
+	}
+	if parsed.Type != FRAME_DATA || parsed.StreamID != 7 || string(parsed.Payload) != "payload" {
+		t.Errorf("frame did not round trip. got=%+v", parsed)
+	}
+
+	invalid := [][]byte{
+		{FRAME_DATA, 0, 0},
+		Frame{Type: 0x7F, StreamID: 1}.Serialize(),
+		Frame{Type: FRAME_DATA, StreamID: 0}.Serialize(),
+		Frame{Type: FRAME_WINDOW, StreamID: 1, Payload: []byte{1}}.Serialize(),
+		Frame{Type: FRAME_OPEN_FAIL, StreamID: 1}.Serialize(),
+	}
+	for _, data := range invalid {
+		if _, err := ParseFrame(data); err == nil {
+			t.Errorf("expected frame %v to be rejected", data)
+		}
+	}
+}
+
+func connectSessions(t *testing.T, accept func(stream *Stream, target string)) (*Session, *Session) {
+	toServer := make(chan []byte, 1024)
+	toClient := make(chan []byte, 1024)
+
+	client := NewSession(func(data []byte) error {
+		toServer <- append([]byte{}, data...)
+		return nil
+	}, nil)
+	server := NewSession(func(data []byte) error {
+		toClient <- append([]byte{}, data...)
+		return nil
+	}, accept)
+
+	deliver := func(messages chan []byte, session *Session) {
+		for {
+			select {
+			case data := <-messages:
+				if err := session.HandleMessage(data); err != nil {
+					t.Errorf("failed to handle message: %v", err)
+				}
+			case <-session.Done():
+				return
+			}
+		}
+	}
+	go deliver(toServer, server)
+	go deliver(toClient, client)
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func echoStream(stream *Stream, target string) {
+	stream.Accept()
+	io.Copy(stream, stream)
+	stream.CloseWrite()
+}
+
+func TestSessionMultiplexing(t *testing.T) {
+	client, _ := connectSessions(t, echoStream)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			stream, err := client.Open(context.Background(), "echo:7")
+			if err != nil {
+				t.Errorf("failed to open stream: %v", err)
+				return
+			}
+			defer stream.Close()
+
+			payload := bytes.Repeat([]byte{byte('a' + i)}, 3*INITIAL_WINDOW+123)
+			go func() {
+				stream.Write(payload)
+				stream.CloseWrite()
+			}()
+
+			echoed, err := io.ReadAll(stream)
+			if err != nil {
+				t.Errorf("failed to read echo: %v", err)
+				return
+			}
+			if !bytes.Equal(echoed, payload) {
+				t.Errorf("stream %d echoed %d bytes, expected %d", i, len(echoed), len(payload))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for client.NumStreams() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected finished streams to be removed. got=%d", client.NumStreams())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionReject(t *testing.T) {
+	client, _ := connectSessions(t, func(stream *Stream, target string) {
+		stream.Reject(0x02, "not allowed: "+target)
+	})
+
+	_, err := client.Open(context.Background(), "blocked:22")
+	var openErr OpenError
+	if !errors.As(err, &openErr) || openErr.Code != 0x02 || openErr.Message != "not allowed: blocked:22" {
+		t.Errorf("expected open error. got=%v", err)
+	}
+
+	_, server := connectSessions(t, echoStream)
+	_, err = server.Open(context.Background(), "client:1")
+	if !errors.As(err, &openErr) {
+		t.Errorf("expected session without accept handler to reject streams. got=%v", err)
+	}
+}
+
+func TestStreamResetAndClose(t *testing.T) {
+	accepted := make(chan *Stream, 1)
+	client, _ := connectSessions(t, func(stream *Stream, target string) {
+		stream.Accept()
+		accepted <- stream
+	})
+
+	stream, err := client.Open(context.Background(), "target:1")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	remote := <-accepted
+
+	stream.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = stream.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected read deadline to expire. got=%v", err)
+	}
+	stream.SetReadDeadline(time.Time{})
+
+	stream.Close()
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = remote.Read(make([]byte, 1))
+	if !errors.Is(err, ErrStreamReset) {
+		t.Errorf("expected remote stream to be reset. got=%v", err)
+	}
+
+	second, err := client.Open(context.Background(), "target:2")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	client.Close()
+	_, err = second.Read(make([]byte, 1))
+	if !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected closed session to abort streams. got=%v", err)
+	}
+	if _, err := client.Open(context.Background(), "target:3"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("expected open on closed session to fail. got=%v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrSessionClosed = errors.New("mux session closed")
+var ErrStreamReset = errors.New("mux stream reset by peer")
+
+type OpenError struct {
+	Code    byte
+	Message string
+}
+
+func (err OpenError) Error() string {
+	return fmt.Sprintf("mux stream rejected: %s", err.Message)
+}
+
+type Session struct {
+	send       func(data []byte) error
+	accept     func(stream *Stream, target string)
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	streams    map[uint32]*Stream
+	nextID     uint32
+	closed     bool
+	done       chan struct{}
+}
+
+func NewSession(send func(data []byte) error, accept func(stream *Stream, target string)) *Session {
+	return &Session{
+		send:    send,
+		accept:  accept,
+		streams: map[uint32]*Stream{},
+		done:    make(chan struct{})}
+}
+
+func (session *Session) Done() <-chan struct{} {
+	return session.done
+}
+
+func (session *Session) NumStreams() int {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return len(session.streams)
+}
+
+func (session *Session) Open(ctx context.Context, target string) (*Stream, error) {
+	session.mutex.Lock()
+	if session.closed {
+		session.mutex.Unlock()
+		return nil, ErrSessionClosed
+	}
+	session.nextID++
+	stream := newStream(session, session.nextID, target)
+	session.streams[stream.id] = stream
+	session.mutex.Unlock()
+
+	err := session.writeFrame(Frame{Type: FRAME_OPEN, StreamID: stream.id, Payload: []byte(target)})
+	if err != nil {
+		session.remove(stream.id)
+		return nil, err
+	}
+
+	select {
+	case err = <-stream.opened:
+		if err != nil {
+			session.remove(stream.id)
+			return nil, err
+		}
+		return stream, nil
+	case <-ctx.Done():
+		stream.Close()
+		return nil, ctx.Err()
+	case <-session.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+func (session *Session) HandleMessage(data []byte) error {
+	frame, err := ParseFrame(data)
+	if err != nil {
+		return err
+	}
+
+	if frame.Type == FRAME_OPEN {
+		session.handleOpen(frame)
+		return nil
+	}
+
+	session.mutex.Lock()
+	stream, exists := session.streams[frame.StreamID]
+	session.mutex.Unlock()
+	if !exists {
+		return nil
+	}
+
+	switch frame.Type {
+	case FRAME_OPEN_OK:
+		stream.resolveOpen(nil)
+	case FRAME_OPEN_FAIL:
+		session.remove(stream.id)
+		stream.resolveOpen(OpenError{Code: frame.Payload[0], Message: string(frame.Payload[1:])})
+	case FRAME_DATA:
+		stream.receive(frame.Payload)
+	case FRAME_CLOSE:
+		stream.receiveClose()
+	case FRAME_RESET:
+		session.remove(stream.id)
+		stream.abort(ErrStreamReset)
+	case FRAME_WINDOW:
+		stream.grant(int(binary.BigEndian.Uint32(frame.Payload)))
+	}
+	return nil
+}
+
+func (session *Session) handleOpen(frame Frame) {
+	session.mutex.Lock()
+	_, exists := session.streams[frame.StreamID]
+	if session.accept == nil || session.closed || exists {
+		session.mutex.Unlock()
+		session.writeFrame(Frame{Type: FRAME_OPEN_FAIL, StreamID: frame.StreamID, Payload: []byte{0x01}})
+		return
+	}
+	stream := newStream(session, frame.StreamID, string(frame.Payload))
+	session.streams[stream.id] = stream
+	session.mutex.Unlock()
+
+	go session.accept(stream, stream.target)
+}
+
+func (session *Session) Close() {
+	session.mutex.Lock()
+	if session.closed {
+		session.mutex.Unlock()
+		return
+	}
+	session.closed = true
+	streams := session.streams
+	session.streams = map[uint32]*Stream{}
+	close(session.done)
+	session.mutex.Unlock()
+
+	for _, stream := range streams {
+		stream.abort(ErrSessionClosed)
+	}
+}
+
+func (session *Session) remove(id uint32) {
+	session.mutex.Lock()
+	delete(session.streams, id)
+	session.mutex.Unlock()
+}
+
+func (session *Session) writeFrame(frame Frame) error {
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+
+	select {
+	case <-session.done:
+		return ErrSessionClosed
+	default:
+	}
+	return session.send(frame.Serialize())
+}
@@ -0,0 +1,292 @@
+package mux
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type streamAddr struct {
+	target string
+}
+
+func (addr streamAddr) Network() string {
+	return "mux"
+}
+
+func (addr streamAddr) String() string {
+	return addr.target
+}
+
+type Stream struct {
+	session       *Session
+	id            uint32
+	target        string
+	opened        chan error
+	readable      chan struct{}
+	writable      chan struct{}
+	mutex         sync.Mutex
+	buffer        []byte
+	consumed      int
+	sendWindow    int
+	localClosed   bool
+	remoteClosed  bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(session *Session, id uint32, target string) *Stream {
+	return &Stream{
+		session:    session,
+		id:         id,
+		target:     target,
+		opened:     make(chan error, 1),
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+		sendWindow: INITIAL_WINDOW}
+}
+
+func (stream *Stream) ID() uint32 {
+	return stream.id
+}
+
+func (stream *Stream) Target() string {
+	return stream.target
+}
+
+func (stream *Stream) Accept() error {
+	return stream.session.writeFrame(Frame{Type: FRAME_OPEN_OK, StreamID: stream.id})
+}
+
+func (stream *Stream) Reject(code byte, message string) error {
+	stream.session.remove(stream.id)
+	stream.abort(net.ErrClosed)
+	payload := append([]byte{code}, message...)
+	return stream.session.writeFrame(Frame{Type: FRAME_OPEN_FAIL, StreamID: stream.id, Payload: payload})
+}
+
+func (stream *Stream) Read(data []byte) (int, error) {
+	for {
+		stream.mutex.Lock()
+		if len(stream.buffer) > 0 {
+			n := copy(data, stream.buffer)
+			stream.buffer = stream.buffer[n:]
+			stream.consumed += n
+			increment := 0
+			if stream.consumed >= INITIAL_WINDOW/2 {
+				increment = stream.consumed
+				stream.consumed = 0
+			}
+			stream.mutex.Unlock()
+
+			if increment > 0 {
+				stream.session.writeFrame(windowFrame(stream.id, increment))
+			}
+			return n, nil
+		}
+		if stream.err != nil {
+			err := stream.err
+			stream.mutex.Unlock()
+			return 0, err
+		}
+		if stream.remoteClosed {
+			stream.mutex.Unlock()
+			return 0, io.EOF
+		}
+		deadline := stream.readDeadline
+		stream.mutex.Unlock()
+
+		err := wait(stream.readable, deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (stream *Stream) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		stream.mutex.Lock()
+		if stream.err != nil {
+			err := stream.err
+			stream.mutex.Unlock()
+			return written, err
+		}
+		if stream.localClosed {
+			stream.mutex.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if stream.sendWindow == 0 {
+			deadline := stream.writeDeadline
+			stream.mutex.Unlock()
+
+			err := wait(stream.writable, deadline)
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := min(len(data)-written, MAX_DATA_SIZE, stream.sendWindow)
+		stream.sendWindow -= n
+		stream.mutex.Unlock()
+
+		err := stream.session.writeFrame(Frame{Type: FRAME_DATA, StreamID: stream.id, Payload: data[written : written+n]})
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (stream *Stream) CloseWrite() error {
+	stream.mutex.Lock()
+	if stream.localClosed || stream.err != nil {
+		stream.mutex.Unlock()
+		return nil
+	}
+	stream.localClosed = true
+	finished := stream.remoteClosed
+	stream.mutex.Unlock()
+
+	if finished {
+		stream.session.remove(stream.id)
+	}
+	return stream.session.writeFrame(Frame{Type: FRAME_CLOSE, StreamID: stream.id})
+}
+
+func (stream *Stream) Close() error {
+	stream.mutex.Lock()
+	reset := stream.err == nil && !(stream.localClosed && stream.remoteClosed)
+	if stream.err == nil {
+		stream.err = net.ErrClosed
+	}
+	stream.mutex.Unlock()
+	notify(stream.readable)
+	notify(stream.writable)
+
+	stream.session.remove(stream.id)
+	if reset {
+		return stream.session.writeFrame(Frame{Type: FRAME_RESET, StreamID: stream.id})
+	}
+	return nil
+}
+
+func (stream *Stream) LocalAddr() net.Addr {
+	return streamAddr{target: "local"}
+}
+
+func (stream *Stream) RemoteAddr() net.Addr {
+	return streamAddr{target: stream.target}
+}
+
+func (stream *Stream) SetDeadline(deadline time.Time) error {
+	stream.SetReadDeadline(deadline)
+	return stream.SetWriteDeadline(deadline)
+}
+
+func (stream *Stream) SetReadDeadline(deadline time.Time) error {
+	stream.mutex.Lock()
+	stream.readDeadline = deadline
+	stream.mutex.Unlock()
+	notify(stream.readable)
+	return nil
+}
+
+func (stream *Stream) SetWriteDeadline(deadline time.Time) error {
+	stream.mutex.Lock()
+	stream.writeDeadline = deadline
+	stream.mutex.Unlock()
+	notify(stream.writable)
+	return nil
+}
+
+func (stream *Stream) receive(data []byte) {
+	stream.mutex.Lock()
+	if stream.err != nil || stream.remoteClosed {
+		stream.mutex.Unlock()
+		return
+	}
+	if len(stream.buffer)+len(data) > INITIAL_WINDOW {
+		stream.mutex.Unlock()
+		stream.session.remove(stream.id)
+		stream.abort(errors.New("mux peer exceeded the stream window"))
+		stream.session.writeFrame(Frame{Type: FRAME_RESET, StreamID: stream.id})
+		return
+	}
+	stream.buffer = append(stream.buffer, data...)
+	stream.mutex.Unlock()
+	notify(stream.readable)
+}
+
+func (stream *Stream) receiveClose() {
+	stream.mutex.Lock()
+	stream.remoteClosed = true
+	finished := stream.localClosed
+	stream.mutex.Unlock()
+	notify(stream.readable)
+
+	if finished {
+		stream.session.remove(stream.id)
+	}
+}
+
+func (stream *Stream) grant(increment int) {
+	stream.mutex.Lock()
+	stream.sendWindow += increment
+	stream.mutex.Unlock()
+	notify(stream.writable)
+}
+
+func (stream *Stream) abort(err error) {
+	stream.mutex.Lock()
+	if stream.err == nil {
+		stream.err = err
+	}
+	stream.mutex.Unlock()
+
+	stream.resolveOpen(err)
+	notify(stream.readable)
+	notify(stream.writable)
+}
+
+func (stream *Stream) resolveOpen(err error) {
+	select {
+	case stream.opened <- err:
+	default:
+	}
+}
+
+func notify(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
+
+func wait(signal chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-signal
+		return nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-signal:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,45 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+)
+
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	header Header
+}
+
+func NewConn(conn net.Conn, reader *bufio.Reader, header Header) *Conn {
+	return &Conn{Conn: conn, reader: reader, header: header}
+}
+
+func (conn *Conn) Read(data []byte) (int, error) {
+	return conn.reader.Read(data)
+}
+
+func (conn *Conn) RemoteAddr() net.Addr {
+	if conn.header.Source != nil {
+		return conn.header.Source
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+func (conn *Conn) LocalAddr() net.Addr {
+	if conn.header.Destination != nil {
+		return conn.header.Destination
+	}
+	return conn.Conn.LocalAddr()
+}
+
+func (conn *Conn) ProxyHeader() Header {
+	return conn.header
+}
+
+func (conn *Conn) CloseWrite() error {
+	if halfCloser, ok := conn.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return conn.Conn.Close()
+}
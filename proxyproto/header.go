@@ -0,0 +1,362 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const V1_PREFIX = "PROXY "
+const V1_MAX_LENGTH = 107
+
+const COMMAND_LOCAL byte = 0x0
+const COMMAND_PROXY byte = 0x1
+
+const FAMILY_UNSPEC byte = 0x00
+const FAMILY_TCP4 byte = 0x11
+const FAMILY_UDP4 byte = 0x12
+const FAMILY_TCP6 byte = 0x21
+const FAMILY_UDP6 byte = 0x22
+const FAMILY_UNIX_STREAM byte = 0x31
+const FAMILY_UNIX_DGRAM byte = 0x32
+
+const TLV_ALPN byte = 0x01
+const TLV_AUTHORITY byte = 0x02
+const TLV_CRC32C byte = 0x03
+const TLV_NOOP byte = 0x04
+const TLV_UNIQUE_ID byte = 0x05
+const TLV_SSL byte = 0x20
+const TLV_NETNS byte = 0x30
+
+var V2_SIGNATURE = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+type Header struct {
+	Version     int
+	Command     byte
+	Family      byte
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV
+}
+
+func (header Header) TLV(tlvType byte) ([]byte, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == tlvType {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (header Header) Authority() string {
+	value, _ := header.TLV(TLV_AUTHORITY)
+	return string(value)
+}
+
+func (header Header) UniqueID() []byte {
+	value, _ := header.TLV(TLV_UNIQUE_ID)
+	return value
+}
+
+func HasHeader(reader *bufio.Reader) (bool, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return false, err
+	}
+
+	switch first[0] {
+	case V1_PREFIX[0]:
+		prefix, err := reader.Peek(len(V1_PREFIX))
+		if err != nil {
+			return false, err
+		}
+		return string(prefix) == V1_PREFIX, nil
+	case V2_SIGNATURE[0]:
+		signature, err := reader.Peek(len(V2_SIGNATURE))
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(signature, V2_SIGNATURE), nil
+	}
+
+	return false, nil
+}
+
+func ReadHeader(reader *bufio.Reader) (Header, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read proxy header: %w", err)
+	}
+
+	if first[0] == V2_SIGNATURE[0] {
+		return readV2Header(reader)
+	}
+
+	return readV1Header(reader)
+}
+
+func readV1Header(reader *bufio.Reader) (Header, error) {
+	line := []byte{}
+	for len(line) < V1_MAX_LENGTH {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return Header{}, fmt.Errorf("failed to read v1 proxy header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return Header{}, fmt.Errorf("v1 proxy header not terminated by CRLF")
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) < 2 || fields[0] != strings.TrimSpace(V1_PREFIX) {
+		return Header{}, fmt.Errorf("v1 proxy header malformed: %q", line)
+	}
+
+	header := Header{Version: 1, Command: COMMAND_PROXY}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		header.Command = COMMAND_LOCAL
+		header.Family = FAMILY_UNSPEC
+		return header, nil
+	case "TCP4":
+		header.Family = FAMILY_TCP4
+	case "TCP6":
+		header.Family = FAMILY_TCP6
+	default:
+		return Header{}, fmt.Errorf("v1 proxy header protocol invalid: %s", fields[1])
+	}
+
+	if len(fields) != 6 {
+		return Header{}, fmt.Errorf("v1 proxy header malformed: %q", line)
+	}
+
+	source, err := parseV1Address(fields[2], fields[4], header.Family)
+	if err != nil {
+		return Header{}, err
+	}
+
+	destination, err := parseV1Address(fields[3], fields[5], header.Family)
+	if err != nil {
+		return Header{}, err
+	}
+
+	header.Source = source
+	header.Destination = destination
+	return header, nil
+}
+
+func parseV1Address(rawIp string, rawPort string, family byte) (*net.TCPAddr, error) {
+	ip := net.ParseIP(rawIp)
+	if ip == nil {
+		return nil, fmt.Errorf("v1 proxy header address invalid: %s", rawIp)
+	}
+
+	if (family == FAMILY_TCP4) != (ip.To4() != nil) {
+		return nil, fmt.Errorf("v1 proxy header address %s does not match protocol", rawIp)
+	}
+
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+	if err != nil || (len(rawPort) > 1 && rawPort[0] == '0') {
+		return nil, fmt.Errorf("v1 proxy header port invalid: %s", rawPort)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readV2Header(reader *bufio.Reader) (Header, error) {
+	fixed := make([]byte, 16)
+	_, err := readFull(reader, fixed)
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read v2 proxy header: %w", err)
+	}
+
+	if !bytes.Equal(fixed[:12], V2_SIGNATURE) {
+		return Header{}, fmt.Errorf("v2 proxy header signature invalid")
+	}
+
+	if fixed[12]>>4 != 0x2 {
+		return Header{}, fmt.Errorf("v2 proxy header version invalid: %d", fixed[12]>>4)
+	}
+
+	header := Header{
+		Version: 2,
+		Command: fixed[12] & 0x0F,
+		Family:  fixed[13]}
+
+	if header.Command != COMMAND_LOCAL && header.Command != COMMAND_PROXY {
+		return Header{}, fmt.Errorf("v2 proxy header command invalid: %d", header.Command)
+	}
+
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+	payload := make([]byte, length)
+	_, err = readFull(reader, payload)
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read v2 proxy header addresses: %w", err)
+	}
+
+	addressLength, err := parseV2Addresses(&header, payload)
+	if err != nil {
+		return Header{}, err
+	}
+
+	tlvs, err := parseTLVs(payload[addressLength:])
+	if err != nil {
+		return Header{}, err
+	}
+	header.TLVs = tlvs
+
+	err = verifyChecksum(header, fixed, payload, addressLength)
+	if err != nil {
+		return Header{}, err
+	}
+
+	if header.Command == COMMAND_LOCAL {
+		header.Source = nil
+		header.Destination = nil
+	}
+
+	return header, nil
+}
+
+func parseV2Addresses(header *Header, payload []byte) (int, error) {
+	switch header.Family {
+	case FAMILY_TCP4, FAMILY_UDP4:
+		if len(payload) < 12 {
+			return 0, fmt.Errorf("v2 proxy header too short for IPv4 addresses")
+		}
+		header.Source, header.Destination = inetAddresses(header.Family,
+			net.IP(payload[0:4]), net.IP(payload[4:8]),
+			binary.BigEndian.Uint16(payload[8:10]), binary.BigEndian.Uint16(payload[10:12]))
+		return 12, nil
+	case FAMILY_TCP6, FAMILY_UDP6:
+		if len(payload) < 36 {
+			return 0, fmt.Errorf("v2 proxy header too short for IPv6 addresses")
+		}
+		header.Source, header.Destination = inetAddresses(header.Family,
+			net.IP(payload[0:16]), net.IP(payload[16:32]),
+			binary.BigEndian.Uint16(payload[32:34]), binary.BigEndian.Uint16(payload[34:36]))
+		return 36, nil
+	case FAMILY_UNIX_STREAM, FAMILY_UNIX_DGRAM:
+		if len(payload) < 216 {
+			return 0, fmt.Errorf("v2 proxy header too short for unix addresses")
+		}
+		network := "unix"
+		if header.Family == FAMILY_UNIX_DGRAM {
+			network = "unixgram"
+		}
+		header.Source = &net.UnixAddr{Name: cString(payload[0:108]), Net: network}
+		header.Destination = &net.UnixAddr{Name: cString(payload[108:216]), Net: network}
+		return 216, nil
+	case FAMILY_UNSPEC:
+		return 0, nil
+	}
+
+	if header.Command == COMMAND_LOCAL {
+		return 0, nil
+	}
+
+	return 0, fmt.Errorf("v2 proxy header family invalid: %#x", header.Family)
+}
+
+func inetAddresses(family byte, sourceIp net.IP, destinationIp net.IP, sourcePort uint16, destinationPort uint16) (net.Addr, net.Addr) {
+	sourceIp = append(net.IP{}, sourceIp...)
+	destinationIp = append(net.IP{}, destinationIp...)
+
+	if family == FAMILY_UDP4 || family == FAMILY_UDP6 {
+		return &net.UDPAddr{IP: sourceIp, Port: int(sourcePort)},
+			&net.UDPAddr{IP: destinationIp, Port: int(destinationPort)}
+	}
+
+	return &net.TCPAddr{IP: sourceIp, Port: int(sourcePort)},
+		&net.TCPAddr{IP: destinationIp, Port: int(destinationPort)}
+}
+
+func parseTLVs(data []byte) ([]TLV, error) {
+	tlvs := []TLV{}
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("v2 proxy header TLV truncated")
+		}
+
+		tlvType := data[0]
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, fmt.Errorf("v2 proxy header TLV %#x exceeds header length", tlvType)
+		}
+
+		tlvs = append(tlvs, TLV{Type: tlvType, Value: append([]byte{}, data[3:3+length]...)})
+		data = data[3+length:]
+	}
+
+	return tlvs, nil
+}
+
+func verifyChecksum(header Header, fixed []byte, payload []byte, addressLength int) error {
+	expected, found := header.TLV(TLV_CRC32C)
+	if !found {
+		return nil
+	}
+
+	if len(expected) != 4 {
+		return fmt.Errorf("v2 proxy header CRC32C TLV has invalid length")
+	}
+
+	zeroed := append([]byte{}, payload...)
+	offset := addressLength
+	for offset+3 <= len(zeroed) {
+		length := int(binary.BigEndian.Uint16(zeroed[offset+1 : offset+3]))
+		if zeroed[offset] == TLV_CRC32C {
+			for i := offset + 3; i < offset+3+length; i++ {
+				zeroed[i] = 0
+			}
+		}
+		offset += 3 + length
+	}
+
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	checksum.Write(fixed)
+	checksum.Write(zeroed)
+
+	if checksum.Sum32() != binary.BigEndian.Uint32(expected) {
+		return fmt.Errorf("v2 proxy header CRC32C mismatch")
+	}
+
+	return nil
+}
+
+func readFull(reader *bufio.Reader, data []byte) (int, error) {
+	read := 0
+	for read < len(data) {
+		n, err := reader.Read(data[read:])
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func cString(data []byte) string {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		return string(data)
+	}
+	return string(data[:end])
+}
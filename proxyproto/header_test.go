@@ -0,0 +1,175 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestReadV1Header(t *testing.T) {
+	tests := []struct {
+		input               string
+		expectedCommand     byte
+		expectedSource      string
+		expectedDestination string
+	}{
+		{"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\nGET", COMMAND_PROXY, "192.0.2.1:56324", "198.51.100.7:443"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\nGET", COMMAND_PROXY, "[2001:db8::1]:4000", "[2001:db8::2]:80"},
+		{"PROXY UNKNOWN\r\nGET", COMMAND_LOCAL, "", ""},
+	}
+
+	for _, tt := range tests {
+		reader := bufio.NewReader(strings.NewReader(tt.input))
+		header, err := ReadHeader(reader)
+		if err != nil {
+			t.Fatalf("failed to read %q: %v", tt.input, err)
+		}
+
+		if header.Version != 1 || header.Command != tt.expectedCommand {
+			t.Errorf("unexpected version/command for %q. got=%d/%d", tt.input, header.Version, header.Command)
+		}
+
+		if tt.expectedSource != "" && header.Source.String() != tt.expectedSource {
+			t.Errorf("unexpected source. expected=%s, got=%s", tt.expectedSource, header.Source)
+		}
+
+		if tt.expectedDestination != "" && header.Destination.String() != tt.expectedDestination {
+			t.Errorf("unexpected destination. expected=%s, got=%s", tt.expectedDestination, header.Destination)
+		}
+
+		rest, _ := reader.ReadString('\n')
+		if rest != "GET" {
+			t.Errorf("header consumed request bytes. remaining=%q", rest)
+		}
+	}
+}
+
+func TestReadV1HeaderInvalid(t *testing.T) {
+	inputs := []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.7 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 99999 443\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.7 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 1 2\n",
+		"PROXY " + strings.Repeat("A", 120) + "\r\n",
+	}
+
+	for _, input := range inputs {
+		_, err := ReadHeader(bufio.NewReader(strings.NewReader(input)))
+		if err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func buildV2Header(command byte, family byte, addresses []byte, tlvs []TLV, withChecksum bool) []byte {
+	payload := append([]byte{}, addresses...)
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	checksumOffset := -1
+	if withChecksum {
+		payload = append(payload, TLV_CRC32C, 0, 4)
+		checksumOffset = len(payload)
+		payload = append(payload, 0, 0, 0, 0)
+	}
+
+	header := append([]byte{}, V2_SIGNATURE...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	header = append(header, payload...)
+
+	if withChecksum {
+		checksum := crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(header[16+checksumOffset:], checksum)
+	}
+
+	return header
+}
+
+func TestReadV2Header(t *testing.T) {
+	addresses := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0xDC, 0x04, 0x01, 0xBB}
+	tlvs := []TLV{
+		{Type: TLV_AUTHORITY, Value: []byte("api.example.internal")},
+		{Type: TLV_UNIQUE_ID, Value: []byte{1, 2, 3}},
+	}
+	input := append(buildV2Header(COMMAND_PROXY, FAMILY_TCP4, addresses, tlvs, true), []byte("GET")...)
+
+	reader := bufio.NewReader(bytes.NewReader(input))
+	header, err := ReadHeader(reader)
+	if err != nil {
+		t.Fatalf("failed to read v2 header: %v", err)
+	}
+
+	if header.Version != 2 || header.Command != COMMAND_PROXY || header.Family != FAMILY_TCP4 {
+		t.Errorf("unexpected header fields: %+v", header)
+	}
+
+	if header.Source.String() != "192.0.2.1:56324" || header.Destination.String() != "198.51.100.7:443" {
+		t.Errorf("unexpected addresses. got=%s -> %s", header.Source, header.Destination)
+	}
+
+	if header.Authority() != "api.example.internal" {
+		t.Errorf("unexpected authority. got=%s", header.Authority())
+	}
+
+	if !bytes.Equal(header.UniqueID(), []byte{1, 2, 3}) {
+		t.Errorf("unexpected unique id. got=%x", header.UniqueID())
+	}
+
+	rest, _ := reader.ReadString('\n')
+	if rest != "GET" {
+		t.Errorf("header consumed request bytes. remaining=%q", rest)
+	}
+}
+
+func TestReadV2HeaderLocal(t *testing.T) {
+	input := buildV2Header(COMMAND_LOCAL, FAMILY_UNSPEC, nil, nil, false)
+
+	header, err := ReadHeader(bufio.NewReader(bytes.NewReader(input)))
+	if err != nil {
+		t.Fatalf("failed to read v2 header: %v", err)
+	}
+
+	if header.Command != COMMAND_LOCAL || header.Source != nil {
+		t.Errorf("unexpected local header: %+v", header)
+	}
+}
+
+func TestReadV2HeaderChecksumMismatch(t *testing.T) {
+	addresses := []byte{192, 0, 2, 1, 198, 51, 100, 7, 0xDC, 0x04, 0x01, 0xBB}
+	input := buildV2Header(COMMAND_PROXY, FAMILY_TCP4, addresses, nil, true)
+	input[16] = 10
+
+	_, err := ReadHeader(bufio.NewReader(bytes.NewReader(input)))
+	if err == nil {
+		t.Errorf("expected checksum error")
+	}
+}
+
+func TestHasHeader(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected bool
+	}{
+		{[]byte("PROXY TCP4 192.0.2.1 198.51.100.7 1 2\r\n"), true},
+		{append(append([]byte{}, V2_SIGNATURE...), 0x21, 0x11, 0, 0), true},
+		{[]byte("POST /greet HTTP/1.1\r\n"), false},
+		{[]byte("GET / HTTP/1.1\r\n"), false},
+	}
+
+	for _, tt := range tests {
+		present, err := HasHeader(bufio.NewReader(bytes.NewReader(tt.input)))
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.input, err)
+		}
+		if present != tt.expected {
+			t.Errorf("unexpected detection for %q. expected=%t, got=%t", tt.input, tt.expected, present)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const BINDING_SOURCE_BODY = "body"
+const BINDING_SOURCE_QUERY = "query"
+const BINDING_SOURCE_PATH = "path"
+
+const VALIDATE_TAG = "validate"
+
+type StatusCoder interface {
+	StatusCode() int
+}
+
+func JSON[Req any, Resp any](handler func(context.Context, Req) (Resp, error)) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		var input Req
+
+		problem := bindRequest(request, &input)
+		if problem != nil {
+			return problem.ToResponse(), nil
+		}
+
+		output, err := handler(request.Context(), input)
+		if err != nil {
+			var problem http.Problem
+			if errors.As(err, &problem) {
+				return problem.ToResponse(), nil
+			}
+
+			var httpError http.HttpError
+			if errors.As(err, &httpError) {
+				response := http.NewProblem(httpError.StatusCode, httpError.Message).ToResponse()
+				for name, value := range httpError.Headers {
+					response.Headers[name] = value
+				}
+				return response, nil
+			}
+
+			return http.HttpResponse{}, err
+		}
+
+		statusCode := responseStatus(output)
+		if statusCode == 204 {
+			return http.HttpResponse{StatusCode: 204, Headers: map[string]string{}, Content: []byte{}}, nil
+		}
+		return http.NewJsonResponse(output, statusCode)
+	}
+}
+
+func responseStatus(output any) int {
+	if coder, ok := output.(StatusCoder); ok && coder.StatusCode() != 0 {
+		return coder.StatusCode()
+	}
+	return 200
+}
+
+func AddJSONRoute[Req any, Resp any](router Router, path string, handler func(context.Context, Req) (Resp, error), options ...RouteOption) error {
+	options = append(options, withTypes(reflect.TypeOf((*Req)(nil)).Elem(), reflect.TypeOf((*Resp)(nil)).Elem()))
+	return router.AddRoute(path, JSON(handler), options...)
+}
+
+func bindRequest(request http.HttpRequest, target any) *http.Problem {
+	if len(request.Content) > 0 {
+		contentType, _ := request.Header("Content-Type")
+		if !isJsonContentType(contentType) {
+			problem := http.NewProblem(415, fmt.Sprintf("unsupported content type %q, expected application/json", contentType))
+			return &problem
+		}
+
+		err := json.Unmarshal(request.Content, target)
+		if err != nil {
+			problem := http.NewProblem(400, fmt.Sprintf("malformed JSON body: %v", err))
+			return &problem
+		}
+	}
+
+	value := reflect.ValueOf(target).Elem()
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fieldErrors := bindParams(request, value)
+	if len(fieldErrors) > 0 {
+		problem := http.NewProblem(400, "invalid request parameters")
+		problem.Errors = fieldErrors
+		return &problem
+	}
+
+	fieldErrors = validateStruct(value, "")
+	if len(fieldErrors) > 0 {
+		problem := http.NewProblem(422, "request validation failed")
+		problem.Errors = fieldErrors
+		return &problem
+	}
+
+	return nil
+}
+
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func bindParams(request http.HttpRequest, value reflect.Value) []http.FieldError {
+	fieldErrors := []http.FieldError{}
+	query := request.GetQueryParams()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		source, name := paramSource(field)
+		if source == "" {
+			continue
+		}
+
+		var raw string
+		var present bool
+		if source == BINDING_SOURCE_QUERY {
+			raw, present = query[name]
+			if present {
+				decoded, err := url.QueryUnescape(raw)
+				if err == nil {
+					raw = decoded
+				}
+			}
+		} else {
+			raw, present = request.PathParams[name]
+		}
+
+		if !present {
+			continue
+		}
+
+		err := setFieldValue(value.Field(i), raw)
+		if err != nil {
+			fieldErrors = append(fieldErrors, http.FieldError{
+				Field:   name,
+				Source:  source,
+				Message: err.Error()})
+		}
+	}
+
+	return fieldErrors
+}
+
+func paramSource(field reflect.StructField) (string, string) {
+	if name, exists := field.Tag.Lookup(BINDING_SOURCE_PATH); exists {
+		return BINDING_SOURCE_PATH, name
+	}
+	if name, exists := field.Tag.Lookup(BINDING_SOURCE_QUERY); exists {
+		return BINDING_SOURCE_QUERY, name
+	}
+	return "", ""
+}
+
+func setFieldValue(field reflect.Value, raw string) error {
+	if field.Kind() == reflect.Pointer {
+		target := reflect.New(field.Type().Elem())
+		err := setFieldValue(target.Elem(), raw)
+		if err != nil {
+			return err
+		}
+		field.Set(target)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("expected a boolean, got %q", raw)
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", raw)
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a non-negative integer, got %q", raw)
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number, got %q", raw)
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		parts := strings.Split(raw, ",")
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			err := setFieldValue(slice.Index(i), part)
+			if err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", field.Type())
+	}
+
+	return nil
+}
+
+func validateStruct(value reflect.Value, prefix string) []http.FieldError {
+	fieldErrors := []http.FieldError{}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		source, name := fieldName(field)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fieldValue := value.Field(i)
+
+		for _, rule := range parseRules(field.Tag.Get(VALIDATE_TAG)) {
+			message := checkRule(rule, fieldValue)
+			if message != "" {
+				fieldErrors = append(fieldErrors, http.FieldError{Field: name, Source: source, Message: message})
+				break
+			}
+		}
+
+		nested := fieldValue
+		if nested.Kind() == reflect.Pointer && !nested.IsNil() {
+			nested = nested.Elem()
+		}
+		if nested.Kind() == reflect.Struct && source == BINDING_SOURCE_BODY {
+			fieldErrors = append(fieldErrors, validateStruct(nested, name)...)
+		}
+	}
+
+	return fieldErrors
+}
+
+func fieldName(field reflect.StructField) (string, string) {
+	source, name := paramSource(field)
+	if source != "" {
+		return source, name
+	}
+
+	jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if jsonName == "" || jsonName == "-" {
+		jsonName = field.Name
+	}
+	return BINDING_SOURCE_BODY, jsonName
+}
+
+type validationRule struct {
+	name     string
+	argument string
+}
+
+func parseRules(tag string) []validationRule {
+	rules := []validationRule{}
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, argument, _ := strings.Cut(part, "=")
+		rules = append(rules, validationRule{name: name, argument: argument})
+	}
+	return rules
+}
+
+func checkRule(rule validationRule, value reflect.Value) string {
+	if rule.name == "required" {
+		if value.IsZero() {
+			return "is required"
+		}
+		return ""
+	}
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+
+	switch rule.name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(rule.argument, 64)
+		if err != nil {
+			return fmt.Sprintf("invalid %s rule %q", rule.name, rule.argument)
+		}
+
+		measured, unit, ok := measure(value)
+		if !ok {
+			return fmt.Sprintf("%s rule not supported for %s", rule.name, value.Type())
+		}
+
+		if rule.name == "min" && measured < limit {
+			return strings.TrimSpace(fmt.Sprintf("must be at least %s %s", rule.argument, unit))
+		}
+		if rule.name == "max" && measured > limit {
+			return strings.TrimSpace(fmt.Sprintf("must be at most %s %s", rule.argument, unit))
+		}
+	case "oneof":
+		options := strings.Fields(rule.argument)
+		actual := fmt.Sprint(value.Interface())
+		for _, option := range options {
+			if actual == option {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(options, ", "))
+	default:
+		return fmt.Sprintf("unknown validation rule %s", rule.name)
+	}
+
+	return ""
+}
+
+func measure(value reflect.Value) (float64, string, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "", true
+	case reflect.String:
+		return float64(len([]rune(value.String()))), "characters", true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), "elements", true
+	}
+	return 0, "", false
+}
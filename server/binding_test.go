@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+type createMessageRequest struct {
+	Room     string   `json:"-" path:"room" validate:"required"`
+	DryRun   bool     `json:"-" query:"dry_run"`
+	Limit    int      `json:"-" query:"limit" validate:"min=1,max=100"`
+	Tags     []string `json:"-" query:"tags"`
+	Text     string   `json:"text" validate:"required,max=10"`
+	Priority string   `json:"priority" validate:"oneof=low high"`
+	Author   struct {
+		Name string `json:"name" validate:"required"`
+	} `json:"author"`
+}
+
+type createMessageResponse struct {
+	Room   string   `json:"room"`
+	Text   string   `json:"text"`
+	Limit  int      `json:"limit"`
+	DryRun bool     `json:"dry_run"`
+	Tags   []string `json:"tags"`
+}
+
+func TestJSONBinding(t *testing.T) {
+	handler := JSON(func(ctx context.Context, request createMessageRequest) (createMessageResponse, error) {
+		if request.Text == "forbidden" {
+			return createMessageResponse{}, http.HttpError{StatusCode: 403, Message: "nope"}
+		}
+		if request.Text == "boom" {
+			return createMessageResponse{}, errors.New("boom")
+		}
+		return createMessageResponse{
+			Room:   request.Room,
+			Text:   request.Text,
+			Limit:  request.Limit,
+			DryRun: request.DryRun,
+			Tags:   request.Tags}, nil
+	})
+
+	jsonHeaders := map[string]string{"Content-Type": "application/json; charset=utf-8"}
+	validBody := `{"text":"hi","priority":"low","author":{"name":"alice"}}`
+
+	tests := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		body           string
+		expectedStatus int
+		expectedFields []string
+	}{
+		{"valid", "/rooms/general?limit=5&dry_run=true&tags=a,b", jsonHeaders, validBody, 200, nil},
+		{"wrong content type", "/rooms/general?limit=5", map[string]string{"Content-Type": "text/plain"}, validBody, 415, nil},
+		{"malformed json", "/rooms/general?limit=5", jsonHeaders, `{"text":`, 400, nil},
+		{"bad query type", "/rooms/general?limit=many", jsonHeaders, validBody, 400, []string{"limit"}},
+		{"validation", "/rooms/general?limit=500", jsonHeaders, `{"text":"far too long text","priority":"mid"}`, 422,
+			[]string{"limit", "text", "priority", "author.name"}},
+		{"handler http error", "/rooms/general?limit=5", jsonHeaders, `{"text":"forbidden","priority":"low","author":{"name":"a"}}`, 403, nil},
+	}
+
+	for _, tt := range tests {
+		request := http.HttpRequest{
+			Method:     "POST",
+			FullPath:   tt.path,
+			Headers:    tt.headers,
+			Content:    []byte(tt.body),
+			PathParams: map[string]string{"room": "general"}}
+
+		response, err := handler(request)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("%s: unexpected status. expected=%d, got=%d (%s)", tt.name, tt.expectedStatus, response.StatusCode, response.Content)
+			continue
+		}
+
+		if tt.expectedStatus == 200 {
+			expected := `{"room":"general","text":"hi","limit":5,"dry_run":true,"tags":["a","b"]}`
+			if string(response.Content) != expected {
+				t.Errorf("%s: unexpected body. expected=%s, got=%s", tt.name, expected, response.Content)
+			}
+			continue
+		}
+
+		if response.Headers["Content-Type"] != http.CONTENT_TYPE_PROBLEM_JSON {
+			t.Errorf("%s: expected problem content type. got=%s", tt.name, response.Headers["Content-Type"])
+		}
+
+		problem := http.Problem{}
+		json.Unmarshal(response.Content, &problem)
+		if problem.Status != tt.expectedStatus {
+			t.Errorf("%s: unexpected problem status. got=%d", tt.name, problem.Status)
+		}
+
+		fields := []string{}
+		for _, fieldError := range problem.Errors {
+			fields = append(fields, fieldError.Field)
+		}
+		if tt.expectedFields != nil && len(fields) != len(tt.expectedFields) {
+			t.Errorf("%s: unexpected field errors. expected=%v, got=%+v", tt.name, tt.expectedFields, problem.Errors)
+			continue
+		}
+		for i := range tt.expectedFields {
+			if fields[i] != tt.expectedFields[i] {
+				t.Errorf("%s: unexpected field error %d. expected=%s, got=%s", tt.name, i, tt.expectedFields[i], fields[i])
+			}
+		}
+	}
+
+	_, err := handler(http.HttpRequest{
+		FullPath:   "/rooms/general?limit=5",
+		Headers:    jsonHeaders,
+		Content:    []byte(`{"text":"boom","priority":"low","author":{"name":"a"}}`),
+		PathParams: map[string]string{"room": "general"}})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("expected handler error to propagate. got=%v", err)
+	}
+}
+
+type createdResponse struct {
+	ID string `json:"id"`
+}
+
+func (createdResponse) StatusCode() int {
+	return 201
+}
+
+type deletedResponse struct{}
+
+func (deletedResponse) StatusCode() int {
+	return 204
+}
+
+func TestJSONBindingStatusAndHeaders(t *testing.T) {
+	created := JSON(func(ctx context.Context, request struct{}) (createdResponse, error) {
+		return createdResponse{ID: "42"}, nil
+	})
+	response, err := created(http.HttpRequest{Method: "POST"})
+	if err != nil || response.StatusCode != 201 || string(response.Content) != `{"id":"42"}` {
+		t.Errorf("expected 201 with body. got=%d %s, %v", response.StatusCode, response.Content, err)
+	}
+
+	deleted := JSON(func(ctx context.Context, request struct{}) (deletedResponse, error) {
+		return deletedResponse{}, nil
+	})
+	response, err = deleted(http.HttpRequest{Method: "DELETE"})
+	if err != nil || response.StatusCode != 204 || len(response.Content) != 0 {
+		t.Errorf("expected empty 204. got=%d %s, %v", response.StatusCode, response.Content, err)
+	}
+
+	throttled := JSON(func(ctx context.Context, request struct{}) (createdResponse, error) {
+		return createdResponse{}, http.HttpError{StatusCode: 503, Message: "busy", Headers: map[string]string{"Retry-After": "5"}}
+	})
+	response, err = throttled(http.HttpRequest{Method: "POST"})
+	if err != nil || response.StatusCode != 503 {
+		t.Fatalf("expected 503 problem. got=%d, %v", response.StatusCode, err)
+	}
+	if response.Headers["Retry-After"] != "5" || response.Headers["Content-Type"] != http.CONTENT_TYPE_PROBLEM_JSON {
+		t.Errorf("expected error headers on the problem response. got=%v", response.Headers)
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/tracing"
+)
+
+const ROUTE_CONNECT = "connect"
+const TUNNEL_KIND_CONNECT = "connect"
+
+const DEFAULT_TUNNEL_DIAL_TIMEOUT = 10 * time.Second
+const DEFAULT_TUNNEL_IDLE_TIMEOUT = 5 * time.Minute
+const DEFAULT_PROXY_REALM = "gosocks"
+
+const PROXY_AUTHORIZATION_HEADER = "Proxy-Authorization"
+const PROXY_AUTHENTICATE_HEADER = "Proxy-Authenticate"
+
+const REJECT_REASON_DISABLED = "disabled"
+const REJECT_REASON_AUTH = "auth"
+const REJECT_REASON_TARGET = "target"
+const REJECT_REASON_DIAL = "dial"
+
+type ConnectConfig struct {
+	AllowedTargets []string
+	Credentials    map[string]string
+	Realm          string
+	Authorize      func(request http.HttpRequest, target string) error
+	DialTimeout    time.Duration
+	IdleTimeout    time.Duration
+	Dial           func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+type connectTunnel struct {
+	config ConnectConfig
+	rules  []targetRule
+}
+
+type targetRule struct {
+	anyHost bool
+	host    string
+	suffix  string
+	prefix  netip.Prefix
+	port    string
+}
+
+func (server *gosocksServer) SetConnectTunnel(config ConnectConfig) error {
+	tunnel, err := newConnectTunnel(config)
+	if err != nil {
+		return err
+	}
+	server.connect = tunnel
+	return nil
+}
+
+func newConnectTunnel(config ConnectConfig) (*connectTunnel, error) {
+	if config.Realm == "" {
+		config.Realm = DEFAULT_PROXY_REALM
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = DEFAULT_TUNNEL_DIAL_TIMEOUT
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DEFAULT_TUNNEL_IDLE_TIMEOUT
+	}
+	if config.Dial == nil {
+		dialer := &net.Dialer{Timeout: config.DialTimeout}
+		config.Dial = dialer.DialContext
+	}
+
+	rules, err := parseTargetRules(config.AllowedTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectTunnel{config: config, rules: rules}, nil
+}
+
+func parseTargetRules(patterns []string) ([]targetRule, error) {
+	rules := []targetRule{}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target pattern %s: expected host:port", pattern)
+		}
+		if port != "*" {
+			number, err := strconv.Atoi(port)
+			if err != nil || number < 1 || number > 65535 {
+				return nil, fmt.Errorf("invalid target pattern %s: port must be 1-65535 or *", pattern)
+			}
+		}
+
+		rule := targetRule{port: port}
+		host = strings.ToLower(host)
+
+		switch {
+		case host == "*":
+			rule.anyHost = true
+		case strings.HasPrefix(host, "*."):
+			rule.suffix = strings.TrimPrefix(host, "*")
+		case strings.Contains(host, "/"):
+			prefix, err := netip.ParsePrefix(host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target pattern %s: %w", pattern, err)
+			}
+			rule.prefix = prefix.Masked()
+		default:
+			addr, err := netip.ParseAddr(host)
+			if err == nil {
+				rule.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			} else {
+				rule.host = strings.TrimSuffix(host, ".")
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (rule targetRule) matchesPort(port string) bool {
+	return rule.port == "*" || rule.port == port
+}
+
+func (rule targetRule) matchesName(host string, port string) bool {
+	if !rule.matchesPort(port) {
+		return false
+	}
+	if rule.anyHost || (rule.host != "" && rule.host == host) {
+		return true
+	}
+	return rule.suffix != "" && strings.HasSuffix(host, rule.suffix) && len(host) > len(rule.suffix)
+}
+
+func (rule targetRule) matchesAddr(addr netip.Addr, port string) bool {
+	if !rule.matchesPort(port) {
+		return false
+	}
+	return rule.anyHost || (rule.prefix.IsValid() && rule.prefix.Contains(addr.Unmap()))
+}
+
+func resolveTarget(ctx context.Context, rules []targetRule, target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || host == "" || port == "" {
+		return "", http.BadRequest(fmt.Sprintf("invalid tunnel target %s", target))
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		for _, rule := range rules {
+			if rule.matchesAddr(addr, port) {
+				return net.JoinHostPort(addr.Unmap().String(), port), nil
+			}
+		}
+		return "", http.Forbidden(fmt.Sprintf("tunnel target %s not allowed", target))
+	}
+
+	hasPrefixRules := false
+	for _, rule := range rules {
+		if rule.matchesName(host, port) {
+			return net.JoinHostPort(host, port), nil
+		}
+		if rule.prefix.IsValid() && rule.matchesPort(port) {
+			hasPrefixRules = true
+		}
+	}
+
+	if hasPrefixRules {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return "", http.BadGateway(fmt.Sprintf("failed to resolve %s: %v", host, err))
+		}
+		for _, addr := range addrs {
+			for _, rule := range rules {
+				if rule.prefix.IsValid() && rule.matchesAddr(addr, port) {
+					return net.JoinHostPort(addr.Unmap().String(), port), nil
+				}
+			}
+		}
+	}
+
+	return "", http.Forbidden(fmt.Sprintf("tunnel target %s not allowed", target))
+}
+
+func (tunnel *connectTunnel) authenticate(request http.HttpRequest) error {
+	if len(tunnel.config.Credentials) == 0 {
+		return nil
+	}
+
+	authorization, _ := request.Header(PROXY_AUTHORIZATION_HEADER)
+	scheme, encoded, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return http.HttpError{StatusCode: 407, Message: "proxy authentication required"}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return http.HttpError{StatusCode: 407, Message: "malformed proxy credentials"}
+	}
+
+	user, password, _ := strings.Cut(string(decoded), ":")
+	if !checkCredentials(tunnel.config.Credentials, user, password) {
+		return http.HttpError{StatusCode: 407, Message: "invalid proxy credentials"}
+	}
+	return nil
+}
+
+func checkCredentials(credentials map[string]string, user string, password string) bool {
+	expected, exists := credentials[user]
+	if !exists {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+func (server *gosocksServer) handleConnect(request http.HttpRequest, span *tracing.Span, conn net.Conn, reader *bufio.Reader, start time.Time) {
+	logger := logging.FromContext(request.Context())
+	target := request.FullPath
+
+	reject := func(reason string, httpError http.HttpError) {
+		logger.Warn("tunnel rejected",
+			"target", target,
+			"status", httpError.StatusCode,
+			"error", httpError.Message)
+		server.metrics.TunnelsRejected.Inc(TUNNEL_KIND_CONNECT, reason)
+
+		response := httpError.ToResponse()
+		setResponseHeader(&response, REQUEST_ID_HEADER, RequestID(request.Context()))
+		setResponseHeader(&response, "Connection", "close")
+		if httpError.StatusCode == 407 {
+			setResponseHeader(&response, PROXY_AUTHENTICATE_HEADER, fmt.Sprintf("Basic realm=%q", server.connect.config.Realm))
+		}
+		endRequestSpan(span, ROUTE_CONNECT, response)
+		accessLog(logger, request, response, time.Now().Sub(start))
+		conn.Write(response.Serialize())
+	}
+
+	if server.connect == nil {
+		reject(REJECT_REASON_DISABLED, http.MethodNotAllowed("CONNECT is not enabled"))
+		return
+	}
+	tunnel := server.connect
+
+	err := tunnel.authenticate(request)
+	if err == nil && tunnel.config.Authorize != nil {
+		err = tunnel.config.Authorize(request, target)
+	}
+	if err != nil {
+		httpError, ok := err.(http.HttpError)
+		if !ok {
+			httpError = http.Forbidden(err.Error())
+		}
+		reject(REJECT_REASON_AUTH, httpError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), tunnel.config.DialTimeout)
+	address, err := resolveTarget(ctx, tunnel.rules, target)
+	if err != nil {
+		cancel()
+		reject(REJECT_REASON_TARGET, err.(http.HttpError))
+		return
+	}
+
+	targetConn, err := tunnel.config.Dial(ctx, "tcp", address)
+	cancel()
+	if err != nil {
+		httpError := http.BadGateway(err.Error())
+		if errors.Is(err, context.DeadlineExceeded) {
+			httpError = http.GatewayTimeout(err.Error())
+		}
+		reject(REJECT_REASON_DIAL, httpError)
+		return
+	}
+	defer targetConn.Close()
+
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 200 Connection Established%s%s: %s%s%s",
+		http.CLRF, REQUEST_ID_HEADER, RequestID(request.Context()), http.CLRF, http.CLRF)
+	if err != nil {
+		logger.Warn("failed to send tunnel response", "error", err)
+		return
+	}
+
+	established := http.HttpResponse{StatusCode: 200}
+	endRequestSpan(span, ROUTE_CONNECT, established)
+	accessLog(logger, request, established, time.Now().Sub(start))
+
+	server.metrics.TunnelsOpened.Inc(TUNNEL_KIND_CONNECT)
+	server.metrics.ActiveTunnels.Inc(TUNNEL_KIND_CONNECT)
+	defer server.metrics.ActiveTunnels.Dec(TUNNEL_KIND_CONNECT)
+	server.activeTunnels.Add(1)
+	defer server.activeTunnels.Add(-1)
+
+	tunnelStart := time.Now()
+	bytesIn, bytesOut, err := splice(request.Context(), conn, reader, targetConn, tunnel.config.IdleTimeout)
+	server.metrics.TunnelBytes.Add(float64(bytesIn), TUNNEL_KIND_CONNECT, metrics.DIRECTION_IN)
+	server.metrics.TunnelBytes.Add(float64(bytesOut), TUNNEL_KIND_CONNECT, metrics.DIRECTION_OUT)
+
+	attrs := []any{
+		"target", target,
+		"address", address,
+		"duration_ms", time.Since(tunnelStart).Milliseconds(),
+		"bytes_in", bytesIn,
+		"bytes_out", bytesOut}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Info("tunnel closed", attrs...)
+}
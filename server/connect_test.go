@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/metrics"
+)
+
+func TestResolveTarget(t *testing.T) {
+	rules, err := parseTargetRules([]string{
+		"example.com:443",
+		"*.internal:*",
+		"10.0.0.0/8:22",
+		"192.0.2.7:8080",
+	})
+	if err != nil {
+		t.Fatalf("failed to parse rules: %v", err)
+	}
+
+	tests := []struct {
+		target   string
+		expected string
+		status   int
+	}{
+		{"example.com:443", "example.com:443", 0},
+		{"EXAMPLE.com.:443", "example.com:443", 0},
+		{"example.com:80", "", 403},
+		{"db.internal:5432", "db.internal:5432", 0},
+		{"internal:5432", "", 403},
+		{"10.1.2.3:22", "10.1.2.3:22", 0},
+		{"10.1.2.3:23", "", 403},
+		{"192.0.2.7:8080", "192.0.2.7:8080", 0},
+		{"[::ffff:192.0.2.7]:8080", "192.0.2.7:8080", 0},
+		{"192.0.2.8:8080", "", 403},
+		{"no-port", "", 400},
+	}
+
+	for _, tt := range tests {
+		address, err := resolveTarget(context.Background(), rules, tt.target)
+		if tt.status == 0 {
+			if err != nil || address != tt.expected {
+				t.Errorf("resolveTarget(%s) = %q, %v. want=%q", tt.target, address, err, tt.expected)
+			}
+			continue
+		}
+
+		httpError, ok := err.(http.HttpError)
+		if !ok || httpError.StatusCode != tt.status {
+			t.Errorf("resolveTarget(%s) expected status %d. got=%q, %v", tt.target, tt.status, address, err)
+		}
+	}
+}
+
+func TestParseTargetRulesInvalid(t *testing.T) {
+	for _, pattern := range []string{"example.com", "example.com:0", "example.com:http", "10.0.0.0/33:22"} {
+		if _, err := parseTargetRules([]string{pattern}); err == nil {
+			t.Errorf("expected pattern %q to be rejected", pattern)
+		}
+	}
+
+	rules, err := parseTargetRules(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := resolveTarget(context.Background(), rules, "127.0.0.1:80"); err == nil {
+		t.Errorf("expected an empty allowlist to deny every target")
+	}
+}
+
+func startTcpEchoTarget(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func startConnectServer(t *testing.T, config *ConnectConfig) (string, *metrics.Registry) {
+	srv := NewServerWithListeners(ListenerConfig{Name: "test", Address: "127.0.0.1:0"})
+	srv.SetRoutes(NewRouter())
+	registry := metrics.NewRegistry()
+	srv.SetMetrics(registry)
+
+	if config != nil {
+		err := srv.SetConnectTunnel(*config)
+		if err != nil {
+			t.Fatalf("failed to configure CONNECT: %v", err)
+		}
+	}
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	return srv.Status().Listeners[0].Address, registry
+}
+
+func sendConnect(t *testing.T, address string, target string, headers map[string]string, pipelined string) (net.Conn, *bufio.Reader, http.HttpResponse) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	request := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	for name, value := range headers {
+		request += name + ": " + value + "\r\n"
+	}
+	request += "\r\n" + pipelined
+
+	_, err = conn.Write([]byte(request))
+	if err != nil {
+		t.Fatalf("failed to send CONNECT: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, reader, err := http.ParseHttpResponseToMethod(conn, "CONNECT")
+	if err != nil {
+		t.Fatalf("failed to read CONNECT response: %v", err)
+	}
+	return conn, reader, response
+}
+
+func TestConnectRejected(t *testing.T) {
+	target := startTcpEchoTarget(t)
+
+	disabled, _ := startConnectServer(t, nil)
+	_, _, response := sendConnect(t, disabled, target, nil, "")
+	if response.StatusCode != 405 {
+		t.Errorf("expected 405 while CONNECT is disabled. got=%d", response.StatusCode)
+	}
+
+	address, registry := startConnectServer(t, &ConnectConfig{
+		AllowedTargets: []string{"127.0.0.1:1"},
+		Credentials:    map[string]string{"alice": "secret"}})
+
+	_, _, response = sendConnect(t, address, target, nil, "")
+	if response.StatusCode != 407 {
+		t.Errorf("expected 407 without credentials. got=%d", response.StatusCode)
+	}
+	if challenge, _ := response.Header(PROXY_AUTHENTICATE_HEADER); challenge != `Basic realm="gosocks"` {
+		t.Errorf("unexpected proxy challenge. got=%q", challenge)
+	}
+
+	wrong := base64.StdEncoding.EncodeToString([]byte("alice:wrong"))
+	_, _, response = sendConnect(t, address, target, map[string]string{PROXY_AUTHORIZATION_HEADER: "Basic " + wrong}, "")
+	if response.StatusCode != 407 {
+		t.Errorf("expected 407 for wrong credentials. got=%d", response.StatusCode)
+	}
+
+	valid := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	_, _, response = sendConnect(t, address, target, map[string]string{PROXY_AUTHORIZATION_HEADER: "Basic " + valid}, "")
+	if response.StatusCode != 403 {
+		t.Errorf("expected 403 for target outside the allowlist. got=%d", response.StatusCode)
+	}
+
+	_, _, response = sendConnect(t, address, "127.0.0.1:1", map[string]string{PROXY_AUTHORIZATION_HEADER: "Basic " + valid}, "")
+	if response.StatusCode != 502 {
+		t.Errorf("expected 502 for unreachable target. got=%d", response.StatusCode)
+	}
+
+	exposition := registry.Snapshot().String()
+	for _, reason := range []string{REJECT_REASON_AUTH, REJECT_REASON_TARGET, REJECT_REASON_DIAL} {
+		if !strings.Contains(exposition, `reason="`+reason+`"`) {
+			t.Errorf("expected rejected tunnel metric for reason %s", reason)
+		}
+	}
+}
+
+func TestConnectAuthorizeHook(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	address, _ := startConnectServer(t, &ConnectConfig{
+		AllowedTargets: []string{"*:*"},
+		Authorize: func(request http.HttpRequest, target string) error {
+			if value, _ := request.Header("X-Tenant"); value == "blocked" {
+				return errors.New("tenant is blocked")
+			}
+			return nil
+		}})
+
+	_, _, response := sendConnect(t, address, target, map[string]string{"X-Tenant": "blocked"}, "")
+	if response.StatusCode != 403 {
+		t.Errorf("expected 403 from authorize hook. got=%d", response.StatusCode)
+	}
+
+	_, _, response = sendConnect(t, address, target, map[string]string{"X-Tenant": "acme"}, "")
+	if response.StatusCode != 200 {
+		t.Errorf("expected tunnel to be established. got=%d", response.StatusCode)
+	}
+}
+
+func TestConnectTunnel(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	address, registry := startConnectServer(t, &ConnectConfig{AllowedTargets: []string{"127.0.0.1:*"}})
+
+	conn, reader, response := sendConnect(t, address, target, nil, "early ")
+	if response.StatusCode != 200 {
+		t.Fatalf("expected 200 Connection Established. got=%d: %s", response.StatusCode, response.Content)
+	}
+	if _, exists := response.Header("Content-Length"); exists {
+		t.Errorf("expected no Content-Length on the tunnel response")
+	}
+
+	conn.Write([]byte("data"))
+	echoed := make([]byte, len("early data"))
+	_, err := io.ReadFull(reader, echoed)
+	if err != nil {
+		t.Fatalf("failed to read tunnelled data: %v", err)
+	}
+	if string(echoed) != "early data" {
+		t.Errorf("unexpected tunnelled data. got=%q", echoed)
+	}
+
+	conn.(*net.TCPConn).CloseWrite()
+	rest, err := io.ReadAll(reader)
+	if err != nil || len(rest) != 0 {
+		t.Errorf("expected tunnel to close cleanly after half-close. got=%q, %v", rest, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		exposition := registry.Snapshot().String()
+		if strings.Contains(exposition, `gosocks_tunnel_bytes_total{kind="connect",direction="in"} 10`) &&
+			strings.Contains(exposition, `gosocks_tunnel_bytes_total{kind="connect",direction="out"} 10`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected tunnel byte accounting in exposition:\n%s", exposition)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectIdleTimeout(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	address, _ := startConnectServer(t, &ConnectConfig{
+		AllowedTargets: []string{"127.0.0.1:*"},
+		IdleTimeout:    100 * time.Millisecond})
+
+	conn, reader, response := sendConnect(t, address, target, nil, "")
+	if response.StatusCode != 200 {
+		t.Fatalf("expected tunnel to be established. got=%d", response.StatusCode)
+	}
+
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := reader.ReadByte()
+	if err == nil {
+		t.Fatalf("expected idle tunnel to be closed")
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("expected idle timeout to close the tunnel. got error %v after %v", err, elapsed)
+	}
+}
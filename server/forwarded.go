@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const FORWARDED_HEADER = "Forwarded"
+const X_FORWARDED_FOR_HEADER = "X-Forwarded-For"
+const X_FORWARDED_PROTO_HEADER = "X-Forwarded-Proto"
+const X_FORWARDED_HOST_HEADER = "X-Forwarded-Host"
+
+const SCHEME_HTTP = "http"
+const SCHEME_HTTPS = "https"
+
+type forwardedHop struct {
+	client string
+	proto  string
+	host   string
+}
+
+func (server *gosocksServer) SetTrustedProxies(sources ...string) error {
+	trusted, err := parseTrustedSources(sources)
+	if err != nil {
+		return err
+	}
+	server.trustedProxies = trusted
+	return nil
+}
+
+func resolveClient(request http.HttpRequest, trusted []netip.Prefix) http.HttpRequest {
+	request.ClientIP = hostOnly(request.RemoteAddr)
+	request.Scheme = SCHEME_HTTP
+	if request.TLS != nil {
+		request.Scheme = SCHEME_HTTPS
+	}
+	request.Host, _ = request.Header("Host")
+
+	if !isTrustedAddress(trusted, request.ClientIP) {
+		return request
+	}
+
+	hops := forwardedHops(request)
+	if len(hops) == 0 {
+		return request
+	}
+
+	selected := hops[0]
+	for i := len(hops) - 1; i >= 0; i-- {
+		selected = hops[i]
+		if !isTrustedAddress(trusted, hops[i].client) {
+			break
+		}
+	}
+
+	if selected.client != "" {
+		request.ClientIP = selected.client
+	}
+	if selected.proto == SCHEME_HTTP || selected.proto == SCHEME_HTTPS {
+		request.Scheme = selected.proto
+	}
+	if selected.host != "" {
+		request.Host = selected.host
+	}
+
+	return request
+}
+
+func forwardedHops(request http.HttpRequest) []forwardedHop {
+	forwarded, exists := request.Header(FORWARDED_HEADER)
+	if exists {
+		return parseForwarded(forwarded)
+	}
+
+	forwardedFor, exists := request.Header(X_FORWARDED_FOR_HEADER)
+	if !exists {
+		return nil
+	}
+
+	clients := splitList(forwardedFor)
+	proto, _ := request.Header(X_FORWARDED_PROTO_HEADER)
+	host, _ := request.Header(X_FORWARDED_HOST_HEADER)
+	protos := splitList(proto)
+	hosts := splitList(host)
+
+	hops := []forwardedHop{}
+	for i, client := range clients {
+		hops = append(hops, forwardedHop{
+			client: hostOnly(client),
+			proto:  strings.ToLower(listValue(protos, i, len(clients))),
+			host:   listValue(hosts, i, len(clients))})
+	}
+
+	return hops
+}
+
+func listValue(values []string, index int, count int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values) == count {
+		return values[index]
+	}
+	return values[len(values)-1]
+}
+
+func parseForwarded(value string) []forwardedHop {
+	hops := []forwardedHop{}
+
+	for _, element := range splitQuoted(value, ',') {
+		hop := forwardedHop{}
+		for _, pair := range splitQuoted(element, ';') {
+			name, value, found := strings.Cut(pair, "=")
+			if !found {
+				continue
+			}
+			value = strings.Trim(strings.TrimSpace(value), "\"")
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "for":
+				hop.client = hostOnly(value)
+			case "proto":
+				hop.proto = strings.ToLower(value)
+			case "host":
+				hop.host = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+func splitQuoted(value string, separator rune) []string {
+	parts := []string{}
+	quoted := false
+	start := 0
+
+	for i, char := range value {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case char == separator && !quoted:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+func hostOnly(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func isTrustedAddress(trusted []netip.Prefix, address string) bool {
+	ip, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+func TestResolveClient(t *testing.T) {
+	trusted, err := parseTrustedSources([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted proxies: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		headers        map[string]string
+		expectedIp     string
+		expectedScheme string
+		expectedHost   string
+	}{
+		{"direct", "203.0.113.9:4000", map[string]string{"Host": "api.local"},
+			"203.0.113.9", "http", "api.local"},
+		{"untrusted peer ignores headers", "203.0.113.9:4000",
+			map[string]string{"Host": "api.local", "X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			"203.0.113.9", "http", "api.local"},
+		{"x-forwarded", "10.0.0.2:4000",
+			map[string]string{"Host": "internal", "X-Forwarded-For": "198.51.100.1, 10.0.0.5", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com"},
+			"198.51.100.1", "https", "api.example.com"},
+		{"spoofed leftmost entry", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1"},
+			"198.51.100.1", "http", ""},
+		{"forwarded", "10.0.0.2:4000",
+			map[string]string{"forwarded": `for="[2001:db8::1]:4711";proto=https;host="ws.example.com", for=10.0.0.7`},
+			"2001:db8::1", "https", "ws.example.com"},
+		{"all hops trusted", "10.0.0.2:4000",
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.4"},
+			"10.0.0.3", "http", ""},
+	}
+
+	for _, tt := range tests {
+		request := resolveClient(http.HttpRequest{RemoteAddr: tt.remoteAddr, Headers: tt.headers}, trusted)
+
+		if request.ClientIP != tt.expectedIp || request.Scheme != tt.expectedScheme || request.Host != tt.expectedHost {
+			t.Errorf("%s: unexpected resolution. expected=%s %s %s, got=%s %s %s", tt.name,
+				tt.expectedIp, tt.expectedScheme, tt.expectedHost,
+				request.ClientIP, request.Scheme, request.Host)
+		}
+	}
+}
+
+func TestCheckOriginUsesResolvedHost(t *testing.T) {
+	request := http.HttpRequest{
+		Headers: map[string]string{"Origin": "https://ws.example.com"},
+		Scheme:  "https",
+		Host:    "ws.example.com"}
+
+	err := websocket.CheckOrigin(request, nil)
+	if err != nil {
+		t.Errorf("expected same origin to be accepted: %v", err)
+	}
+
+	request.Scheme = "http"
+	err = websocket.CheckOrigin(request, nil)
+	if err == nil {
+		t.Errorf("expected scheme mismatch to be rejected")
+	}
+
+	err = websocket.CheckOrigin(request, []string{"https://ws.example.com"})
+	if err != nil {
+		t.Errorf("expected allowed origin to be accepted: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const HEALTH_STATUS_OK = "ok"
+const HEALTH_STATUS_READY = "ready"
+const HEALTH_STATUS_NOT_READY = "not ready"
+
+type HealthCheck func(ctx context.Context) error
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+type ServerStatus struct {
+	Listening         bool             `json:"listening"`
+	Listeners         []ListenerStatus `json:"listeners"`
+	ShuttingDown      bool             `json:"shutting_down"`
+	ActiveConnections int64            `json:"active_connections"`
+	ActiveWebSockets  int64            `json:"active_websockets"`
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	ServerStatus
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+	ServerStatus
+}
+
+func (server *gosocksServer) AddReadinessCheck(name string, check HealthCheck) {
+	server.checksMutex.Lock()
+	defer server.checksMutex.Unlock()
+	server.readinessChecks = append(server.readinessChecks, namedHealthCheck{name: name, check: check})
+}
+
+func (server *gosocksServer) CheckReadiness(ctx context.Context) (bool, map[string]string) {
+	server.checksMutex.Lock()
+	checks := append([]namedHealthCheck{}, server.readinessChecks...)
+	server.checksMutex.Unlock()
+
+	ready := true
+	results := map[string]string{}
+
+	if server.shuttingDown.Load() {
+		ready = false
+		results["shutdown"] = "server is shutting down"
+	}
+
+	for _, namedCheck := range checks {
+		err := namedCheck.check(ctx)
+		if err != nil {
+			ready = false
+			results[namedCheck.name] = err.Error()
+			continue
+		}
+		results[namedCheck.name] = HEALTH_STATUS_OK
+	}
+
+	return ready, results
+}
+
+func (server *gosocksServer) Status() ServerStatus {
+	server.listenerMutex.Lock()
+	listeners := []ListenerStatus{}
+	for _, listener := range server.listeners {
+		listeners = append(listeners, listener.status())
+	}
+	server.listenerMutex.Unlock()
+
+	return ServerStatus{
+		Listening:         len(listeners) > 0,
+		Listeners:         listeners,
+		ShuttingDown:      server.shuttingDown.Load(),
+		ActiveConnections: server.activeConnections.Load(),
+		ActiveWebSockets:  server.activeWebSockets.Load()}
+}
+
+func AddHealthRoutes(router Router, srv Server) error {
+	err := router.AddRoute("/healthz", LivenessHandler(srv),
+		WithMethods("GET"),
+		WithName("healthz"),
+		WithSummary("Liveness probe"),
+		WithSchemas(nil, healthResponse{}))
+	if err != nil {
+		return err
+	}
+
+	return router.AddRoute("/readyz", ReadinessHandler(srv),
+		WithMethods("GET"),
+		WithName("readyz"),
+		WithSummary("Readiness probe"),
+		WithSchemas(nil, readinessResponse{}))
+}
+
+func LivenessHandler(srv Server) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		response := healthResponse{Status: HEALTH_STATUS_OK, ServerStatus: srv.Status()}
+		return http.NewJsonResponse(response, 200)
+	}
+}
+
+func ReadinessHandler(srv Server) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		ready, checks := srv.CheckReadiness(request.Context())
+
+		response := readinessResponse{
+			Status:       HEALTH_STATUS_READY,
+			Checks:       checks,
+			ServerStatus: srv.Status()}
+		statusCode := 200
+
+		if !ready {
+			response.Status = HEALTH_STATUS_NOT_READY
+			statusCode = 503
+		}
+
+		return http.NewJsonResponse(response, statusCode)
+	}
+}
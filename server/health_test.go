@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	srv := NewServer(0)
+	dependencyUp := true
+	srv.AddReadinessCheck("dependency", func(ctx context.Context) error {
+		if !dependencyUp {
+			return fmt.Errorf("dependency down")
+		}
+		return nil
+	})
+
+	handler := ReadinessHandler(srv)
+
+	tests := []struct {
+		dependencyUp   bool
+		shuttingDown   bool
+		expectedStatus int
+		expectedCheck  string
+	}{
+		{true, false, 200, HEALTH_STATUS_OK},
+		{false, false, 503, "dependency down"},
+		{true, true, 503, HEALTH_STATUS_OK},
+	}
+
+	for _, tt := range tests {
+		dependencyUp = tt.dependencyUp
+		srv.(*gosocksServer).shuttingDown.Store(tt.shuttingDown)
+
+		response, err := handler(http.HttpRequest{FullPath: "/readyz"})
+		if err != nil {
+			t.Errorf("readiness handler failed: %v", err)
+			continue
+		}
+
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("status code does not match expected status code %d. got=%d",
+				tt.expectedStatus, response.StatusCode)
+		}
+
+		body := readinessResponse{}
+		err = json.Unmarshal(response.Content, &body)
+		if err != nil {
+			t.Errorf("failed to decode readiness response: %v", err)
+			continue
+		}
+
+		if body.Checks["dependency"] != tt.expectedCheck {
+			t.Errorf("unexpected dependency check result. expected=%s, got=%s",
+				tt.expectedCheck, body.Checks["dependency"])
+		}
+
+		if body.ShuttingDown != tt.shuttingDown {
+			t.Errorf("unexpected shutting_down value. expected=%t, got=%t",
+				tt.shuttingDown, body.ShuttingDown)
+		}
+	}
+}
+
+func TestShutdownClosesWebSockets(t *testing.T) {
+	router := NewRouter()
+	router.AddWebSocket("/ws", websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen:    func(websocket.WsConnection) {},
+		OnMessage: func(websocket.WsMessageEvent, websocket.WsConnection) {},
+		OnClose:   func(websocket.WsCloseEvent, websocket.WsConnection) {},
+		OnError:   func(error, websocket.WsConnection) {},
+	}))
+
+	srv := NewServerWithListeners(ListenerConfig{Name: "ws", Address: "127.0.0.1:0"})
+	srv.SetRoutes(router)
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	statusLine, _ := reader.ReadString('\n')
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 101") {
+		t.Fatalf("expected upgrade to succeed. got=%q", statusLine)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read handshake response: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		t.Fatalf("expected close frame on shutdown: %v", err)
+	}
+	if header[0]&websocket.OPCODE_MASK != websocket.OPCODE_CLOSE || binary.BigEndian.Uint16(header[2:]) != websocket.STATUS_GOING_AWAY {
+		t.Errorf("expected going away close frame. got=%x", header)
+	}
+	conn.Write(websocket.NewCloseFrame(websocket.STATUS_GOING_AWAY, "", true).Serialize())
+
+	err = <-shutdown
+	if err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
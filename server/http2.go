@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/http2"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/tracing"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+const HTTP1_ALPN_PROTOCOL = "http/1.1"
+const WEBSOCKET_CONNECT_PROTOCOL = "websocket"
+
+const h2cUpgradeResponse = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"
+
+func (server *gosocksServer) SetHttp2(config http2.Config) error {
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	server.http2 = &config
+	return nil
+}
+
+func withHttp2Protocols(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	protocols := []string{http2.ALPN_PROTOCOL}
+	for _, protocol := range config.NextProtos {
+		if protocol != http2.ALPN_PROTOCOL {
+			protocols = append(protocols, protocol)
+		}
+	}
+	if !slices.Contains(protocols, HTTP1_ALPN_PROTOCOL) {
+		protocols = append(protocols, HTTP1_ALPN_PROTOCOL)
+	}
+	config.NextProtos = protocols
+	return config
+}
+
+func (server *gosocksServer) sniffHttp2(conn net.Conn) (net.Conn, bool) {
+	if server.http2 == nil {
+		return conn, false
+	}
+
+	sniffed, ok := conn.(*sniffedConn)
+	if !ok {
+		sniffed = &sniffedConn{Conn: conn, reader: bufio.NewReader(conn)}
+	}
+
+	for length := 1; length <= len(http2.CLIENT_PREFACE); length++ {
+		peeked, err := sniffed.reader.Peek(length)
+		if err != nil || peeked[length-1] != http2.CLIENT_PREFACE[length-1] {
+			return sniffed, false
+		}
+	}
+	return sniffed, true
+}
+
+func (server *gosocksServer) upgradeHttp2(conn net.Conn, reader io.Reader, router Router, request http.HttpRequest) {
+	_, err := conn.Write([]byte(h2cUpgradeResponse))
+	if err != nil {
+		return
+	}
+
+	server.serveHttp2(conn, reader, router, nil, &request)
+}
+
+func (server *gosocksServer) serveHttp2(conn net.Conn, reader io.Reader, router Router, tlsState *tls.ConnectionState, upgrade *http.HttpRequest) {
+	if server.http2 == nil {
+		return
+	}
+
+	handler := func(stream *http2.Stream, request http.HttpRequest) {
+		server.handleHttp2Stream(router, stream, request, conn, tlsState)
+	}
+
+	h2conn := http2.NewServerConn(conn, reader, *server.http2, handler)
+	stop := context.AfterFunc(server.drainCtx, h2conn.Shutdown)
+	defer stop()
+
+	err := h2conn.Serve(server.ctx, upgrade)
+	if err != nil && !errors.Is(err, http2.ErrConnClosed) && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		server.logger.Warn("http2 connection failed",
+			"remote_addr", conn.RemoteAddr().String(),
+			"error", err)
+	}
+}
+
+func (server *gosocksServer) handleHttp2Stream(router Router, stream *http2.Stream, request http.HttpRequest, conn net.Conn, tlsState *tls.ConnectionState) {
+	start := time.Now()
+
+	request, span, valid := server.startRequest(request, conn, tlsState)
+	if !valid {
+		stream.WriteResponse(http.BadRequest("").ToResponse())
+		return
+	}
+
+	if request.Method == "CONNECT" && stream.ConnectProtocol() == WEBSOCKET_CONNECT_PROTOCOL {
+		server.handleHttp2Websocket(router, stream, request, span, start)
+		return
+	}
+	defer span.End()
+
+	if request.Method == "CONNECT" {
+		logger := logging.FromContext(request.Context())
+		logger.Warn("rejecting http2 connect request", "authority", request.FullPath)
+		response := http.MethodNotAllowed("").ToResponse()
+		setResponseHeader(&response, REQUEST_ID_HEADER, RequestID(request.Context()))
+		endRequestSpan(span, ROUTE_REJECTED, response)
+		accessLog(logger, request, response, time.Now().Sub(start))
+		stream.WriteResponse(response)
+		return
+	}
+
+	response := server.serveRequest(router, request, span, start)
+	stream.WriteResponse(response)
+}
+
+func (server *gosocksServer) handleHttp2Websocket(router Router, stream *http2.Stream, request http.HttpRequest, span *tracing.Span, start time.Time) {
+	respond := func(response http.HttpResponse) error {
+		if response.StatusCode == 200 {
+			return stream.Accept(response.Headers)
+		}
+		return stream.WriteResponse(response)
+	}
+	server.serveWebsocket(router, request, span, stream, bufio.NewReader(stream), start, websocket.ExtendedConnectHandshake, respond)
+}
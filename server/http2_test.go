@@ -0,0 +1,438 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/http2"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+func startHttp2Server(t *testing.T, config ListenerConfig, router Router) Server {
+	srv := NewServerWithListeners(config)
+	srv.SetRoutes(router)
+	err := srv.SetHttp2(http2.Config{EnableConnectProtocol: true})
+	if err != nil {
+		t.Fatalf("failed to enable http2: %v", err)
+	}
+
+	err = srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+func http2TestRouter() Router {
+	router := NewRouter()
+	router.AddRoute("/rooms/{room}", func(request http.HttpRequest) (http.HttpResponse, error) {
+		body := fmt.Sprintf("%s %s %s %s", request.Protocol, request.Scheme, request.PathParam("room"), request.Content)
+		return http.NewPlainTextResponse(body, 200), nil
+	})
+	router.AddRoute("/missing", func(request http.HttpRequest) (http.HttpResponse, error) {
+		return http.HttpResponse{}, http.ErrorNotFound("no such room")
+	})
+	return router
+}
+
+func TestHttp2PriorKnowledge(t *testing.T) {
+	srv := startHttp2Server(t, ListenerConfig{Name: "test", Address: "127.0.0.1:0"}, http2TestRouter())
+	address := srv.Status().Listeners[0].Address
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	client, err := http2.NewClientConn(conn, http2.Config{})
+	if err != nil {
+		t.Fatalf("failed to start http2 client: %v", err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			request := http.HttpRequest{
+				Method:   "POST",
+				FullPath: fmt.Sprintf("/rooms/%d", i),
+				Headers:  map[string]string{"Host": "localhost"},
+				Content:  []byte("hello")}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			response, err := client.RoundTrip(ctx, request)
+			if err != nil {
+				t.Errorf("request %d failed: %v", i, err)
+				return
+			}
+			expected := fmt.Sprintf("HTTP/2.0 http %d hello", i)
+			if response.StatusCode != 200 || string(response.Content) != expected {
+				t.Errorf("unexpected response %d. expected=%q, got=%d %q", i, expected, response.StatusCode, response.Content)
+			}
+			if _, exists := response.Header(REQUEST_ID_HEADER); !exists {
+				t.Errorf("expected %s header on response %d", REQUEST_ID_HEADER, i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.RoundTrip(ctx, http.HttpRequest{
+		Method:   "GET",
+		FullPath: "/missing",
+		Headers:  map[string]string{"Host": "localhost"}})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if response.StatusCode != 404 {
+		t.Errorf("expected status 404. got=%d", response.StatusCode)
+	}
+
+	response, err = client.RoundTrip(ctx, http.HttpRequest{
+		Method:   "CONNECT",
+		FullPath: "example.com:443",
+		Headers:  map[string]string{"Host": "example.com:443"}})
+	if err != nil {
+		t.Fatalf("connect request failed: %v", err)
+	}
+	if response.StatusCode != 405 {
+		t.Errorf("expected connect to be rejected with 405. got=%d", response.StatusCode)
+	}
+}
+
+func TestHttp2Upgrade(t *testing.T) {
+	srv := startHttp2Server(t, ListenerConfig{Name: "test", Address: "127.0.0.1:0"}, http2TestRouter())
+
+	conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	settings := base64.RawURLEncoding.EncodeToString(http2.SettingsFrame().Payload)
+	fmt.Fprintf(conn, "GET /rooms/upgraded HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: %s\r\n\r\n", settings)
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read upgrade response: %v", err)
+	}
+	if !strings.HasPrefix(status, "HTTP/1.1 101") {
+		t.Fatalf("expected 101 switching protocols. got=%q", status)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read upgrade headers: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	conn.Write([]byte(http2.CLIENT_PREFACE))
+	conn.Write(http2.SettingsFrame().Serialize())
+
+	decoder := http2.NewDecoder(http2.DEFAULT_HEADER_TABLE_SIZE, 0)
+	var statusCode string
+	var body []byte
+	for {
+		frame, err := http2.ReadFrame(reader, http2.MIN_FRAME_SIZE)
+		if err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+		if frame.StreamID != 1 {
+			continue
+		}
+		switch frame.Type {
+		case http2.FRAME_HEADERS:
+			fields, err := decoder.Decode(frame.Payload)
+			if err != nil {
+				t.Fatalf("failed to decode headers: %v", err)
+			}
+			for _, field := range fields {
+				if field.Name == ":status" {
+					statusCode = field.Value
+				}
+			}
+		case http2.FRAME_DATA:
+			body = append(body, frame.Payload...)
+		}
+		if frame.Has(http2.FLAG_END_STREAM) {
+			break
+		}
+	}
+
+	if statusCode != "200" || string(body) != "HTTP/2.0 http upgraded " {
+		t.Errorf("unexpected upgraded response. got=%s %q", statusCode, body)
+	}
+}
+
+func TestHttp2TLSNegotiation(t *testing.T) {
+	srv := startHttp2Server(t, ListenerConfig{
+		Name:      "secure",
+		Address:   "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{generateTestCertificate(t)}}}, http2TestRouter())
+	address := srv.Status().Listeners[0].Address
+
+	transport := &nethttp.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true}
+	defer transport.CloseIdleConnections()
+	client := &nethttp.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	response, err := client.Post("https://"+address+"/rooms/secure", "text/plain", strings.NewReader("hi"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	if response.ProtoMajor != 2 {
+		t.Errorf("expected http2 to be negotiated. got=%s", response.Proto)
+	}
+	if string(body) != "HTTP/2.0 https secure hi" {
+		t.Errorf("unexpected body. got=%q", body)
+	}
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	if conn.ConnectionState().NegotiatedProtocol != "http/1.1" {
+		t.Errorf("expected http/1.1 to remain available. got=%q", conn.ConnectionState().NegotiatedProtocol)
+	}
+	fmt.Fprintf(conn, "GET /rooms/legacy HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	if !strings.HasPrefix(line, "HTTP/1.1 200") {
+		t.Errorf("expected http/1.1 response. got=%q", line)
+	}
+}
+
+func TestHttp2ShutdownDrainsStreams(t *testing.T) {
+	release := make(chan struct{})
+	router := NewRouter()
+	router.AddRoute("/slow", func(request http.HttpRequest) (http.HttpResponse, error) {
+		<-release
+		return http.NewPlainTextResponse("done", 200), nil
+	})
+	srv := startHttp2Server(t, ListenerConfig{Name: "test", Address: "127.0.0.1:0"}, router)
+
+	conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	client, err := http2.NewClientConn(conn, http2.Config{})
+	if err != nil {
+		t.Fatalf("failed to start http2 client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		response, err := client.RoundTrip(ctx, http.HttpRequest{
+			Method:   "GET",
+			FullPath: "/slow",
+			Headers:  map[string]string{"Host": "localhost"}})
+		if err == nil && string(response.Content) != "done" {
+			err = fmt.Errorf("unexpected body %q", response.Content)
+		}
+		result <- err
+	}()
+
+	for client.NumStreams() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+
+	for {
+		_, err = client.OpenStream(ctx, http.HttpRequest{
+			Method:   "GET",
+			FullPath: "/slow",
+			Headers:  map[string]string{"Host": "localhost"}}, true)
+		if errors.Is(err, http2.ErrGoAway) {
+			break
+		}
+		if err == nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		t.Fatalf("unexpected error opening stream: %v", err)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+}
+
+func TestHttp2WebSockets(t *testing.T) {
+	router := NewRouter()
+	router.AddWebSocket("/ws/{room}", websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen: func(conn websocket.WsConnection) {},
+		OnMessage: func(event websocket.WsMessageEvent, conn websocket.WsConnection) {
+			conn.SendText(conn.Request().PathParam("room") + " " + string(event.Data))
+		},
+		OnClose: func(websocket.WsCloseEvent, websocket.WsConnection) {},
+		OnError: func(error, websocket.WsConnection) {},
+	}))
+	srv := startHttp2Server(t, ListenerConfig{Name: "test", Address: "127.0.0.1:0"}, router)
+
+	conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	client, err := http2.NewClientConn(conn, http2.Config{})
+	if err != nil {
+		t.Fatalf("failed to start http2 client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	openWebSocket := func(path string, headers map[string]string) (*http2.Stream, http.HttpResponse) {
+		headers["Host"] = "localhost"
+		stream, err := client.OpenConnectStream(ctx, http.HttpRequest{FullPath: path, Headers: headers}, WEBSOCKET_CONNECT_PROTOCOL)
+		if err != nil {
+			t.Fatalf("failed to open websocket stream: %v", err)
+		}
+		response, err := stream.ReadResponse(ctx)
+		if err != nil {
+			t.Fatalf("failed to read websocket response: %v", err)
+		}
+		return stream, response
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		stream, response := openWebSocket(fmt.Sprintf("/ws/%d", i), map[string]string{"Sec-WebSocket-Version": "13"})
+		if response.StatusCode != 200 {
+			t.Fatalf("expected websocket to be accepted. got=%d", response.StatusCode)
+		}
+
+		wg.Add(1)
+		go func(i int, stream *http2.Stream) {
+			defer wg.Done()
+			defer stream.Close()
+
+			reader := bufio.NewReader(stream)
+			for j := 0; j < 3; j++ {
+				stream.Write(websocket.NewTextFrame(true, fmt.Sprintf("message %d", j)).Serialize())
+				frame, err := websocket.DeserialzeWebSocketFrame(reader)
+				if err != nil {
+					t.Errorf("failed to read websocket frame on session %d: %v", i, err)
+					return
+				}
+				expected := fmt.Sprintf("%d message %d", i, j)
+				if string(frame.Payload) != expected {
+					t.Errorf("unexpected echo. expected=%q, got=%q", expected, frame.Payload)
+				}
+			}
+		}(i, stream)
+	}
+	wg.Wait()
+
+	stream, response := openWebSocket("/missing", map[string]string{"Sec-WebSocket-Version": "13"})
+	stream.Close()
+	if response.StatusCode != 404 {
+		t.Errorf("expected unknown websocket route to return 404. got=%d", response.StatusCode)
+	}
+
+	stream, response = openWebSocket("/ws/1", map[string]string{})
+	stream.Close()
+	if response.StatusCode != 400 {
+		t.Errorf("expected missing Sec-WebSocket-Version to return 400. got=%d", response.StatusCode)
+	}
+
+	if client.NumStreams() != 0 {
+		t.Errorf("expected all streams to be closed. got=%d", client.NumStreams())
+	}
+}
+
+func TestHttp2WebSocketConcurrentWrites(t *testing.T) {
+	const senders = 8
+	const size = 40000
+
+	router := NewRouter()
+	router.AddWebSocket("/ws", websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen: func(conn websocket.WsConnection) {
+			for i := 0; i < senders; i++ {
+				go conn.SendBinary(bytes.Repeat([]byte{byte('a' + i)}, size))
+			}
+		},
+		OnMessage: func(websocket.WsMessageEvent, websocket.WsConnection) {},
+		OnClose:   func(websocket.WsCloseEvent, websocket.WsConnection) {},
+		OnError:   func(error, websocket.WsConnection) {},
+	}))
+	srv := startHttp2Server(t, ListenerConfig{Name: "test", Address: "127.0.0.1:0"}, router)
+
+	conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	client, err := http2.NewClientConn(conn, http2.Config{InitialWindowSize: http2.DEFAULT_WINDOW_SIZE})
+	if err != nil {
+		t.Fatalf("failed to start http2 client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := http.HttpRequest{FullPath: "/ws", Headers: map[string]string{"Host": "localhost", "Sec-WebSocket-Version": "13"}}
+	stream, err := client.OpenConnectStream(ctx, request, WEBSOCKET_CONNECT_PROTOCOL)
+	if err != nil {
+		t.Fatalf("failed to open websocket stream: %v", err)
+	}
+	defer stream.Close()
+	response, err := stream.ReadResponse(ctx)
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("expected websocket to be accepted. got=%d, %v", response.StatusCode, err)
+	}
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(stream)
+	seen := map[byte]bool{}
+	for i := 0; i < senders; i++ {
+		frame, err := websocket.DeserialzeWebSocketFrame(reader)
+		if err != nil {
+			t.Fatalf("failed to read websocket frame %d: %v", i, err)
+		}
+		if len(frame.Payload) != size || !bytes.Equal(frame.Payload, bytes.Repeat(frame.Payload[:1], size)) {
+			t.Fatalf("frame %d was interleaved with another write", i)
+		}
+		seen[frame.Payload[0]] = true
+	}
+	if len(seen) != senders {
+		t.Errorf("expected %d distinct frames. got=%d", senders, len(seen))
+	}
+}
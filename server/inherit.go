@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const LISTEN_FDS_START = 3
+
+const ENV_LISTEN_PID = "LISTEN_PID"
+const ENV_LISTEN_FDS = "LISTEN_FDS"
+const ENV_LISTEN_FDNAMES = "LISTEN_FDNAMES"
+
+const ENV_INHERITED_FDS = "GOSOCKS_LISTEN_FDS"
+const ENV_INHERITED_FDNAMES = "GOSOCKS_LISTEN_FDNAMES"
+const ENV_READY_FD = "GOSOCKS_READY_FD"
+
+type inheritedListener struct {
+	name     string
+	listener net.Listener
+}
+
+var inheritOnce sync.Once
+var inheritMutex sync.Mutex
+var inheritedListeners []*inheritedListener
+
+func loadInheritedListeners() {
+	inheritOnce.Do(func() {
+		inheritedListeners = []*inheritedListener{}
+
+		if os.Getenv(ENV_LISTEN_PID) == strconv.Itoa(os.Getpid()) {
+			listeners, err := listenersFromEnv(ENV_LISTEN_FDS, ENV_LISTEN_FDNAMES, LISTEN_FDS_START)
+			if err != nil {
+				slog.Default().Error("failed to inherit systemd sockets", "error", err)
+			}
+			inheritedListeners = append(inheritedListeners, listeners...)
+		}
+		os.Unsetenv(ENV_LISTEN_PID)
+		os.Unsetenv(ENV_LISTEN_FDS)
+		os.Unsetenv(ENV_LISTEN_FDNAMES)
+
+		listeners, err := listenersFromEnv(ENV_INHERITED_FDS, ENV_INHERITED_FDNAMES, LISTEN_FDS_START+len(inheritedListeners))
+		if err != nil {
+			slog.Default().Error("failed to inherit parent sockets", "error", err)
+		}
+		inheritedListeners = append(inheritedListeners, listeners...)
+		os.Unsetenv(ENV_INHERITED_FDS)
+		os.Unsetenv(ENV_INHERITED_FDNAMES)
+	})
+}
+
+func listenersFromEnv(countVariable string, namesVariable string, firstFd int) ([]*inheritedListener, error) {
+	rawCount := os.Getenv(countVariable)
+	if rawCount == "" {
+		return []*inheritedListener{}, nil
+	}
+
+	count, err := strconv.Atoi(rawCount)
+	if err != nil || count < 0 {
+		return []*inheritedListener{}, fmt.Errorf("invalid %s: %s", countVariable, rawCount)
+	}
+
+	names := strings.Split(os.Getenv(namesVariable), ":")
+	listeners := []*inheritedListener{}
+
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(firstFd+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return listeners, fmt.Errorf("fd %d is not a listening socket: %w", firstFd+i, err)
+		}
+
+		listeners = append(listeners, &inheritedListener{name: name, listener: listener})
+	}
+
+	return listeners, nil
+}
+
+func takeInheritedListener(name string, network string, address string) net.Listener {
+	loadInheritedListeners()
+
+	inheritMutex.Lock()
+	defer inheritMutex.Unlock()
+
+	for i, inherited := range inheritedListeners {
+		if (name != "" && inherited.name == name) || addressMatches(inherited.listener, network, address) {
+			inheritedListeners = append(inheritedListeners[:i], inheritedListeners[i+1:]...)
+			return inherited.listener
+		}
+	}
+
+	return nil
+}
+
+func addressMatches(listener net.Listener, network string, address string) bool {
+	if network == "unix" {
+		unixAddr, ok := listener.Addr().(*net.UnixAddr)
+		return ok && unixAddr.Name == address
+	}
+
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	wanted, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil || wanted.Port != tcpAddr.Port {
+		return false
+	}
+
+	if wanted.IP == nil || wanted.IP.IsUnspecified() {
+		return tcpAddr.IP.IsUnspecified()
+	}
+
+	return wanted.IP.Equal(tcpAddr.IP)
+}
+
+func notifyParentReady() {
+	rawFd := os.Getenv(ENV_READY_FD)
+	if rawFd == "" {
+		return
+	}
+	os.Unsetenv(ENV_READY_FD)
+
+	fd, err := strconv.Atoi(rawFd)
+	if err != nil {
+		return
+	}
+
+	readyPipe := os.NewFile(uintptr(fd), "ready")
+	readyPipe.Write([]byte("ready\n"))
+	readyPipe.Close()
+}
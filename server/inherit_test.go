@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListenersFromEnv(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	t.Setenv("TEST_LISTEN_FDS", "1")
+	t.Setenv("TEST_LISTEN_FDNAMES", "data")
+
+	inherited, err := listenersFromEnv("TEST_LISTEN_FDS", "TEST_LISTEN_FDNAMES", int(file.Fd()))
+	if err != nil {
+		t.Fatalf("failed to inherit listener: %v", err)
+	}
+
+	if len(inherited) != 1 {
+		t.Fatalf("expected 1 inherited listener. got=%d", len(inherited))
+	}
+	defer inherited[0].listener.Close()
+
+	if inherited[0].name != "data" {
+		t.Errorf("unexpected listener name. expected=data, got=%s", inherited[0].name)
+	}
+
+	if inherited[0].listener.Addr().String() != listener.Addr().String() {
+		t.Errorf("inherited listener address does not match. expected=%s, got=%s",
+			listener.Addr().String(), inherited[0].listener.Addr().String())
+	}
+}
+
+func TestAddressMatches(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{listener.Addr().String(), true},
+		{net.JoinHostPort("127.0.0.2", strconv.Itoa(port)), false},
+		{net.JoinHostPort("", strconv.Itoa(port)), false},
+		{"127.0.0.1:1", false},
+	}
+
+	for _, tt := range tests {
+		if addressMatches(listener, "tcp", tt.address) != tt.expected {
+			t.Errorf("unexpected match result for %s. expected=%t", tt.address, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const REJECT_REASON_CONNECTIONS = "connections"
+const REJECT_REASON_HTTP = "http"
+const REJECT_REASON_WEBSOCKET = "websocket"
+const REJECT_REASON_WORKERS = "workers"
+
+const ROUTE_REJECTED = "rejected"
+
+const MAX_PENDING_REJECTIONS = 16
+const REJECT_WRITE_TIMEOUT = time.Second
+
+const MIN_ACCEPT_BACKOFF = 5 * time.Millisecond
+const MAX_ACCEPT_BACKOFF = time.Second
+
+var ErrWorkerPoolFull = errors.New("worker pool queue is full")
+
+type ConnectionLimits struct {
+	MaxConnections   int
+	MaxHttpRequests  int
+	MaxWebSockets    int
+	RejectWithStatus bool
+	Workers          int
+	WorkerQueueSize  int
+}
+
+type semaphore chan struct{}
+
+func newSemaphore(size int) semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return make(semaphore, size)
+}
+
+func (s semaphore) tryAcquire() bool {
+	if s == nil {
+		return true
+	}
+
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() {
+	if s == nil {
+		return
+	}
+	<-s
+}
+
+type workerPool struct {
+	jobs    chan func()
+	pending semaphore
+}
+
+func newWorkerPool(ctx context.Context, workers int, queueSize int) *workerPool {
+	if workers <= 0 {
+		return nil
+	}
+
+	pool := &workerPool{
+		jobs:    make(chan func()),
+		pending: newSemaphore(workers + queueSize)}
+	for i := 0; i < workers; i++ {
+		go pool.work(ctx)
+	}
+	return pool
+}
+
+func (pool *workerPool) work(ctx context.Context) {
+	for {
+		select {
+		case job := <-pool.jobs:
+			job()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (pool *workerPool) submit(ctx context.Context, job func()) error {
+	if !pool.pending.tryAcquire() {
+		return ErrWorkerPoolFull
+	}
+
+	select {
+	case pool.jobs <- func() {
+		defer pool.pending.release()
+		job()
+	}:
+		return nil
+	case <-ctx.Done():
+		pool.pending.release()
+		return ctx.Err()
+	}
+}
+
+type requestResult struct {
+	response http.HttpResponse
+	route    string
+	err      error
+}
+
+func (server *gosocksServer) SetConnectionLimits(limits ConnectionLimits) {
+	server.limits = limits
+	server.connectionSlots = newSemaphore(limits.MaxConnections)
+	server.httpSlots = newSemaphore(limits.MaxHttpRequests)
+	server.websocketSlots = newSemaphore(limits.MaxWebSockets)
+	server.rejectSlots = newSemaphore(MAX_PENDING_REJECTIONS)
+}
+
+func (server *gosocksServer) acceptConnection(conn net.Conn, listener *serverListener) {
+	if !server.connectionSlots.tryAcquire() {
+		server.rejectConnection(conn)
+		return
+	}
+
+	go func() {
+		defer server.connectionSlots.release()
+		server.handleConnection(conn, listener)
+	}()
+}
+
+func (server *gosocksServer) rejectConnection(conn net.Conn) {
+	server.metrics.ConnectionsRejected.Inc(REJECT_REASON_CONNECTIONS)
+	server.logger.Warn("connection limit reached, rejecting connection",
+		"remote_addr", conn.RemoteAddr().String())
+
+	if server.limits.RejectWithStatus && server.rejectSlots.tryAcquire() {
+		go func() {
+			defer server.rejectSlots.release()
+			defer conn.Close()
+			conn.SetWriteDeadline(time.Now().Add(REJECT_WRITE_TIMEOUT))
+			conn.Write(http.ServiceUnavailable("").ToResponse().Serialize())
+		}()
+		return
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (server *gosocksServer) processRequest(router Router, request http.HttpRequest) (http.HttpResponse, string, error) {
+	if !server.httpSlots.tryAcquire() {
+		server.metrics.ConnectionsRejected.Inc(REJECT_REASON_HTTP)
+		return http.HttpResponse{}, ROUTE_REJECTED, http.ServiceUnavailable("too many concurrent requests")
+	}
+	defer server.httpSlots.release()
+
+	if server.workers == nil {
+		return server.handleRequest(router, request)
+	}
+
+	done := make(chan requestResult, 1)
+	err := server.workers.submit(request.Context(), func() {
+		response, route, err := server.handleRequest(router, request)
+		done <- requestResult{response: response, route: route, err: err}
+	})
+	if err != nil {
+		server.metrics.ConnectionsRejected.Inc(REJECT_REASON_WORKERS)
+		return http.HttpResponse{}, ROUTE_REJECTED, http.ServiceUnavailable(err.Error())
+	}
+
+	select {
+	case result := <-done:
+		return result.response, result.route, result.err
+	case <-request.Context().Done():
+		return http.HttpResponse{}, ROUTE_REJECTED, request.Context().Err()
+	}
+}
+
+func nextAcceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return MIN_ACCEPT_BACKOFF
+	}
+
+	delay *= 2
+	if delay > MAX_ACCEPT_BACKOFF {
+		return MAX_ACCEPT_BACKOFF
+	}
+	return delay
+}
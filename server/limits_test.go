@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestAcceptBackoff(t *testing.T) {
+	expected := []time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+	}
+
+	var backoff time.Duration
+	for _, expectedBackoff := range expected {
+		backoff = nextAcceptBackoff(backoff)
+		if backoff != expectedBackoff {
+			t.Errorf("unexpected backoff. expected=%s, got=%s", expectedBackoff, backoff)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		backoff = nextAcceptBackoff(backoff)
+	}
+
+	if backoff != MAX_ACCEPT_BACKOFF {
+		t.Errorf("backoff was not capped. expected=%s, got=%s", MAX_ACCEPT_BACKOFF, backoff)
+	}
+}
+
+func TestHttpRequestLimit(t *testing.T) {
+	srv := NewServer(0).(*gosocksServer)
+	srv.SetConnectionLimits(ConnectionLimits{MaxHttpRequests: 1})
+
+	started := make(chan bool)
+	release := make(chan bool)
+	router := NewRouter()
+	router.AddRoute("/slow", func(request http.HttpRequest) (http.HttpResponse, error) {
+		started <- true
+		<-release
+		return http.HttpResponse{StatusCode: 200}, nil
+	})
+	srv.SetRoutes(router)
+
+	go srv.processRequest(router, http.HttpRequest{FullPath: "/slow"})
+	<-started
+
+	_, route, err := srv.processRequest(router, http.HttpRequest{FullPath: "/slow"})
+	httpError, ok := err.(http.HttpError)
+	if !ok || httpError.StatusCode != 503 {
+		t.Errorf("expected 503 when request limit is reached. got=%v", err)
+	}
+
+	if route != ROUTE_REJECTED {
+		t.Errorf("expected route %s. got=%s", ROUTE_REJECTED, route)
+	}
+
+	close(release)
+}
+
+func TestWorkerPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := NewServer(0).(*gosocksServer)
+	srv.workers = newWorkerPool(ctx, 2, 0)
+	router := NewRouter()
+	router.AddRoute("/", buildStatusCodeHandler(204))
+	srv.SetRoutes(router)
+
+	response, _, err := srv.processRequest(router, http.HttpRequest{FullPath: "/"})
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if response.StatusCode != 204 {
+		t.Errorf("status code does not match expected status code 204. got=%d", response.StatusCode)
+	}
+}
+
+func TestRejectConnectionDoesNotBlock(t *testing.T) {
+	srv := NewServer(0).(*gosocksServer)
+	srv.SetConnectionLimits(ConnectionLimits{MaxConnections: 1, RejectWithStatus: true})
+
+	clients := []net.Conn{}
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i <= MAX_PENDING_REJECTIONS; i++ {
+		client, conn := net.Pipe()
+		clients = append(clients, client)
+		srv.rejectConnection(conn)
+	}
+	if elapsed := time.Since(start); elapsed > REJECT_WRITE_TIMEOUT/2 {
+		t.Errorf("rejecting non-reading clients blocked the caller for %s", elapsed)
+	}
+
+	response, _, err := http.ParseHttpResponse(clients[0])
+	if err != nil || response.StatusCode != 503 {
+		t.Errorf("expected a 503 for a rejection within budget. got=%d, %v", response.StatusCode, err)
+	}
+
+	clients[MAX_PENDING_REJECTIONS].SetReadDeadline(time.Now().Add(time.Second))
+	_, err = clients[MAX_PENDING_REJECTIONS].Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected the connection over the rejection budget to be closed. got=%v", err)
+	}
+}
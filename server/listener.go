@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const UNIX_ADDRESS_PREFIX = "unix:"
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+type ListenerConfig struct {
+	Name          string
+	Address       string
+	Router        Router
+	Permissions   os.FileMode
+	ProxyProtocol *ProxyProtocolConfig
+	TLSConfig     *tls.Config
+	Protocol      string
+}
+
+type ListenerStatus struct {
+	Name      string `json:"name"`
+	Network   string `json:"network"`
+	Address   string `json:"address"`
+	Listening bool   `json:"listening"`
+	TLS       bool   `json:"tls"`
+}
+
+type serverListener struct {
+	config    ListenerConfig
+	network   string
+	listener  net.Listener
+	inherited bool
+
+	trustedProxies []netip.Prefix
+	routes         atomic.Pointer[routerRef]
+}
+
+type routerRef struct {
+	router Router
+}
+
+func parseListenAddress(address string) (string, string) {
+	if strings.HasPrefix(address, UNIX_ADDRESS_PREFIX) {
+		return "unix", strings.TrimPrefix(address, UNIX_ADDRESS_PREFIX)
+	}
+	return "tcp", address
+}
+
+func listen(config ListenerConfig) (*serverListener, error) {
+	network, address := parseListenAddress(config.Address)
+
+	var trustedProxies []netip.Prefix
+	if config.ProxyProtocol != nil {
+		if len(config.ProxyProtocol.TrustedSources) == 0 && network != "unix" {
+			return nil, fmt.Errorf("listener %s enables PROXY protocol without trusted sources", config.Name)
+		}
+
+		var err error
+		trustedProxies, err = parseTrustedSources(config.ProxyProtocol.TrustedSources)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inherited := takeInheritedListener(config.Name, network, address)
+	if inherited != nil {
+		return newServerListener(config, network, inherited, true, trustedProxies), nil
+	}
+
+	if network == "unix" {
+		err := removeStaleSocket(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", config.Address, err)
+	}
+
+	if network == "unix" && config.Permissions != 0 {
+		err := os.Chmod(address, config.Permissions)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set permissions on %s: %w", address, err)
+		}
+	}
+
+	return newServerListener(config, network, listener, false, trustedProxies), nil
+}
+
+func newServerListener(config ListenerConfig, network string, listener net.Listener, inherited bool, trustedProxies []netip.Prefix) *serverListener {
+	sl := &serverListener{
+		config:         config,
+		network:        network,
+		listener:       listener,
+		inherited:      inherited,
+		trustedProxies: trustedProxies}
+	sl.routes.Store(&routerRef{router: config.Router})
+	return sl
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect socket path %s: %w", path, err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("socket path %s exists and is not a socket", path)
+	}
+
+	return os.Remove(path)
+}
+
+func (sl *serverListener) router(fallback Router) Router {
+	routes := sl.routes.Load()
+	if routes != nil && routes.router != nil {
+		return routes.router
+	}
+	return fallback
+}
+
+func (sl *serverListener) status() ListenerStatus {
+	return ListenerStatus{
+		Name:      sl.config.Name,
+		Network:   sl.network,
+		Address:   sl.listener.Addr().String(),
+		Listening: true,
+		TLS:       sl.config.TLSConfig != nil}
+}
+
+func (sl *serverListener) handshakeTLS(conn net.Conn) (net.Conn, *tls.ConnectionState, error) {
+	if sl.config.TLSConfig == nil {
+		return conn, nil, nil
+	}
+
+	tlsConn := tls.Server(conn, sl.config.TLSConfig)
+	conn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	err := tlsConn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		return conn, nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+	return tlsConn, &state, nil
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestParseListenAddress(t *testing.T) {
+	tests := []struct {
+		address         string
+		expectedNetwork string
+		expectedAddress string
+	}{
+		{":8080", "tcp", ":8080"},
+		{"127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"[::1]:8080", "tcp", "[::1]:8080"},
+		{"unix:/run/gosocks.sock", "unix", "/run/gosocks.sock"},
+	}
+
+	for _, tt := range tests {
+		network, address := parseListenAddress(tt.address)
+		if network != tt.expectedNetwork || address != tt.expectedAddress {
+			t.Errorf("unexpected result for %s. expected=%s %s, got=%s %s",
+				tt.address, tt.expectedNetwork, tt.expectedAddress, network, address)
+		}
+	}
+}
+
+func TestMultipleListeners(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "admin.sock")
+
+	dataRouter := NewRouter()
+	dataRouter.AddRoute("/data", buildStatusCodeHandler(200))
+	adminRouter := NewRouter()
+	adminRouter.AddRoute("/admin", buildStatusCodeHandler(200))
+
+	srv := NewServerWithListeners(
+		ListenerConfig{Name: "data", Address: "127.0.0.1:0"},
+		ListenerConfig{Name: "admin", Address: UNIX_ADDRESS_PREFIX + socketPath, Router: adminRouter, Permissions: 0600})
+	srv.SetRoutes(dataRouter)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("unix socket was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected socket permissions. expected=0600, got=%o", info.Mode().Perm())
+	}
+
+	tcpAddress := srv.Status().Listeners[0].Address
+
+	tests := []struct {
+		network        string
+		address        string
+		path           string
+		expectedStatus string
+	}{
+		{"tcp", tcpAddress, "/data", "200"},
+		{"tcp", tcpAddress, "/admin", "404"},
+		{"unix", socketPath, "/admin", "200"},
+		{"unix", socketPath, "/data", "404"},
+	}
+
+	for _, tt := range tests {
+		statusLine, err := doRawRequest(tt.network, tt.address, tt.path)
+		if err != nil {
+			t.Errorf("request to %s %s failed: %v", tt.network, tt.path, err)
+			continue
+		}
+
+		if !strings.HasPrefix(statusLine, "HTTP/1.1 "+tt.expectedStatus) {
+			t.Errorf("unexpected status for %s %s. expected=%s, got=%s",
+				tt.network, tt.path, tt.expectedStatus, statusLine)
+		}
+	}
+}
+
+func doRawRequest(network string, address string, path string) (string, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", path)
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestTLSListener(t *testing.T) {
+	certificate := generateTestCertificate(t)
+
+	router := NewRouter()
+	router.AddRoute("/rooms/{room}", func(request http.HttpRequest) (http.HttpResponse, error) {
+		if request.TLS == nil {
+			return http.NewPlainTextResponse("no tls", 500), nil
+		}
+		return http.NewPlainTextResponse(request.Scheme+" "+request.PathParam("room"), 200), nil
+	})
+
+	srv := NewServerWithListeners(ListenerConfig{
+		Name:      "secure",
+		Address:   "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}}})
+	srv.SetRoutes(router)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	status := srv.Status().Listeners[0]
+	if !status.TLS {
+		t.Errorf("expected listener status to report tls")
+	}
+
+	conn, err := tls.Dial("tcp", status.Address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /rooms/general HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	response := readAll(conn)
+	if !strings.HasPrefix(response, "HTTP/1.1 200") || !strings.HasSuffix(response, "https general") {
+		t.Errorf("unexpected response: %q", response)
+	}
+}
+
+type recordingConn struct {
+	net.Conn
+	received bytes.Buffer
+}
+
+func (conn *recordingConn) Read(data []byte) (int, error) {
+	n, err := conn.Conn.Read(data)
+	conn.received.Write(data[:n])
+	return n, err
+}
+
+func TestTLSListenerSendsCloseNotify(t *testing.T) {
+	srv := NewServerWithListeners(ListenerConfig{
+		Name:      "secure",
+		Address:   "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{generateTestCertificate(t)}}})
+	srv.SetRoutes(NewRouter())
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	rawConn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	recorder := &recordingConn{Conn: rawConn}
+	conn := tls.Client(recorder, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /missing HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	readAll(conn)
+
+	var lastRecordType byte
+	records := recorder.received.Bytes()
+	for len(records) >= 5 {
+		lastRecordType = records[0]
+		records = records[min(len(records), 5+int(binary.BigEndian.Uint16(records[3:5]))):]
+	}
+	if lastRecordType != 21 {
+		t.Errorf("expected the connection to end with a close_notify alert. last record type=%d", lastRecordType)
+	}
+}
+
+func generateTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour)}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMissingHostHeaderRejected(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/data", buildStatusCodeHandler(200))
+
+	srv := NewServerWithListeners(ListenerConfig{Name: "data", Address: "127.0.0.1:0"})
+	srv.SetRoutes(router)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	tests := []struct {
+		request        string
+		expectedStatus string
+	}{
+		{"GET /data HTTP/1.1\r\n\r\n", "HTTP/1.1 400"},
+		{"GET /data HTTP/1.0\r\n\r\n", "HTTP/1.1 200"},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", srv.Status().Listeners[0].Address)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		fmt.Fprint(conn, tt.request)
+		statusLine, _ := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if !strings.HasPrefix(statusLine, tt.expectedStatus) {
+			t.Errorf("unexpected status for %q. expected=%s, got=%q", tt.request, tt.expectedStatus, statusLine)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const OPENAPI_VERSION = "3.1.0"
+const CONTENT_TYPE_JSON = "application/json"
+
+var OPENAPI_METHODS = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type schemaBuilder struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+func OpenAPIDocument(info OpenAPIInfo, routers ...Router) (map[string]any, error) {
+	builder := &schemaBuilder{components: map[string]any{}, names: map[reflect.Type]string{}}
+	paths := map[string]any{}
+	documented := map[string]RouteInfo{}
+
+	for _, router := range routers {
+		for _, route := range router.Routes() {
+			if route.Kind != ROUTE_KIND_HTTP {
+				continue
+			}
+
+			path := openAPIPath(route.Pattern)
+			pathItem, exists := paths[path].(map[string]any)
+			if !exists {
+				pathItem = map[string]any{}
+				paths[path] = pathItem
+			}
+
+			methods := []string{route.Method}
+			if route.Method == METHOD_ANY {
+				methods = OPENAPI_METHODS
+			}
+
+			for _, method := range methods {
+				method = strings.ToLower(method)
+				key := path + " " + method
+
+				previous, exists := documented[key]
+				if exists && previous.Host != route.Host {
+					return nil, fmt.Errorf("openapi: %s %s is registered for hosts %q and %q", strings.ToUpper(method), route.Pattern, previous.Host, route.Host)
+				}
+				if exists && (previous.Method != METHOD_ANY || route.Method == METHOD_ANY) {
+					continue
+				}
+
+				documented[key] = route
+				pathItem[method] = builder.operation(route, method)
+			}
+		}
+	}
+
+	document := map[string]any{
+		"openapi": OPENAPI_VERSION,
+		"info":    info,
+		"paths":   paths}
+
+	if len(builder.components) > 0 {
+		document["components"] = map[string]any{"schemas": builder.components}
+	}
+
+	return document, nil
+}
+
+func MarshalOpenAPI(info OpenAPIInfo, routers ...Router) ([]byte, error) {
+	document, err := OpenAPIDocument(info, routers...)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+func OpenAPIHandler(info OpenAPIInfo, routers ...Router) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		content, err := MarshalOpenAPI(info, routers...)
+		if err != nil {
+			return http.HttpResponse{}, err
+		}
+
+		return http.HttpResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": CONTENT_TYPE_JSON},
+			Content:    content}, nil
+	}
+}
+
+func openAPIPath(pattern string) string {
+	return strings.ReplaceAll(pattern, REST_PARAM_SUFFIX+"}", "}")
+}
+
+func (builder *schemaBuilder) operation(route RouteInfo, method string) map[string]any {
+	operation := map[string]any{
+		"operationId": operationId(route, method)}
+
+	if route.Summary != "" {
+		operation["summary"] = route.Summary
+	}
+	if route.Host != "" {
+		operation["x-host"] = route.Host
+	}
+
+	parameters, hasBody := builder.parameters(route)
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if hasBody {
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				CONTENT_TYPE_JSON: map[string]any{"schema": builder.schema(route.RequestType)}}}
+	}
+
+	status := successStatus(route.ResponseType)
+	success := map[string]any{"description": "Successful response"}
+	if route.ResponseType != nil && status != 204 {
+		success["content"] = map[string]any{
+			CONTENT_TYPE_JSON: map[string]any{"schema": builder.schema(route.ResponseType)}}
+	}
+	responses := map[string]any{strconv.Itoa(status): success}
+
+	if route.RequestType != nil {
+		problem := map[string]any{
+			http.CONTENT_TYPE_PROBLEM_JSON: map[string]any{"schema": builder.schema(reflect.TypeOf(http.Problem{}))}}
+
+		responses["400"] = map[string]any{"description": "Malformed request", "content": problem}
+		responses["422"] = map[string]any{"description": "Validation failed", "content": problem}
+		if hasBody {
+			responses["415"] = map[string]any{"description": "Unsupported content type", "content": problem}
+		}
+		responses["default"] = map[string]any{"description": "Error", "content": problem}
+	}
+	operation["responses"] = responses
+
+	return operation
+}
+
+func successStatus(responseType reflect.Type) int {
+	if responseType == nil || !responseType.Implements(reflect.TypeOf((*StatusCoder)(nil)).Elem()) {
+		return 200
+	}
+	if responseType.Kind() == reflect.Pointer || responseType.Kind() == reflect.Interface {
+		return 200
+	}
+	return responseStatus(reflect.Zero(responseType).Interface())
+}
+
+func operationId(route RouteInfo, method string) string {
+	if route.Name != "" && route.Method != METHOD_ANY && !strings.Contains(route.Method, ",") {
+		return route.Name
+	}
+
+	parts := []string{method}
+	for _, segment := range splitPath(route.Pattern) {
+		if paramName, isParam := parseParamSegment(segment); isParam {
+			segment = paramName
+		}
+		if segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func (builder *schemaBuilder) parameters(route RouteInfo) ([]any, bool) {
+	parameters := []any{}
+	declared := map[string]bool{}
+	hasBody := false
+
+	requestType := route.RequestType
+	if requestType != nil && requestType.Kind() == reflect.Pointer {
+		requestType = requestType.Elem()
+	}
+
+	if requestType != nil && requestType.Kind() == reflect.Struct {
+		for i := 0; i < requestType.NumField(); i++ {
+			field := requestType.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			source, name := paramSource(field)
+			if source == "" {
+				if jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ","); jsonName != "-" {
+					hasBody = true
+				}
+				continue
+			}
+
+			rules := parseRules(field.Tag.Get(VALIDATE_TAG))
+			schema := builder.schema(field.Type)
+			applyRules(schema, rules, field.Type)
+
+			parameters = append(parameters, map[string]any{
+				"name":     name,
+				"in":       source,
+				"required": source == BINDING_SOURCE_PATH || hasRule(rules, "required"),
+				"schema":   schema})
+			declared[source+":"+name] = true
+		}
+	} else if requestType != nil {
+		hasBody = true
+	}
+
+	for _, segment := range splitPath(route.Pattern) {
+		paramName, isParam := parseParamSegment(segment)
+		if !isParam || declared[BINDING_SOURCE_PATH+":"+paramName] {
+			continue
+		}
+		parameters = append(parameters, map[string]any{
+			"name":     paramName,
+			"in":       BINDING_SOURCE_PATH,
+			"required": true,
+			"schema":   map[string]any{"type": "string"}})
+	}
+
+	return parameters, hasBody
+}
+
+func (builder *schemaBuilder) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		return builder.schema(t.Elem())
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": builder.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": builder.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return builder.structSchema(t)
+		}
+
+		name, exists := builder.names[t]
+		if exists {
+			return map[string]any{"$ref": "#/components/schemas/" + name}
+		}
+
+		name = builder.componentName(t)
+		builder.names[t] = name
+		builder.components[name] = map[string]any{}
+		builder.components[name] = builder.structSchema(t)
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]any{}
+}
+
+func (builder *schemaBuilder) componentName(t reflect.Type) string {
+	base := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, t.Name())
+
+	name := base
+	for i := 2; builder.components[name] != nil; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	return name
+}
+
+func (builder *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	builder.collectProperties(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (builder *schemaBuilder) collectProperties(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if field.Anonymous && jsonName == "" && field.Type.Kind() == reflect.Struct {
+			builder.collectProperties(field.Type, properties, required)
+			continue
+		}
+
+		if !field.IsExported() || jsonName == "-" {
+			continue
+		}
+		if source, _ := paramSource(field); source != "" {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+
+		rules := parseRules(field.Tag.Get(VALIDATE_TAG))
+		schema := builder.schema(field.Type)
+		if _, isReference := schema["$ref"]; !isReference {
+			applyRules(schema, rules, field.Type)
+		}
+		properties[jsonName] = schema
+
+		if hasRule(rules, "required") {
+			*required = append(*required, jsonName)
+		}
+	}
+}
+
+func hasRule(rules []validationRule, name string) bool {
+	for _, rule := range rules {
+		if rule.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func applyRules(schema map[string]any, rules []validationRule, t reflect.Type) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for _, rule := range rules {
+		switch rule.name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(rule.argument, 64)
+			if err != nil {
+				continue
+			}
+			schema[constraintKeyword(rule.name, t)] = limit
+		case "oneof":
+			options := []any{}
+			for _, option := range strings.Fields(rule.argument) {
+				options = append(options, option)
+			}
+			schema["enum"] = options
+		}
+	}
+}
+
+func constraintKeyword(rule string, t reflect.Type) string {
+	suffix := ""
+	switch t.Kind() {
+	case reflect.String:
+		suffix = "Length"
+	case reflect.Slice, reflect.Array:
+		suffix = "Items"
+	case reflect.Map:
+		suffix = "Properties"
+	default:
+		if rule == "min" {
+			return "minimum"
+		}
+		return "maximum"
+	}
+	return rule + suffix
+}
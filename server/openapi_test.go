@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	router := NewRouter()
+	AddJSONRoute(router, "/rooms/{room}/messages",
+		func(ctx context.Context, request createMessageRequest) (createMessageResponse, error) {
+			return createMessageResponse{}, nil
+		},
+		WithMethods("POST"), WithName("createMessage"))
+	router.AddRoute("/files/{name}", buildStatusCodeHandler(200))
+	router.AddRoute("/static/{path...}", buildStatusCodeHandler(200), WithMethods("GET"))
+	AddJSONRoute(router, "/rooms",
+		func(ctx context.Context, request struct{}) (createdResponse, error) {
+			return createdResponse{}, nil
+		},
+		WithMethods("POST"))
+
+	content, err := MarshalOpenAPI(OpenAPIInfo{Title: "test", Version: "1"}, router)
+	if err != nil {
+		t.Fatalf("failed to marshal document: %v", err)
+	}
+
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationId string `json:"operationId"`
+			Parameters  []struct {
+				Name     string         `json:"name"`
+				In       string         `json:"in"`
+				Required bool           `json:"required"`
+				Schema   map[string]any `json:"schema"`
+			} `json:"parameters"`
+			RequestBody map[string]any `json:"requestBody"`
+			Responses   map[string]any `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                  `json:"required"`
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.Unmarshal(content, &document)
+	if err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+
+	operation := document.Paths["/rooms/{room}/messages"]["post"]
+	if operation.OperationId != "createMessage" || operation.RequestBody == nil {
+		t.Errorf("unexpected operation: %+v", operation)
+	}
+
+	parameters := map[string]string{}
+	for _, parameter := range operation.Parameters {
+		parameters[parameter.In+":"+parameter.Name] = parameter.Schema["type"].(string)
+	}
+	expectedParameters := map[string]string{"path:room": "string", "query:dry_run": "boolean", "query:limit": "integer", "query:tags": "array"}
+	for key, schemaType := range expectedParameters {
+		if parameters[key] != schemaType {
+			t.Errorf("unexpected parameter %s. expected=%s, got=%s", key, schemaType, parameters[key])
+		}
+	}
+
+	for _, status := range []string{"200", "400", "415", "422"} {
+		if operation.Responses[status] == nil {
+			t.Errorf("missing %s response", status)
+		}
+	}
+
+	schema := document.Components.Schemas["createMessageRequest"]
+	if len(schema.Required) != 1 || schema.Required[0] != "text" {
+		t.Errorf("unexpected required fields: %v", schema.Required)
+	}
+	if schema.Properties["text"]["maxLength"] != float64(10) || schema.Properties["limit"] != nil {
+		t.Errorf("unexpected body properties: %v", schema.Properties)
+	}
+
+	if created := document.Paths["/rooms"]["post"]; created.Responses["201"] == nil || created.Responses["200"] != nil {
+		t.Errorf("expected StatusCoder responses to be documented as 201. got=%v", created.Responses)
+	}
+
+	files := document.Paths["/files/{name}"]["get"]
+	if files.OperationId != "get_files_name" || len(files.Parameters) != 1 || !files.Parameters[0].Required {
+		t.Errorf("unexpected untyped operation: %+v", files)
+	}
+	static, exists := document.Paths["/static/{path}"]["get"]
+	if !exists || len(static.Parameters) != 1 || static.Parameters[0].Name != "path" {
+		t.Errorf("expected rest parameter to be templated as {path}. got=%v", document.Paths)
+	}
+
+	for _, method := range OPENAPI_METHODS {
+		if _, exists := document.Paths["/files/{name}"][strings.ToLower(method)]; !exists {
+			t.Errorf("expected route without methods to document %s", method)
+		}
+	}
+}
+
+func TestOpenAPIDocumentHostConflicts(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/status", buildStatusCodeHandler(200), WithMethods("GET"))
+	router.Host("api.example.com").AddRoute("/status", buildStatusCodeHandler(200), WithMethods("GET"))
+
+	_, err := OpenAPIDocument(OpenAPIInfo{Title: "test", Version: "1"}, router)
+	if err == nil {
+		t.Errorf("expected host-scoped routes sharing a pattern to be rejected")
+	}
+
+	single := NewRouter()
+	single.AddRoute("/status", buildStatusCodeHandler(200), WithMethods("GET"))
+	_, err = OpenAPIDocument(OpenAPIInfo{Title: "test", Version: "1"}, single, single)
+	if err != nil {
+		t.Errorf("expected the same router listed twice to be documented once. got=%v", err)
+	}
+}
+
+type openAPIPage[T any] struct {
+	Items []T `json:"items"`
+}
+
+func TestOpenAPIComponentNames(t *testing.T) {
+	type Problem struct {
+		Reason string `json:"reason"`
+	}
+
+	router := NewRouter()
+	AddJSONRoute(router, "/problems",
+		func(ctx context.Context, request createdResponse) (Problem, error) {
+			return Problem{}, nil
+		},
+		WithMethods("POST"))
+	AddJSONRoute(router, "/created",
+		func(ctx context.Context, request struct{}) (openAPIPage[createdResponse], error) {
+			return openAPIPage[createdResponse]{}, nil
+		},
+		WithMethods("GET"))
+	AddJSONRoute(router, "/deleted",
+		func(ctx context.Context, request struct{}) (openAPIPage[deletedResponse], error) {
+			return openAPIPage[deletedResponse]{}, nil
+		},
+		WithMethods("GET"))
+
+	document, err := OpenAPIDocument(OpenAPIInfo{Title: "test", Version: "1"}, router)
+	if err != nil {
+		t.Fatalf("failed to build document: %v", err)
+	}
+	components := document["components"].(map[string]any)["schemas"].(map[string]any)
+
+	resolve := func(path string, method string, status string, contentType string) map[string]any {
+		operation := document["paths"].(map[string]any)[path].(map[string]any)[method].(map[string]any)
+		response := operation["responses"].(map[string]any)[status].(map[string]any)
+		schema := response["content"].(map[string]any)[contentType].(map[string]any)["schema"].(map[string]any)
+		name := strings.TrimPrefix(schema["$ref"].(string), "#/components/schemas/")
+		component, exists := components[name].(map[string]any)
+		if !exists {
+			t.Fatalf("reference %s of %s does not resolve", name, path)
+		}
+		return component["properties"].(map[string]any)
+	}
+
+	if _, exists := resolve("/problems", "post", "200", CONTENT_TYPE_JSON)["reason"]; !exists {
+		t.Errorf("expected local Problem type not to be shadowed by http.Problem")
+	}
+	if _, exists := resolve("/problems", "post", "default", http.CONTENT_TYPE_PROBLEM_JSON)["status"]; !exists {
+		t.Errorf("expected http.Problem not to be shadowed by the local Problem type")
+	}
+
+	created := resolve("/created", "get", "200", CONTENT_TYPE_JSON)["items"].(map[string]any)["items"].(map[string]any)["$ref"]
+	deleted := resolve("/deleted", "get", "200", CONTENT_TYPE_JSON)["items"].(map[string]any)["items"].(map[string]any)["$ref"]
+	if created == deleted {
+		t.Errorf("expected generic instantiations to reference distinct item schemas. got=%v", created)
+	}
+
+	for name := range components {
+		if strings.ContainsAny(name, "[]/* ") {
+			t.Errorf("component name %q is not a valid OpenAPI component key", name)
+		}
+	}
+}
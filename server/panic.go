@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+type handlerPanic struct {
+	value interface{}
+}
+
+func (hp handlerPanic) Error() string {
+	return fmt.Sprintf("handler panicked: %v", hp.value)
+}
+
+func callHandler(handler HttpHandler, request http.HttpRequest) (response http.HttpResponse, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			response = http.HttpResponse{}
+			err = handlerPanic{value: recovered}
+		}
+	}()
+
+	return handler(request)
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/tracing"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+const DEFAULT_HEALTH_CHECK_INTERVAL = 10 * time.Second
+const DEFAULT_HEALTH_CHECK_TIMEOUT = 2 * time.Second
+const PROXY_MAX_ATTEMPTS = 2
+
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+var websocketHandshakeHeaders = []string{
+	"Sec-WebSocket-Key",
+	"Sec-WebSocket-Accept",
+	"Sec-WebSocket-Version",
+	"Sec-WebSocket-Extensions",
+}
+
+type ReverseProxyConfig struct {
+	Upstreams             []string
+	StripPrefix           string
+	PreserveHost          bool
+	SetRequestHeaders     map[string]string
+	RemoveRequestHeaders  []string
+	SetResponseHeaders    map[string]string
+	RemoveResponseHeaders []string
+	HealthCheckPath       string
+	HealthCheckInterval   time.Duration
+	HealthCheckTimeout    time.Duration
+	Client                *http.Client
+}
+
+type UpstreamStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+}
+
+type ReverseProxy struct {
+	config    ReverseProxyConfig
+	upstreams []*upstream
+	client    *http.Client
+	next      atomic.Uint64
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type upstream struct {
+	target  *url.URL
+	healthy atomic.Bool
+}
+
+func NewReverseProxy(config ReverseProxyConfig) (*ReverseProxy, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, fmt.Errorf("reverse proxy needs at least one upstream")
+	}
+	if config.HealthCheckInterval == 0 {
+		config.HealthCheckInterval = DEFAULT_HEALTH_CHECK_INTERVAL
+	}
+	if config.HealthCheckTimeout == 0 {
+		config.HealthCheckTimeout = DEFAULT_HEALTH_CHECK_TIMEOUT
+	}
+
+	proxy := &ReverseProxy{config: config, client: config.Client, stop: make(chan struct{})}
+	if proxy.client == nil {
+		proxy.client = http.NewClient(http.ClientConfig{MaxRedirects: -1})
+	}
+
+	for _, rawURL := range config.Upstreams {
+		target, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream %s: %w", rawURL, err)
+		}
+		if (target.Scheme != SCHEME_HTTP && target.Scheme != SCHEME_HTTPS) || target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream %s: expected http(s)://host[:port][/path]", rawURL)
+		}
+
+		u := &upstream{target: target}
+		u.healthy.Store(true)
+		proxy.upstreams = append(proxy.upstreams, u)
+	}
+
+	if config.HealthCheckPath != "" {
+		go proxy.runHealthChecks()
+	}
+
+	return proxy, nil
+}
+
+func (proxy *ReverseProxy) Mount(router Router, prefix string, options ...RouteOption) error {
+	pattern := strings.TrimSuffix(prefix, "/") + "/{path" + REST_PARAM_SUFFIX + "}"
+
+	err := router.AddRoute(pattern, proxy.Handler(), options...)
+	if err != nil {
+		return err
+	}
+	return router.AddWebSocket(pattern, nil, WithUpgrader(proxy.WebSocketUpgrader()))
+}
+
+func (proxy *ReverseProxy) Close() {
+	proxy.stopOnce.Do(func() {
+		close(proxy.stop)
+	})
+}
+
+func (proxy *ReverseProxy) Status() []UpstreamStatus {
+	statuses := []UpstreamStatus{}
+	for _, u := range proxy.upstreams {
+		statuses = append(statuses, UpstreamStatus{URL: u.target.String(), Healthy: u.healthy.Load()})
+	}
+	return statuses
+}
+
+func (proxy *ReverseProxy) ReadinessCheck() HealthCheck {
+	return func(ctx context.Context) error {
+		if proxy.pick() == nil {
+			return fmt.Errorf("no healthy upstream")
+		}
+		return nil
+	}
+}
+
+func (proxy *ReverseProxy) Handler() HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		logger := logging.FromContext(request.Context())
+
+		attempts := 1
+		if http.IsIdempotent(request.Method) {
+			attempts = min(PROXY_MAX_ATTEMPTS, len(proxy.upstreams))
+		}
+
+		var lastErr error
+		for attempt := 0; attempt < attempts; attempt++ {
+			target := proxy.pick()
+			if target == nil {
+				return http.HttpResponse{}, http.ServiceUnavailable("no healthy upstream")
+			}
+
+			response, err := proxy.client.Do(proxy.upstreamRequest(request, target))
+			if err == nil {
+				return proxy.downstreamResponse(response), nil
+			}
+
+			logger.Warn("upstream request failed", "upstream", target.target.String(), "error", err)
+			lastErr = err
+			if request.Context().Err() != nil {
+				break
+			}
+		}
+
+		if errors.Is(lastErr, context.DeadlineExceeded) {
+			return http.HttpResponse{}, http.GatewayTimeout(lastErr.Error())
+		}
+		return http.HttpResponse{}, http.BadGateway(lastErr.Error())
+	}
+}
+
+func (proxy *ReverseProxy) WebSocketUpgrader() WebSocketUpgrader {
+	return func(request http.HttpRequest) (WebSocketUpgrade, error) {
+		logger := logging.FromContext(request.Context())
+
+		target := proxy.pick()
+		if target == nil {
+			logger.Warn("no healthy upstream for websocket")
+			return WebSocketUpgrade{}, http.ServiceUnavailable("no healthy upstream")
+		}
+
+		upstreamRequest := proxy.upstreamRequest(request, target)
+		for _, headerName := range websocketHandshakeHeaders {
+			http.DeleteHeader(upstreamRequest.Headers, headerName)
+		}
+
+		upstreamConn, err := proxy.client.Dial(request.Context(), target.target.Scheme, target.target.Host)
+		if err != nil {
+			logger.Warn("upstream websocket failed", "upstream", target.target.String(), "error", err)
+			return WebSocketUpgrade{}, http.BadGateway(err.Error())
+		}
+
+		response, upstreamReader, err := websocket.ClientHandshake(upstreamConn, upstreamRequest)
+		if err != nil {
+			upstreamConn.Close()
+			logger.Warn("upstream websocket failed", "upstream", target.target.String(), "error", err)
+			return WebSocketUpgrade{}, proxy.upgradeError(response, err)
+		}
+
+		headers := map[string]string{}
+		if protocol, selected := response.Header("Sec-WebSocket-Protocol"); selected {
+			offered, _ := request.Header("Sec-WebSocket-Protocol")
+			if !slices.Contains(splitList(offered), protocol) {
+				upstreamConn.Close()
+				logger.Warn("upstream selected a subprotocol the client did not offer",
+					"upstream", target.target.String(),
+					"protocol", protocol)
+				return WebSocketUpgrade{}, http.BadGateway(fmt.Sprintf("upstream selected unoffered subprotocol %q", protocol))
+			}
+			headers["Sec-WebSocket-Protocol"] = protocol
+		}
+
+		return WebSocketUpgrade{
+			Headers: headers,
+			Handler: func(request http.HttpRequest, conn net.Conn, reader *bufio.Reader) {
+				err := websocket.Relay(conn, reader, upstreamConn, upstreamReader)
+				if err != nil {
+					logger.Debug("websocket relay ended", "upstream", target.target.String(), "error", err)
+				}
+			},
+			Abort: func() { upstreamConn.Close() }}, nil
+	}
+}
+
+func (proxy *ReverseProxy) upgradeError(response http.HttpResponse, err error) http.HttpError {
+	if response.StatusCode < 400 {
+		return http.BadGateway(err.Error())
+	}
+
+	headers := proxy.downstreamResponse(response).Headers
+	http.DeleteHeader(headers, "Content-Type")
+	return http.HttpError{StatusCode: response.StatusCode, Message: err.Error(), Headers: headers}
+}
+
+func (proxy *ReverseProxy) pick() *upstream {
+	count := uint64(len(proxy.upstreams))
+	start := proxy.next.Add(1) - 1
+
+	for i := uint64(0); i < count; i++ {
+		u := proxy.upstreams[(start+i)%count]
+		if u.healthy.Load() {
+			return u
+		}
+	}
+	return nil
+}
+
+func (proxy *ReverseProxy) upstreamRequest(request http.HttpRequest, target *upstream) http.HttpRequest {
+	headers := map[string]string{}
+	for headerName, headerValue := range request.Headers {
+		headers[headerName] = headerValue
+	}
+
+	connection, _ := request.Header("Connection")
+	for _, token := range splitList(connection) {
+		http.DeleteHeader(headers, token)
+	}
+	for _, headerName := range hopByHopHeaders {
+		http.DeleteHeader(headers, headerName)
+	}
+
+	host := request.Host
+	if host == "" {
+		host, _ = request.Header("Host")
+	}
+	scheme := request.Scheme
+	if scheme == "" {
+		scheme = SCHEME_HTTP
+	}
+
+	forwardedFor := request.ClientIP
+	if previous, exists := request.Header(X_FORWARDED_FOR_HEADER); exists && forwardedFor != "" {
+		forwardedFor = previous + ", " + forwardedFor
+	}
+	http.DeleteHeader(headers, X_FORWARDED_FOR_HEADER)
+	http.DeleteHeader(headers, X_FORWARDED_PROTO_HEADER)
+	http.DeleteHeader(headers, X_FORWARDED_HOST_HEADER)
+	if forwardedFor != "" {
+		headers[X_FORWARDED_FOR_HEADER] = forwardedFor
+	}
+	headers[X_FORWARDED_PROTO_HEADER] = scheme
+	if host != "" {
+		headers[X_FORWARDED_HOST_HEADER] = host
+	}
+
+	if requestId := RequestID(request.Context()); requestId != "" {
+		http.DeleteHeader(headers, REQUEST_ID_HEADER)
+		headers[REQUEST_ID_HEADER] = requestId
+	}
+	tracing.Inject(request.Context(), headers)
+
+	for _, headerName := range proxy.config.RemoveRequestHeaders {
+		http.DeleteHeader(headers, headerName)
+	}
+	for headerName, headerValue := range proxy.config.SetRequestHeaders {
+		http.DeleteHeader(headers, headerName)
+		headers[headerName] = headerValue
+	}
+
+	upstreamHost := target.target.Host
+	if proxy.config.PreserveHost && host != "" {
+		upstreamHost = host
+	}
+	http.DeleteHeader(headers, "Host")
+	headers["Host"] = upstreamHost
+
+	path := strings.TrimPrefix(request.Path(), proxy.config.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	path = strings.TrimSuffix(target.target.Path, "/") + path
+	if _, query, found := strings.Cut(request.FullPath, "?"); found {
+		path += "?" + query
+	}
+
+	upstreamRequest := request
+	upstreamRequest.FullPath = path
+	upstreamRequest.Protocol = "HTTP/1.1"
+	upstreamRequest.Headers = headers
+	upstreamRequest.Scheme = target.target.Scheme
+	upstreamRequest.Host = target.target.Host
+	return upstreamRequest
+}
+
+func (proxy *ReverseProxy) downstreamResponse(response http.HttpResponse) http.HttpResponse {
+	headers := map[string]string{}
+	for headerName, headerValue := range response.Headers {
+		headers[headerName] = headerValue
+	}
+
+	connection, _ := response.Header("Connection")
+	for _, token := range splitList(connection) {
+		http.DeleteHeader(headers, token)
+	}
+	for _, headerName := range hopByHopHeaders {
+		http.DeleteHeader(headers, headerName)
+	}
+	http.DeleteHeader(headers, "Content-Length")
+	for _, headerName := range proxy.config.RemoveResponseHeaders {
+		http.DeleteHeader(headers, headerName)
+	}
+	for headerName, headerValue := range proxy.config.SetResponseHeaders {
+		http.DeleteHeader(headers, headerName)
+		headers[headerName] = headerValue
+	}
+
+	response.Headers = headers
+	return response
+}
+
+func (proxy *ReverseProxy) runHealthChecks() {
+	ticker := time.NewTicker(proxy.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	proxy.CheckHealth(context.Background())
+	for {
+		select {
+		case <-proxy.stop:
+			return
+		case <-ticker.C:
+			proxy.CheckHealth(context.Background())
+		}
+	}
+}
+
+func (proxy *ReverseProxy) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range proxy.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			u.healthy.Store(proxy.probe(ctx, u))
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (proxy *ReverseProxy) probe(ctx context.Context, u *upstream) bool {
+	ctx, cancel := context.WithTimeout(ctx, proxy.config.HealthCheckTimeout)
+	defer cancel()
+
+	target := *u.target
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(proxy.config.HealthCheckPath, "/")
+
+	response, err := proxy.client.Get(ctx, target.String())
+	return err == nil && response.StatusCode < 400
+}
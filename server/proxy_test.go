@@ -0,0 +1,446 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+type proxiedRequest struct {
+	Upstream       string `json:"upstream"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	Host           string `json:"host"`
+	ForwardedFor   string `json:"forwarded_for"`
+	ForwardedHost  string `json:"forwarded_host"`
+	ForwardedProto string `json:"forwarded_proto"`
+	Tenant         string `json:"tenant"`
+	Secret         string `json:"secret"`
+	Body           string `json:"body"`
+}
+
+func startProxyTestServer(t *testing.T, router Router) string {
+	srv := NewServerWithListeners(ListenerConfig{Name: "test", Address: "127.0.0.1:0"})
+	srv.SetRoutes(router)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	return srv.Status().Listeners[0].Address
+}
+
+func startEchoUpstream(t *testing.T, name string, healthStatus int) string {
+	router := NewRouter()
+	router.AddRoute("/api/{path...}", func(request http.HttpRequest) (http.HttpResponse, error) {
+		header := func(name string) string {
+			value, _ := request.Header(name)
+			return value
+		}
+
+		response, err := http.NewJsonResponse(proxiedRequest{
+			Upstream:       name,
+			Method:         request.Method,
+			Path:           request.FullPath,
+			Host:           header("Host"),
+			ForwardedFor:   header(X_FORWARDED_FOR_HEADER),
+			ForwardedHost:  header(X_FORWARDED_HOST_HEADER),
+			ForwardedProto: header(X_FORWARDED_PROTO_HEADER),
+			Tenant:         header("X-Tenant"),
+			Secret:         header("X-Secret"),
+			Body:           string(request.Content)}, 200)
+		response.Headers["X-Powered-By"] = "legacy"
+		response.Headers["Keep-Alive"] = "timeout=5"
+		return response, err
+	})
+	router.AddRoute("/api/healthz", buildStatusCodeHandler(healthStatus))
+	router.AddWebSocket("/api/ws", websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen: func(conn websocket.WsConnection) {
+			if protocol, exists := conn.Request().Header("Sec-WebSocket-Protocol"); exists {
+				conn.SendText("protocol:" + protocol)
+			}
+		},
+		OnMessage: func(event websocket.WsMessageEvent, conn websocket.WsConnection) {
+			conn.SendText(name + ":" + string(event.Data))
+		},
+		OnClose: func(websocket.WsCloseEvent, websocket.WsConnection) {},
+		OnError: func(error, websocket.WsConnection) {},
+	}))
+
+	return "http://" + startProxyTestServer(t, router) + "/api"
+}
+
+func startReverseProxy(t *testing.T, config ReverseProxyConfig) (*ReverseProxy, string) {
+	proxy, err := NewReverseProxy(config)
+	if err != nil {
+		t.Fatalf("failed to create reverse proxy: %v", err)
+	}
+	t.Cleanup(proxy.Close)
+
+	router := NewRouter()
+	err = proxy.Mount(router, "/legacy")
+	if err != nil {
+		t.Fatalf("failed to mount reverse proxy: %v", err)
+	}
+
+	return proxy, "http://" + startProxyTestServer(t, router)
+}
+
+func doProxiedRequest(t *testing.T, client *http.Client, method string, url string, headers map[string]string) (http.HttpResponse, proxiedRequest) {
+	request, err := http.NewClientRequest(context.Background(), method, url, []byte("payload"))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	for name, value := range headers {
+		request.Headers[name] = value
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("proxied request failed: %v", err)
+	}
+
+	var proxied proxiedRequest
+	if response.StatusCode == 200 {
+		err = json.Unmarshal(response.Content, &proxied)
+		if err != nil {
+			t.Fatalf("failed to decode upstream echo %q: %v", response.Content, err)
+		}
+	}
+	return response, proxied
+}
+
+func dialProxiedWebSocket(t *testing.T, proxyURL string, headers map[string]string) (net.Conn, http.HttpResponse, *bufio.Reader, error) {
+	host := strings.TrimPrefix(proxyURL, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatalf("failed to connect to proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request := http.HttpRequest{FullPath: "/legacy/ws", Headers: map[string]string{"Host": host}}
+	for name, value := range headers {
+		request.Headers[name] = value
+	}
+
+	response, reader, err := websocket.ClientHandshake(conn, request)
+	return conn, response, reader, err
+}
+
+func TestReverseProxyHttp(t *testing.T) {
+	first := startEchoUpstream(t, "first", 200)
+	second := startEchoUpstream(t, "second", 200)
+
+	_, proxyURL := startReverseProxy(t, ReverseProxyConfig{
+		Upstreams:             []string{first, second},
+		StripPrefix:           "/legacy",
+		SetRequestHeaders:     map[string]string{"X-Tenant": "acme"},
+		RemoveRequestHeaders:  []string{"X-Secret"},
+		SetResponseHeaders:    map[string]string{"X-Proxied": "gosocks"},
+		RemoveResponseHeaders: []string{"X-Powered-By"}})
+
+	client := http.NewClient(http.ClientConfig{})
+	defer client.Close()
+
+	upstreams := []string{}
+	for i := 0; i < 4; i++ {
+		response, proxied := doProxiedRequest(t, client, "POST", proxyURL+"/legacy/users/42?x=1",
+			map[string]string{"X-Secret": "hunter2", X_FORWARDED_FOR_HEADER: "203.0.113.9"})
+
+		if response.StatusCode != 200 {
+			t.Fatalf("unexpected status %d: %s", response.StatusCode, response.Content)
+		}
+		upstreams = append(upstreams, proxied.Upstream)
+
+		if proxied.Method != "POST" || proxied.Path != "/api/users/42?x=1" || proxied.Body != "payload" {
+			t.Errorf("request was not forwarded verbatim: %+v", proxied)
+		}
+		if proxied.ForwardedFor != "203.0.113.9, 127.0.0.1" {
+			t.Errorf("unexpected X-Forwarded-For. got=%q", proxied.ForwardedFor)
+		}
+		if proxied.ForwardedProto != "http" || proxied.ForwardedHost != strings.TrimPrefix(proxyURL, "http://") {
+			t.Errorf("unexpected forwarded proto/host. got=%q %q", proxied.ForwardedProto, proxied.ForwardedHost)
+		}
+		if proxied.Host == proxied.ForwardedHost {
+			t.Errorf("expected upstream host to be rewritten. got=%q", proxied.Host)
+		}
+		if proxied.Tenant != "acme" || proxied.Secret != "" {
+			t.Errorf("request headers were not rewritten: %+v", proxied)
+		}
+
+		if _, exists := response.Header("X-Powered-By"); exists {
+			t.Errorf("expected X-Powered-By to be removed")
+		}
+		if _, exists := response.Header("Keep-Alive"); exists {
+			t.Errorf("expected hop-by-hop Keep-Alive header to be removed")
+		}
+		if value, _ := response.Header("X-Proxied"); value != "gosocks" {
+			t.Errorf("expected X-Proxied response header. got=%q", value)
+		}
+	}
+
+	if strings.Join(upstreams, ",") != "first,second,first,second" {
+		t.Errorf("expected round robin across upstreams. got=%v", upstreams)
+	}
+}
+
+func TestReverseProxyDownstreamContentLength(t *testing.T) {
+	proxy, err := NewReverseProxy(ReverseProxyConfig{Upstreams: []string{"http://127.0.0.1:1"}})
+	if err != nil {
+		t.Fatalf("failed to create reverse proxy: %v", err)
+	}
+
+	response := proxy.downstreamResponse(http.HttpResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"content-length": "5", "content-type": "text/plain"},
+		Content:    []byte("hello")})
+
+	serialized := strings.ToLower(string(response.Serialize()))
+	if count := strings.Count(serialized, "content-length:"); count != 1 {
+		t.Errorf("expected a single Content-Length header. got=%d in %q", count, serialized)
+	}
+	if value, _ := response.Header("Content-Type"); value != "text/plain" {
+		t.Errorf("expected Content-Type to be kept. got=%q", value)
+	}
+}
+
+func TestReverseProxyHealthChecks(t *testing.T) {
+	healthy := startEchoUpstream(t, "healthy", 200)
+	unhealthy := startEchoUpstream(t, "unhealthy", 503)
+
+	proxy, proxyURL := startReverseProxy(t, ReverseProxyConfig{
+		Upstreams:       []string{unhealthy, healthy},
+		StripPrefix:     "/legacy",
+		HealthCheckPath: "/healthz"})
+	proxy.CheckHealth(context.Background())
+
+	statuses := proxy.Status()
+	if len(statuses) != 2 || statuses[0].Healthy || !statuses[1].Healthy {
+		t.Fatalf("unexpected upstream status: %+v", statuses)
+	}
+	if err := proxy.ReadinessCheck()(context.Background()); err != nil {
+		t.Errorf("expected proxy to be ready: %v", err)
+	}
+
+	client := http.NewClient(http.ClientConfig{})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		_, proxied := doProxiedRequest(t, client, "GET", proxyURL+"/legacy/x", nil)
+		if proxied.Upstream != "healthy" {
+			t.Errorf("expected unhealthy upstream to be skipped. got=%q", proxied.Upstream)
+		}
+	}
+
+	for _, u := range proxy.upstreams {
+		u.healthy.Store(false)
+	}
+	response, _ := doProxiedRequest(t, client, "GET", proxyURL+"/legacy/x", nil)
+	if response.StatusCode != 503 {
+		t.Errorf("expected 503 without healthy upstreams. got=%d", response.StatusCode)
+	}
+	if err := proxy.ReadinessCheck()(context.Background()); err == nil {
+		t.Errorf("expected proxy not to be ready")
+	}
+}
+
+func TestReverseProxyBadGateway(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedAddress := listener.Addr().String()
+	listener.Close()
+
+	_, proxyURL := startReverseProxy(t, ReverseProxyConfig{Upstreams: []string{"http://" + closedAddress}})
+
+	client := http.NewClient(http.ClientConfig{})
+	defer client.Close()
+
+	response, _ := doProxiedRequest(t, client, "GET", proxyURL+"/legacy/x", nil)
+	if response.StatusCode != 502 {
+		t.Errorf("expected 502 for unreachable upstream. got=%d", response.StatusCode)
+	}
+}
+
+func TestReverseProxyWebSocket(t *testing.T) {
+	upstream := startEchoUpstream(t, "upstream", 200)
+	_, proxyURL := startReverseProxy(t, ReverseProxyConfig{Upstreams: []string{upstream}, StripPrefix: "/legacy"})
+
+	conn, response, reader, err := dialProxiedWebSocket(t, proxyURL, map[string]string{"Sec-WebSocket-Protocol": "chat"})
+	if err != nil {
+		t.Fatalf("websocket handshake through proxy failed: %v", err)
+	}
+	if protocol, exists := response.Header("Sec-WebSocket-Protocol"); exists {
+		t.Errorf("expected no subprotocol when the upstream selected none. got=%q", protocol)
+	}
+
+	frame, err := websocket.DeserialzeWebSocketFrame(reader)
+	if err != nil || string(frame.Payload) != "protocol:chat" {
+		t.Fatalf("expected Sec-WebSocket-Protocol to reach the upstream. got=%q, %v", frame.Payload, err)
+	}
+
+	for _, message := range []string{"hello", "world"} {
+		conn.Write(websocket.NewTextFrame(true, message).Serialize())
+
+		frame, err := websocket.DeserialzeWebSocketFrame(reader)
+		if err != nil {
+			t.Fatalf("failed to read relayed frame: %v", err)
+		}
+		if frame.Masked || string(frame.Payload) != "upstream:"+message {
+			t.Errorf("unexpected relayed frame. masked=%t payload=%q", frame.Masked, frame.Payload)
+		}
+	}
+
+	conn.Write(websocket.NewCloseFrame(1000, "done", true).Serialize())
+	frame, err = websocket.DeserialzeWebSocketFrame(reader)
+	if err != nil || frame.OpCode != websocket.OPCODE_CLOSE {
+		t.Errorf("expected close frame to be relayed back. got=%+v, %v", frame, err)
+	}
+}
+
+func TestReverseProxyWebSocketUpstreamFailures(t *testing.T) {
+	router := NewRouter()
+	router.AddWebSocket("/api/ws", func(request http.HttpRequest, conn net.Conn, reader *bufio.Reader) {
+		t.Errorf("expected upstream upgrade to be rejected")
+	})
+	srv := NewServerWithListeners(ListenerConfig{Name: "upstream", Address: "127.0.0.1:0"})
+	srv.SetRoutes(router)
+	srv.AddUpgradeHook(func(request http.HttpRequest, attributes *websocket.Attributes) error {
+		return http.HttpError{StatusCode: 401, Message: "missing token", Headers: map[string]string{"WWW-Authenticate": "Bearer"}}
+	})
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start upstream: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedAddress := listener.Addr().String()
+	listener.Close()
+
+	rejecting := "http://" + srv.Status().Listeners[0].Address + "/api"
+	unreachable := "http://" + closedAddress
+
+	tests := []struct {
+		name           string
+		upstream       string
+		unhealthy      bool
+		expectedStatus int
+		expectedHeader string
+	}{
+		{"rejected by upstream", rejecting, false, 401, "Bearer"},
+		{"unreachable upstream", unreachable, false, 502, ""},
+		{"no healthy upstream", rejecting, true, 503, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, proxyURL := startReverseProxy(t, ReverseProxyConfig{Upstreams: []string{tt.upstream}, StripPrefix: "/legacy"})
+			if tt.unhealthy {
+				proxy.upstreams[0].healthy.Store(false)
+			}
+
+			_, response, _, err := dialProxiedWebSocket(t, proxyURL, nil)
+			if err == nil {
+				t.Fatalf("expected the downstream handshake to fail")
+			}
+			if response.StatusCode != tt.expectedStatus {
+				t.Errorf("unexpected downstream status. expected=%d got=%d", tt.expectedStatus, response.StatusCode)
+			}
+			if value, _ := response.Header("WWW-Authenticate"); value != tt.expectedHeader {
+				t.Errorf("unexpected WWW-Authenticate header. expected=%q got=%q", tt.expectedHeader, value)
+			}
+		})
+	}
+}
+
+func startSubprotocolUpstream(t *testing.T, protocol string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				request, reader, err := http.ParseHttpRequest(conn)
+				if err != nil {
+					return
+				}
+				response, err := websocket.Handshake(request)
+				if err != nil {
+					return
+				}
+				response.Headers["Sec-WebSocket-Protocol"] = protocol
+				conn.Write(response.Serialize())
+				conn.Write(websocket.NewTextFrame(true, "selected").Serialize())
+				reader.ReadByte()
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String()
+}
+
+func TestReverseProxyWebSocketSubprotocol(t *testing.T) {
+	tests := []struct {
+		name             string
+		offered          string
+		selected         string
+		expectedStatus   int
+		expectedProtocol string
+	}{
+		{"offered protocol", "chat, superchat", "superchat", 101, "superchat"},
+		{"unoffered protocol", "chat", "superchat", 502, ""},
+		{"nothing offered", "", "chat", 502, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := startSubprotocolUpstream(t, tt.selected)
+			_, proxyURL := startReverseProxy(t, ReverseProxyConfig{Upstreams: []string{upstream}})
+
+			headers := map[string]string{}
+			if tt.offered != "" {
+				headers["Sec-WebSocket-Protocol"] = tt.offered
+			}
+			_, response, reader, _ := dialProxiedWebSocket(t, proxyURL, headers)
+
+			if response.StatusCode != tt.expectedStatus {
+				t.Fatalf("unexpected downstream status. expected=%d got=%d", tt.expectedStatus, response.StatusCode)
+			}
+			if protocol, _ := response.Header("Sec-WebSocket-Protocol"); protocol != tt.expectedProtocol {
+				t.Errorf("unexpected Sec-WebSocket-Protocol. expected=%q got=%q", tt.expectedProtocol, protocol)
+			}
+			if tt.expectedStatus != 101 {
+				return
+			}
+
+			frame, err := websocket.DeserialzeWebSocketFrame(reader)
+			if err != nil || string(frame.Payload) != "selected" {
+				t.Errorf("expected upstream frame to be relayed. got=%q, %v", frame.Payload, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/proxyproto"
+)
+
+const DEFAULT_PROXY_HEADER_TIMEOUT = 5 * time.Second
+
+type ProxyProtocolConfig struct {
+	TrustedSources     []string
+	HeaderTimeout      time.Duration
+	AllowMissingHeader bool
+}
+
+func parseTrustedSources(sources []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	for _, source := range sources {
+		if !strings.Contains(source, "/") {
+			addr, err := netip.ParseAddr(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy source %s: %w", source, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy source %s: %w", source, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func isTrustedSource(trusted []netip.Prefix, addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sl *serverListener) readProxyHeader(conn net.Conn) (net.Conn, error) {
+	if sl.config.ProxyProtocol == nil {
+		return conn, nil
+	}
+	if sl.network != "unix" && !isTrustedSource(sl.trustedProxies, conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := sl.config.ProxyProtocol.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_PROXY_HEADER_TIMEOUT
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	present, err := proxyproto.HasHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect proxy header: %w", err)
+	}
+
+	if !present && !sl.config.ProxyProtocol.AllowMissingHeader {
+		return nil, fmt.Errorf("trusted source %s sent no proxy header", conn.RemoteAddr())
+	}
+	if !present {
+		return proxyproto.NewConn(conn, reader, proxyproto.Header{}), nil
+	}
+
+	header, err := proxyproto.ReadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return proxyproto.NewConn(conn, reader, header), nil
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestIsTrustedSource(t *testing.T) {
+	trusted, err := parseTrustedSources([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("failed to parse trusted sources: %v", err)
+	}
+
+	tests := []struct {
+		addr     net.Addr
+		expected bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}, false},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, false},
+	}
+
+	for _, tt := range tests {
+		if isTrustedSource(trusted, tt.addr) != tt.expected {
+			t.Errorf("unexpected trust for %s. expected=%t", tt.addr, tt.expected)
+		}
+	}
+
+	if isTrustedSource(nil, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}) {
+		t.Errorf("expected an empty allowlist to trust nobody")
+	}
+
+	_, err = parseTrustedSources([]string{"not-an-ip"})
+	if err == nil {
+		t.Errorf("expected error for invalid source")
+	}
+}
+
+func startWhoamiServer(t *testing.T, config ListenerConfig) string {
+	router := NewRouter()
+	router.AddRoute("/whoami", func(request http.HttpRequest) (http.HttpResponse, error) {
+		return http.NewPlainTextResponse(request.RemoteAddr, 200), nil
+	})
+
+	srv := NewServerWithListeners(config)
+	srv.SetRoutes(router)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	return srv.Status().Listeners[0].Address
+}
+
+func TestProxyProtocolRemoteAddr(t *testing.T) {
+	tests := []struct {
+		allowMissing bool
+		header       string
+		expected     string
+	}{
+		{false, "PROXY TCP4 203.0.113.9 127.0.0.1 40000 80\r\n", "203.0.113.9:40000"},
+		{false, "", ""},
+		{true, "PROXY TCP4 203.0.113.9 127.0.0.1 40000 80\r\n", "203.0.113.9:40000"},
+		{true, "", "127.0.0.1:"},
+	}
+
+	for _, tt := range tests {
+		address := startWhoamiServer(t, ListenerConfig{
+			Name:    "proxied",
+			Address: "127.0.0.1:0",
+			ProxyProtocol: &ProxyProtocolConfig{
+				TrustedSources:     []string{"127.0.0.1"},
+				AllowMissingHeader: tt.allowMissing}})
+
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+
+		fmt.Fprintf(conn, "%sGET /whoami HTTP/1.1\r\nHost: localhost\r\n\r\n", tt.header)
+		response := readAll(conn)
+		conn.Close()
+
+		if tt.expected == "" {
+			if response != "" {
+				t.Errorf("expected connection without PROXY header to be dropped. got=%q", response)
+			}
+			continue
+		}
+		if !strings.Contains(response, "\r\n\r\n"+tt.expected) {
+			t.Errorf("unexpected remote address for header %q. got=%q", tt.header, response)
+		}
+	}
+}
+
+func TestProxyProtocolUnixListener(t *testing.T) {
+	address := startWhoamiServer(t, ListenerConfig{
+		Name:          "proxied",
+		Address:       UNIX_ADDRESS_PREFIX + filepath.Join(t.TempDir(), "proxied.sock"),
+		ProxyProtocol: &ProxyProtocolConfig{}})
+
+	conn, err := net.Dial("unix", strings.TrimPrefix(address, UNIX_ADDRESS_PREFIX))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "PROXY TCP4 203.0.113.9 127.0.0.1 40000 80\r\nGET /whoami HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	response := readAll(conn)
+	if !strings.Contains(response, "\r\n\r\n203.0.113.9:40000") {
+		t.Errorf("expected unix socket peer to be trusted. got=%q", response)
+	}
+}
+
+func TestProxyProtocolRequiresTrustedSources(t *testing.T) {
+	srv := NewServerWithListeners(ListenerConfig{
+		Name:          "proxied",
+		Address:       "127.0.0.1:0",
+		ProxyProtocol: &ProxyProtocolConfig{}})
+
+	err := srv.Start()
+	if err == nil {
+		srv.Stop()
+		t.Fatalf("expected PROXY protocol without trusted sources to be rejected")
+	}
+}
+
+func readAll(conn net.Conn) string {
+	var builder strings.Builder
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		builder.WriteString(line)
+		if err != nil {
+			return builder.String()
+		}
+	}
+}
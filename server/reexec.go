@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+func (server *gosocksServer) Reexec(ctx context.Context) error {
+	server.listenerMutex.Lock()
+	listeners := append([]*serverListener{}, server.listeners...)
+	server.listenerMutex.Unlock()
+
+	files := []*os.File{}
+	names := []string{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for i, listener := range listeners {
+		fileListener, ok := listener.listener.(filer)
+		if !ok {
+			return fmt.Errorf("listener %s cannot be handed over", listener.config.Name)
+		}
+
+		file, err := fileListener.File()
+		if err != nil {
+			return fmt.Errorf("failed to get file of listener %s: %w", listener.config.Name, err)
+		}
+
+		files = append(files, file)
+		name := listener.config.Name
+		if name == "" {
+			name = fmt.Sprintf("listener%d", i)
+		}
+		names = append(names, name)
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), readyWriter)
+	cmd.Env = append(childEnvironment(),
+		fmt.Sprintf("%s=%d", ENV_INHERITED_FDS, len(files)),
+		fmt.Sprintf("%s=%s", ENV_INHERITED_FDNAMES, strings.Join(names, ":")),
+		fmt.Sprintf("%s=%d", ENV_READY_FD, LISTEN_FDS_START+len(files)))
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start child process: %w", err)
+	}
+	go cmd.Wait()
+
+	server.logger.Info("started child process, waiting for readiness", "pid", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(readyReader).ReadString('\n')
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			return fmt.Errorf("child process exited before becoming ready: %w", err)
+		}
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return fmt.Errorf("child process did not become ready: %w", ctx.Err())
+	}
+
+	server.logger.Info("child process ready, draining connections", "pid", cmd.Process.Pid)
+
+	for _, listener := range listeners {
+		if unixListener, ok := listener.listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+
+	return server.Shutdown(ctx)
+}
+
+func childEnvironment() []string {
+	environment := []string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		switch name {
+		case ENV_LISTEN_PID, ENV_LISTEN_FDS, ENV_LISTEN_FDNAMES,
+			ENV_INHERITED_FDS, ENV_INHERITED_FDNAMES, ENV_READY_FD:
+			continue
+		}
+		environment = append(environment, variable)
+	}
+	return environment
+}
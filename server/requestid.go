@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+type requestIdKey struct{}
+
+func RequestID(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+func withRequestID(request http.HttpRequest) (http.HttpRequest, string) {
+	requestId, exists := request.Header(REQUEST_ID_HEADER)
+	if !exists || requestId == "" || len(requestId) > 128 {
+		requestId = generateRequestID()
+	}
+
+	ctx := context.WithValue(request.Context(), requestIdKey{}, requestId)
+	return request.WithContext(ctx), requestId
+}
+
+func generateRequestID() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const METHOD_ANY = "*"
+const REST_PARAM_SUFFIX = "..."
+
+const ROUTE_KIND_HTTP = "http"
+const ROUTE_KIND_WEBSOCKET = "websocket"
+
+type RouteInfo struct {
+	Kind         string       `json:"kind"`
+	Host         string       `json:"host,omitempty"`
+	Method       string       `json:"method"`
+	Pattern      string       `json:"pattern"`
+	Name         string       `json:"name,omitempty"`
+	Summary      string       `json:"summary,omitempty"`
+	RequestType  reflect.Type `json:"-"`
+	ResponseType reflect.Type `json:"-"`
+}
+
+type RouteOption func(*routeOptions)
+
+type routeOptions struct {
+	name         string
+	methods      []string
+	summary      string
+	requestType  reflect.Type
+	responseType reflect.Type
+	upgrader     WebSocketUpgrader
+}
+
+func WithName(name string) RouteOption {
+	return func(options *routeOptions) {
+		options.name = name
+	}
+}
+
+func WithMethods(methods ...string) RouteOption {
+	return func(options *routeOptions) {
+		for _, method := range methods {
+			options.methods = append(options.methods, strings.ToUpper(method))
+		}
+	}
+}
+
+func WithSummary(summary string) RouteOption {
+	return func(options *routeOptions) {
+		options.summary = summary
+	}
+}
+
+func WithSchemas(request any, response any) RouteOption {
+	return func(options *routeOptions) {
+		if request != nil {
+			options.requestType = reflect.TypeOf(request)
+		}
+		if response != nil {
+			options.responseType = reflect.TypeOf(response)
+		}
+	}
+}
+
+func WithUpgrader(upgrader WebSocketUpgrader) RouteOption {
+	return func(options *routeOptions) {
+		options.upgrader = upgrader
+	}
+}
+
+func withTypes(requestType reflect.Type, responseType reflect.Type) RouteOption {
+	return func(options *routeOptions) {
+		options.requestType = requestType
+		options.responseType = responseType
+	}
+}
+
+func buildRouteOptions(options []RouteOption) routeOptions {
+	config := routeOptions{}
+	for _, option := range options {
+		option(&config)
+	}
+	if len(config.methods) == 0 {
+		config.methods = []string{METHOD_ANY}
+	}
+	return config
+}
+
+func (rr *recursiveRouter) rootRouter() *recursiveRouter {
+	if rr.root != nil {
+		return rr.root
+	}
+	return rr
+}
+
+func (rr *recursiveRouter) reserveName(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	_, exists := rr.rootRouter().names[name]
+	if exists {
+		return fmt.Errorf("route name %s already registered", name)
+	}
+	return nil
+}
+
+func (rr *recursiveRouter) registerName(info RouteInfo) {
+	if info.Name == "" {
+		return
+	}
+	rr.rootRouter().names[info.Name] = info
+}
+
+func (rr *recursiveRouter) Routes() []RouteInfo {
+	rr.mutex.RLock()
+	defer rr.mutex.RUnlock()
+
+	root := rr.rootRouter()
+	routers := []*recursiveRouter{root}
+	for _, hostRouter := range root.hosts {
+		routers = append(routers, hostRouter)
+	}
+	for _, hostRouter := range root.wildcardHosts {
+		routers = append(routers, hostRouter)
+	}
+
+	routes := []RouteInfo{}
+	for _, router := range routers {
+		routes = router.httpRoot.collect(router.host, routes)
+		routes = router.websocketRoot.collect(router.host, routes)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Host != routes[j].Host {
+			return routes[i].Host < routes[j].Host
+		}
+		if routes[i].Pattern != routes[j].Pattern {
+			return routes[i].Pattern < routes[j].Pattern
+		}
+		if routes[i].Kind != routes[j].Kind {
+			return routes[i].Kind < routes[j].Kind
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+func (r *httpRoute) collect(host string, routes []RouteInfo) []RouteInfo {
+	for _, endpoint := range r.endpoints {
+		routes = append(routes, RouteInfo{
+			Kind:         ROUTE_KIND_HTTP,
+			Host:         host,
+			Method:       endpoint.method,
+			Pattern:      endpoint.pattern,
+			Name:         endpoint.name,
+			Summary:      endpoint.summary,
+			RequestType:  endpoint.requestType,
+			ResponseType: endpoint.responseType})
+	}
+
+	for _, child := range r.childRoutes {
+		routes = child.collect(host, routes)
+	}
+	if r.paramRoute != nil {
+		routes = r.paramRoute.collect(host, routes)
+	}
+
+	return routes
+}
+
+func (wsr *websocketRoute) collect(host string, routes []RouteInfo) []RouteInfo {
+	if wsr.hasEndpoint() {
+		routes = append(routes, RouteInfo{
+			Kind:    ROUTE_KIND_WEBSOCKET,
+			Host:    host,
+			Method:  "GET",
+			Pattern: wsr.pattern,
+			Name:    wsr.name})
+	}
+
+	for _, child := range wsr.childRoutes {
+		routes = child.collect(host, routes)
+	}
+	if wsr.paramRoute != nil {
+		routes = wsr.paramRoute.collect(host, routes)
+	}
+
+	return routes
+}
+
+func (rr *recursiveRouter) URL(name string, params ...string) (string, error) {
+	rr.mutex.RLock()
+	info, exists := rr.rootRouter().names[name]
+	rr.mutex.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("no route named %s", name)
+	}
+
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("route %s: params must be name/value pairs", name)
+	}
+
+	values := map[string]string{}
+	order := []string{}
+	for i := 0; i < len(params); i += 2 {
+		if _, exists := values[params[i]]; !exists {
+			order = append(order, params[i])
+		}
+		values[params[i]] = params[i+1]
+	}
+
+	segments := strings.Split(info.Pattern, "/")
+	for i, segment := range segments {
+		paramName, isParam := parseParamSegment(segment)
+		if !isParam {
+			continue
+		}
+
+		value, exists := values[paramName]
+		if !exists || (value == "" && !isRestSegment(segment)) {
+			return "", fmt.Errorf("route %s: missing path parameter %s", name, paramName)
+		}
+		if isRestSegment(segment) {
+			parts := strings.Split(value, "/")
+			for j, part := range parts {
+				parts[j] = url.PathEscape(part)
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+		delete(values, paramName)
+	}
+	path := strings.Join(segments, "/")
+
+	query := []string{}
+	for _, key := range order {
+		value, exists := values[key]
+		if exists {
+			query = append(query, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+
+	return path, nil
+}
+
+func RoutesHandler(router Router) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		var buffer bytes.Buffer
+		writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+
+		fmt.Fprintln(writer, "KIND\tHOST\tMETHOD\tPATTERN\tNAME")
+		for _, route := range router.Routes() {
+			host := route.Host
+			if host == "" {
+				host = "*"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", route.Kind, host, route.Method, route.Pattern, route.Name)
+		}
+		writer.Flush()
+
+		return http.NewPlainTextResponse(buffer.String(), 200), nil
+	}
+}
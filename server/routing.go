@@ -4,139 +4,533 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"net/url"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/brain-dev-null/gosocks/http"
 )
 
 type HttpHandler func(http.HttpRequest) (http.HttpResponse, error)
-type WebSocketHandler func(net.Conn, *bufio.Reader)
+type WebSocketHandler func(http.HttpRequest, net.Conn, *bufio.Reader)
+type WebSocketUpgrader func(http.HttpRequest) (WebSocketUpgrade, error)
+
+type WebSocketUpgrade struct {
+	Headers map[string]string
+	Handler WebSocketHandler
+	Abort   func()
+}
 
 type Router interface {
 	RouteHttpRequest(request http.HttpRequest) (HttpHandler, error)
 	RouteWebSocket(request http.HttpRequest) (WebSocketHandler, error)
-	AddRoute(path string, handler HttpHandler) error
-	AddWebSocket(path string, handler WebSocketHandler) error
+	MatchHttpRequest(request http.HttpRequest) (HttpRouteMatch, error)
+	MatchWebSocket(request http.HttpRequest) (WebSocketRouteMatch, error)
+	AddRoute(path string, handler HttpHandler, options ...RouteOption) error
+	AddWebSocket(path string, handler WebSocketHandler, options ...RouteOption) error
+	Host(pattern string) Router
+	RemoveRoute(path string, methods ...string) error
+	RemoveWebSocket(path string) error
+	Routes() []RouteInfo
+	URL(name string, params ...string) (string, error)
+}
+
+type HttpRouteMatch struct {
+	Host    string
+	Pattern string
+	Name    string
+	Handler HttpHandler
+	Params  map[string]string
+}
+
+type WebSocketRouteMatch struct {
+	Host     string
+	Pattern  string
+	Name     string
+	Handler  WebSocketHandler
+	Upgrader WebSocketUpgrader
+	Params   map[string]string
 }
 
 type recursiveRouter struct {
 	httpRoot      httpRoute
 	websocketRoot websocketRoute
+	host          string
+	root          *recursiveRouter
+	hosts         map[string]*recursiveRouter
+	wildcardHosts map[string]*recursiveRouter
+	names         map[string]RouteInfo
+	mutex         *sync.RWMutex
 }
 
 func (rr *recursiveRouter) RouteHttpRequest(request http.HttpRequest) (HttpHandler, error) {
-	segments := strings.Split(request.Path(), "/")
-	if len(segments) > 0 && segments[0] == "" {
-		segments = segments[1:]
+	match, err := rr.MatchHttpRequest(request)
+	if err != nil {
+		return nil, err
 	}
-	handler, _, matched := rr.httpRoot.match(segments)
 
-	if !matched {
-		return nil, http.ErrorNotFound(fmt.Sprintf("No HTTP route for: %s", request.Path()))
+	return match.Handler, nil
+}
+
+func (rr *recursiveRouter) RouteWebSocket(request http.HttpRequest) (WebSocketHandler, error) {
+	match, err := rr.MatchWebSocket(request)
+	if err != nil {
+		return nil, err
 	}
 
-	return handler, nil
+	return match.Handler, nil
 }
 
-func (rr *recursiveRouter) RouteWebSocket(request http.HttpRequest) (WebSocketHandler, error) {
-	segments := strings.Split(request.Path(), "/")
-	if len(segments) > 0 && segments[0] == "" {
-		segments = segments[1:]
+func (rr *recursiveRouter) MatchHttpRequest(request http.HttpRequest) (HttpRouteMatch, error) {
+	rr.mutex.RLock()
+	defer rr.mutex.RUnlock()
+
+	hostRouter := rr.hostRouter(request)
+	segments := splitPath(request.Path())
+	params := map[string]string{}
+	route, matched := hostRouter.httpRoot.match(segments, params)
+
+	if !matched {
+		return HttpRouteMatch{}, http.ErrorNotFound(fmt.Sprintf("No HTTP route for: %s", request.Path()))
+	}
+
+	endpoint, exists := route.endpoints[request.Method]
+	if !exists && request.Method == "HEAD" {
+		endpoint, exists = route.endpoints["GET"]
+	}
+	if !exists {
+		endpoint, exists = route.endpoints[METHOD_ANY]
+	}
+	if !exists {
+		httpError := http.MethodNotAllowed(fmt.Sprintf("%s not allowed for: %s", request.Method, request.Path()))
+		httpError.Headers = map[string]string{"Allow": route.allowedMethods()}
+		return HttpRouteMatch{}, httpError
 	}
-	handler, _, matched := rr.websocketRoot.match(segments)
+
+	return HttpRouteMatch{
+		Host:    hostRouter.host,
+		Pattern: endpoint.pattern,
+		Name:    endpoint.name,
+		Handler: endpoint.handler,
+		Params:  params}, nil
+}
+
+func (rr *recursiveRouter) MatchWebSocket(request http.HttpRequest) (WebSocketRouteMatch, error) {
+	rr.mutex.RLock()
+	defer rr.mutex.RUnlock()
+
+	hostRouter := rr.hostRouter(request)
+	segments := splitPath(request.Path())
+	params := map[string]string{}
+	route, matched := hostRouter.websocketRoot.match(segments, params)
 
 	if !matched {
-		return nil, http.ErrorNotFound(fmt.Sprintf("No WebSocket route for: %s", request.Path()))
+		return WebSocketRouteMatch{}, http.ErrorNotFound(fmt.Sprintf("No WebSocket route for: %s", request.Path()))
 	}
 
-	return handler, nil
+	return WebSocketRouteMatch{
+		Host:     hostRouter.host,
+		Pattern:  route.pattern,
+		Name:     route.name,
+		Handler:  route.handler,
+		Upgrader: route.upgrader,
+		Params:   params}, nil
 }
-func (rr *recursiveRouter) AddRoute(path string, handler HttpHandler) error {
-	segments := strings.Split(path, "/")
-	if len(segments) > 0 && segments[0] == "" {
-		segments = segments[1:]
+
+func (rr *recursiveRouter) AddRoute(path string, handler HttpHandler, options ...RouteOption) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	config := buildRouteOptions(options)
+	err := rr.reserveName(config.name)
+	if err != nil {
+		return err
+	}
+
+	segments := splitPath(path)
+	for i, method := range config.methods {
+		endpoint := &httpEndpoint{
+			method:       method,
+			pattern:      path,
+			name:         config.name,
+			summary:      config.summary,
+			requestType:  config.requestType,
+			responseType: config.responseType,
+			handler:      handler}
+		err := rr.httpRoot.merge(segments, endpoint)
+		if err != nil {
+			if i > 0 {
+				rr.httpRoot.remove(segments, config.methods[:i])
+			}
+			return err
+		}
 	}
-	return rr.httpRoot.merge(segments, handler)
+
+	rr.registerName(RouteInfo{
+		Kind:    ROUTE_KIND_HTTP,
+		Host:    rr.host,
+		Method:  strings.Join(config.methods, ","),
+		Pattern: path,
+		Name:    config.name})
+	return nil
 }
 
-func (rr *recursiveRouter) AddWebSocket(path string, handler WebSocketHandler) error {
+func (rr *recursiveRouter) AddWebSocket(path string, handler WebSocketHandler, options ...RouteOption) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	config := buildRouteOptions(options)
+	if handler == nil && config.upgrader == nil {
+		return fmt.Errorf("websocket route %s needs a handler or an upgrader", path)
+	}
+	err := rr.reserveName(config.name)
+	if err != nil {
+		return err
+	}
+
+	segments := splitPath(path)
+	err = rr.websocketRoot.merge(segments, path, config.name, handler, config.upgrader)
+	if err != nil {
+		return err
+	}
+
+	rr.registerName(RouteInfo{
+		Kind:    ROUTE_KIND_WEBSOCKET,
+		Host:    rr.host,
+		Method:  "GET",
+		Pattern: path,
+		Name:    config.name})
+	return nil
+}
+
+func (rr *recursiveRouter) RemoveRoute(path string, methods ...string) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	normalizedMethods := []string{}
+	for _, method := range methods {
+		normalizedMethods = append(normalizedMethods, strings.ToUpper(method))
+	}
+
+	segments := splitPath(path)
+	removed := rr.httpRoot.remove(segments, normalizedMethods)
+	if len(removed) == 0 {
+		return http.ErrorNotFound(fmt.Sprintf("No HTTP route for: %s", path))
+	}
+
+	remaining := rr.httpRoot.find(segments)
+	for _, endpoint := range removed {
+		if endpoint.name == "" {
+			continue
+		}
+
+		remainingMethods := []string{}
+		if remaining != nil {
+			for method, other := range remaining.endpoints {
+				if other.name == endpoint.name {
+					remainingMethods = append(remainingMethods, method)
+				}
+			}
+		}
+
+		if len(remainingMethods) == 0 {
+			delete(rr.rootRouter().names, endpoint.name)
+			continue
+		}
+		sort.Strings(remainingMethods)
+		info := rr.rootRouter().names[endpoint.name]
+		info.Method = strings.Join(remainingMethods, ",")
+		rr.rootRouter().names[endpoint.name] = info
+	}
+
+	return nil
+}
+
+func (rr *recursiveRouter) RemoveWebSocket(path string) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	removed := rr.websocketRoot.remove(splitPath(path))
+	if removed == nil {
+		return http.ErrorNotFound(fmt.Sprintf("No WebSocket route for: %s", path))
+	}
+
+	if removed.name != "" {
+		delete(rr.rootRouter().names, removed.name)
+	}
+	return nil
+}
+
+func (rr *recursiveRouter) Host(pattern string) Router {
+	if rr.root != nil {
+		return rr.root.Host(pattern)
+	}
+
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	pattern = normalizeHost(pattern)
+	hosts := rr.hosts
+	key := pattern
+	if strings.HasPrefix(pattern, "*.") {
+		hosts = rr.wildcardHosts
+		key = strings.TrimPrefix(pattern, "*")
+	}
+
+	hostRouter, exists := hosts[key]
+	if !exists {
+		hostRouter = newRecursiveRouter()
+		hostRouter.host = pattern
+		hostRouter.root = rr
+		hostRouter.mutex = rr.mutex
+		hosts[key] = hostRouter
+	}
+
+	return hostRouter
+}
+
+func (rr *recursiveRouter) hostRouter(request http.HttpRequest) *recursiveRouter {
+	if rr.root != nil {
+		return rr
+	}
+
+	host := request.Host
+	if host == "" {
+		host, _ = request.Header("Host")
+	}
+	host = normalizeHost(host)
+	if host == "" {
+		return rr
+	}
+
+	hostRouter, exists := rr.hosts[host]
+	if exists {
+		return hostRouter
+	}
+
+	var longestMatch *recursiveRouter
+	longestSuffix := 0
+	for suffix, wildcardRouter := range rr.wildcardHosts {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) && len(suffix) > longestSuffix {
+			longestMatch = wildcardRouter
+			longestSuffix = len(suffix)
+		}
+	}
+	if longestMatch != nil {
+		return longestMatch
+	}
+
+	return rr
+}
+
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	hostname, _, err := net.SplitHostPort(host)
+	if err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(host, ".")
+}
+
+func splitPath(path string) []string {
 	segments := strings.Split(path, "/")
 	if len(segments) > 0 && segments[0] == "" {
 		segments = segments[1:]
 	}
-	return rr.websocketRoot.merge(segments, handler)
+	return segments
+}
+
+func decodeSegment(segment string) string {
+	decoded, err := url.PathUnescape(segment)
+	if err != nil {
+		return segment
+	}
+	return decoded
+}
+
+func parseParamSegment(segment string) (string, bool) {
+	if len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return strings.TrimSuffix(segment[1:len(segment)-1], REST_PARAM_SUFFIX), true
+	}
+	return "", false
+}
+
+func isRestSegment(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, REST_PARAM_SUFFIX+"}")
 }
 
 func NewRouter() Router {
-	httpRoot := httpRoute{childRoutes: map[string]*httpRoute{}, handler: nil}
+	return newRecursiveRouter()
+}
+
+func newRecursiveRouter() *recursiveRouter {
+	httpRoot := httpRoute{childRoutes: map[string]*httpRoute{}, endpoints: map[string]*httpEndpoint{}}
 	websocketRoot := websocketRoute{childRoutes: map[string]*websocketRoute{}, handler: nil}
-	return &recursiveRouter{httpRoot: httpRoot, websocketRoot: websocketRoot}
+	return &recursiveRouter{
+		httpRoot:      httpRoot,
+		websocketRoot: websocketRoot,
+		hosts:         map[string]*recursiveRouter{},
+		wildcardHosts: map[string]*recursiveRouter{},
+		names:         map[string]RouteInfo{},
+		mutex:         &sync.RWMutex{}}
+}
+
+type httpEndpoint struct {
+	method       string
+	pattern      string
+	name         string
+	summary      string
+	requestType  reflect.Type
+	responseType reflect.Type
+	handler      HttpHandler
 }
 
 type httpRoute struct {
 	childRoutes map[string]*httpRoute
-	handler     HttpHandler
+	paramRoute  *httpRoute
+	paramName   string
+	paramRest   bool
+	endpoints   map[string]*httpEndpoint
 }
 
 type websocketRoute struct {
 	childRoutes map[string]*websocketRoute
+	paramRoute  *websocketRoute
+	paramName   string
+	paramRest   bool
 	handler     WebSocketHandler
+	upgrader    WebSocketUpgrader
+	pattern     string
+	name        string
+}
+
+func (r *httpRoute) allowedMethods() string {
+	methods := []string{}
+	for method := range r.endpoints {
+		methods = append(methods, method)
+	}
+	if r.endpoints["GET"] != nil && r.endpoints["HEAD"] == nil {
+		methods = append(methods, "HEAD")
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
 }
 
-func (r *httpRoute) merge(segments []string, handler HttpHandler) error {
+func (r *httpRoute) merge(segments []string, endpoint *httpEndpoint) error {
 	if len(segments) == 0 {
-		if r.handler != nil {
+		if r.endpoints[endpoint.method] != nil {
 			return fmt.Errorf("conflicting path!")
 		}
-		r.handler = handler
+		r.endpoints[endpoint.method] = endpoint
 		return nil
 	}
 
 	segment, remainingSegments := segments[0], segments[1:]
 
+	if paramName, isParam := parseParamSegment(segment); isParam {
+		rest := isRestSegment(segment)
+		if rest && len(remainingSegments) > 0 {
+			return fmt.Errorf("catch-all parameter %s must be the last segment", segment)
+		}
+		if r.paramRoute == nil {
+			r.paramRoute = &httpRoute{
+				childRoutes: map[string]*httpRoute{},
+				endpoints:   map[string]*httpEndpoint{},
+			}
+			r.paramName = paramName
+			r.paramRest = rest
+		} else if r.paramName != paramName || r.paramRest != rest {
+			return fmt.Errorf("conflicting path parameter: {%s} and %s", r.paramName, segment)
+		}
+		return r.paramRoute.merge(remainingSegments, endpoint)
+	}
+
 	childRoute, exists := r.childRoutes[segment]
 	if !exists {
 		childRoute = &httpRoute{
 			childRoutes: map[string]*httpRoute{},
-			handler:     nil,
+			endpoints:   map[string]*httpEndpoint{},
 		}
 		r.childRoutes[segment] = childRoute
 	}
-	err := childRoute.merge(remainingSegments, handler)
+	err := childRoute.merge(remainingSegments, endpoint)
 	return err
 }
 
-func (fpe httpRoute) match(segments []string) (HttpHandler, int, bool) {
+func (fpe *httpRoute) match(segments []string, params map[string]string) (*httpRoute, bool) {
 	if len(segments) == 0 {
-		if fpe.handler == nil {
-			return nil, -1, false
+		if len(fpe.endpoints) == 0 {
+			return nil, false
 		}
-		return fpe.handler, 1, true
+		return fpe, true
 	}
 
 	segment := segments[0]
 	remainingSegments := segments[1:]
 
 	childRoute, exists := fpe.childRoutes[segment]
+	if exists {
+		route, matched := childRoute.match(remainingSegments, params)
+		if matched {
+			return route, true
+		}
+	}
 
-	if !exists {
-		return nil, -1, false
+	if fpe.paramRoute == nil || (segment == "" && !fpe.paramRest) {
+		return nil, false
 	}
 
-	return childRoute.match(remainingSegments)
+	if fpe.paramRest {
+		if len(fpe.paramRoute.endpoints) == 0 {
+			return nil, false
+		}
+		params[fpe.paramName] = decodeSegment(strings.Join(segments, "/"))
+		return fpe.paramRoute, true
+	}
+
+	params[fpe.paramName] = decodeSegment(segment)
+	route, matched := fpe.paramRoute.match(remainingSegments, params)
+	if !matched {
+		delete(params, fpe.paramName)
+	}
+	return route, matched
 }
 
-func (wsr *websocketRoute) merge(segments []string, handler WebSocketHandler) error {
+func (wsr *websocketRoute) merge(segments []string, pattern string, name string, handler WebSocketHandler, upgrader WebSocketUpgrader) error {
 	if len(segments) == 0 {
-		if wsr.handler != nil {
+		if wsr.hasEndpoint() {
 			return fmt.Errorf("conflicting path!")
 		}
 		wsr.handler = handler
+		wsr.upgrader = upgrader
+		wsr.pattern = pattern
+		wsr.name = name
 		return nil
 	}
 
 	segment, remainingSegments := segments[0], segments[1:]
 
+	if paramName, isParam := parseParamSegment(segment); isParam {
+		rest := isRestSegment(segment)
+		if rest && len(remainingSegments) > 0 {
+			return fmt.Errorf("catch-all parameter %s must be the last segment", segment)
+		}
+		if wsr.paramRoute == nil {
+			wsr.paramRoute = &websocketRoute{
+				childRoutes: map[string]*websocketRoute{},
+				handler:     nil,
+			}
+			wsr.paramName = paramName
+			wsr.paramRest = rest
+		} else if wsr.paramName != paramName || wsr.paramRest != rest {
+			return fmt.Errorf("conflicting path parameter: {%s} and %s", wsr.paramName, segment)
+		}
+		return wsr.paramRoute.merge(remainingSegments, pattern, name, handler, upgrader)
+	}
+
 	childRoute, exists := wsr.childRoutes[segment]
 	if !exists {
 		childRoute = &websocketRoute{
@@ -145,26 +539,167 @@ func (wsr *websocketRoute) merge(segments []string, handler WebSocketHandler) er
 		}
 		wsr.childRoutes[segment] = childRoute
 	}
-	err := childRoute.merge(remainingSegments, handler)
+	err := childRoute.merge(remainingSegments, pattern, name, handler, upgrader)
 	return err
 }
 
-func (wsr websocketRoute) match(segments []string) (WebSocketHandler, int, bool) {
+func (wsr *websocketRoute) match(segments []string, params map[string]string) (*websocketRoute, bool) {
 	if len(segments) == 0 {
-		if wsr.handler == nil {
-			return nil, -1, false
+		if !wsr.hasEndpoint() {
+			return nil, false
 		}
-		return wsr.handler, 1, true
+		return wsr, true
 	}
 
 	segment := segments[0]
 	remainingSegments := segments[1:]
 
 	childRoute, exists := wsr.childRoutes[segment]
+	if exists {
+		route, matched := childRoute.match(remainingSegments, params)
+		if matched {
+			return route, true
+		}
+	}
 
+	if wsr.paramRoute == nil || (segment == "" && !wsr.paramRest) {
+		return nil, false
+	}
+
+	if wsr.paramRest {
+		if !wsr.paramRoute.hasEndpoint() {
+			return nil, false
+		}
+		params[wsr.paramName] = decodeSegment(strings.Join(segments, "/"))
+		return wsr.paramRoute, true
+	}
+
+	params[wsr.paramName] = decodeSegment(segment)
+	route, matched := wsr.paramRoute.match(remainingSegments, params)
+	if !matched {
+		delete(params, wsr.paramName)
+	}
+	return route, matched
+}
+
+func (r *httpRoute) child(segment string) (*httpRoute, bool) {
+	if paramName, isParam := parseParamSegment(segment); isParam {
+		if r.paramRoute == nil || r.paramName != paramName {
+			return nil, false
+		}
+		return r.paramRoute, true
+	}
+
+	childRoute, exists := r.childRoutes[segment]
+	return childRoute, exists
+}
+
+func (r *httpRoute) find(segments []string) *httpRoute {
+	if len(segments) == 0 {
+		return r
+	}
+
+	childRoute, exists := r.child(segments[0])
+	if !exists {
+		return nil
+	}
+	return childRoute.find(segments[1:])
+}
+
+func (r *httpRoute) remove(segments []string, methods []string) []*httpEndpoint {
+	if len(segments) == 0 {
+		removed := []*httpEndpoint{}
+		if len(methods) == 0 {
+			for method, endpoint := range r.endpoints {
+				removed = append(removed, endpoint)
+				delete(r.endpoints, method)
+			}
+			return removed
+		}
+
+		for _, method := range methods {
+			endpoint, exists := r.endpoints[method]
+			if exists {
+				removed = append(removed, endpoint)
+				delete(r.endpoints, method)
+			}
+		}
+		return removed
+	}
+
+	segment, remainingSegments := segments[0], segments[1:]
+
+	childRoute, exists := r.child(segment)
+	if !exists {
+		return nil
+	}
+
+	removed := childRoute.remove(remainingSegments, methods)
+	if childRoute.isEmpty() {
+		if childRoute == r.paramRoute {
+			r.paramRoute = nil
+			r.paramName = ""
+			r.paramRest = false
+		} else {
+			delete(r.childRoutes, segment)
+		}
+	}
+	return removed
+}
+
+func (r *httpRoute) isEmpty() bool {
+	return len(r.endpoints) == 0 && len(r.childRoutes) == 0 && r.paramRoute == nil
+}
+
+func (wsr *websocketRoute) child(segment string) (*websocketRoute, bool) {
+	if paramName, isParam := parseParamSegment(segment); isParam {
+		if wsr.paramRoute == nil || wsr.paramName != paramName {
+			return nil, false
+		}
+		return wsr.paramRoute, true
+	}
+
+	childRoute, exists := wsr.childRoutes[segment]
+	return childRoute, exists
+}
+
+func (wsr *websocketRoute) remove(segments []string) *websocketRoute {
+	if len(segments) == 0 {
+		if !wsr.hasEndpoint() {
+			return nil
+		}
+		removed := &websocketRoute{handler: wsr.handler, upgrader: wsr.upgrader, pattern: wsr.pattern, name: wsr.name}
+		wsr.handler = nil
+		wsr.upgrader = nil
+		wsr.pattern = ""
+		wsr.name = ""
+		return removed
+	}
+
+	segment, remainingSegments := segments[0], segments[1:]
+
+	childRoute, exists := wsr.child(segment)
 	if !exists {
-		return nil, -1, false
+		return nil
 	}
 
-	return childRoute.match(remainingSegments)
+	removed := childRoute.remove(remainingSegments)
+	if childRoute.isEmpty() {
+		if childRoute == wsr.paramRoute {
+			wsr.paramRoute = nil
+			wsr.paramName = ""
+			wsr.paramRest = false
+		} else {
+			delete(wsr.childRoutes, segment)
+		}
+	}
+	return removed
+}
+
+func (wsr *websocketRoute) isEmpty() bool {
+	return !wsr.hasEndpoint() && len(wsr.childRoutes) == 0 && wsr.paramRoute == nil
+}
+
+func (wsr *websocketRoute) hasEndpoint() bool {
+	return wsr.handler != nil || wsr.upgrader != nil
 }
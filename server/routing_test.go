@@ -1,6 +1,9 @@
 package server
 
 import (
+	"bufio"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/brain-dev-null/gosocks/http"
@@ -75,8 +78,439 @@ func TestComplexRouter(t *testing.T) {
 	}
 }
 
+func TestRoutingPathParams(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/rooms/{room}/messages", buildStatusCodeHandler(1))
+	router.AddRoute("/rooms/lobby/messages/{message}", buildStatusCodeHandler(2))
+	router.AddWebSocket("/ws/{topic}", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {})
+
+	err := router.AddRoute("/rooms/{id}", buildStatusCodeHandler(3))
+	if err == nil {
+		t.Errorf("expected conflicting parameter names to fail")
+	}
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		expectedParams map[string]string
+	}{
+		{"/rooms/general/messages", 1, map[string]string{"room": "general"}},
+		{"/rooms/lobby/messages", 1, map[string]string{"room": "lobby"}},
+		{"/rooms/lobby/messages/42", 2, map[string]string{"message": "42"}},
+		{"/rooms/a%20b/messages?x=1", 1, map[string]string{"room": "a b"}},
+	}
+
+	for _, tt := range tests {
+		match, err := router.MatchHttpRequest(http.HttpRequest{FullPath: tt.path})
+		if err != nil {
+			t.Errorf("routing %s failed: %v", tt.path, err)
+			continue
+		}
+
+		response, _ := match.Handler(http.HttpRequest{})
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("unexpected route for %s. expected=%d, got=%d", tt.path, tt.expectedStatus, response.StatusCode)
+		}
+
+		if len(match.Params) != len(tt.expectedParams) {
+			t.Errorf("unexpected params for %s. expected=%v, got=%v", tt.path, tt.expectedParams, match.Params)
+		}
+		for name, value := range tt.expectedParams {
+			if match.Params[name] != value {
+				t.Errorf("unexpected param %s for %s. expected=%s, got=%s", name, tt.path, value, match.Params[name])
+			}
+		}
+	}
+
+	_, err = router.MatchHttpRequest(http.HttpRequest{FullPath: "/rooms//messages"})
+	if err == nil {
+		t.Errorf("expected empty parameter segment not to match")
+	}
+
+	match, err := router.MatchWebSocket(http.HttpRequest{FullPath: "/ws/news"})
+	if err != nil || match.Params["topic"] != "news" || match.Pattern != "/ws/{topic}" {
+		t.Errorf("unexpected websocket match: %+v, %v", match, err)
+	}
+}
+
+func TestRoutingWebSocketUpgrader(t *testing.T) {
+	router := NewRouter()
+	upgrader := func(http.HttpRequest) (WebSocketUpgrade, error) { return WebSocketUpgrade{}, nil }
+
+	err := router.AddWebSocket("/ws/{topic}", nil)
+	if err == nil {
+		t.Errorf("expected websocket route without handler or upgrader to fail")
+	}
+	err = router.AddWebSocket("/ws/{topic}", nil, WithUpgrader(upgrader))
+	if err != nil {
+		t.Fatalf("failed to add upgrader route: %v", err)
+	}
+	err = router.AddWebSocket("/ws/{topic}", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {})
+	if err == nil {
+		t.Errorf("expected handler and upgrader on the same path to conflict")
+	}
+
+	match, err := router.MatchWebSocket(http.HttpRequest{FullPath: "/ws/news"})
+	if err != nil || match.Upgrader == nil || match.Params["topic"] != "news" {
+		t.Errorf("unexpected websocket match: %+v, %v", match, err)
+	}
+
+	err = router.RemoveWebSocket("/ws/{topic}")
+	if err != nil {
+		t.Errorf("failed to remove upgrader route: %v", err)
+	}
+	_, err = router.MatchWebSocket(http.HttpRequest{FullPath: "/ws/news"})
+	if err == nil {
+		t.Errorf("expected removed upgrader route not to match")
+	}
+}
+
+func TestRoutingCatchAll(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/legacy/{path...}", buildStatusCodeHandler(1), WithName("legacy"))
+	router.AddRoute("/legacy/health", buildStatusCodeHandler(2))
+	router.AddWebSocket("/legacy/ws/{path...}", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {})
+
+	err := router.AddRoute("/broken/{path...}/tail", buildStatusCodeHandler(3))
+	if err == nil {
+		t.Errorf("expected catch-all parameter before another segment to fail")
+	}
+	err = router.AddRoute("/legacy/{path}", buildStatusCodeHandler(3))
+	if err == nil {
+		t.Errorf("expected catch-all and single parameter on the same segment to conflict")
+	}
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		expectedPath   string
+	}{
+		{"/legacy/a/b/c?x=1", 1, "a/b/c"},
+		{"/legacy/", 1, ""},
+		{"/legacy/health", 2, ""},
+		{"/legacy/health/deep", 1, "health/deep"},
+	}
+
+	for _, tt := range tests {
+		match, err := router.MatchHttpRequest(http.HttpRequest{FullPath: tt.path})
+		if err != nil {
+			t.Errorf("routing %s failed: %v", tt.path, err)
+			continue
+		}
+
+		response, _ := match.Handler(http.HttpRequest{})
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("unexpected route for %s. expected=%d, got=%d", tt.path, tt.expectedStatus, response.StatusCode)
+		}
+		if match.Params["path"] != tt.expectedPath {
+			t.Errorf("unexpected catch-all value for %s. expected=%q, got=%q", tt.path, tt.expectedPath, match.Params["path"])
+		}
+	}
+
+	_, err = router.MatchHttpRequest(http.HttpRequest{FullPath: "/legacy"})
+	if err == nil {
+		t.Errorf("expected catch-all route not to match its bare prefix")
+	}
+
+	match, err := router.MatchWebSocket(http.HttpRequest{FullPath: "/legacy/ws/chat/room"})
+	if err != nil || match.Params["path"] != "chat/room" {
+		t.Errorf("unexpected websocket match: %+v, %v", match, err)
+	}
+
+	url, err := router.URL("legacy", "path", "a b/c")
+	if err != nil || url != "/legacy/a%20b/c" {
+		t.Errorf("unexpected catch-all url. got=%s, %v", url, err)
+	}
+
+	err = router.RemoveRoute("/legacy/{path...}")
+	if err != nil {
+		t.Fatalf("failed to remove catch-all route: %v", err)
+	}
+	_, err = router.MatchHttpRequest(http.HttpRequest{FullPath: "/legacy/a"})
+	if err == nil {
+		t.Errorf("expected removed catch-all route not to match")
+	}
+}
+
 func buildStatusCodeHandler(statusCode int) HttpHandler {
 	return func(hr http.HttpRequest) (http.HttpResponse, error) {
 		return http.HttpResponse{StatusCode: statusCode}, nil
 	}
 }
+
+func TestHostRouting(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/status", buildStatusCodeHandler(1))
+	router.Host("api.example.internal").AddRoute("/status", buildStatusCodeHandler(2))
+	router.Host("*.example.internal").AddRoute("/status", buildStatusCodeHandler(3))
+	router.Host("*.eu.example.internal").AddRoute("/status", buildStatusCodeHandler(4))
+	router.Host("ws.example.internal").AddWebSocket("/ws", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {})
+
+	tests := []struct {
+		host           string
+		expectedStatus int
+	}{
+		{"", 1},
+		{"localhost:8080", 1},
+		{"api.example.internal", 2},
+		{"API.example.internal:8443", 2},
+		{"other.example.internal", 3},
+		{"a.b.example.internal", 3},
+		{"node1.eu.example.internal", 4},
+		{"example.internal", 1},
+	}
+
+	for _, tt := range tests {
+		request := http.HttpRequest{FullPath: "/status", Headers: map[string]string{"Host": tt.host}}
+		match, err := router.MatchHttpRequest(request)
+		if err != nil {
+			t.Errorf("routing for host %q failed: %v", tt.host, err)
+			continue
+		}
+
+		response, _ := match.Handler(request)
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("unexpected route for host %q. expected=%d, got=%d", tt.host, tt.expectedStatus, response.StatusCode)
+		}
+	}
+
+	_, err := router.MatchWebSocket(http.HttpRequest{FullPath: "/ws", Headers: map[string]string{"Host": "ws.example.internal"}})
+	if err != nil {
+		t.Errorf("expected websocket route on host: %v", err)
+	}
+
+	_, err = router.MatchWebSocket(http.HttpRequest{FullPath: "/ws", Headers: map[string]string{"Host": "api.example.internal"}})
+	if err == nil {
+		t.Errorf("expected websocket route to be scoped to its host")
+	}
+
+	forwarded := http.HttpRequest{FullPath: "/status", Host: "api.example.internal", Headers: map[string]string{"Host": "10.0.0.1"}}
+	match, _ := router.MatchHttpRequest(forwarded)
+	if match.Host != "api.example.internal" {
+		t.Errorf("expected resolved host to take precedence. got=%q", match.Host)
+	}
+}
+
+func TestRoutingMethods(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/items", buildStatusCodeHandler(1), WithMethods("GET"))
+	router.AddRoute("/items", buildStatusCodeHandler(2), WithMethods("post"))
+	router.AddRoute("/any", buildStatusCodeHandler(3))
+
+	err := router.AddRoute("/items", buildStatusCodeHandler(4), WithMethods("GET"))
+	if err == nil {
+		t.Errorf("expected duplicate method registration to fail")
+	}
+
+	tests := []struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"GET", "/items", 1},
+		{"POST", "/items", 2},
+		{"DELETE", "/any", 3},
+		{"HEAD", "/items", 1},
+	}
+
+	for _, tt := range tests {
+		request := http.HttpRequest{Method: tt.method, FullPath: tt.path}
+		handler, err := router.RouteHttpRequest(request)
+		if err != nil {
+			t.Errorf("routing %s %s failed: %v", tt.method, tt.path, err)
+			continue
+		}
+		response, _ := handler(request)
+		if response.StatusCode != tt.expectedStatus {
+			t.Errorf("unexpected handler for %s %s. expected=%d, got=%d", tt.method, tt.path, tt.expectedStatus, response.StatusCode)
+		}
+	}
+
+	_, err = router.RouteHttpRequest(http.HttpRequest{Method: "DELETE", FullPath: "/items"})
+	httpError, ok := err.(http.HttpError)
+	if !ok || httpError.StatusCode != 405 {
+		t.Errorf("expected 405 for unregistered method. got=%v", err)
+	}
+	if allow, _ := httpError.ToResponse().Header("Allow"); allow != "GET, HEAD, POST" {
+		t.Errorf("unexpected Allow header. got=%q", allow)
+	}
+}
+
+func TestRoutesAndURL(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/rooms/{room}/messages", buildStatusCodeHandler(1), WithMethods("GET", "POST"), WithName("messages"))
+	router.AddRoute("/health", buildStatusCodeHandler(1))
+	router.Host("api.example.internal").AddRoute("/users/{id}", buildStatusCodeHandler(1), WithName("user"))
+	router.AddWebSocket("/ws/{topic}", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {}, WithName("topic"))
+
+	err := router.AddRoute("/other", buildStatusCodeHandler(1), WithName("messages"))
+	if err == nil {
+		t.Errorf("expected duplicate route name to fail")
+	}
+
+	expected := []RouteInfo{
+		{Kind: ROUTE_KIND_HTTP, Method: "*", Pattern: "/health"},
+		{Kind: ROUTE_KIND_HTTP, Method: "GET", Pattern: "/rooms/{room}/messages", Name: "messages"},
+		{Kind: ROUTE_KIND_HTTP, Method: "POST", Pattern: "/rooms/{room}/messages", Name: "messages"},
+		{Kind: ROUTE_KIND_WEBSOCKET, Method: "GET", Pattern: "/ws/{topic}", Name: "topic"},
+		{Kind: ROUTE_KIND_HTTP, Host: "api.example.internal", Method: "*", Pattern: "/users/{id}", Name: "user"},
+	}
+
+	routes := router.Routes()
+	if len(routes) != len(expected) {
+		t.Fatalf("unexpected number of routes. expected=%d, got=%d: %+v", len(expected), len(routes), routes)
+	}
+	for i := range expected {
+		if routes[i] != expected[i] {
+			t.Errorf("unexpected route %d. expected=%+v, got=%+v", i, expected[i], routes[i])
+		}
+	}
+
+	urlTests := []struct {
+		name     string
+		params   []string
+		expected string
+	}{
+		{"messages", []string{"room", "a b"}, "/rooms/a%20b/messages"},
+		{"messages", []string{"room", "general", "limit", "10"}, "/rooms/general/messages?limit=10"},
+		{"user", []string{"id", "42"}, "/users/42"},
+		{"topic", []string{"topic", "news"}, "/ws/news"},
+	}
+
+	for _, tt := range urlTests {
+		path, err := router.URL(tt.name, tt.params...)
+		if err != nil || path != tt.expected {
+			t.Errorf("unexpected url for %s. expected=%s, got=%s (%v)", tt.name, tt.expected, path, err)
+		}
+	}
+
+	_, err = router.URL("messages")
+	if err == nil {
+		t.Errorf("expected missing parameter error")
+	}
+
+	_, err = router.URL("unknown")
+	if err == nil {
+		t.Errorf("expected unknown route error")
+	}
+}
+
+func TestRemoveRoutes(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("/rooms/{room}/messages", buildStatusCodeHandler(1), WithMethods("GET", "POST"), WithName("messages"))
+	router.AddRoute("/rooms", buildStatusCodeHandler(2))
+	router.AddWebSocket("/ws/{topic}", func(hr http.HttpRequest, conn net.Conn, reader *bufio.Reader) {}, WithName("topic"))
+
+	err := router.RemoveRoute("/rooms/{room}/messages", "post")
+	if err != nil {
+		t.Fatalf("failed to remove POST route: %v", err)
+	}
+
+	_, err = router.RouteHttpRequest(http.HttpRequest{Method: "POST", FullPath: "/rooms/a/messages"})
+	if httpError, ok := err.(http.HttpError); !ok || httpError.StatusCode != 405 {
+		t.Errorf("expected removed method to be rejected with 405. got=%v", err)
+	}
+
+	_, err = router.URL("messages", "room", "a")
+	if err != nil {
+		t.Errorf("expected name to survive while GET remains: %v", err)
+	}
+
+	err = router.RemoveRoute("/rooms/{room}/messages")
+	if err != nil {
+		t.Fatalf("failed to remove route: %v", err)
+	}
+
+	_, err = router.URL("messages", "room", "a")
+	if err == nil {
+		t.Errorf("expected name to be released after removal")
+	}
+
+	root := router.(*recursiveRouter)
+	if root.httpRoot.childRoutes["rooms"].paramRoute != nil {
+		t.Errorf("expected parameter branch to be pruned")
+	}
+
+	_, err = router.RouteHttpRequest(http.HttpRequest{Method: "GET", FullPath: "/rooms"})
+	if err != nil {
+		t.Errorf("expected sibling route to remain: %v", err)
+	}
+
+	err = router.RemoveRoute("/rooms")
+	if err != nil || len(root.httpRoot.childRoutes) != 0 {
+		t.Errorf("expected tree to be pruned to the root. err=%v children=%d", err, len(root.httpRoot.childRoutes))
+	}
+
+	err = router.RemoveRoute("/rooms")
+	if err == nil {
+		t.Errorf("expected removing a missing route to fail")
+	}
+
+	err = router.RemoveWebSocket("/ws/{topic}")
+	if err != nil || len(root.websocketRoot.childRoutes) != 0 {
+		t.Errorf("expected websocket route to be removed and pruned. err=%v", err)
+	}
+
+	if len(router.Routes()) != 0 {
+		t.Errorf("expected empty route table. got=%+v", router.Routes())
+	}
+}
+
+func TestReplaceRoutesWhileServing(t *testing.T) {
+	first := NewRouter()
+	first.AddRoute("/version", func(request http.HttpRequest) (http.HttpResponse, error) {
+		return http.NewPlainTextResponse("1", 200), nil
+	})
+	second := NewRouter()
+	second.AddRoute("/version", func(request http.HttpRequest) (http.HttpResponse, error) {
+		return http.NewPlainTextResponse("2", 200), nil
+	})
+
+	srv := NewServerWithListeners(ListenerConfig{Name: "data", Address: "127.0.0.1:0"})
+	srv.SetRoutes(first)
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	address := srv.Status().Listeners[0].Address
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if i%2 == 0 {
+				srv.SetRoutes(second)
+			} else {
+				srv.SetRoutes(first)
+			}
+			first.AddRoute("/dynamic", buildStatusCodeHandler(200))
+			first.RemoveRoute("/dynamic")
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		statusLine, err := doRawRequest("tcp", address, "/version")
+		if err != nil || !strings.HasPrefix(statusLine, "HTTP/1.1 200") {
+			t.Errorf("request during swap failed: %q %v", statusLine, err)
+		}
+	}
+	<-done
+
+	srv.SetRoutes(second)
+	err = srv.SetListenerRoutes("data", first)
+	if err != nil {
+		t.Fatalf("failed to set listener routes: %v", err)
+	}
+
+	conn, _ := net.Dial("tcp", address)
+	defer conn.Close()
+	conn.Write([]byte("GET /version HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if response := readAll(conn); !strings.HasSuffix(response, "1") {
+		t.Errorf("expected listener router to take precedence. got=%q", response)
+	}
+
+	if srv.SetListenerRoutes("missing", first) == nil {
+		t.Errorf("expected unknown listener to fail")
+	}
+}
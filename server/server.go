@@ -2,12 +2,23 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/http2"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/tracing"
 	"github.com/brain-dev-null/gosocks/websocket"
 )
 
@@ -15,90 +26,440 @@ type Server interface {
 	Start() error
 	Stop()
 	SetRoutes(router Router)
+	SetLogger(logger *slog.Logger)
+	SetMetrics(registry *metrics.Registry)
+	SetTracer(tracer *tracing.Tracer)
+	Shutdown(ctx context.Context) error
+	SetShutdownDelay(delay time.Duration)
+	AddReadinessCheck(name string, check HealthCheck)
+	CheckReadiness(ctx context.Context) (bool, map[string]string)
+	Status() ServerStatus
+	SetConnectionLimits(limits ConnectionLimits)
+	AddListener(config ListenerConfig)
+	Reexec(ctx context.Context) error
+	SetTrustedProxies(sources ...string) error
+	SetAllowedOrigins(origins ...string)
+	AddUpgradeHook(hook UpgradeHook)
+	SetListenerRoutes(name string, router Router) error
+	SetConnectTunnel(config ConnectConfig) error
+	SetSocksProxy(config SocksConfig) error
+	SetHttp2(config http2.Config) error
 }
 
+const ROUTE_UNMATCHED = "unmatched"
+
 type gosocksServer struct {
-	port       int
-	httpRouter Router
-	running    bool
+	listenerConfigs   []ListenerConfig
+	httpRouter        atomic.Pointer[routerRef]
+	running           atomic.Bool
+	shuttingDown      atomic.Bool
+	shutdownDelay     time.Duration
+	listenerMutex     sync.Mutex
+	listeners         []*serverListener
+	activeConnections atomic.Int64
+	activeWebSockets  atomic.Int64
+	activeTunnels     atomic.Int64
+	checksMutex       sync.Mutex
+	readinessChecks   []namedHealthCheck
+	limits            ConnectionLimits
+	connectionSlots   semaphore
+	httpSlots         semaphore
+	rejectSlots       semaphore
+	websocketSlots    semaphore
+	workers           *workerPool
+	logger            *slog.Logger
+	metrics           *metrics.ServerMetrics
+	tracer            *tracing.Tracer
+	trustedProxies    []netip.Prefix
+	originCheck       bool
+	allowedOrigins    []string
+	upgradeHooks      []UpgradeHook
+	connect           *connectTunnel
+	socks             *socksProxy
+	http2             *http2.Config
+	ctx               context.Context
+	cancel            context.CancelFunc
+	drainCtx          context.Context
+	drain             context.CancelFunc
 }
 
 func NewServer(port int) Server {
+	return NewServerWithListeners(ListenerConfig{
+		Name:    "default",
+		Address: fmt.Sprintf(":%d", port)})
+}
+
+func NewServerWithListeners(configs ...ListenerConfig) Server {
 	return &gosocksServer{
-		port:       port,
-		httpRouter: nil,
-		running:    false}
+		listenerConfigs: configs,
+		listeners:       []*serverListener{},
+		readinessChecks: []namedHealthCheck{},
+		logger:          slog.Default(),
+		metrics:         &metrics.ServerMetrics{}}
+}
+
+func (server *gosocksServer) AddListener(config ListenerConfig) {
+	server.listenerConfigs = append(server.listenerConfigs, config)
 }
 
 func (server *gosocksServer) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", server.port))
-	if err != nil {
-		return fmt.Errorf("Error creating listener: %w", err)
+	listeners := []*serverListener{}
+	for _, config := range server.listenerConfigs {
+		if server.http2 != nil && config.TLSConfig != nil {
+			config.TLSConfig = withHttp2Protocols(config.TLSConfig)
+		}
+
+		err := validateListenerProtocol(config, server.socks != nil)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.listener.Close()
+			}
+			return fmt.Errorf("Error creating listener: %w", err)
+		}
+
+		listener, err := listen(config)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.listener.Close()
+			}
+			return fmt.Errorf("Error creating listener: %w", err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	server.drainCtx, server.drain = context.WithCancel(server.ctx)
+	server.workers = newWorkerPool(server.ctx, server.limits.Workers, server.limits.WorkerQueueSize)
+	server.listenerMutex.Lock()
+	server.listeners = listeners
+	server.listenerMutex.Unlock()
+	server.shuttingDown.Store(false)
+	server.running.Store(true)
+
+	for _, listener := range listeners {
+		go server.runLoop(listener)
+		server.logger.Info("server started",
+			"listener", listener.config.Name,
+			"network", listener.network,
+			"address", listener.listener.Addr().String(),
+			"inherited", listener.inherited,
+			"tls", listener.config.TLSConfig != nil)
 	}
-	server.running = true
-	go server.runLoop(listener)
-	log.Printf("Server started. Listening on Port %d", server.port)
+
+	notifyParentReady()
 	return nil
 }
 
 func (server *gosocksServer) Stop() {
-	server.running = false
+	server.running.Store(false)
+	server.closeListeners()
+	if server.cancel != nil {
+		server.cancel()
+	}
+}
+
+func (server *gosocksServer) Shutdown(ctx context.Context) error {
+	server.shuttingDown.Store(true)
+	server.logger.Info("server shutting down",
+		"active_connections", server.activeConnections.Load())
+
+	select {
+	case <-time.After(server.shutdownDelay):
+	case <-ctx.Done():
+	}
+
+	server.running.Store(false)
+	server.closeListeners()
+	if server.drain != nil {
+		server.drain()
+	}
+
+	err := server.waitForConnections(ctx, func() bool {
+		return server.activeConnections.Load() <= server.activeWebSockets.Load()+server.activeTunnels.Load()
+	})
+
+	if server.cancel != nil {
+		server.cancel()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return server.waitForConnections(ctx, func() bool {
+		return server.activeConnections.Load() == 0
+	})
+}
+
+func (server *gosocksServer) SetShutdownDelay(delay time.Duration) {
+	server.shutdownDelay = delay
+}
+
+func (server *gosocksServer) closeListeners() {
+	server.listenerMutex.Lock()
+	defer server.listenerMutex.Unlock()
+
+	for _, listener := range server.listeners {
+		listener.listener.Close()
+	}
+	server.listeners = []*serverListener{}
+}
+
+func (server *gosocksServer) waitForConnections(ctx context.Context, drained func() bool) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !drained() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
 }
 
 func (server *gosocksServer) SetRoutes(router Router) {
-	server.httpRouter = router
+	server.httpRouter.Store(&routerRef{router: router})
+}
+
+func (server *gosocksServer) SetListenerRoutes(name string, router Router) error {
+	server.listenerMutex.Lock()
+	defer server.listenerMutex.Unlock()
+
+	found := false
+	for i := range server.listenerConfigs {
+		if server.listenerConfigs[i].Name == name {
+			server.listenerConfigs[i].Router = router
+			found = true
+		}
+	}
+
+	for _, listener := range server.listeners {
+		if listener.config.Name == name {
+			listener.routes.Store(&routerRef{router: router})
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("no listener named %s", name)
+	}
+	return nil
+}
+
+func (server *gosocksServer) routes() Router {
+	routes := server.httpRouter.Load()
+	if routes == nil {
+		return nil
+	}
+	return routes.router
 }
 
-func (server *gosocksServer) runLoop(listener net.Listener) {
+func (server *gosocksServer) SetLogger(logger *slog.Logger) {
+	server.logger = logger
+}
+
+func (server *gosocksServer) SetMetrics(registry *metrics.Registry) {
+	server.metrics = metrics.NewServerMetrics(registry)
+}
+
+func (server *gosocksServer) SetAllowedOrigins(origins ...string) {
+	server.originCheck = true
+	server.allowedOrigins = origins
+}
+
+func (server *gosocksServer) SetTracer(tracer *tracing.Tracer) {
+	server.tracer = tracer
+}
+
+func (server *gosocksServer) runLoop(listener *serverListener) {
+	var backoff time.Duration
+
 	for {
-		if !server.running {
+		if !server.running.Load() {
 			break
 		}
-		conn, err := listener.Accept()
+		conn, err := listener.listener.Accept()
 		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
+			if !server.running.Load() || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			backoff = nextAcceptBackoff(backoff)
+			server.logger.Error("failed to accept connection",
+				"error", err,
+				"retry_in", backoff)
+			time.Sleep(backoff)
+			continue
 		}
+		backoff = 0
 
-		go server.handleConnection(conn)
+		server.acceptConnection(conn, listener)
 	}
 }
 
-func (sever *gosocksServer) handleConnection(conn net.Conn) {
+func (sever *gosocksServer) handleConnection(conn net.Conn, listener *serverListener) {
+	defer func() { conn.Close() }()
+	sever.activeConnections.Add(1)
+	defer sever.activeConnections.Add(-1)
+	sever.metrics.ActiveConnections.Inc()
+	defer sever.metrics.ActiveConnections.Dec()
+
+	router := listener.router(sever.routes())
+
+	peerAddr := conn.RemoteAddr()
+	proxiedConn, err := listener.readProxyHeader(conn)
+	if err != nil {
+		sever.logger.Warn("failed to read proxy protocol header",
+			"peer_addr", peerAddr.String(),
+			"error", err)
+		return
+	}
+	conn = proxiedConn
+
+	conn, tlsState, err := listener.handshakeTLS(conn)
+	if err != nil {
+		sever.logger.Warn("tls handshake failed",
+			"remote_addr", conn.RemoteAddr().String(),
+			"error", err)
+		return
+	}
+
+	if tlsState != nil && tlsState.NegotiatedProtocol == http2.ALPN_PROTOCOL {
+		sever.serveHttp2(conn, nil, router, tlsState, nil)
+		return
+	}
+
+	conn, isSocks := sever.sniffSocks(conn, listener)
+	if isSocks {
+		sever.handleSocks(conn)
+		return
+	}
+
+	conn, isHttp2 := sever.sniffHttp2(conn)
+	if isHttp2 {
+		sever.serveHttp2(conn, nil, router, tlsState, nil)
+		return
+	}
+
 	start := time.Now()
 	request, reader, err := http.ParseHttpRequest(conn)
 	if err != nil {
-		log.Printf("failed to parse http request: %v", err)
+		sever.logger.Warn("failed to parse http request",
+			"remote_addr", conn.RemoteAddr().String(),
+			"error", err)
 		response := http.BadRequest("").ToResponse().Serialize()
 		conn.Write(response)
-		conn.Close()
+		return
+	}
+
+	if sever.http2 != nil && tlsState == nil && http2.IsUpgradeRequest(request) {
+		sever.upgradeHttp2(conn, reader, router, request)
+		return
+	}
+
+	request, span, valid := sever.startRequest(request.WithContext(sever.ctx), conn, tlsState)
+	if !valid {
+		conn.Write(http.BadRequest("").ToResponse().Serialize())
+		return
+	}
+
+	if request.Method == "CONNECT" {
+		sever.handleConnect(request, span, conn, reader, start)
+		return
 	}
 
 	if isWebSocketUpgradeRequest(request) {
-		sever.handleWebsocket(request, conn, reader, start)
+		sever.handleWebsocket(router, request, span, conn, reader, start)
 		return
 	}
 
-	response, err := sever.handleRequest(request)
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	go watchDisconnect(reader, cancel)
+	request = request.WithContext(ctx)
+
+	response := sever.serveRequest(router, request, span, start)
+	setResponseHeader(&response, "Connection", "close")
+
+	serializedResponse := response.Serialize()
+	if request.Method == "HEAD" {
+		serializedResponse = serializedResponse[:len(serializedResponse)-len(response.Content)]
+	}
+
+	conn.Write(serializedResponse)
+}
+
+func (server *gosocksServer) startRequest(request http.HttpRequest, conn net.Conn, tlsState *tls.ConnectionState) (http.HttpRequest, *tracing.Span, bool) {
+	request.RemoteAddr = conn.RemoteAddr().String()
+	request.TLS = tlsState
+	request = resolveClient(request, server.trustedProxies)
+
+	if _, exists := request.Header("Host"); !exists && request.Protocol == "HTTP/1.1" {
+		server.logger.Warn("rejecting request without host header",
+			"remote_addr", request.RemoteAddr,
+			"path", request.FullPath)
+		return request, nil, false
+	}
+	request, requestId := withRequestID(request)
+
+	ctx, span := server.startRequestSpan(request)
+	span.SetAttribute("request_id", requestId)
+
+	logger := server.logger.With("request_id", requestId)
+	if span != nil {
+		logger = logger.With("trace_id", span.SpanContext().TraceID.String())
+	}
+
+	ctx = logging.WithLogger(ctx, logger)
+	ctx = metrics.WithMetrics(ctx, server.metrics)
+	return request.WithContext(ctx), span, true
+}
+
+func (server *gosocksServer) serveRequest(router Router, request http.HttpRequest, span *tracing.Span, start time.Time) http.HttpResponse {
+	logger := logging.FromContext(request.Context())
+	response, route, err := server.processRequest(router, request)
 
 	duration := time.Now().Sub(start)
 
 	if err != nil {
+		var panicErr handlerPanic
+		if errors.As(err, &panicErr) {
+			server.metrics.HandlerPanics.Inc("http")
+		}
+
 		if httpError, ok := err.(http.HttpError); ok {
-			log.Printf("Error: %s", httpError.Message)
+			logger.Warn("request failed",
+				"status", httpError.StatusCode,
+				"error", httpError.Message)
 			response = httpError.ToResponse()
+		} else if problem, ok := err.(http.Problem); ok {
+			logger.Warn("request failed",
+				"status", problem.Status,
+				"error", problem.Detail)
+			response = problem.ToResponse()
 		} else {
-			log.Printf("Error: %v", err)
+			logger.Error("request handler failed", "error", err)
 			response = http.InternalServerError("").ToResponse()
 		}
 	}
 
-	accessLog(request, response, duration)
+	setResponseHeader(&response, REQUEST_ID_HEADER, RequestID(request.Context()))
+	endRequestSpan(span, route, response)
+	accessLog(logger, request, response, duration)
+	server.metrics.HttpRequests.Inc(route, request.Method, strconv.Itoa(response.StatusCode))
+	server.metrics.HttpRequestDuration.Observe(duration.Seconds(), route, request.Method)
 
-	serializedResponse := response.Serialize()
-
-	conn.Write(serializedResponse)
+	return response
+}
 
-	conn.Close()
+func watchDisconnect(reader *bufio.Reader, cancel context.CancelFunc) {
+	_, err := reader.Peek(1)
+	if err != nil {
+		cancel()
+	}
 }
 
 func isWebSocketUpgradeRequest(request http.HttpRequest) bool {
@@ -127,18 +488,56 @@ func isWebSocketUpgradeRequest(request http.HttpRequest) bool {
 	return true
 }
 
-func (server *gosocksServer) handleRequest(request http.HttpRequest) (http.HttpResponse, error) {
-	handle, err := server.httpRouter.RouteHttpRequest(request)
+func (server *gosocksServer) handleRequest(router Router, request http.HttpRequest) (http.HttpResponse, string, error) {
+	_, routeSpan := tracing.StartSpan(request.Context(), "route")
+	match, err := router.MatchHttpRequest(request)
+	routeSpan.SetAttribute("http.route", match.Pattern)
+	routeSpan.End()
+
 	if err != nil {
-		return http.HttpResponse{}, err
+		return http.HttpResponse{}, ROUTE_UNMATCHED, err
 	}
 
-	response, err := handle(request)
+	request.PathParams = match.Params
+	ctx, handlerSpan := tracing.StartSpan(request.Context(), "handler")
+	handlerSpan.SetAttribute("http.route", match.Pattern)
+	response, err := callHandler(match.Handler, request.WithContext(ctx))
+	handlerSpan.SetError(err)
+	handlerSpan.End()
+
 	if err != nil {
-		return http.HttpResponse{}, err
+		return http.HttpResponse{}, match.Pattern, err
+	}
+
+	return response, match.Pattern, nil
+}
+
+func (server *gosocksServer) startRequestSpan(request http.HttpRequest) (context.Context, *tracing.Span) {
+	ctx := tracing.WithTracer(request.Context(), server.tracer)
+	if parent, ok := tracing.Extract(request); ok {
+		ctx = tracing.WithRemoteSpanContext(ctx, parent)
+	}
+
+	ctx, span := tracing.StartSpan(ctx, "HTTP "+request.Method)
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.target", request.Path())
+	return ctx, span
+}
+
+func endRequestSpan(span *tracing.Span, route string, response http.HttpResponse) {
+	span.SetAttribute("http.route", route)
+	span.SetAttribute("http.status_code", response.StatusCode)
+	if response.StatusCode >= 500 {
+		span.SetStatus(tracing.STATUS_ERROR)
 	}
+	span.End()
+}
 
-	return response, nil
+func setResponseHeader(response *http.HttpResponse, name string, value string) {
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	response.Headers[name] = value
 }
 
 func postProcessResponse(response *http.HttpResponse) {
@@ -146,40 +545,142 @@ func postProcessResponse(response *http.HttpResponse) {
 	response.Headers["Content-Length"] = fmt.Sprintf("%d", contentLength)
 }
 
-func (server *gosocksServer) handleWebsocket(initialRequest http.HttpRequest, conn net.Conn, reader *bufio.Reader, start time.Time) {
-	handle, err := server.httpRouter.RouteWebSocket(initialRequest)
+func (server *gosocksServer) handleWebsocket(router Router, initialRequest http.HttpRequest, span *tracing.Span, conn net.Conn, reader *bufio.Reader, start time.Time) {
+	respond := func(response http.HttpResponse) error {
+		_, err := conn.Write(response.Serialize())
+		return err
+	}
+	server.serveWebsocket(router, initialRequest, span, conn, reader, start, websocket.Handshake, respond)
+}
+
+func (server *gosocksServer) serveWebsocket(router Router, initialRequest http.HttpRequest, span *tracing.Span, conn net.Conn, reader *bufio.Reader, start time.Time, handshake func(http.HttpRequest) (http.HttpResponse, error), respond func(http.HttpResponse) error) {
+	logger := logging.FromContext(initialRequest.Context())
+
+	if !server.websocketSlots.tryAcquire() {
+		server.metrics.ConnectionsRejected.Inc(REJECT_REASON_WEBSOCKET)
+		logger.Warn("websocket limit reached, rejecting upgrade")
+		response := http.ServiceUnavailable("").ToResponse()
+		endRequestSpan(span, ROUTE_REJECTED, response)
+		respond(response)
+		return
+	}
+	defer server.websocketSlots.release()
+
+	match, err := router.MatchWebSocket(initialRequest)
 	if err != nil {
-		log.Printf("not found: %s", initialRequest.Path())
+		logger.Warn("no websocket route", "path", initialRequest.Path())
+		response := http.ErrorNotFound("").ToResponse()
+		endRequestSpan(span, ROUTE_UNMATCHED, response)
+		respond(response)
 		return
 	}
+	handle := match.Handler
+	initialRequest.PathParams = match.Params
 
-	handhakeResponse, err := websocket.Handshake(initialRequest)
+	if server.originCheck {
+		err = websocket.CheckOrigin(initialRequest, server.allowedOrigins)
+		if err != nil {
+			logger.Warn("websocket origin rejected", "error", err)
+			response := http.Forbidden(err.Error()).ToResponse()
+			endRequestSpan(span, match.Pattern, response)
+			respond(response)
+			return
+		}
+	}
+
+	initialRequest, err = server.runUpgradeHooks(initialRequest)
+	if err != nil {
+		httpError := err.(http.HttpError)
+		logger.Warn("websocket upgrade rejected",
+			"status", httpError.StatusCode,
+			"error", httpError.Message)
+		response := httpError.ToResponse()
+		endRequestSpan(span, match.Pattern, response)
+		accessLog(logger, initialRequest, response, time.Now().Sub(start))
+		respond(response)
+		return
+	}
+
+	handhakeResponse, err := handshake(initialRequest)
 	if err != nil {
-		log.Printf("handshake error: %v", err)
-		response := http.BadRequest(err.Error())
-		conn.Write(response.ToResponse().Serialize())
+		logger.Warn("websocket handshake failed", "error", err)
+		response := http.BadRequest(err.Error()).ToResponse()
+		endRequestSpan(span, match.Pattern, response)
+		respond(response)
 		return
 	}
-	_, err = conn.Write(handhakeResponse.Serialize())
+
+	abort := func() {}
+	if match.Upgrader != nil {
+		upgrade, err := match.Upgrader(initialRequest)
+		if err != nil {
+			httpError, ok := err.(http.HttpError)
+			if !ok {
+				httpError = http.BadGateway(err.Error())
+			}
+			logger.Warn("websocket upgrade failed",
+				"status", httpError.StatusCode,
+				"error", httpError.Message)
+			response := httpError.ToResponse()
+			endRequestSpan(span, match.Pattern, response)
+			accessLog(logger, initialRequest, response, time.Now().Sub(start))
+			respond(response)
+			return
+		}
+
+		for headerName, headerValue := range upgrade.Headers {
+			setResponseHeader(&handhakeResponse, headerName, headerValue)
+		}
+		if upgrade.Handler != nil {
+			handle = upgrade.Handler
+		}
+		if upgrade.Abort != nil {
+			abort = upgrade.Abort
+		}
+	}
+
+	setResponseHeader(&handhakeResponse, REQUEST_ID_HEADER, RequestID(initialRequest.Context()))
+	err = respond(handhakeResponse)
 
 	duration := time.Now().Sub(start)
 
 	if err != nil {
-		log.Printf("error sending handshake response: %v", err)
-		conn.Close()
+		logger.Error("failed to send handshake response", "error", err)
+		span.SetError(err)
+		span.End()
+		abort()
 		return
 	}
 
-	accessLog(initialRequest, handhakeResponse, duration)
+	endRequestSpan(span, match.Pattern, handhakeResponse)
+	accessLog(logger, initialRequest, handhakeResponse, duration)
+	server.metrics.WsOpened.Inc()
+	server.activeWebSockets.Add(1)
+	defer server.activeWebSockets.Add(-1)
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			server.metrics.HandlerPanics.Inc("websocket")
+			logger.Error("websocket handler panicked", "panic", fmt.Sprint(recovered))
+		}
+	}()
 
-	go handle(conn, reader)
+	handle(initialRequest, conn, reader)
 }
 
-func accessLog(request http.HttpRequest, response http.HttpResponse, duration time.Duration) {
-	log.Printf(
-		"%s %s %s %d\n",
-		request.Method,
-		request.FullPath,
-		http.GetStatus(response.StatusCode),
-		duration.Microseconds())
+func accessLog(logger *slog.Logger, request http.HttpRequest, response http.HttpResponse, duration time.Duration) {
+	userAgent, _ := request.Header("User-Agent")
+	referer, _ := request.Header("Referer")
+
+	logger.Info(logging.ACCESS_LOG_MESSAGE,
+		"remote_addr", request.ClientIP,
+		"peer_addr", request.RemoteAddr,
+		"method", request.Method,
+		"path", request.FullPath,
+		"protocol", request.Protocol,
+		"status", response.StatusCode,
+		"bytes", len(response.Content),
+		"duration_us", duration.Microseconds(),
+		"user_agent", userAgent,
+		"referer", referer)
 }
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/socks"
+)
+
+const LISTENER_PROTOCOL_AUTO = ""
+const LISTENER_PROTOCOL_HTTP = "http"
+const LISTENER_PROTOCOL_SOCKS = "socks"
+
+const TUNNEL_KIND_SOCKS = "socks"
+const TUNNEL_KIND_SOCKS_UDP = "socks_udp"
+
+const DEFAULT_SOCKS_HANDSHAKE_TIMEOUT = 10 * time.Second
+const MAX_UDP_DATAGRAM_SIZE = 64 * 1024
+
+const REJECT_REASON_COMMAND = "command"
+const REJECT_REASON_PROTOCOL = "protocol"
+
+type SocksConfig struct {
+	AllowedTargets   []string
+	Credentials      map[string]string
+	DisableUDP       bool
+	HandshakeTimeout time.Duration
+	DialTimeout      time.Duration
+	IdleTimeout      time.Duration
+	Dial             func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+type socksProxy struct {
+	config SocksConfig
+	rules  []targetRule
+}
+
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *sniffedConn) Read(data []byte) (int, error) {
+	return conn.reader.Read(data)
+}
+
+func (conn *sniffedConn) CloseWrite() error {
+	closeWrite(conn.Conn)
+	return nil
+}
+
+func (server *gosocksServer) SetSocksProxy(config SocksConfig) error {
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = DEFAULT_SOCKS_HANDSHAKE_TIMEOUT
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = DEFAULT_TUNNEL_DIAL_TIMEOUT
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DEFAULT_TUNNEL_IDLE_TIMEOUT
+	}
+	if config.Dial == nil {
+		dialer := &net.Dialer{Timeout: config.DialTimeout}
+		config.Dial = dialer.DialContext
+	}
+
+	rules, err := parseTargetRules(config.AllowedTargets)
+	if err != nil {
+		return err
+	}
+
+	server.socks = &socksProxy{config: config, rules: rules}
+	return nil
+}
+
+func validateListenerProtocol(config ListenerConfig, socksEnabled bool) error {
+	switch config.Protocol {
+	case LISTENER_PROTOCOL_AUTO, LISTENER_PROTOCOL_HTTP:
+		return nil
+	case LISTENER_PROTOCOL_SOCKS:
+		if !socksEnabled {
+			return fmt.Errorf("listener %s serves socks but no socks proxy is configured", config.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("listener %s has unknown protocol %s", config.Name, config.Protocol)
+}
+
+func (server *gosocksServer) sniffSocks(conn net.Conn, listener *serverListener) (net.Conn, bool) {
+	switch {
+	case listener.config.Protocol == LISTENER_PROTOCOL_SOCKS:
+		return conn, true
+	case listener.config.Protocol == LISTENER_PROTOCOL_HTTP || server.socks == nil:
+		return conn, false
+	}
+
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	sniffed := &sniffedConn{Conn: conn, reader: reader}
+	if err != nil {
+		return sniffed, false
+	}
+	return sniffed, first[0] == socks.VERSION
+}
+
+func (server *gosocksServer) rejectSocks(logger *slog.Logger, kind string, reason string, message string) {
+	server.metrics.TunnelsRejected.Inc(kind, reason)
+	logger.Warn("socks request rejected", "reason", reason, "error", message)
+}
+
+func (server *gosocksServer) handleSocks(conn net.Conn) {
+	logger := server.logger.With(
+		"session_id", generateRequestID(),
+		"remote_addr", conn.RemoteAddr().String())
+
+	proxy := server.socks
+	if proxy == nil {
+		logger.Warn("rejecting socks connection, socks proxy is not configured")
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(proxy.config.HandshakeTimeout))
+
+	methods, err := socks.ReadGreeting(conn)
+	if err != nil {
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_PROTOCOL, err.Error())
+		return
+	}
+
+	method := proxy.selectMethod(methods)
+	err = socks.WriteMethod(conn, method)
+	if err != nil {
+		logger.Warn("failed to send socks method selection", "error", err)
+		return
+	}
+	if method == socks.METHOD_NO_ACCEPTABLE {
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_AUTH, "no acceptable authentication method")
+		return
+	}
+
+	if method == socks.METHOD_USER_PASS {
+		user, password, err := socks.ReadCredentials(conn)
+		if err != nil {
+			server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_PROTOCOL, err.Error())
+			return
+		}
+		if !checkCredentials(proxy.config.Credentials, user, password) {
+			socks.WriteAuthStatus(conn, socks.AUTH_FAILURE)
+			server.rejectSocks(logger.With("user", user), TUNNEL_KIND_SOCKS, REJECT_REASON_AUTH, "invalid credentials")
+			return
+		}
+		err = socks.WriteAuthStatus(conn, socks.AUTH_SUCCESS)
+		if err != nil {
+			return
+		}
+		logger = logger.With("user", user)
+	}
+
+	request, err := socks.ReadRequest(conn)
+	if err != nil {
+		if errors.Is(err, socks.ErrUnsupportedAddress) {
+			socks.WriteReply(conn, socks.REPLY_ADDRESS_NOT_SUPPORTED, socks.Addr{})
+		}
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_PROTOCOL, err.Error())
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	logger = logger.With("target", request.Addr.String())
+
+	switch request.Command {
+	case socks.COMMAND_CONNECT:
+		server.socksConnect(conn, request, logger)
+	case socks.COMMAND_UDP_ASSOCIATE:
+		if proxy.config.DisableUDP {
+			socks.WriteReply(conn, socks.REPLY_COMMAND_NOT_SUPPORTED, socks.Addr{})
+			server.rejectSocks(logger, TUNNEL_KIND_SOCKS_UDP, REJECT_REASON_COMMAND, "udp associate is disabled")
+			return
+		}
+		server.socksAssociate(conn, request, logger)
+	default:
+		socks.WriteReply(conn, socks.REPLY_COMMAND_NOT_SUPPORTED, socks.Addr{})
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_COMMAND,
+			fmt.Sprintf("unsupported command %d", request.Command))
+	}
+}
+
+func (proxy *socksProxy) selectMethod(methods []byte) byte {
+	wanted := socks.METHOD_NO_AUTH
+	if len(proxy.config.Credentials) > 0 {
+		wanted = socks.METHOD_USER_PASS
+	}
+
+	for _, method := range methods {
+		if method == wanted {
+			return method
+		}
+	}
+	return socks.METHOD_NO_ACCEPTABLE
+}
+
+func socksReplyFor(err error) byte {
+	var httpError http.HttpError
+	if errors.As(err, &httpError) {
+		switch httpError.StatusCode {
+		case 400:
+			return socks.REPLY_ADDRESS_NOT_SUPPORTED
+		case 403:
+			return socks.REPLY_NOT_ALLOWED
+		}
+		return socks.REPLY_HOST_UNREACHABLE
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks.REPLY_CONNECTION_REFUSED
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks.REPLY_NETWORK_UNREACHABLE
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return socks.REPLY_TTL_EXPIRED
+	}
+	return socks.REPLY_HOST_UNREACHABLE
+}
+
+func (server *gosocksServer) socksConnect(conn net.Conn, request socks.Request, logger *slog.Logger) {
+	proxy := server.socks
+
+	ctx, cancel := context.WithTimeout(server.ctx, proxy.config.DialTimeout)
+	address, err := resolveTarget(ctx, proxy.rules, request.Addr.String())
+	if err != nil {
+		cancel()
+		socks.WriteReply(conn, socksReplyFor(err), socks.Addr{})
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_TARGET, err.Error())
+		return
+	}
+
+	targetConn, err := proxy.config.Dial(ctx, "tcp", address)
+	cancel()
+	if err != nil {
+		socks.WriteReply(conn, socksReplyFor(err), socks.Addr{})
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS, REJECT_REASON_DIAL, err.Error())
+		return
+	}
+	defer targetConn.Close()
+
+	err = socks.WriteReply(conn, socks.REPLY_SUCCEEDED, socks.AddrFromNetAddr(targetConn.LocalAddr()))
+	if err != nil {
+		logger.Warn("failed to send socks reply", "error", err)
+		return
+	}
+
+	server.metrics.TunnelsOpened.Inc(TUNNEL_KIND_SOCKS)
+	server.metrics.ActiveTunnels.Inc(TUNNEL_KIND_SOCKS)
+	defer server.metrics.ActiveTunnels.Dec(TUNNEL_KIND_SOCKS)
+	server.activeTunnels.Add(1)
+	defer server.activeTunnels.Add(-1)
+
+	start := time.Now()
+	bytesIn, bytesOut, err := splice(server.ctx, conn, conn, targetConn, proxy.config.IdleTimeout)
+	server.metrics.TunnelBytes.Add(float64(bytesIn), TUNNEL_KIND_SOCKS, metrics.DIRECTION_IN)
+	server.metrics.TunnelBytes.Add(float64(bytesOut), TUNNEL_KIND_SOCKS, metrics.DIRECTION_OUT)
+
+	attrs := []any{
+		"command", "connect",
+		"address", address,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"bytes_in", bytesIn,
+		"bytes_out", bytesOut}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Info("socks session closed", attrs...)
+}
+
+func (server *gosocksServer) socksAssociate(conn net.Conn, request socks.Request, logger *slog.Logger) {
+	proxy := server.socks
+
+	localAddr, localOk := conn.LocalAddr().(*net.TCPAddr)
+	remoteAddr, remoteOk := conn.RemoteAddr().(*net.TCPAddr)
+	if !localOk || !remoteOk {
+		socks.WriteReply(conn, socks.REPLY_COMMAND_NOT_SUPPORTED, socks.Addr{})
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS_UDP, REJECT_REASON_COMMAND, "udp associate requires a tcp listener")
+		return
+	}
+
+	packetConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localAddr.IP})
+	if err != nil {
+		socks.WriteReply(conn, socks.REPLY_GENERAL_FAILURE, socks.Addr{})
+		server.rejectSocks(logger, TUNNEL_KIND_SOCKS_UDP, REJECT_REASON_DIAL, err.Error())
+		return
+	}
+	defer packetConn.Close()
+
+	err = socks.WriteReply(conn, socks.REPLY_SUCCEEDED, socks.AddrFromNetAddr(packetConn.LocalAddr()))
+	if err != nil {
+		logger.Warn("failed to send socks reply", "error", err)
+		return
+	}
+
+	server.metrics.TunnelsOpened.Inc(TUNNEL_KIND_SOCKS_UDP)
+	server.metrics.ActiveTunnels.Inc(TUNNEL_KIND_SOCKS_UDP)
+	defer server.metrics.ActiveTunnels.Dec(TUNNEL_KIND_SOCKS_UDP)
+	server.activeTunnels.Add(1)
+	defer server.activeTunnels.Add(-1)
+
+	closeAll := func() {
+		conn.Close()
+		packetConn.Close()
+	}
+	stop := context.AfterFunc(server.ctx, closeAll)
+	defer stop()
+
+	go func() {
+		io.Copy(io.Discard, conn)
+		closeAll()
+	}()
+
+	association := &udpAssociation{
+		proxy:      proxy,
+		packetConn: packetConn,
+		clientIP:   remoteAddr.IP,
+		clientPort: request.Addr.Port,
+		targets:    map[string]*net.UDPAddr{},
+		peers:      map[string]bool{},
+		logger:     logger}
+
+	start := time.Now()
+	err = association.relay(server.ctx)
+	server.metrics.TunnelBytes.Add(float64(association.bytesIn), TUNNEL_KIND_SOCKS_UDP, metrics.DIRECTION_IN)
+	server.metrics.TunnelBytes.Add(float64(association.bytesOut), TUNNEL_KIND_SOCKS_UDP, metrics.DIRECTION_OUT)
+
+	attrs := []any{
+		"command", "udp_associate",
+		"relay_addr", packetConn.LocalAddr().String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+		"bytes_in", association.bytesIn,
+		"bytes_out", association.bytesOut,
+		"dropped", association.dropped}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Info("socks session closed", attrs...)
+}
+
+type udpAssociation struct {
+	proxy      *socksProxy
+	packetConn *net.UDPConn
+	clientIP   net.IP
+	clientPort int
+	clientAddr *net.UDPAddr
+	targets    map[string]*net.UDPAddr
+	peers      map[string]bool
+	logger     *slog.Logger
+	bytesIn    int64
+	bytesOut   int64
+	dropped    int64
+}
+
+func (association *udpAssociation) relay(ctx context.Context) error {
+	buffer := make([]byte, MAX_UDP_DATAGRAM_SIZE)
+	idleTimeout := association.proxy.config.IdleTimeout
+	lastActivity := time.Now()
+
+	for {
+		association.packetConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		n, from, err := association.packetConn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if time.Since(lastActivity) < idleTimeout {
+					continue
+				}
+				return ErrTunnelIdle
+			}
+			if errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				return nil
+			}
+			return err
+		}
+		lastActivity = time.Now()
+
+		if association.isClient(from) {
+			association.forward(ctx, buffer[:n], from)
+		} else if association.peers[from.String()] && association.clientAddr != nil {
+			association.reply(buffer[:n], from)
+		} else {
+			association.dropped++
+		}
+	}
+}
+
+func (association *udpAssociation) isClient(from *net.UDPAddr) bool {
+	if association.clientAddr != nil {
+		return from.IP.Equal(association.clientAddr.IP) && from.Port == association.clientAddr.Port
+	}
+	if !from.IP.Equal(association.clientIP) {
+		return false
+	}
+	return association.clientPort == 0 || association.clientPort == from.Port
+}
+
+func (association *udpAssociation) forward(ctx context.Context, packet []byte, from *net.UDPAddr) {
+	association.clientAddr = from
+
+	datagram, err := socks.ParseDatagram(packet)
+	if err != nil {
+		association.dropped++
+		association.logger.Debug("dropping socks datagram", "error", err)
+		return
+	}
+
+	target := datagram.Addr.String()
+	targetAddr, exists := association.targets[target]
+	if !exists {
+		resolveCtx, cancel := context.WithTimeout(ctx, association.proxy.config.DialTimeout)
+		address, err := resolveTarget(resolveCtx, association.proxy.rules, target)
+		if err == nil {
+			targetAddr, err = net.ResolveUDPAddr("udp", address)
+		}
+		cancel()
+		if err != nil {
+			association.dropped++
+			association.logger.Debug("dropping socks datagram", "destination", target, "error", err)
+			return
+		}
+		association.targets[target] = targetAddr
+		association.peers[targetAddr.String()] = true
+	}
+
+	n, err := association.packetConn.WriteToUDP(datagram.Payload, targetAddr)
+	association.bytesIn += int64(n)
+	if err != nil {
+		association.logger.Debug("failed to forward socks datagram", "destination", target, "error", err)
+	}
+}
+
+func (association *udpAssociation) reply(payload []byte, from *net.UDPAddr) {
+	packet := socks.Datagram{Addr: socks.AddrFromNetAddr(from), Payload: payload}.Serialize()
+	_, err := association.packetConn.WriteToUDP(packet, association.clientAddr)
+	if err != nil {
+		association.logger.Debug("failed to return socks datagram", "source", from.String(), "error", err)
+		return
+	}
+	association.bytesOut += int64(len(payload))
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/socks"
+)
+
+func startSocksServer(t *testing.T, config SocksConfig, listeners ...ListenerConfig) (Server, *metrics.Registry) {
+	srv := NewServerWithListeners(listeners...)
+	router := NewRouter()
+	router.AddRoute("/ping", func(request http.HttpRequest) (http.HttpResponse, error) {
+		return http.NewPlainTextResponse("pong", 200), nil
+	})
+	srv.SetRoutes(router)
+	registry := metrics.NewRegistry()
+	srv.SetMetrics(registry)
+
+	err := srv.SetSocksProxy(config)
+	if err != nil {
+		t.Fatalf("failed to configure socks: %v", err)
+	}
+
+	err = srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	return srv, registry
+}
+
+func dialSocks(t *testing.T, address string, user string, password string, request socks.Request) (net.Conn, socks.Addr, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	bound, err := socks.ClientHandshake(conn, user, password, request)
+	conn.SetDeadline(time.Time{})
+	return conn, bound, err
+}
+
+func socksConnectRequest(target string) socks.Request {
+	host, port, _ := net.SplitHostPort(target)
+	addr := socks.Addr{Host: host}
+	addr.Port, _ = net.LookupPort("tcp", port)
+	return socks.Request{Command: socks.COMMAND_CONNECT, Addr: addr}
+}
+
+func TestSocksSharedListener(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	srv, registry := startSocksServer(t,
+		SocksConfig{AllowedTargets: []string{"127.0.0.1:*"}},
+		ListenerConfig{Name: "shared", Address: "127.0.0.1:0"})
+	address := srv.Status().Listeners[0].Address
+
+	conn, bound, err := dialSocks(t, address, "", "", socksConnectRequest(target))
+	if err != nil {
+		t.Fatalf("socks connect failed: %v", err)
+	}
+	if bound.Host != "127.0.0.1" || bound.Port == 0 {
+		t.Errorf("unexpected bound address %v", bound)
+	}
+
+	conn.Write([]byte("hello"))
+	echoed := make([]byte, 5)
+	_, err = io.ReadFull(conn, echoed)
+	if err != nil || string(echoed) != "hello" {
+		t.Fatalf("unexpected tunnelled data %q: %v", echoed, err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	io.ReadAll(conn)
+
+	client := http.NewClient(http.ClientConfig{})
+	defer client.Close()
+	response, err := client.Get(context.Background(), "http://"+address+"/ping")
+	if err != nil || response.StatusCode != 200 || string(response.Content) != "pong" {
+		t.Errorf("expected HTTP to keep working on the shared port. got=%+v, %v", response, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(registry.Snapshot().String(), `gosocks_tunnel_bytes_total{kind="socks",direction="out"} 5`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected socks byte accounting:\n%s", registry.Snapshot().String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSocksDedicatedListener(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	srv, registry := startSocksServer(t,
+		SocksConfig{
+			AllowedTargets: []string{"127.0.0.1:" + strings.Split(target, ":")[1]},
+			Credentials:    map[string]string{"alice": "secret"}},
+		ListenerConfig{Name: "http", Address: "127.0.0.1:0", Protocol: LISTENER_PROTOCOL_HTTP},
+		ListenerConfig{Name: "socks", Address: "127.0.0.1:0", Protocol: LISTENER_PROTOCOL_SOCKS})
+	httpAddress := srv.Status().Listeners[0].Address
+	socksAddress := srv.Status().Listeners[1].Address
+
+	_, _, err := dialSocks(t, httpAddress, "alice", "secret", socksConnectRequest(target))
+	if err == nil {
+		t.Errorf("expected HTTP-only listener to refuse socks")
+	}
+
+	_, _, err = dialSocks(t, socksAddress, "", "", socksConnectRequest(target))
+	if err == nil {
+		t.Errorf("expected socks without credentials to be refused")
+	}
+
+	_, _, err = dialSocks(t, socksAddress, "alice", "wrong", socksConnectRequest(target))
+	if !errors.Is(err, socks.ErrAuthenticationFailed) {
+		t.Errorf("expected authentication failure. got=%v", err)
+	}
+
+	_, _, err = dialSocks(t, socksAddress, "alice", "secret", socksConnectRequest("127.0.0.1:1"))
+	var replyErr socks.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Reply != socks.REPLY_NOT_ALLOWED {
+		t.Errorf("expected not allowed reply. got=%v", err)
+	}
+
+	_, _, err = dialSocks(t, socksAddress, "alice", "secret",
+		socks.Request{Command: socks.COMMAND_BIND, Addr: socks.Addr{Host: "127.0.0.1", Port: 80}})
+	if !errors.As(err, &replyErr) || replyErr.Reply != socks.REPLY_COMMAND_NOT_SUPPORTED {
+		t.Errorf("expected command not supported reply. got=%v", err)
+	}
+
+	conn, _, err := dialSocks(t, socksAddress, "alice", "secret", socksConnectRequest(target))
+	if err != nil {
+		t.Fatalf("authenticated socks connect failed: %v", err)
+	}
+	conn.Write([]byte("ping"))
+	echoed := make([]byte, 4)
+	_, err = io.ReadFull(conn, echoed)
+	if err != nil || string(echoed) != "ping" {
+		t.Errorf("unexpected tunnelled data %q: %v", echoed, err)
+	}
+
+	exposition := registry.Snapshot().String()
+	for _, reason := range []string{REJECT_REASON_AUTH, REJECT_REASON_TARGET, REJECT_REASON_COMMAND} {
+		if !strings.Contains(exposition, `kind="socks",reason="`+reason+`"`) {
+			t.Errorf("expected rejected socks metric for reason %s", reason)
+		}
+	}
+}
+
+func TestSocksListenerRequiresConfig(t *testing.T) {
+	srv := NewServerWithListeners(ListenerConfig{Name: "socks", Address: "127.0.0.1:0", Protocol: LISTENER_PROTOCOL_SOCKS})
+	err := srv.Start()
+	if err == nil {
+		srv.Stop()
+		t.Fatalf("expected socks listener without socks config to fail")
+	}
+}
+
+func TestSocksUdpAssociate(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, from, err := echo.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(append([]byte("echo:"), buffer[:n]...), from)
+		}
+	}()
+
+	srv, _ := startSocksServer(t,
+		SocksConfig{AllowedTargets: []string{echo.LocalAddr().String()}},
+		ListenerConfig{Name: "shared", Address: "127.0.0.1:0"})
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer client.Close()
+
+	control, relay, err := dialSocks(t, srv.Status().Listeners[0].Address, "", "", socks.Request{
+		Command: socks.COMMAND_UDP_ASSOCIATE,
+		Addr:    socks.AddrFromNetAddr(client.LocalAddr())})
+	if err != nil {
+		t.Fatalf("udp associate failed: %v", err)
+	}
+
+	relayAddr, err := net.ResolveUDPAddr("udp", relay.String())
+	if err != nil {
+		t.Fatalf("invalid relay address %v: %v", relay, err)
+	}
+
+	blocked := socks.Datagram{Addr: socks.Addr{Host: "127.0.0.1", Port: 9}, Payload: []byte("nope")}
+	client.WriteToUDP(blocked.Serialize(), relayAddr)
+
+	datagram := socks.Datagram{Addr: socks.AddrFromNetAddr(echo.LocalAddr()), Payload: []byte("ping")}
+	client.WriteToUDP(datagram.Serialize(), relayAddr)
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 1024)
+	n, _, err := client.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("failed to read relayed datagram: %v", err)
+	}
+
+	reply, err := socks.ParseDatagram(buffer[:n])
+	if err != nil {
+		t.Fatalf("failed to parse relayed datagram: %v", err)
+	}
+	if reply.Addr != datagram.Addr || string(reply.Payload) != "echo:ping" {
+		t.Errorf("unexpected relayed datagram %+v", reply)
+	}
+
+	control.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		client.WriteToUDP(datagram.Serialize(), relayAddr)
+		client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		_, _, err = client.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected association to end with the control connection")
+		}
+	}
+}
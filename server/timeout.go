@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+type handlerResult struct {
+	response http.HttpResponse
+	err      error
+}
+
+// WithTimeout answers 503 once timeout elapses and 499 when the request
+// context is cancelled first. The handler keeps running in the background
+// until it returns, so it must stop as soon as request.Context() is done.
+func WithTimeout(handler HttpHandler, timeout time.Duration) HttpHandler {
+	return func(request http.HttpRequest) (http.HttpResponse, error) {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+
+		done := make(chan handlerResult, 1)
+		go func() {
+			response, err := callHandler(handler, request.WithContext(ctx))
+			done <- handlerResult{response: response, err: err}
+		}()
+
+		select {
+		case result := <-done:
+			return result.response, result.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return http.HttpResponse{}, http.ServiceUnavailable("handler timed out")
+			}
+			return http.HttpResponse{}, http.ClientClosedRequest("request cancelled")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestWithTimeout(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	slowHandler := func(request http.HttpRequest) (http.HttpResponse, error) {
+		<-request.Context().Done()
+		cancelled <- true
+		return http.HttpResponse{StatusCode: 200}, nil
+	}
+
+	handler := WithTimeout(slowHandler, 10*time.Millisecond)
+	_, err := handler(http.HttpRequest{FullPath: "/slow"})
+
+	httpError, ok := err.(http.HttpError)
+	if !ok {
+		t.Fatalf("expected error of type HttpError. got=%T", err)
+	}
+
+	if httpError.StatusCode != 503 {
+		t.Errorf("expected HttpError with code 503. got=%d", httpError.StatusCode)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("handler context was not cancelled")
+	}
+}
+
+func TestWithTimeoutCancelled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slowHandler := func(request http.HttpRequest) (http.HttpResponse, error) {
+		<-release
+		return http.HttpResponse{StatusCode: 200}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	handler := WithTimeout(slowHandler, time.Second)
+	_, err := handler(http.HttpRequest{FullPath: "/slow"}.WithContext(ctx))
+
+	httpError, ok := err.(http.HttpError)
+	if !ok {
+		t.Fatalf("expected error of type HttpError. got=%T", err)
+	}
+
+	if httpError.StatusCode != 499 {
+		t.Errorf("expected HttpError with code 499. got=%d", httpError.StatusCode)
+	}
+}
+
+func TestWithTimeoutFastHandler(t *testing.T) {
+	handler := WithTimeout(buildStatusCodeHandler(200), time.Second)
+	response, err := handler(http.HttpRequest{FullPath: "/fast"})
+
+	if err != nil {
+		t.Fatalf("request handler failed: %v", err)
+	}
+
+	if response.StatusCode != 200 {
+		t.Errorf("status code does not match expected status code 200. got=%d",
+			response.StatusCode)
+	}
+}
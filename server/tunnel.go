@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const TUNNEL_BUFFER_SIZE = 32 * 1024
+
+var ErrTunnelIdle = errors.New("tunnel idle timeout")
+
+type tunnelResult struct {
+	bytes int64
+	err   error
+}
+
+func splice(ctx context.Context, client net.Conn, clientReader io.Reader, target net.Conn, idleTimeout time.Duration) (int64, int64, error) {
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	closeBoth := func() {
+		client.Close()
+		target.Close()
+	}
+	stop := context.AfterFunc(ctx, closeBoth)
+	defer stop()
+
+	inbound := make(chan tunnelResult, 1)
+	outbound := make(chan tunnelResult, 1)
+
+	go func() {
+		n, err := copyWithIdleTimeout(target, clientReader, client, idleTimeout, &lastActivity)
+		closeWrite(target)
+		inbound <- tunnelResult{bytes: n, err: err}
+	}()
+	go func() {
+		n, err := copyWithIdleTimeout(client, target, target, idleTimeout, &lastActivity)
+		closeWrite(client)
+		outbound <- tunnelResult{bytes: n, err: err}
+	}()
+
+	var in, out tunnelResult
+	select {
+	case in = <-inbound:
+		if in.err != nil {
+			closeBoth()
+		}
+		out = <-outbound
+	case out = <-outbound:
+		if out.err != nil {
+			closeBoth()
+		}
+		in = <-inbound
+	}
+	closeBoth()
+
+	err := in.err
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		err = out.err
+	}
+	if errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+		err = nil
+	}
+	return in.bytes, out.bytes, err
+}
+
+func copyWithIdleTimeout(dst io.Writer, src io.Reader, srcConn net.Conn, idleTimeout time.Duration, lastActivity *atomic.Int64) (int64, error) {
+	buffer := make([]byte, TUNNEL_BUFFER_SIZE)
+	var total int64
+
+	for {
+		if idleTimeout > 0 {
+			srcConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
+		n, err := src.Read(buffer)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			written, writeErr := dst.Write(buffer[:n])
+			total += int64(written)
+			if writeErr != nil {
+				return total, writeErr
+			}
+		}
+
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if idleTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+			idle := time.Since(time.Unix(0, lastActivity.Load()))
+			if idle < idleTimeout {
+				continue
+			}
+			return total, ErrTunnelIdle
+		}
+		return total, err
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+	conn.Close()
+}
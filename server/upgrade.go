@@ -0,0 +1,30 @@
+package server
+
+import (
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+type UpgradeHook func(request http.HttpRequest, attributes *websocket.Attributes) error
+
+func (server *gosocksServer) AddUpgradeHook(hook UpgradeHook) {
+	server.upgradeHooks = append(server.upgradeHooks, hook)
+}
+
+func (server *gosocksServer) runUpgradeHooks(request http.HttpRequest) (http.HttpRequest, error) {
+	request, attributes := websocket.WithAttributes(request)
+
+	for _, hook := range server.upgradeHooks {
+		err := hook(request, attributes)
+		if err == nil {
+			continue
+		}
+
+		if httpError, ok := err.(http.HttpError); ok {
+			return request, httpError
+		}
+		return request, http.Forbidden(err.Error())
+	}
+
+	return request, nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+func TestUpgradeHookPopulatesAttributes(t *testing.T) {
+	userId := websocket.NewAttributeKey[string]("user_id")
+	opened := make(chan string, 1)
+
+	router := NewRouter()
+	router.AddWebSocket("/ws", websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen: func(conn websocket.WsConnection) {
+			user, _ := websocket.GetAttribute(conn.Attributes(), userId)
+			opened <- fmt.Sprintf("%s %d", user, len(conn.ID()))
+			conn.Close(1000, "done")
+		},
+		OnMessage: func(websocket.WsMessageEvent, websocket.WsConnection) {},
+		OnClose:   func(websocket.WsCloseEvent, websocket.WsConnection) {},
+		OnError:   func(error, websocket.WsConnection) {},
+	}))
+
+	srv := NewServerWithListeners(ListenerConfig{Name: "ws", Address: "127.0.0.1:0"})
+	srv.SetRoutes(router)
+	srv.AddUpgradeHook(func(request http.HttpRequest, attributes *websocket.Attributes) error {
+		token, exists := request.Header("Authorization")
+		if !exists {
+			return http.HttpError{StatusCode: 401, Message: "missing token"}
+		}
+		websocket.SetAttribute(attributes, userId, strings.TrimPrefix(token, "Bearer "))
+		return nil
+	})
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer srv.Stop()
+
+	address := srv.Status().Listeners[0].Address
+
+	statusLine := doUpgradeRequest(t, address, "")
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 401") {
+		t.Errorf("expected unauthenticated upgrade to be rejected. got=%q", statusLine)
+	}
+
+	statusLine = doUpgradeRequest(t, address, "Authorization: Bearer alice\r\n")
+	if !strings.HasPrefix(statusLine, "HTTP/1.1 101") {
+		t.Fatalf("expected upgrade to succeed. got=%q", statusLine)
+	}
+
+	result := <-opened
+	if result != "alice 32" {
+		t.Errorf("unexpected OnOpen state. got=%q", result)
+	}
+}
+
+func doUpgradeRequest(t *testing.T, address string, extraHeaders string) string {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n%s\r\n", extraHeaders)
+	return strings.SplitN(readAll(conn), "\r\n", 2)[0]
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/mux"
+	"github.com/brain-dev-null/gosocks/socks"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+const TUNNEL_KIND_WEBSOCKET = "websocket"
+const DEFAULT_TUNNEL_MAX_STREAMS = 256
+
+const REJECT_REASON_STREAMS = "streams"
+
+type WebSocketTunnelConfig struct {
+	AllowedTargets []string
+	MaxStreams     int
+	DialTimeout    time.Duration
+	IdleTimeout    time.Duration
+	Dial           func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+type webSocketTunnel struct {
+	config WebSocketTunnelConfig
+	rules  []targetRule
+}
+
+func NewWebSocketTunnel(config WebSocketTunnelConfig) (WebSocketHandler, error) {
+	if config.MaxStreams == 0 {
+		config.MaxStreams = DEFAULT_TUNNEL_MAX_STREAMS
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = DEFAULT_TUNNEL_DIAL_TIMEOUT
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DEFAULT_TUNNEL_IDLE_TIMEOUT
+	}
+	if config.Dial == nil {
+		dialer := &net.Dialer{Timeout: config.DialTimeout}
+		config.Dial = dialer.DialContext
+	}
+
+	rules, err := parseTargetRules(config.AllowedTargets)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := &webSocketTunnel{config: config, rules: rules}
+	return tunnel.serve, nil
+}
+
+func (tunnel *webSocketTunnel) serve(request http.HttpRequest, conn net.Conn, reader *bufio.Reader) {
+	var session *mux.Session
+
+	websocket.NewWsConnection(websocket.WsHandler{
+		OnOpen: func(wsConn websocket.WsConnection) {
+			session = mux.NewSession(wsConn.SendBinary, func(stream *mux.Stream, target string) {
+				tunnel.serveStream(wsConn, session, stream, target)
+			})
+		},
+		OnMessage: func(event websocket.WsMessageEvent, wsConn websocket.WsConnection) {
+			err := session.HandleMessage(event.Data)
+			if err != nil {
+				logging.FromContext(wsConn.Context()).Warn("invalid tunnel frame", "error", err)
+				wsConn.Close(websocket.STATUS_PROTOCOL_ERROR, "invalid tunnel frame")
+			}
+		},
+		OnClose: func(event websocket.WsCloseEvent, wsConn websocket.WsConnection) {
+			session.Close()
+		},
+		OnError: func(err error, wsConn websocket.WsConnection) {},
+	})(request, conn, reader)
+
+	if session != nil {
+		session.Close()
+	}
+}
+
+func (tunnel *webSocketTunnel) serveStream(wsConn websocket.WsConnection, session *mux.Session, stream *mux.Stream, target string) {
+	ctx := wsConn.Context()
+	logger := logging.FromContext(ctx).With(
+		"connection_id", wsConn.ID(),
+		"stream_id", stream.ID(),
+		"target", target)
+	serverMetrics := metrics.FromContext(ctx)
+
+	reject := func(reason string, code byte, message string) {
+		serverMetrics.TunnelsRejected.Inc(TUNNEL_KIND_WEBSOCKET, reason)
+		logger.Warn("tunnel stream rejected", "reason", reason, "error", message)
+		stream.Reject(code, message)
+	}
+
+	if session.NumStreams() > tunnel.config.MaxStreams {
+		reject(REJECT_REASON_STREAMS, socks.REPLY_GENERAL_FAILURE, "too many streams")
+		return
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, tunnel.config.DialTimeout)
+	address, err := resolveTarget(dialCtx, tunnel.rules, target)
+	if err != nil {
+		cancel()
+		reject(REJECT_REASON_TARGET, socksReplyFor(err), err.Error())
+		return
+	}
+
+	targetConn, err := tunnel.config.Dial(dialCtx, "tcp", address)
+	cancel()
+	if err != nil {
+		reject(REJECT_REASON_DIAL, socksReplyFor(err), err.Error())
+		return
+	}
+	defer targetConn.Close()
+
+	err = stream.Accept()
+	if err != nil {
+		stream.Close()
+		return
+	}
+
+	serverMetrics.TunnelsOpened.Inc(TUNNEL_KIND_WEBSOCKET)
+	serverMetrics.ActiveTunnels.Inc(TUNNEL_KIND_WEBSOCKET)
+	defer serverMetrics.ActiveTunnels.Dec(TUNNEL_KIND_WEBSOCKET)
+
+	start := time.Now()
+	bytesIn, bytesOut, err := splice(ctx, stream, stream, targetConn, tunnel.config.IdleTimeout)
+	serverMetrics.TunnelBytes.Add(float64(bytesIn), TUNNEL_KIND_WEBSOCKET, metrics.DIRECTION_IN)
+	serverMetrics.TunnelBytes.Add(float64(bytesOut), TUNNEL_KIND_WEBSOCKET, metrics.DIRECTION_OUT)
+
+	attrs := []any{
+		"address", address,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"bytes_in", bytesIn,
+		"bytes_out", bytesOut}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger.Info("tunnel stream closed", attrs...)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/mux"
+	"github.com/brain-dev-null/gosocks/socks"
+	"github.com/brain-dev-null/gosocks/tunnel"
+)
+
+func startWebSocketTunnel(t *testing.T, config WebSocketTunnelConfig) (*tunnel.Client, *metrics.Registry) {
+	handler, err := NewWebSocketTunnel(config)
+	if err != nil {
+		t.Fatalf("failed to create tunnel: %v", err)
+	}
+
+	srv := NewServerWithListeners(ListenerConfig{Name: "test", Address: "127.0.0.1:0"})
+	router := NewRouter()
+	router.AddWebSocket("/tunnel", handler)
+	srv.SetRoutes(router)
+	registry := metrics.NewRegistry()
+	srv.SetMetrics(registry)
+
+	err = srv.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	client, err := tunnel.NewClient(tunnel.ClientConfig{
+		URL: fmt.Sprintf("ws://%s/tunnel", srv.Status().Listeners[0].Address)})
+	if err != nil {
+		t.Fatalf("failed to create tunnel client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client, registry
+}
+
+func TestWebSocketTunnelMultiplexing(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	client, registry := startWebSocketTunnel(t, WebSocketTunnelConfig{AllowedTargets: []string{"127.0.0.1:*"}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := client.DialContext(context.Background(), target)
+			if err != nil {
+				t.Errorf("failed to open stream: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			payload := bytes.Repeat([]byte{byte('a' + i)}, 2*mux.INITIAL_WINDOW)
+			go func() {
+				conn.Write(payload)
+				conn.(*mux.Stream).CloseWrite()
+			}()
+
+			echoed, err := io.ReadAll(conn)
+			if err != nil || !bytes.Equal(echoed, payload) {
+				t.Errorf("stream %d echoed %d bytes, expected %d: %v", i, len(echoed), len(payload), err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	exposition := registry.Snapshot().String()
+	if !strings.Contains(exposition, `gosocks_websocket_connections_opened_total 1`) {
+		t.Errorf("expected all streams to share one websocket:\n%s", exposition)
+	}
+	if !strings.Contains(exposition, `gosocks_tunnels_opened_total{kind="websocket"} 4`) {
+		t.Errorf("expected four tunnel streams:\n%s", exposition)
+	}
+
+	_, err := client.DialContext(context.Background(), "10.0.0.1:22")
+	var openErr mux.OpenError
+	if !errors.As(err, &openErr) || openErr.Code != socks.REPLY_NOT_ALLOWED {
+		t.Errorf("expected disallowed target to be rejected. got=%v", err)
+	}
+}
+
+func TestWebSocketTunnelLocalListeners(t *testing.T) {
+	target := startTcpEchoTarget(t)
+	client, _ := startWebSocketTunnel(t, WebSocketTunnelConfig{AllowedTargets: []string{"127.0.0.1:*"}})
+
+	socksListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { socksListener.Close() })
+	go client.ServeSocks(socksListener)
+
+	forwardListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { forwardListener.Close() })
+	go client.ServeForward(forwardListener, target)
+
+	conn, _, err := dialSocks(t, socksListener.Addr().String(), "", "", socksConnectRequest(target))
+	if err != nil {
+		t.Fatalf("socks connect through tunnel failed: %v", err)
+	}
+	assertEcho(t, conn, "via socks")
+
+	_, _, err = dialSocks(t, socksListener.Addr().String(), "", "", socksConnectRequest("10.0.0.1:22"))
+	var replyErr socks.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Reply != socks.REPLY_NOT_ALLOWED {
+		t.Errorf("expected server rejection to be relayed to the socks client. got=%v", err)
+	}
+
+	conn, err = net.Dial("tcp", forwardListener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	assertEcho(t, conn, "via forward")
+}
+
+func assertEcho(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err := conn.Write([]byte(message))
+	if err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	echoed := make([]byte, len(message))
+	_, err = io.ReadFull(conn, echoed)
+	if err != nil || string(echoed) != message {
+		t.Fatalf("unexpected echo %q: %v", echoed, err)
+	}
+}
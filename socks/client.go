@@ -0,0 +1,60 @@
+package socks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrAuthenticationFailed = errors.New("socks authentication failed")
+
+func ClientHandshake(conn io.ReadWriter, user string, password string, request Request) (Addr, error) {
+	method := METHOD_NO_AUTH
+	if user != "" {
+		method = METHOD_USER_PASS
+	}
+
+	_, err := conn.Write([]byte{VERSION, 1, method})
+	if err != nil {
+		return Addr{}, err
+	}
+
+	selection := make([]byte, 2)
+	_, err = io.ReadFull(conn, selection)
+	if err != nil {
+		return Addr{}, fmt.Errorf("failed to read socks method selection: %w", err)
+	}
+	if selection[0] != VERSION {
+		return Addr{}, ErrUnsupportedVersion
+	}
+	if selection[1] != method {
+		return Addr{}, fmt.Errorf("socks server rejected authentication method %d", method)
+	}
+
+	if method == METHOD_USER_PASS {
+		credentials := []byte{AUTH_VERSION, byte(len(user))}
+		credentials = append(credentials, user...)
+		credentials = append(credentials, byte(len(password)))
+		credentials = append(credentials, password...)
+		_, err = conn.Write(credentials)
+		if err != nil {
+			return Addr{}, err
+		}
+
+		status := make([]byte, 2)
+		_, err = io.ReadFull(conn, status)
+		if err != nil {
+			return Addr{}, fmt.Errorf("failed to read socks auth status: %w", err)
+		}
+		if status[1] != AUTH_SUCCESS {
+			return Addr{}, ErrAuthenticationFailed
+		}
+	}
+
+	_, err = conn.Write(request.Serialize())
+	if err != nil {
+		return Addr{}, err
+	}
+
+	return ReadReply(conn)
+}
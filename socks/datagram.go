@@ -0,0 +1,36 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var ErrFragmentedDatagram = errors.New("fragmented socks datagrams are not supported")
+
+type Datagram struct {
+	Addr    Addr
+	Payload []byte
+}
+
+func ParseDatagram(packet []byte) (Datagram, error) {
+	if len(packet) < 4 {
+		return Datagram{}, fmt.Errorf("socks datagram too short: %d bytes", len(packet))
+	}
+	if packet[2] != 0x00 {
+		return Datagram{}, ErrFragmentedDatagram
+	}
+
+	reader := bytes.NewReader(packet[3:])
+	addr, err := ReadAddr(reader)
+	if err != nil {
+		return Datagram{}, fmt.Errorf("failed to read socks datagram address: %w", err)
+	}
+
+	return Datagram{Addr: addr, Payload: packet[len(packet)-reader.Len():]}, nil
+}
+
+func (datagram Datagram) Serialize() []byte {
+	buffer := append([]byte{0x00, 0x00, 0x00}, datagram.Addr.Serialize()...)
+	return append(buffer, datagram.Payload...)
+}
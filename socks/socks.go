@@ -0,0 +1,279 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+const VERSION byte = 0x05
+const AUTH_VERSION byte = 0x01
+
+const METHOD_NO_AUTH byte = 0x00
+const METHOD_USER_PASS byte = 0x02
+const METHOD_NO_ACCEPTABLE byte = 0xFF
+
+const AUTH_SUCCESS byte = 0x00
+const AUTH_FAILURE byte = 0x01
+
+const COMMAND_CONNECT byte = 0x01
+const COMMAND_BIND byte = 0x02
+const COMMAND_UDP_ASSOCIATE byte = 0x03
+
+const ADDRESS_IPV4 byte = 0x01
+const ADDRESS_DOMAIN byte = 0x03
+const ADDRESS_IPV6 byte = 0x04
+
+const REPLY_SUCCEEDED byte = 0x00
+const REPLY_GENERAL_FAILURE byte = 0x01
+const REPLY_NOT_ALLOWED byte = 0x02
+const REPLY_NETWORK_UNREACHABLE byte = 0x03
+const REPLY_HOST_UNREACHABLE byte = 0x04
+const REPLY_CONNECTION_REFUSED byte = 0x05
+const REPLY_TTL_EXPIRED byte = 0x06
+const REPLY_COMMAND_NOT_SUPPORTED byte = 0x07
+const REPLY_ADDRESS_NOT_SUPPORTED byte = 0x08
+
+var ErrUnsupportedVersion = errors.New("unsupported socks version")
+var ErrUnsupportedAddress = errors.New("unsupported socks address type")
+
+type Addr struct {
+	Host string
+	Port int
+}
+
+type Request struct {
+	Command byte
+	Addr    Addr
+}
+
+type ReplyError struct {
+	Reply byte
+}
+
+func (err ReplyError) Error() string {
+	return fmt.Sprintf("socks request failed: %s", ReplyText(err.Reply))
+}
+
+func ReplyText(reply byte) string {
+	switch reply {
+	case REPLY_SUCCEEDED:
+		return "succeeded"
+	case REPLY_GENERAL_FAILURE:
+		return "general failure"
+	case REPLY_NOT_ALLOWED:
+		return "connection not allowed by ruleset"
+	case REPLY_NETWORK_UNREACHABLE:
+		return "network unreachable"
+	case REPLY_HOST_UNREACHABLE:
+		return "host unreachable"
+	case REPLY_CONNECTION_REFUSED:
+		return "connection refused"
+	case REPLY_TTL_EXPIRED:
+		return "ttl expired"
+	case REPLY_COMMAND_NOT_SUPPORTED:
+		return "command not supported"
+	case REPLY_ADDRESS_NOT_SUPPORTED:
+		return "address type not supported"
+	}
+	return fmt.Sprintf("unknown reply %d", reply)
+}
+
+func AddrFromNetAddr(addr net.Addr) Addr {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, _ := netip.AddrFromSlice(a.IP)
+		return Addr{Host: ip.Unmap().String(), Port: a.Port}
+	case *net.UDPAddr:
+		ip, _ := netip.AddrFromSlice(a.IP)
+		return Addr{Host: ip.Unmap().String(), Port: a.Port}
+	}
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return Addr{Host: "0.0.0.0"}
+	}
+	number, _ := strconv.Atoi(port)
+	return Addr{Host: host, Port: number}
+}
+
+func (addr Addr) String() string {
+	return net.JoinHostPort(addr.Host, strconv.Itoa(addr.Port))
+}
+
+func (addr Addr) Serialize() []byte {
+	buffer := []byte{}
+
+	ip, err := netip.ParseAddr(addr.Host)
+	switch {
+	case err == nil && ip.Unmap().Is4():
+		buffer = append(buffer, ADDRESS_IPV4)
+		buffer = append(buffer, ip.Unmap().AsSlice()...)
+	case err == nil:
+		buffer = append(buffer, ADDRESS_IPV6)
+		buffer = append(buffer, ip.AsSlice()...)
+	default:
+		buffer = append(buffer, ADDRESS_DOMAIN, byte(len(addr.Host)))
+		buffer = append(buffer, addr.Host...)
+	}
+
+	return binary.BigEndian.AppendUint16(buffer, uint16(addr.Port))
+}
+
+func ReadAddr(reader io.Reader) (Addr, error) {
+	addressType := make([]byte, 1)
+	_, err := io.ReadFull(reader, addressType)
+	if err != nil {
+		return Addr{}, err
+	}
+
+	var host string
+	switch addressType[0] {
+	case ADDRESS_IPV4, ADDRESS_IPV6:
+		length := 4
+		if addressType[0] == ADDRESS_IPV6 {
+			length = 16
+		}
+		raw := make([]byte, length)
+		_, err = io.ReadFull(reader, raw)
+		if err != nil {
+			return Addr{}, err
+		}
+		ip, _ := netip.AddrFromSlice(raw)
+		host = ip.String()
+	case ADDRESS_DOMAIN:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(reader, length)
+		if err != nil {
+			return Addr{}, err
+		}
+		raw := make([]byte, length[0])
+		_, err = io.ReadFull(reader, raw)
+		if err != nil {
+			return Addr{}, err
+		}
+		host = string(raw)
+	default:
+		return Addr{}, ErrUnsupportedAddress
+	}
+
+	port := make([]byte, 2)
+	_, err = io.ReadFull(reader, port)
+	if err != nil {
+		return Addr{}, err
+	}
+
+	return Addr{Host: host, Port: int(binary.BigEndian.Uint16(port))}, nil
+}
+
+func ReadGreeting(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read socks greeting: %w", err)
+	}
+	if header[0] != VERSION {
+		return nil, ErrUnsupportedVersion
+	}
+
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(reader, methods)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read socks methods: %w", err)
+	}
+	return methods, nil
+}
+
+func WriteMethod(writer io.Writer, method byte) error {
+	_, err := writer.Write([]byte{VERSION, method})
+	return err
+}
+
+func ReadCredentials(reader io.Reader) (string, string, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read socks credentials: %w", err)
+	}
+	if header[0] != AUTH_VERSION {
+		return "", "", fmt.Errorf("unsupported socks auth version %d", header[0])
+	}
+
+	user := make([]byte, header[1])
+	_, err = io.ReadFull(reader, user)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read socks username: %w", err)
+	}
+
+	length := make([]byte, 1)
+	_, err = io.ReadFull(reader, length)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read socks password: %w", err)
+	}
+	password := make([]byte, length[0])
+	_, err = io.ReadFull(reader, password)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read socks password: %w", err)
+	}
+
+	return string(user), string(password), nil
+}
+
+func WriteAuthStatus(writer io.Writer, status byte) error {
+	_, err := writer.Write([]byte{AUTH_VERSION, status})
+	return err
+}
+
+func ReadRequest(reader io.Reader) (Request, error) {
+	header := make([]byte, 3)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return Request{}, fmt.Errorf("failed to read socks request: %w", err)
+	}
+	if header[0] != VERSION {
+		return Request{}, ErrUnsupportedVersion
+	}
+
+	addr, err := ReadAddr(reader)
+	if err != nil {
+		return Request{Command: header[1]}, fmt.Errorf("failed to read socks request address: %w", err)
+	}
+
+	return Request{Command: header[1], Addr: addr}, nil
+}
+
+func (request Request) Serialize() []byte {
+	return append([]byte{VERSION, request.Command, 0x00}, request.Addr.Serialize()...)
+}
+
+func WriteReply(writer io.Writer, reply byte, bound Addr) error {
+	if bound.Host == "" {
+		bound.Host = "0.0.0.0"
+	}
+	_, err := writer.Write(append([]byte{VERSION, reply, 0x00}, bound.Serialize()...))
+	return err
+}
+
+func ReadReply(reader io.Reader) (Addr, error) {
+	header := make([]byte, 3)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return Addr{}, fmt.Errorf("failed to read socks reply: %w", err)
+	}
+	if header[0] != VERSION {
+		return Addr{}, ErrUnsupportedVersion
+	}
+
+	bound, err := ReadAddr(reader)
+	if err != nil {
+		return Addr{}, fmt.Errorf("failed to read socks reply address: %w", err)
+	}
+	if header[1] != REPLY_SUCCEEDED {
+		return bound, ReplyError{Reply: header[1]}
+	}
+	return bound, nil
+}
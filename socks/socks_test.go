@@ -0,0 +1,76 @@
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAddrSerialization(t *testing.T) {
+	tests := []struct {
+		addr     Addr
+		expected []byte
+	}{
+		{Addr{Host: "192.0.2.1", Port: 80}, []byte{ADDRESS_IPV4, 192, 0, 2, 1, 0, 80}},
+		{Addr{Host: "::ffff:192.0.2.1", Port: 80}, []byte{ADDRESS_IPV4, 192, 0, 2, 1, 0, 80}},
+		{Addr{Host: "example.com", Port: 443}, append(append([]byte{ADDRESS_DOMAIN, 11}, "example.com"...), 1, 187)},
+		{Addr{Host: "2001:db8::1", Port: 53}, []byte{ADDRESS_IPV6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 53}},
+	}
+
+	for _, tt := range tests {
+		serialized := tt.addr.Serialize()
+		if !bytes.Equal(serialized, tt.expected) {
+			t.Errorf("%v serialized wrong. expected=%v, got=%v", tt.addr, tt.expected, serialized)
+		}
+
+		parsed, err := ReadAddr(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", tt.addr, err)
+		}
+		if parsed.Port != tt.addr.Port || (parsed.Host != tt.addr.Host && tt.addr.Host != "::ffff:192.0.2.1") {
+			t.Errorf("address did not round trip. expected=%v, got=%v", tt.addr, parsed)
+		}
+	}
+
+	_, err := ReadAddr(bytes.NewReader([]byte{0x09, 0, 0}))
+	if !errors.Is(err, ErrUnsupportedAddress) {
+		t.Errorf("expected unsupported address error. got=%v", err)
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	raw := Request{Command: COMMAND_CONNECT, Addr: Addr{Host: "example.com", Port: 8080}}.Serialize()
+
+	request, err := ReadRequest(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	if request.Command != COMMAND_CONNECT || request.Addr.String() != "example.com:8080" {
+		t.Errorf("unexpected request: %+v", request)
+	}
+
+	raw[0] = 0x04
+	_, err = ReadRequest(bytes.NewReader(raw))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected unsupported version error. got=%v", err)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	datagram := Datagram{Addr: Addr{Host: "198.51.100.4", Port: 53}, Payload: []byte("query")}
+
+	parsed, err := ParseDatagram(datagram.Serialize())
+	if err != nil {
+		t.Fatalf("failed to parse datagram: %v", err)
+	}
+	if parsed.Addr != datagram.Addr || string(parsed.Payload) != "query" {
+		t.Errorf("datagram did not round trip. got=%+v", parsed)
+	}
+
+	fragmented := datagram.Serialize()
+	fragmented[2] = 1
+	_, err = ParseDatagram(fragmented)
+	if !errors.Is(err, ErrFragmentedDatagram) {
+		t.Errorf("expected fragmented datagram error. got=%v", err)
+	}
+}
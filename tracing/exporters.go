@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+type stdoutExporter struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewStdoutExporter(writer io.Writer) Exporter {
+	return &stdoutExporter{encoder: json.NewEncoder(writer)}
+}
+
+func (exporter *stdoutExporter) ExportSpan(span SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.encoder.Encode(span)
+}
+
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{spans: []SpanData{}}
+}
+
+func (exporter *InMemoryExporter) ExportSpan(span SpanData) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.spans = append(exporter.spans, span)
+}
+
+func (exporter *InMemoryExporter) Spans() []SpanData {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	return append([]SpanData{}, exporter.spans...)
+}
+
+func (exporter *InMemoryExporter) Reset() {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	exporter.spans = []SpanData{}
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+const TRACEPARENT_HEADER = "traceparent"
+const TRACESTATE_HEADER = "tracestate"
+
+func Extract(request http.HttpRequest) (SpanContext, bool) {
+	traceparent, exists := request.Header(TRACEPARENT_HEADER)
+	if !exists {
+		return SpanContext{}, false
+	}
+
+	spanContext, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	tracestate, exists := request.Header(TRACESTATE_HEADER)
+	if exists {
+		spanContext.TraceState = tracestate
+	}
+
+	spanContext.Remote = true
+	return spanContext, true
+}
+
+func Inject(ctx context.Context, headers map[string]string) {
+	spanContext := SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return
+	}
+
+	headers[TRACEPARENT_HEADER] = FormatTraceparent(spanContext)
+	if spanContext.TraceState != "" {
+		headers[TRACESTATE_HEADER] = spanContext.TraceState
+	}
+}
+
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("traceparent malformed: %s", traceparent)
+	}
+
+	version, traceId, spanId, flags := parts[0], parts[1], parts[2], parts[3]
+
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) {
+		return SpanContext{}, fmt.Errorf("traceparent version invalid: %s", version)
+	}
+
+	if version == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("traceparent malformed: %s", traceparent)
+	}
+
+	spanContext := SpanContext{}
+
+	if len(traceId) != 32 || !isLowerHex(traceId) {
+		return SpanContext{}, fmt.Errorf("trace id invalid: %s", traceId)
+	}
+	hex.Decode(spanContext.TraceID[:], []byte(traceId))
+
+	if len(spanId) != 16 || !isLowerHex(spanId) {
+		return SpanContext{}, fmt.Errorf("parent id invalid: %s", spanId)
+	}
+	hex.Decode(spanContext.SpanID[:], []byte(spanId))
+
+	if len(flags) != 2 || !isLowerHex(flags) {
+		return SpanContext{}, fmt.Errorf("trace flags invalid: %s", flags)
+	}
+	flagBytes, _ := hex.DecodeString(flags)
+	spanContext.Flags = flagBytes[0]
+
+	if !spanContext.IsValid() {
+		return SpanContext{}, fmt.Errorf("traceparent contains zero ids: %s", traceparent)
+	}
+
+	return spanContext, nil
+}
+
+func FormatTraceparent(spanContext SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-%02x",
+		spanContext.TraceID.String(),
+		spanContext.SpanID.String(),
+		spanContext.Flags)
+}
+
+func isLowerHex(value string) bool {
+	for _, c := range value {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
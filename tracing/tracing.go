@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const STATUS_UNSET = "unset"
+const STATUS_OK = "ok"
+const STATUS_ERROR = "error"
+
+const FLAG_SAMPLED byte = 0x01
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+	Remote     bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanData struct {
+	Name         string            `json:"name"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	TraceState   string            `json:"trace_state,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationUs   int64             `json:"duration_us"`
+	Attributes   map[string]string `json:"attributes"`
+	Status       string            `json:"status"`
+	Error        string            `json:"error,omitempty"`
+}
+
+type Exporter interface {
+	ExportSpan(span SpanData)
+}
+
+type Tracer struct {
+	exporter Exporter
+}
+
+type Span struct {
+	tracer      *Tracer
+	spanContext SpanContext
+	parentId    SpanID
+	name        string
+	start       time.Time
+	mutex       sync.Mutex
+	attributes  map[string]string
+	status      string
+	err         string
+	ended       bool
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+type remoteKey struct{}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+func WithTracer(ctx context.Context, tracer *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+func TracerFromContext(ctx context.Context) *Tracer {
+	tracer, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return tracer
+}
+
+func WithRemoteSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	spanContext.Remote = true
+	return context.WithValue(ctx, remoteKey{}, spanContext)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.spanContext
+	}
+
+	remote, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return remote
+}
+
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return TracerFromContext(ctx).Start(ctx, name)
+}
+
+func (tracer *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	spanContext := SpanContext{
+		SpanID: generateSpanID(),
+		Flags:  FLAG_SAMPLED}
+
+	if parent.IsValid() {
+		spanContext.TraceID = parent.TraceID
+		spanContext.Flags = parent.Flags
+		spanContext.TraceState = parent.TraceState
+	} else {
+		spanContext.TraceID = generateTraceID()
+	}
+
+	span := &Span{
+		tracer:      tracer,
+		spanContext: spanContext,
+		parentId:    parent.SpanID,
+		name:        name,
+		start:       time.Now(),
+		attributes:  map[string]string{},
+		status:      STATUS_UNSET}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.spanContext
+}
+
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.attributes[key] = fmt.Sprint(value)
+}
+
+func (span *Span) SetStatus(status string) {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.status = status
+}
+
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.status = STATUS_ERROR
+	span.err = err.Error()
+}
+
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+
+	end := time.Now()
+	data := SpanData{
+		Name:       span.name,
+		TraceID:    span.spanContext.TraceID.String(),
+		SpanID:     span.spanContext.SpanID.String(),
+		TraceState: span.spanContext.TraceState,
+		Start:      span.start,
+		End:        end,
+		DurationUs: end.Sub(span.start).Microseconds(),
+		Attributes: map[string]string{},
+		Status:     span.status,
+		Error:      span.err}
+	if span.parentId.IsValid() {
+		data.ParentSpanID = span.parentId.String()
+	}
+	for key, value := range span.attributes {
+		data.Attributes[key] = value
+	}
+	span.mutex.Unlock()
+
+	if span.tracer.exporter != nil {
+		span.tracer.exporter.ExportSpan(data)
+	}
+}
+
+func generateTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func generateSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
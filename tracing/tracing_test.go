@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		valid       bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"garbage", false},
+	}
+
+	for _, tt := range tests {
+		spanContext, err := ParseTraceparent(tt.traceparent)
+		if tt.valid && err != nil {
+			t.Errorf("expected %s to be valid. got=%v", tt.traceparent, err)
+			continue
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("expected %s to be invalid", tt.traceparent)
+			continue
+		}
+
+		if tt.valid && FormatTraceparent(spanContext)[3:52] != tt.traceparent[3:52] {
+			t.Errorf("formatted traceparent does not match. expected=%s, got=%s",
+				tt.traceparent, FormatTraceparent(spanContext))
+		}
+	}
+}
+
+func TestSpanPropagation(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	request := http.HttpRequest{
+		Headers: map[string]string{
+			"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"Tracestate":  "vendor=value",
+		}}
+
+	parent, ok := Extract(request)
+	if !ok {
+		t.Fatalf("failed to extract traceparent")
+	}
+
+	ctx := WithTracer(context.Background(), tracer)
+	ctx = WithRemoteSpanContext(ctx, parent)
+
+	ctx, rootSpan := StartSpan(ctx, "root")
+	_, childSpan := StartSpan(ctx, "child")
+	childSpan.End()
+	rootSpan.End()
+
+	headers := map[string]string{}
+	Inject(ctx, headers)
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 exported spans. got=%d", len(spans))
+	}
+
+	child, root := spans[0], spans[1]
+
+	if root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("root span did not continue remote trace. got=%s", root.TraceID)
+	}
+
+	if root.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("root span parent is not remote span. got=%s", root.ParentSpanID)
+	}
+
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+		t.Errorf("child span is not a child of root span. got=%+v", child)
+	}
+
+	expectedTraceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + root.SpanID + "-01"
+	if headers[TRACEPARENT_HEADER] != expectedTraceparent {
+		t.Errorf("unexpected injected traceparent. expected=%s, got=%s",
+			expectedTraceparent, headers[TRACEPARENT_HEADER])
+	}
+
+	if headers[TRACESTATE_HEADER] != "vendor=value" {
+		t.Errorf("tracestate was not propagated. got=%s", headers[TRACESTATE_HEADER])
+	}
+}
+
+func TestNilTracerIsNoop(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "noop")
+	span.SetAttribute("key", "value")
+	span.End()
+
+	if SpanFromContext(ctx) != nil {
+		t.Errorf("expected no span in context without tracer")
+	}
+}
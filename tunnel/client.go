@@ -0,0 +1,314 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/mux"
+	"github.com/brain-dev-null/gosocks/socks"
+	"github.com/brain-dev-null/gosocks/websocket"
+)
+
+const DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+const DEFAULT_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+var ErrClientClosed = errors.New("tunnel client closed")
+
+type ClientConfig struct {
+	URL              string
+	Headers          map[string]string
+	TLSConfig        *tls.Config
+	DialTimeout      time.Duration
+	HandshakeTimeout time.Duration
+	Logger           *slog.Logger
+}
+
+type Client struct {
+	config     ClientConfig
+	endpoint   *url.URL
+	httpClient *http.Client
+	logger     *slog.Logger
+	mutex      sync.Mutex
+	session    *mux.Session
+	wsConn     websocket.WsConnection
+	closed     bool
+}
+
+func NewClient(config ClientConfig) (*Client, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnel url %s: %w", config.URL, err)
+	}
+	if endpoint.Scheme != "ws" && endpoint.Scheme != "wss" {
+		return nil, fmt.Errorf("unsupported tunnel url scheme [%s]", endpoint.Scheme)
+	}
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("tunnel url %s has no host", config.URL)
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = DEFAULT_HANDSHAKE_TIMEOUT
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	return &Client{
+		config:   config,
+		endpoint: endpoint,
+		httpClient: http.NewClient(http.ClientConfig{
+			DialTimeout: config.DialTimeout,
+			TLSConfig:   config.TLSConfig}),
+		logger: config.Logger}, nil
+}
+
+func (client *Client) DialContext(ctx context.Context, target string) (net.Conn, error) {
+	session, err := client.getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := session.Open(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (client *Client) getSession(ctx context.Context) (*mux.Session, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.closed {
+		return nil, ErrClientClosed
+	}
+	if client.session != nil {
+		select {
+		case <-client.session.Done():
+		default:
+			return client.session, nil
+		}
+	}
+
+	session, wsConn, err := client.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client.session = session
+	client.wsConn = wsConn
+	return session, nil
+}
+
+func (client *Client) connect(ctx context.Context) (*mux.Session, websocket.WsConnection, error) {
+	scheme := "http"
+	if client.endpoint.Scheme == "wss" {
+		scheme = "https"
+	}
+
+	conn, err := client.httpClient.Dial(ctx, scheme, client.endpoint.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := map[string]string{"Host": client.endpoint.Host}
+	for name, value := range client.config.Headers {
+		headers[name] = value
+	}
+	request := http.HttpRequest{FullPath: client.endpoint.RequestURI(), Headers: headers}
+
+	conn.SetDeadline(time.Now().Add(client.config.HandshakeTimeout))
+	_, reader, err := websocket.ClientHandshake(conn, request)
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("tunnel handshake with %s failed: %w", client.endpoint.Redacted(), err)
+	}
+
+	opened := make(chan websocket.WsConnection, 1)
+	var session *mux.Session
+
+	handler := websocket.WsHandler{
+		OnOpen: func(wsConn websocket.WsConnection) {
+			session = mux.NewSession(wsConn.SendBinary, nil)
+			opened <- wsConn
+		},
+		OnMessage: func(event websocket.WsMessageEvent, wsConn websocket.WsConnection) {
+			err := session.HandleMessage(event.Data)
+			if err != nil {
+				client.logger.Warn("invalid tunnel frame", "error", err)
+				wsConn.Close(websocket.STATUS_PROTOCOL_ERROR, "invalid tunnel frame")
+			}
+		},
+		OnClose: func(event websocket.WsCloseEvent, wsConn websocket.WsConnection) {
+			session.Close()
+		},
+		OnError: func(err error, wsConn websocket.WsConnection) {},
+	}
+
+	requestCtx := logging.WithLogger(context.Background(), client.logger)
+	go func() {
+		websocket.ServeClient(request.WithContext(requestCtx), conn, reader, handler)
+		session.Close()
+		client.logger.Info("tunnel connection closed", "url", client.endpoint.Redacted())
+	}()
+
+	wsConn := <-opened
+	client.logger.Info("tunnel connection established", "url", client.endpoint.Redacted())
+	return session, wsConn, nil
+}
+
+func (client *Client) Close() error {
+	client.mutex.Lock()
+	client.closed = true
+	session := client.session
+	wsConn := client.wsConn
+	client.mutex.Unlock()
+
+	client.httpClient.Close()
+	if session != nil {
+		session.Close()
+	}
+	if wsConn != nil {
+		return wsConn.Close(websocket.STATUS_NORMAL_CLOSURE, "client closed")
+	}
+	return nil
+}
+
+func (client *Client) ServeForward(listener net.Listener, target string) error {
+	return serve(listener, func(conn net.Conn) {
+		logger := client.logger.With("remote_addr", conn.RemoteAddr().String(), "target", target)
+
+		ctx, cancel := context.WithTimeout(context.Background(), client.config.DialTimeout)
+		stream, err := client.DialContext(ctx, target)
+		cancel()
+		if err != nil {
+			logger.Warn("failed to open tunnel stream", "error", err)
+			return
+		}
+
+		pipe(conn, stream)
+	})
+}
+
+func (client *Client) ServeSocks(listener net.Listener) error {
+	return serve(listener, client.handleSocks)
+}
+
+func (client *Client) handleSocks(conn net.Conn) {
+	logger := client.logger.With("remote_addr", conn.RemoteAddr().String())
+	conn.SetDeadline(time.Now().Add(client.config.HandshakeTimeout))
+
+	methods, err := socks.ReadGreeting(conn)
+	if err != nil {
+		logger.Warn("failed to read socks greeting", "error", err)
+		return
+	}
+
+	method := socks.METHOD_NO_ACCEPTABLE
+	for _, offered := range methods {
+		if offered == socks.METHOD_NO_AUTH {
+			method = offered
+		}
+	}
+	err = socks.WriteMethod(conn, method)
+	if err != nil || method == socks.METHOD_NO_ACCEPTABLE {
+		return
+	}
+
+	request, err := socks.ReadRequest(conn)
+	if err != nil {
+		if errors.Is(err, socks.ErrUnsupportedAddress) {
+			socks.WriteReply(conn, socks.REPLY_ADDRESS_NOT_SUPPORTED, socks.Addr{})
+		}
+		logger.Warn("failed to read socks request", "error", err)
+		return
+	}
+	if request.Command != socks.COMMAND_CONNECT {
+		socks.WriteReply(conn, socks.REPLY_COMMAND_NOT_SUPPORTED, socks.Addr{})
+		return
+	}
+
+	target := request.Addr.String()
+	ctx, cancel := context.WithTimeout(context.Background(), client.config.DialTimeout)
+	stream, err := client.DialContext(ctx, target)
+	cancel()
+	if err != nil {
+		reply := socks.REPLY_GENERAL_FAILURE
+		var openErr mux.OpenError
+		if errors.As(err, &openErr) {
+			reply = openErr.Code
+		}
+		socks.WriteReply(conn, reply, socks.Addr{})
+		logger.Warn("failed to open tunnel stream", "target", target, "error", err)
+		return
+	}
+
+	err = socks.WriteReply(conn, socks.REPLY_SUCCEEDED, socks.Addr{})
+	if err != nil {
+		stream.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	pipe(conn, stream)
+}
+
+func serve(listener net.Listener, handle func(conn net.Conn)) error {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+func pipe(local net.Conn, remote net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst net.Conn, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		if err != nil {
+			local.Close()
+			remote.Close()
+		} else {
+			closeWrite(dst)
+		}
+		done <- struct{}{}
+	}
+
+	go copyHalf(remote, local)
+	go copyHalf(local, remote)
+	<-done
+	<-done
+
+	local.Close()
+	remote.Close()
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+type AttributeKey[T any] struct {
+	name string
+}
+
+func NewAttributeKey[T any](name string) *AttributeKey[T] {
+	return &AttributeKey[T]{name: name}
+}
+
+func (key *AttributeKey[T]) String() string {
+	return key.name
+}
+
+type Attributes struct {
+	mutex  sync.RWMutex
+	values map[any]any
+}
+
+func NewAttributes() *Attributes {
+	return &Attributes{values: map[any]any{}}
+}
+
+func GetAttribute[T any](attributes *Attributes, key *AttributeKey[T]) (T, bool) {
+	attributes.mutex.RLock()
+	defer attributes.mutex.RUnlock()
+
+	value, exists := attributes.values[key].(T)
+	return value, exists
+}
+
+func SetAttribute[T any](attributes *Attributes, key *AttributeKey[T], value T) {
+	attributes.mutex.Lock()
+	defer attributes.mutex.Unlock()
+
+	attributes.values[key] = value
+}
+
+func DeleteAttribute[T any](attributes *Attributes, key *AttributeKey[T]) {
+	attributes.mutex.Lock()
+	defer attributes.mutex.Unlock()
+
+	delete(attributes.values, key)
+}
+
+func UpdateAttribute[T any](attributes *Attributes, key *AttributeKey[T], update func(T, bool) T) T {
+	attributes.mutex.Lock()
+	defer attributes.mutex.Unlock()
+
+	current, exists := attributes.values[key].(T)
+	updated := update(current, exists)
+	attributes.values[key] = updated
+	return updated
+}
+
+type attributesKey struct{}
+
+func WithAttributes(request http.HttpRequest) (http.HttpRequest, *Attributes) {
+	attributes := AttributesFromContext(request.Context())
+	if attributes != nil {
+		return request, attributes
+	}
+
+	attributes = NewAttributes()
+	ctx := context.WithValue(request.Context(), attributesKey{}, attributes)
+	return request.WithContext(ctx), attributes
+}
+
+func AttributesFromContext(ctx context.Context) *Attributes {
+	attributes, _ := ctx.Value(attributesKey{}).(*Attributes)
+	return attributes
+}
+
+func generateConnectionID() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
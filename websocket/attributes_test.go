@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func TestAttributes(t *testing.T) {
+	userId := NewAttributeKey[string]("user_id")
+	subscriptions := NewAttributeKey[int]("subscriptions")
+	attributes := NewAttributes()
+
+	_, exists := GetAttribute(attributes, userId)
+	if exists {
+		t.Errorf("expected empty attribute store")
+	}
+
+	SetAttribute(attributes, userId, "alice")
+	value, exists := GetAttribute(attributes, userId)
+	if !exists || value != "alice" {
+		t.Errorf("unexpected user id. got=%s %t", value, exists)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			UpdateAttribute(attributes, subscriptions, func(count int, _ bool) int { return count + 1 })
+		}()
+	}
+	wg.Wait()
+
+	count, _ := GetAttribute(attributes, subscriptions)
+	if count != 100 {
+		t.Errorf("unexpected subscription count. expected=100, got=%d", count)
+	}
+
+	DeleteAttribute(attributes, userId)
+	_, exists = GetAttribute(attributes, userId)
+	if exists {
+		t.Errorf("expected attribute to be deleted")
+	}
+}
+
+func TestWithAttributesReusesStore(t *testing.T) {
+	request, first := WithAttributes(http.HttpRequest{})
+	_, second := WithAttributes(request)
+
+	if first != second {
+		t.Errorf("expected attribute store to be reused from request context")
+	}
+}
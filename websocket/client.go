@@ -0,0 +1,66 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func NewHandshakeKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func ClientHandshake(conn net.Conn, request http.HttpRequest) (http.HttpResponse, *bufio.Reader, error) {
+	key := NewHandshakeKey()
+
+	headers := map[string]string{}
+	for headerName, headerValue := range request.Headers {
+		headers[headerName] = headerValue
+	}
+	headers["Upgrade"] = "websocket"
+	headers["Connection"] = "Upgrade"
+	headers["Sec-WebSocket-Key"] = key
+	headers["Sec-WebSocket-Version"] = "13"
+
+	request.Method = "GET"
+	request.Protocol = "HTTP/1.1"
+	request.Headers = headers
+	request.Content = []byte{}
+
+	_, err := conn.Write(request.Serialize())
+	if err != nil {
+		return http.HttpResponse{}, nil, fmt.Errorf("failed to send handshake request: %w", err)
+	}
+
+	response, reader, err := http.ParseHttpResponse(conn)
+	if err != nil {
+		return response, nil, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+
+	if response.StatusCode != 101 {
+		return response, nil, fmt.Errorf("handshake error: unexpected status. got=%d", response.StatusCode)
+	}
+
+	upgrade, _ := response.Header("Upgrade")
+	if !strings.EqualFold(upgrade, "websocket") {
+		return response, nil, fmt.Errorf("handshake error: unexpected Upgrade value. got=%s", upgrade)
+	}
+
+	accept, _ := response.Header("Sec-WebSocket-Accept")
+	if accept != generateAcceptHeader(key) {
+		return response, nil, fmt.Errorf("handshake error: unexpected Sec-WebSocket-Accept value. got=%s", accept)
+	}
+
+	return response, reader, nil
+}
+
+func ServeClient(request http.HttpRequest, conn net.Conn, reader *bufio.Reader, handler WsHandler) {
+	serve(request, conn, reader, handler, true)
+}
@@ -2,13 +2,27 @@ package websocket
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/brain-dev-null/gosocks/http"
+	"github.com/brain-dev-null/gosocks/logging"
+	"github.com/brain-dev-null/gosocks/metrics"
+	"github.com/brain-dev-null/gosocks/tracing"
 )
 
+const STATUS_NORMAL_CLOSURE uint16 = 1000
+const STATUS_GOING_AWAY uint16 = 1001
+const STATUS_PROTOCOL_ERROR uint16 = 1002
 const STATUS_INTERNAL_SERVER_ERROR uint16 = 1011
+const STATUS_BAD_GATEWAY uint16 = 1014
 
 const STATE_OPEN = "open"
 const STATE_CLOSING = "closing"
@@ -37,53 +51,132 @@ type WsConnection interface {
 	Close(statusCode uint16, reason string) error
 	SendText(text string) error
 	SendBinary(data []byte) error
+	Context() context.Context
+	RemoteAddr() net.Addr
+	LocalAddr() net.Addr
+	TLS() *tls.ConnectionState
+	Request() http.HttpRequest
+	ID() string
+	Attributes() *Attributes
 }
 
 type wsConnection struct {
-	reader      *bufio.Reader
-	connection  net.Conn
-	partialData []byte
-	handler     WsHandler
-	isClient    bool
-	state       string
-}
-
-func NewWsConnection(handler WsHandler) func(net.Conn, *bufio.Reader) {
-	return func(conn net.Conn, reader *bufio.Reader) {
-		connection := wsConnection{
-			reader:      reader,
-			connection:  conn,
-			partialData: nil,
-			handler:     handler,
-			isClient:    false,
-			state:       STATE_OPEN}
-		handler.OnOpen(&connection)
-		connection.run()
+	reader        *bufio.Reader
+	connection    net.Conn
+	partialData   []byte
+	partialOpCode byte
+	handler       WsHandler
+	isClient      bool
+	state         atomic.Value
+	request       http.HttpRequest
+	id            string
+	attributes    *Attributes
+	ctx           context.Context
+	cancel        context.CancelFunc
+	logger        *slog.Logger
+	metrics       *metrics.ServerMetrics
+	stats         wsStats
+}
+
+type wsStats struct {
+	framesIn  atomic.Int64
+	framesOut atomic.Int64
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	closeCode atomic.Uint32
+}
+
+func NewWsConnection(handler WsHandler) func(http.HttpRequest, net.Conn, *bufio.Reader) {
+	return func(request http.HttpRequest, conn net.Conn, reader *bufio.Reader) {
+		serve(request, conn, reader, handler, false)
+	}
+}
+
+func serve(request http.HttpRequest, conn net.Conn, reader *bufio.Reader, handler WsHandler, isClient bool) {
+	request, attributes := WithAttributes(request)
+	ctx := request.Context()
+	connCtx, cancel := context.WithCancel(ctx)
+	connectionId := generateConnectionID()
+	connection := &wsConnection{
+		reader:      reader,
+		connection:  conn,
+		partialData: nil,
+		handler:     handler,
+		isClient:    isClient,
+		request:     request,
+		id:          connectionId,
+		attributes:  attributes,
+		ctx:         connCtx,
+		cancel:      cancel,
+		logger:      logging.FromContext(ctx).With("connection_id", connectionId),
+		metrics:     metrics.FromContext(ctx)}
+	connection.state.Store(STATE_OPEN)
+	go connection.closeOnShutdown(ctx)
+	handler.OnOpen(connection)
+	connection.run()
+}
+
+func (wsConn *wsConnection) currentState() string {
+	return wsConn.state.Load().(string)
+}
+
+func (wsConn *wsConnection) closeOnShutdown(parent context.Context) {
+	<-wsConn.ctx.Done()
+	if parent.Err() != nil && wsConn.currentState() == STATE_OPEN {
+		wsConn.Close(STATUS_GOING_AWAY, "server shutting down")
 	}
 }
 
+func (wsConn *wsConnection) Context() context.Context {
+	return wsConn.ctx
+}
+
+func (wsConn *wsConnection) RemoteAddr() net.Addr {
+	return wsConn.connection.RemoteAddr()
+}
+
+func (wsConn *wsConnection) LocalAddr() net.Addr {
+	return wsConn.connection.LocalAddr()
+}
+
+func (wsConn *wsConnection) TLS() *tls.ConnectionState {
+	return wsConn.request.TLS
+}
+
+func (wsConn *wsConnection) Request() http.HttpRequest {
+	return wsConn.request
+}
+
+func (wsConn *wsConnection) ID() string {
+	return wsConn.id
+}
+
+func (wsConn *wsConnection) Attributes() *Attributes {
+	return wsConn.attributes
+}
+
 func (wsConn *wsConnection) Close(statusCode uint16, reason string) error {
+	defer wsConn.cancel()
 	defer wsConn.connection.Close()
 
-	if wsConn.state == STATE_CLOSING {
-		wsConn.state = STATE_CLOSED
+	if wsConn.state.CompareAndSwap(STATE_CLOSING, STATE_CLOSED) {
 		event := WsCloseEvent{Code: statusCode, Reason: reason, WasClean: true}
 		go wsConn.handler.OnClose(event, wsConn)
 		return nil
 	}
 
-	if wsConn.state == STATE_CLOSED {
+	if !wsConn.state.CompareAndSwap(STATE_OPEN, STATE_CLOSING) {
 		return nil
 	}
 
-	wsConn.state = STATE_CLOSING
+	wsConn.stats.closeCode.CompareAndSwap(0, uint32(statusCode))
 	closeFrame := NewCloseFrame(statusCode, reason, wsConn.isClient)
-	_, err := wsConn.connection.Write(closeFrame.Serialize())
+	err := wsConn.writeFrame(closeFrame)
 
 	if err != nil {
 		err := fmt.Errorf("failed to send close frame: %w", err)
 		event := WsCloseEvent{Code: statusCode, Reason: reason, WasClean: false}
-		log.Println(err.Error())
+		wsConn.logger.Warn(err.Error())
 		go wsConn.handler.OnClose(event, wsConn)
 		return err
 	}
@@ -95,14 +188,14 @@ func (wsConn *wsConnection) Close(statusCode uint16, reason string) error {
 }
 
 func (wsConn *wsConnection) Ping() error {
-	pongFrame := NewPingFrame([]byte{}, false)
-	data := pongFrame.Serialize()
+	pingData := binary.BigEndian.AppendUint64([]byte{}, uint64(time.Now().UnixNano()))
+	pingFrame := NewPingFrame(pingData, wsConn.isClient)
 
-	_, err := wsConn.connection.Write(data)
+	err := wsConn.writeFrame(pingFrame)
 
 	if err != nil {
 		err := fmt.Errorf("failed to send ping frame: %w", err)
-		log.Println(err.Error())
+		wsConn.logger.Warn(err.Error())
 		go wsConn.handleInternalError(err)
 		return err
 	}
@@ -111,13 +204,12 @@ func (wsConn *wsConnection) Ping() error {
 }
 
 func (wsConn *wsConnection) Pong(pingData []byte) error {
-	pongFrame := NewPongFrame(pingData, false)
-	data := pongFrame.Serialize()
+	pongFrame := NewPongFrame(pingData, wsConn.isClient)
 
-	_, err := wsConn.connection.Write(data)
+	err := wsConn.writeFrame(pongFrame)
 
 	if err != nil {
-		log.Printf("failed to send pong frame: %v", err)
+		wsConn.logger.Warn("failed to send pong frame", "error", err)
 		wsConn.handleInternalError(err)
 		return err
 	}
@@ -126,11 +218,11 @@ func (wsConn *wsConnection) Pong(pingData []byte) error {
 }
 
 func (wsConn *wsConnection) SendText(text string) error {
-	if wsConn.state != STATE_OPEN {
+	if wsConn.currentState() != STATE_OPEN {
 		return fmt.Errorf("connection closed")
 	}
-	frame := NewTextFrame(false, text).Serialize()
-	_, err := wsConn.connection.Write(frame)
+	frame := NewTextFrame(wsConn.isClient, text)
+	err := wsConn.writeFrame(frame)
 	if err != nil {
 		err := fmt.Errorf("error during send: %w", err)
 		wsConn.handleInternalError(err)
@@ -140,11 +232,11 @@ func (wsConn *wsConnection) SendText(text string) error {
 }
 
 func (wsConn *wsConnection) SendBinary(data []byte) error {
-	if wsConn.state != STATE_OPEN {
+	if wsConn.currentState() != STATE_OPEN {
 		return fmt.Errorf("connection closed")
 	}
-	frame := NewBinaryFrame(false, data).Serialize()
-	_, err := wsConn.connection.Write(frame)
+	frame := NewBinaryFrame(wsConn.isClient, data)
+	err := wsConn.writeFrame(frame)
 	if err != nil {
 		err := fmt.Errorf("error during send: %w", err)
 		wsConn.handleInternalError(err)
@@ -153,9 +245,21 @@ func (wsConn *wsConnection) SendBinary(data []byte) error {
 	return nil
 }
 
+func (wsConn *wsConnection) writeFrame(frame WebSocketFrame) error {
+	n, err := wsConn.connection.Write(frame.Serialize())
+	wsConn.stats.framesOut.Add(1)
+	wsConn.stats.bytesOut.Add(int64(n))
+	wsConn.metrics.WsFrames.Inc(metrics.DIRECTION_OUT)
+	wsConn.metrics.WsBytes.Add(float64(n), metrics.DIRECTION_OUT)
+	return err
+}
+
 func (wsConn *wsConnection) run() {
+	start := time.Now()
+	defer wsConn.logSessionEnd(start)
+	defer wsConn.cancel()
 	defer wsConn.connection.Close()
-	for wsConn.state == STATE_OPEN {
+	for wsConn.currentState() == STATE_OPEN {
 		wsConn.rcvNextMsg()
 	}
 }
@@ -168,8 +272,13 @@ func (wsconn *wsConnection) rcvNextMsg() {
 		return
 	}
 
+	wsconn.stats.framesIn.Add(1)
+	wsconn.stats.bytesIn.Add(int64(frame.WireSize()))
+	wsconn.metrics.WsFrames.Inc(metrics.DIRECTION_IN)
+	wsconn.metrics.WsBytes.Add(float64(frame.WireSize()), metrics.DIRECTION_IN)
+
 	if isCloseFrame(frame) {
-		log.Println("Received close frame")
+		wsconn.logger.Debug("received close frame")
 		code := getStatusCode(frame)
 		reason := getReason(frame)
 
@@ -183,6 +292,7 @@ func (wsconn *wsConnection) rcvNextMsg() {
 	}
 
 	if isPongFrame(frame) {
+		wsconn.observePingRtt(frame.Payload)
 		return
 	}
 
@@ -193,8 +303,7 @@ func (wsconn *wsConnection) rcvNextMsg() {
 			wsconn.handleInternalError(err)
 			return
 		}
-		event := WsMessageEvent{Data: frame.Payload}
-		wsconn.handler.OnMessage(event, wsconn)
+		wsconn.dispatchMessage(frame.OpCode, frame.Payload)
 		return
 	}
 
@@ -206,6 +315,7 @@ func (wsconn *wsConnection) rcvNextMsg() {
 			return
 		}
 		wsconn.partialData = frame.Payload
+		wsconn.partialOpCode = frame.OpCode
 		return
 	}
 
@@ -228,13 +338,51 @@ func (wsconn *wsConnection) rcvNextMsg() {
 		}
 		fullData := append(wsconn.partialData, frame.Payload...)
 		wsconn.partialData = nil
-		event := WsMessageEvent{Data: fullData}
-		wsconn.handler.OnMessage(event, wsconn)
+		wsconn.dispatchMessage(wsconn.partialOpCode, fullData)
+		return
+	}
+
+	wsconn.logger.Warn("unexpected frame type",
+		"fin", frame.Fin,
+		"opcode", frame.OpCode)
+}
+
+func (wsConn *wsConnection) dispatchMessage(opCode byte, data []byte) {
+	_, span := tracing.StartSpan(wsConn.ctx, "websocket message")
+	span.SetAttribute("websocket.opcode", opCode)
+	span.SetAttribute("websocket.message_size", len(data))
+	defer span.End()
+
+	event := WsMessageEvent{Data: data}
+	wsConn.handler.OnMessage(event, wsConn)
+}
+
+func (wsConn *wsConnection) observePingRtt(pongData []byte) {
+	if len(pongData) != 8 {
 		return
 	}
 
-	log.Printf("unexpected frame type: fin=%t opcode=%d",
-		frame.Fin, frame.OpCode)
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(pongData)))
+	rtt := time.Since(sentAt)
+	if rtt < 0 || rtt > time.Minute {
+		return
+	}
+
+	wsConn.metrics.WsPingRtt.Observe(rtt.Seconds())
+}
+
+func (wsConn *wsConnection) logSessionEnd(start time.Time) {
+	closeCode := wsConn.stats.closeCode.Load()
+	wsConn.metrics.WsClosed.Inc(strconv.FormatUint(uint64(closeCode), 10))
+
+	wsConn.logger.Info("websocket session closed",
+		"remote_addr", wsConn.RemoteAddr().String(),
+		"duration_ms", time.Since(start).Milliseconds(),
+		"frames_in", wsConn.stats.framesIn.Load(),
+		"frames_out", wsConn.stats.framesOut.Load(),
+		"bytes_in", wsConn.stats.bytesIn.Load(),
+		"bytes_out", wsConn.stats.bytesOut.Load(),
+		"close_code", closeCode)
 }
 
 func isUnfragmentedFrame(msg WebSocketFrame) bool {
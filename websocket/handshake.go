@@ -85,3 +85,24 @@ func generateAcceptHeader(key string) string {
 
 	return base64Encoded
 }
+
+func ExtendedConnectHandshake(handshakeRequest http.HttpRequest) (http.HttpResponse, error) {
+	handshakeResponse := http.HttpResponse{StatusCode: 200, Headers: map[string]string{}, Content: []byte{}}
+
+	if handshakeRequest.Method != "CONNECT" {
+		return handshakeResponse, fmt.Errorf(
+			"handshake error: unexpected method. got=%s",
+			handshakeRequest.Method)
+	}
+
+	version, exists := handshakeRequest.Header("Sec-WebSocket-Version")
+	if !exists {
+		return handshakeResponse, fmt.Errorf("handshake error: missing Sec-WebSocket-Version header")
+	}
+	if version != "13" {
+		return handshakeResponse, fmt.Errorf(
+			"handshake error: unexpected Sec-WebSocket-Version value. got=%s", version)
+	}
+
+	return handshakeResponse, nil
+}
@@ -0,0 +1,27 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brain-dev-null/gosocks/http"
+)
+
+func CheckOrigin(request http.HttpRequest, allowedOrigins []string) error {
+	origin, exists := request.Header("Origin")
+	if !exists {
+		return nil
+	}
+
+	if strings.EqualFold(origin, request.Scheme+"://"+request.Host) {
+		return nil
+	}
+
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(origin, allowed) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("handshake error: origin %s not allowed", origin)
+}
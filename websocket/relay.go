@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const RELAY_CLOSE_TIMEOUT = 5 * time.Second
+
+func Relay(client net.Conn, clientReader *bufio.Reader, upstream net.Conn, upstreamReader *bufio.Reader) error {
+	done := make(chan error, 2)
+	go func() { done <- relayFrames(clientReader, upstream, true) }()
+	go func() { done <- relayFrames(upstreamReader, client, false) }()
+
+	err := <-done
+	if err == nil {
+		timer := time.NewTimer(RELAY_CLOSE_TIMEOUT)
+		select {
+		case err = <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	client.Close()
+	upstream.Close()
+	return err
+}
+
+func relayFrames(reader *bufio.Reader, writer io.Writer, masked bool) error {
+	for {
+		frame, err := DeserialzeWebSocketFrame(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		frame.Masked = masked
+		frame.MaskingKey = nil
+		if masked {
+			frame.MaskingKey = generateMaskingKey()
+		}
+
+		_, err = writer.Write(frame.Serialize())
+		if err != nil {
+			return err
+		}
+
+		if isCloseFrame(frame) {
+			return nil
+		}
+	}
+}
@@ -47,6 +47,14 @@ func (frame WebSocketFrame) String() string {
 	return buffer.String()
 }
 
+func (frame WebSocketFrame) WireSize() int {
+	size := 1 + len(generatePayloadLength(frame)) + len(frame.Payload)
+	if frame.Masked {
+		size += len(frame.MaskingKey)
+	}
+	return size
+}
+
 func NewCloseFrame(statusCode uint16, reason string, masked bool) WebSocketFrame {
 	var buffer bytes.Buffer
 
@@ -133,9 +141,14 @@ func DeserialzeWebSocketFrame(reader *bufio.Reader) (WebSocketFrame, error) {
 
 	wsFrame.Masked = masked
 
-	maskingKey, err := deserializeMaskingKey(reader)
-
-	wsFrame.MaskingKey = maskingKey
+	if masked {
+		maskingKey, err := deserializeMaskingKey(reader)
+		if err != nil {
+			return wsFrame, fmt.Errorf(
+				"failed to deserialize masking key: %w", err)
+		}
+		wsFrame.MaskingKey = maskingKey
+	}
 
 	payload, err := deserializePayload(reader, wsFrame.MaskingKey, payloadLength)
 
@@ -232,7 +245,10 @@ func deserializePayload(reader *bufio.Reader, maskingKey []byte, payloadLength u
 				i, payloadLength, err)
 		}
 
-		payload = append(payload, data^maskingKey[i%4])
+		if maskingKey != nil {
+			data ^= maskingKey[i%4]
+		}
+		payload = append(payload, data)
 	}
 
 	return payload, nil
@@ -278,7 +294,7 @@ func generatePayloadLength(frame WebSocketFrame) []byte {
 		return []byte{byte(length)}
 	}
 
-	if length < 635536 {
+	if length < 65536 {
 		buffer := []byte{126}
 		return binary.BigEndian.AppendUint16(buffer, uint16(length))
 	}
@@ -54,9 +54,9 @@ func TestWebsocketFrameDeserialization(t *testing.T) {
 			continue
 		}
 
-		if wsFrame.PayloadLength != tt.encodedPayloadLength {
+		if uint64(len(wsFrame.Payload)) != tt.encodedPayloadLength {
 			t.Errorf("expected payload length to be %d. got=%d",
-				tt.encodedPayloadLength, wsFrame.PayloadLength)
+				tt.encodedPayloadLength, len(wsFrame.Payload))
 			continue
 		}
 
@@ -74,9 +74,9 @@ func TestWebsocketFrameDeserialization(t *testing.T) {
 			}
 		}
 
-		if wsFrame.PayloadLength != tt.encodedPayloadLength {
+		if uint64(len(wsFrame.Payload)) != tt.encodedPayloadLength {
 			t.Errorf("expected payload length to be %d. got=%d",
-				tt.encodedPayloadLength, wsFrame.PayloadLength)
+				tt.encodedPayloadLength, len(wsFrame.Payload))
 		}
 
 		for i, b := range wsFrame.Payload {
@@ -88,6 +88,28 @@ func TestWebsocketFrameDeserialization(t *testing.T) {
 	}
 }
 
+func TestPayloadLengthEncoding(t *testing.T) {
+	tests := []struct {
+		length   int
+		expected []byte
+	}{
+		{0, []byte{0}},
+		{125, []byte{125}},
+		{126, []byte{126, 0, 126}},
+		{65535, []byte{126, 255, 255}},
+		{65536, []byte{127, 0, 0, 0, 0, 0, 1, 0, 0}},
+		{100000, []byte{127, 0, 0, 0, 0, 0, 1, 134, 160}},
+	}
+
+	for _, tt := range tests {
+		frame := WebSocketFrame{Payload: make([]byte, tt.length)}
+		encoded := generatePayloadLength(frame)
+		if !bytes.Equal(encoded, tt.expected) {
+			t.Errorf("expected payload length %d to be encoded as %v. got=%v", tt.length, tt.expected, encoded)
+		}
+	}
+}
+
 func repeatPattern(pattern byte, repetitions uint64) []byte {
 	data := []byte{}
 
@@ -97,3 +119,32 @@ func repeatPattern(pattern byte, repetitions uint64) []byte {
 
 	return data
 }
+
+func TestUnmaskedFrameDeserialization(t *testing.T) {
+	frames := []WebSocketFrame{
+		NewTextFrame(false, "hello"),
+		NewTextFrame(true, "hello"),
+		NewCloseFrame(1000, "bye", false),
+	}
+
+	var buffer bytes.Buffer
+	for _, frame := range frames {
+		buffer.Write(frame.Serialize())
+	}
+
+	reader := bufio.NewReader(&buffer)
+	for i, expected := range frames {
+		frame, err := DeserialzeWebSocketFrame(reader)
+		if err != nil {
+			t.Fatalf("failed to deserialize frame %d: %v", i, err)
+		}
+
+		if frame.Masked != expected.Masked || frame.OpCode != expected.OpCode {
+			t.Errorf("frame %d header mismatch. expected masked=%t opcode=%d, got masked=%t opcode=%d",
+				i, expected.Masked, expected.OpCode, frame.Masked, frame.OpCode)
+		}
+		if !bytes.Equal(frame.Payload, expected.Payload) {
+			t.Errorf("frame %d payload mismatch. expected=%q, got=%q", i, expected.Payload, frame.Payload)
+		}
+	}
+}